@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"mediasfu/internal/usecase/callcontrol"
+	"mediasfu/pkg/httpserver"
+	"mediasfu/pkg/rtpengine"
+	"mediasfu/pkg/sip"
+)
+
+// nodeLoad 汇总ng、sip和呼叫控制的负载, 随etcd心跳上报.
+type nodeLoad struct {
+	sync.Mutex
+	engine      *rtpengine.Engine
+	ua          *sip.UA
+	callControl *callcontrol.CallControl
+
+	lastBytes uint64
+	lastTime  time.Time
+}
+
+func (n *nodeLoad) report() httpserver.Load {
+	var load httpserver.Load
+	if n.engine == nil {
+		return load
+	}
+
+	// ng的每个呼叫两端, sip每个呼叫一端, 呼叫控制每路leg一端.
+	calls := len(n.engine.Calls())
+	load.Sessions, load.Peers = calls, calls*2
+	if n.ua != nil {
+		sipCalls := len(n.ua.Calls())
+		load.Sessions += sipCalls
+		load.Peers += sipCalls
+	}
+	if n.callControl != nil {
+		sessions, legs := n.callControl.Count()
+		load.Sessions += sessions
+		load.Peers += legs
+	}
+
+	pool := n.engine.PortPool()
+	load.PortsUsed, load.PortsTotal = pool.Usage()
+	rx, tx := pool.Traffic()
+
+	n.Lock()
+	now := time.Now()
+	if !n.lastTime.IsZero() {
+		if elapsed := now.Sub(n.lastTime).Seconds(); elapsed > 0 {
+			load.Bandwidth = uint64(float64(rx+tx-n.lastBytes) * 8 / elapsed)
+		}
+	}
+	n.lastBytes, n.lastTime = rx+tx, now
+	n.Unlock()
+	return load
+}
@@ -2,387 +2,363 @@ package main
 
 import (
 	log "common/log/newlog"
-	"encoding/json"
+	"common/registry"
+	"context"
 	"flag"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"mediasfu/config"
+	amqprpc "mediasfu/internal/controller/amqp_rpc"
 	v1 "mediasfu/internal/controller/http/v1"
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase"
+	"mediasfu/internal/usecase/callcontrol"
+	"mediasfu/internal/usecase/cdr"
+	"mediasfu/internal/usecase/placement"
+	"mediasfu/internal/usecase/repo"
+	"mediasfu/internal/usecase/sfuadmin"
+	"mediasfu/pkg/directory"
+	"mediasfu/pkg/events"
 	"mediasfu/pkg/httpserver"
 	"mediasfu/pkg/logger"
+	"mediasfu/pkg/mysql"
+	"mediasfu/pkg/rabbitmq/rmq_rpc/server"
+	"mediasfu/pkg/rtpengine"
+	"mediasfu/pkg/signal"
+	"mediasfu/pkg/sip"
+	sfu "mediasfu/pkg/webrtc"
+	"mediasfu/pkg/webrtc/buffer"
+	"mediasfu/pkg/webrtc/stats"
+	"net"
 	"net/http"
-	"sync"
-	"text/template"
+	"os"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-// TODO: put to config.
 var (
-	addr     = flag.String("addr", ":8080", "http service address")
+	cfgPath  = flag.String("config", config.DefaultPath, "config file, yaml or toml, SIGHUP reloads it")
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
-	indexTemplate = &template.Template{}
-
-	// lock for peerConnections and trackLocals
-	listLock        sync.RWMutex
-	peerConnections []peerConnectionState
-	trackLocals     map[string]*webrtc.TrackLocalStaticRTP
-	certPath = "cert01.crt"
-    keyPath = "cert01.pem"
 )
 
-type websocketMessage struct {
-	Event string `json:"event"`
-	Data  string `json:"data"`
-}
-
-type peerConnectionState struct {
-	peerConnection *webrtc.PeerConnection
-	websocket      *threadSafeWriter
-}
-
 // rtp <---> webrtc sfu gateway.
 // source or sink: client A --> leg <--channel--> webrtc <-- client B
 // service : http control
 func main() {
 	// Parse the flags passed to program
 	flag.Parse()
+	cfg, err := config.NewConfig(*cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current.Store(cfg)
 
 	// Init other state
-	logger.Init("debug")
-
-	// 多客户端管理.
-	trackLocals = map[string]*webrtc.TrackLocalStaticRTP{}
+	logger.Init(cfg.Log.Level)
+	stats.InitStats()
+
+	// 媒体事件, 计费和监控通过rabbitmq topic exchange消费.
+	var bus *events.Bus
+	if cfg.RMQ.URL != "" && cfg.RMQ.EventsExchange != "" {
+		bus = events.NewBus()
+		publisher := events.NewAMQPPublisher(events.NewRabbitMQBroker(cfg.RMQ.URL, cfg.RMQ.EventsExchange))
+		publisher.Start(bus)
+		defer publisher.Close()
+	}
+	transport := newTransportConfig(cfg, bus)
 
-	// request a keyframe every 3 seconds
-	go func() {
-		for range time.NewTicker(time.Second * 3).C {
-			dispatchKeyFrame()
+	// 话单, 按bill-id写入mysql.
+	var cdrUseCase usecase.Cdr
+	if cfg.MySQL.URL != "" {
+		ms, err := mysql.New(cfg.MySQL.URL)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
-
-	// use gin instead
-	// HTTP Server
-	handler := gin.New()
-	v1.NewRouter(handler, &upgrader, log.GetLogger())
-
-	// websocket handler
-	//http.HandleFunc("/websocket", websocketHandler)
-	//
-	//// index.html handler
-	//// Read index.html from disk into memory, serve whenever anyone requests /
-	//indexHTML, err := ioutil.ReadFile("index.html")
-	//if err != nil {
-	//	panic(err)
-	//}
-	//indexTemplate = template.Must(template.New("").Parse(string(indexHTML)))
-	//http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-	//	if err := indexTemplate.Execute(w, "ws://"+r.Host+"/websocket"); err != nil {
-	//		log.Fatal(err)
-	//	}
-	//})
-
-	// start https server
-	// log.Fatal(http.ListenAndServeTLS(*addr, certPath, keyPath,nil))
-	// start HTTP server
-	// log.Fatal(http.ListenAndServe(*addr, nil))
-	// service info for etcd or no
-	_ = httpserver.NewNoEtcd(handler, log.GetLogger(), *addr)
-
-	// other things to do?.
-	log.Info("app - Run - exit ! ")
-}
-
-// Add to list of tracks and fire renegotation for all PeerConnections
-func addTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		signalPeerConnections()
-	}()
-
-	// Create a new TrackLocal with the same codec as our incoming
-	trackLocal, err := webrtc.NewTrackLocalStaticRTP(t.Codec().RTPCodecCapability, t.ID(), t.StreamID())
-	if err != nil {
-		panic(err)
+		defer ms.Close()
+		cdrUseCase = cdr.New(repo.NewCdrRepo(ms), log.GetLogger())
 	}
 
-	trackLocals[t.ID()] = trackLocal
-	return trackLocal
-}
-
-// Remove from list of tracks and fire renegotation for all PeerConnections
-func removeTrack(t *webrtc.TrackLocalStaticRTP) {
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		signalPeerConnections()
-	}()
+	// 信令hub记录各会话的E2EE模式, 加密会话的rtp leg只转发.
+	signalHub := signal.NewHub()
 
-	delete(trackLocals, t.ID())
-}
+	// 会话目录, 多节点时会话只在持有节点上.
+	var placementUseCase usecase.Placement
+	if cfg.Cluster.Directory != "" {
+		dir := newDirectory(cfg.Cluster)
+		defer dir.Close()
+		p, err := placement.New(dir, selfNode(cfg.HTTP), cfg.Cluster.JoinMode, log.GetLogger())
+		if err != nil {
+			log.Fatal(err)
+		}
+		placementUseCase = p
+	}
+	sfuNode := sfu.NewSFU(*transport)
 
-// signalPeerConnections updates each PeerConnection so that it is getting all the expected media tracks
-func signalPeerConnections() {
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		dispatchKeyFrame()
+	// request a keyframe every keyframe_interval
+	keyframe := time.NewTicker(cfg.SFU.KeyframeInterval)
+	defer keyframe.Stop()
+	go func() {
+		for range keyframe.C {
+			sfuNode.RequestKeyFrames()
+		}
 	}()
-
-	attemptSync := func() (tryAgain bool) {
-		for i := range peerConnections {
-			if peerConnections[i].peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
-				peerConnections = append(peerConnections[:i], peerConnections[i+1:]...)
-				return true // We modified the slice, start from the beginning
-			}
-
-			// map of sender we already are seanding, so we don't double send
-			existingSenders := map[string]bool{}
-
-			for _, sender := range peerConnections[i].peerConnection.GetSenders() {
-				if sender.Track() == nil {
-					continue
+	go watchReload(*cfgPath, keyframe, sfuNode)
+
+	// rtp媒体中继, ng控制协议、sip和rabbitmq呼叫控制共用端口池.
+	var ua *sip.UA
+	load := &nodeLoad{}
+	if cfg.RTP.NGAddr != "" || cfg.SIP.UDPAddr != "" || cfg.SIP.TCPAddr != "" || cfg.RMQ.URL != "" {
+		engine, err := rtpengine.NewEngine(rtpengine.Config{
+			IP:            cfg.RTP.IP,
+			AdvertiseIP:   cfg.RTP.Advertise,
+			MinPort:       cfg.RTP.MinPort,
+			MaxPort:       cfg.RTP.MaxPort,
+			MediaTimeout:  cfg.RTP.MediaTimeout,
+			BufferFactory: newBufferFactory(cfg),
+			Events:        bus,
+			E2EE:          signalHub.E2EE,
+			AudioObserver: func(session string) rtpengine.AudioObserver {
+				// 会话中有webrtc peer时, 电话一侧的声音也参与说话者检测.
+				if s, _ := sfuNode.GetSession(session); s != nil && s.AudioObserver() != nil {
+					return s.AudioObserver()
 				}
-
-				existingSenders[sender.Track().ID()] = true
-
-				// If we have a RTPSender that doesn't map to a existing track remove and signal
-				if _, ok := trackLocals[sender.Track().ID()]; !ok {
-					if err := peerConnections[i].peerConnection.RemoveTrack(sender); err != nil {
-						return true
-					}
-				}
-			}
-
-			// Don't receive videos we are sending, make sure we don't have loopback
-			for _, receiver := range peerConnections[i].peerConnection.GetReceivers() {
-				if receiver.Track() == nil {
-					continue
-				}
-
-				existingSenders[receiver.Track().ID()] = true
+				return nil
+			},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer engine.Close()
+		load.engine = engine
+
+		// rtpengine ng 控制协议, kamailio/opensips直接控制媒体中继.
+		if cfg.RTP.NGAddr != "" {
+			ng := rtpengine.NewNGServer(engine, rtpengine.NGConfig{UDPAddr: cfg.RTP.NGAddr, TCPAddr: cfg.RTP.NGAddr})
+			if err = ng.Start(); err != nil {
+				log.Fatal(err)
 			}
+			defer ng.Close()
+		}
 
-			// Add all track we aren't sending yet to the PeerConnection
-			for trackID := range trackLocals {
-				if _, ok := existingSenders[trackID]; !ok {
-					if _, err := peerConnections[i].peerConnection.AddTrack(trackLocals[trackID]); err != nil {
-						return true
-					}
-				}
+		// 内置sip ua, 呼入按Request-URI进入会话.
+		if cfg.SIP.UDPAddr != "" || cfg.SIP.TCPAddr != "" {
+			mediaIP := cfg.RTP.Advertise
+			if mediaIP == "" {
+				mediaIP = cfg.RTP.IP
 			}
-
-			offer, err := peerConnections[i].peerConnection.CreateOffer(nil)
-			if err != nil {
-				return true
+			ua = sip.NewUA(sip.Config{
+				UDPAddr:      cfg.SIP.UDPAddr,
+				TCPAddr:      cfg.SIP.TCPAddr,
+				Host:         cfg.RTP.Advertise,
+				MediaIP:      mediaIP,
+				MediaTimeout: cfg.RTP.MediaTimeout,
+				Events:       bus,
+			}, engine.PortPool(), transport.BufferFactory)
+			// 呼入和呼出接通后作为普通rtp peer加入会话, leg和sfu共用BufferFactory, router按ssrc取到leg的Buffer.
+			ua.OnInvite(func(c *sip.Call) error {
+				return joinSipCall(sfuNode, placementUseCase, c)
+			})
+			ua.OnAnswer(func(c *sip.Call) error {
+				return joinSipCall(sfuNode, placementUseCase, c)
+			})
+			if cdrUseCase != nil {
+				ua.OnTerminated(func(c *sip.Call, reason string) {
+					recordSipCall(cdrUseCase, c, reason)
+				})
 			}
-
-			if err = peerConnections[i].peerConnection.SetLocalDescription(offer); err != nil {
-				return true
+			if err = ua.Start(); err != nil {
+				log.Fatal(err)
 			}
+			defer ua.Close()
+			load.ua = ua
+		}
 
-			offerString, err := json.Marshal(offer)
+		// RabbitMQ RPC Server, softswitch通过type头调用呼叫控制.
+		if cfg.RMQ.URL != "" {
+			load.callControl = callcontrol.New(engine, bus, cdrUseCase, log.GetLogger())
+			router := amqprpc.NewRouter(load.callControl)
+			rmqServer, err := server.New(cfg.RMQ.URL, cfg.RMQ.ServerExchange, cfg.RMQ.ClientExchange, router, log.GetLogger())
 			if err != nil {
-				return true
-			}
-
-			if err = peerConnections[i].websocket.WriteJSON(&websocketMessage{
-				Event: "offer",
-				Data:  string(offerString),
-			}); err != nil {
-				return true
+				log.Fatal(err)
 			}
+			defer rmqServer.Shutdown()
 		}
-
-		return
 	}
 
-	for syncAttempt := 0; ; syncAttempt++ {
-		if syncAttempt == 25 {
-			// Release the lock and attempt a sync in 3 seconds. We might be blocking a RemoveTrack or AddTrack
-			go func() {
-				time.Sleep(time.Second * 3)
-				signalPeerConnections()
-			}()
-			return
-		}
+	// use gin instead
+	// HTTP Server
+	handler := gin.New()
+	quality := sfu.NewQualityMonitor(sfuNode, bus,
+		sfu.QualityInterval(cfg.SFU.QualityInterval),
+		sfu.QualityThreshold(cfg.SFU.QualityThreshold))
+	quality.Start()
+	defer quality.Stop()
+	sfuAdmin := sfuadmin.New(sfuNode, quality, log.GetLogger())
+	v1.NewRouter(handler, &upgrader, ua, sfuNode, placementUseCase, signalHub, cdrUseCase, sfuAdmin, log.GetLogger())
 
-		if !attemptSync() {
-			break
-		}
+	// start https server
+	// log.Fatal(http.ListenAndServeTLS(cfg.HTTP.Addr, cfg.HTTP.Cert, cfg.HTTP.Key, nil))
+	// start HTTP server
+	// log.Fatal(http.ListenAndServe(cfg.HTTP.Addr, nil))
+	// service info for etcd or no
+	if cfg.Cluster.Register {
+		_ = httpserver.New(handler, log.GetLogger(), cfg.HTTP.Addr,
+			httpserver.Registry(registry.DefaultRegistry),
+			httpserver.Advertise(cfg.HTTP.Advertise),
+			httpserver.LoadReport(load.report))
+	} else {
+		_ = httpserver.NewNoEtcd(handler, log.GetLogger(), cfg.HTTP.Addr)
 	}
-}
-
-// dispatchKeyFrame sends a keyframe to all PeerConnections, used everytime a new user joins the call
-func dispatchKeyFrame() {
-	listLock.Lock()
-	defer listLock.Unlock()
 
-	for i := range peerConnections {
-		for _, receiver := range peerConnections[i].peerConnection.GetReceivers() {
-			if receiver.Track() == nil {
-				continue
-			}
+	// other things to do?.
+	log.Info("app - Run - exit ! ")
+}
 
-			// send PictureLossIndication。
-			_ = peerConnections[i].peerConnection.WriteRTCP([]rtcp.Packet{
-				&rtcp.PictureLossIndication{
-					MediaSSRC: uint32(receiver.Track().SSRC()),
-				},
-			})
-		}
-	}
+// newBufferFactory sfu、rtp中继和sip各用一个Factory, 内存上限按进程内所有Factory的总占用计算.
+func newBufferFactory(cfg *config.Config) *buffer.Factory {
+	return buffer.NewBufferFactory(cfg.SFU.BufferSize, log.GetLogger(),
+		buffer.FactoryAudioPackets(cfg.SFU.AudioBufferSize),
+		buffer.FactoryMaxMemory(cfg.SFU.BufferMemory<<20),
+		buffer.FactoryOrphanTimeout(cfg.SFU.BufferOrphan),
+	)
 }
 
-// Handle incoming websockets
-func websocketHandler(w http.ResponseWriter, r *http.Request) {
-	// Upgrade HTTP request to Websocket
-	unsafeConn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Error("upgrade:", err)
-		return
+// newTransportConfig sfu会话的ice设置和router配置.
+func newTransportConfig(cfg *config.Config, bus *events.Bus) *sfu.WebRTCTransportConfig {
+	c := &sfu.WebRTCTransportConfig{
+		Configuration: webrtc.Configuration{SDPSemantics: webrtc.SDPSemanticsUnifiedPlan},
+		Router:        cfg.Router,
+		BufferFactory: newBufferFactory(cfg),
+		Events:        bus,
+		RouterFunc:    routerConfig,
 	}
-
-	c := &threadSafeWriter{unsafeConn, sync.Mutex{}}
-
-	// When this frame returns close the Websocket
-	defer c.Close() //nolint
-
-	// Create new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
-	if err != nil {
-		log.Error(err)
-		return
+	for _, s := range cfg.SFU.ICEServers {
+		c.Configuration.ICEServers = append(c.Configuration.ICEServers, webrtc.ICEServer{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		})
 	}
 
-	// When this frame returns close the PeerConnection
-	defer peerConnection.Close() //nolint
-
-	// Accept one audio and one video track incoming
-	// only accept recv?...
-	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
-		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionRecvonly,
-		}); err != nil {
-			log.Error(err)
-			return
+	if len(cfg.SFU.ICEPortRange) == 2 {
+		if err := c.Setting.SetEphemeralUDPPortRange(cfg.SFU.ICEPortRange[0], cfg.SFU.ICEPortRange[1]); err != nil {
+			log.Fatal(err)
 		}
 	}
+	if len(cfg.SFU.NAT1To1IPs) > 0 {
+		c.Setting.SetNAT1To1IPs(cfg.SFU.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+	return c
+}
 
-	// Add our new PeerConnection to global list
-	listLock.Lock()
-	peerConnections = append(peerConnections, peerConnectionState{peerConnection, c})
-	listLock.Unlock()
-
-	// Trickle ICE. Emit server candidate to client
-	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
-		if i == nil {
-			return
-		}
-
-		candidateString, err := json.Marshal(i.ToJSON())
+// 会话目录, 多个网关节点共享.
+func newDirectory(c config.Cluster) directory.Directory {
+	switch c.Directory {
+	case "memory":
+		return directory.NewMemory()
+	case "etcd":
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   c.Etcd,
+			DialTimeout: 5 * time.Second,
+		})
 		if err != nil {
-			log.Error(err)
-			return
+			log.Fatal(err)
 		}
+		return directory.NewEtcd(client)
+	case "redis":
+		return directory.NewRedis(redis.NewClient(&redis.Options{Addr: c.Redis}))
+	}
+	log.Fatal("unknown session directory: " + c.Directory)
+	return nil
+}
 
-		if writeErr := c.WriteJSON(&websocketMessage{
-			Event: "candidate",
-			Data:  string(candidateString),
-		}); writeErr != nil {
-			log.Error(writeErr)
-		}
-	})
+// selfNode 本节点在会话目录中的地址, 客户端重定向和中继连接它的/v1/signal.
+func selfNode(c config.HTTP) directory.Node {
+	host, port, _ := net.SplitHostPort(c.Addr)
+	if c.Advertise != "" {
+		host = c.Advertise
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return directory.Node{
+		ID:  uuid.New().String(),
+		URL: "ws://" + net.JoinHostPort(host, port) + "/v1/signal",
+	}
+}
 
-	// If PeerConnection is closed remove it from global list
-	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
-		switch p {
-		case webrtc.PeerConnectionStateFailed:
-			if err := peerConnection.Close(); err != nil {
-				log.Print(err)
-			}
-		case webrtc.PeerConnectionStateClosed:
-			signalPeerConnections()
+// joinSipCall sip呼叫以Call-ID为peer id加入会话, 挂断时离开. 会话在其他节点时拒绝(404).
+func joinSipCall(s *sfu.SFU, p usecase.Placement, c *sip.Call) error {
+	if p != nil {
+		placed, err := p.Join(context.Background(), c.Session())
+		if err != nil {
+			return fmt.Errorf("app - joinSipCall - p.Join: %w", err)
 		}
-	})
-
-	// remote track come.
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		// Create a track to fan out our incoming video to all peers
-		// trackLocal加入了所有peerConnection.
-		trackLocal := addTrack(t)
-		defer removeTrack(trackLocal)
-
-		buf := make([]byte, 1500)
-		for {
-			i, _, err := t.Read(buf)
-			if err != nil {
-				return
-			}
-
-			// receive from remote. send to local.
-			if _, err = trackLocal.Write(buf[:i]); err != nil {
-				return
-			}
+		if !placed.Local {
+			_ = p.Leave(context.Background(), c.Session())
+			return sip.ErrSessionNotFound
 		}
-	})
-
-	// Signal for the new PeerConnection
-	signalPeerConnections()
-
-	message := &websocketMessage{}
-	for {
-		_, raw, err := c.ReadMessage()
-		if err != nil {
-			log.Println(err)
-			return
-		} else if err := json.Unmarshal(raw, &message); err != nil {
-			log.Println(err)
+	}
+	leave := func() {
+		if p == nil {
 			return
 		}
-
-		switch message.Event {
-		case "candidate":
-			candidate := webrtc.ICECandidateInit{}
-			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := peerConnection.AddICECandidate(candidate); err != nil {
-				log.Println(err)
-				return
-			}
-		case "answer":
-			answer := webrtc.SessionDescription{}
-			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
-				log.Println(err)
-				return
-			}
+		if err := p.Leave(context.Background(), c.Session()); err != nil {
+			log.Error(err, "app - joinSipCall - p.Leave", "session", c.Session())
 		}
 	}
-}
 
-// Helper to make Gorilla Websockets threadsafe
-type threadSafeWriter struct {
-	*websocket.Conn
-	sync.Mutex
+	codec := c.Codec()
+	peer, err := sfu.NewRTPPeer(s, c.Session(), c.ID(), c.Leg(), webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/" + codec.Name, ClockRate: codec.ClockRate},
+		PayloadType:        webrtc.PayloadType(codec.PayloadType),
+	})
+	if err != nil {
+		leave()
+		return fmt.Errorf("app - joinSipCall - sfu.NewRTPPeer: %w", err)
+	}
+	// 通话中换编码(re-INVITE)不重新发布, 按接通时的编码转发.
+	go func() {
+		<-c.Done()
+		_ = peer.Close()
+		leave()
+	}()
+	return nil
 }
 
-// why lock?
-func (t *threadSafeWriter) WriteJSON(v interface{}) error {
-	t.Lock()
-	defer t.Unlock()
-
-	// WriteJSON safe?
-	return t.Conn.WriteJSON(v)
+// sip呼叫的话单, bill-id为Call-ID.
+func recordSipCall(uc usecase.Cdr, c *sip.Call, reason string) {
+	unixMs := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	stats := c.Stats()
+	err := uc.Record(context.Background(), entity.Cdr{
+		BillID:     c.ID(),
+		SessionID:  c.Session(),
+		StartTime:  unixMs(c.Created()),
+		AnswerTime: unixMs(c.Answered()),
+		EndTime:    unixMs(time.Now()),
+		Reason:     reason,
+		Legs: []entity.CdrLeg{{
+			LegID:   c.ID(),
+			Codec:   c.Codec().Name,
+			Packets: stats.Packets,
+			Bytes:   stats.Bytes,
+			Lost:    stats.Lost,
+			Jitter:  stats.Jitter,
+		}},
+	})
+	if err != nil {
+		log.Error(err, "app - recordSipCall - uc.Record", "call_id", c.ID())
+	}
 }
@@ -0,0 +1,71 @@
+//go:build migrate
+// +build migrate
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	// migrate tools
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const (
+	_defaultAttempts = 20
+	_defaultTimeout  = time.Second
+)
+
+// 使用 -tags migrate 编译时, 启动前把migrations/mysql应用到MYSQL_URL.
+func init() {
+	databaseURL, ok := os.LookupEnv("MYSQL_URL")
+	if !ok || len(databaseURL) == 0 {
+		log.Fatalf("migrate: environment variable not declared: MYSQL_URL")
+	}
+
+	// 多条语句的迁移文件需要multiStatements.
+	if strings.Contains(databaseURL, "?") {
+		databaseURL += "&multiStatements=true"
+	} else {
+		databaseURL += "?multiStatements=true"
+	}
+
+	var (
+		attempts = _defaultAttempts
+		err      error
+		m        *migrate.Migrate
+	)
+
+	for attempts > 0 {
+		m, err = migrate.New("file://migrations/mysql", "mysql://"+databaseURL)
+		if err == nil {
+			break
+		}
+
+		log.Printf("Migrate: mysql is trying to connect, attempts left: %d", attempts)
+		time.Sleep(_defaultTimeout)
+		attempts--
+	}
+
+	if err != nil {
+		log.Fatalf("Migrate: mysql connect error: %s", err)
+	}
+
+	err = m.Up()
+	defer m.Close()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		log.Fatalf("Migrate: up error: %s", err)
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		log.Printf("Migrate: no change")
+		return
+	}
+
+	log.Printf("Migrate: up success")
+}
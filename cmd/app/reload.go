@@ -0,0 +1,55 @@
+package main
+
+import (
+	log "common/log/newlog"
+	"mediasfu/config"
+	"mediasfu/pkg/logger"
+	sfu "mediasfu/pkg/webrtc"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 当前生效的配置, SIGHUP后替换为新的副本.
+var current atomic.Value
+
+func currentConfig() *config.Config {
+	return current.Load().(*config.Config)
+}
+
+// routerConfig 用于WebRTCTransportConfig.RouterFunc, 新发布的track读取热更新后的带宽上限和声音检测参数.
+func routerConfig() sfu.RouterConfig {
+	return currentConfig().Router
+}
+
+// watchReload 收到SIGHUP时重新读取配置文件, 只应用日志级别、关键帧间隔、带宽上限和声音检测参数.
+// router配置推送到node上已有的会话.
+func watchReload(path string, keyframe *time.Ticker, node *sfu.SFU) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		cur := currentConfig()
+		next, restart, err := config.Reload(path, cur)
+		if err != nil {
+			log.Error(err, "app - watchReload - config.Reload", "path", path)
+			continue
+		}
+		if restart {
+			log.Warn("app - watchReload - structural settings changed, restart to apply them", "path", path)
+		}
+
+		if next.Log.Level != cur.Log.Level {
+			logger.Init(next.Log.Level)
+		}
+		if next.SFU.KeyframeInterval > 0 && next.SFU.KeyframeInterval != cur.SFU.KeyframeInterval {
+			keyframe.Reset(next.SFU.KeyframeInterval)
+		}
+		current.Store(next)
+		if next.Router != cur.Router {
+			node.Reload()
+		}
+		log.Info("app - watchReload - config reloaded", "path", path, "log_level", next.Log.Level)
+	}
+}
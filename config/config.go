@@ -0,0 +1,143 @@
+// Package config loads the app config from a yaml or toml file and environment variables.
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+
+	sfu "mediasfu/pkg/webrtc"
+)
+
+// DefaultPath -.
+const DefaultPath = "./config/config.yml"
+
+type (
+	// Config -.
+	Config struct {
+		App     `yaml:"app" toml:"app"`
+		HTTP    `yaml:"http" toml:"http"`
+		Log     `yaml:"logger" toml:"logger"`
+		SFU     `yaml:"sfu" toml:"sfu"`
+		Router  sfu.RouterConfig `yaml:"router" toml:"router"`
+		Turn    sfu.TurnConfig   `yaml:"turn" toml:"turn"`
+		RTP     `yaml:"rtp" toml:"rtp"`
+		SIP     `yaml:"sip" toml:"sip"`
+		RMQ     `yaml:"rabbitmq" toml:"rabbitmq"`
+		MySQL   `yaml:"mysql" toml:"mysql"`
+		Cluster `yaml:"cluster" toml:"cluster"`
+	}
+
+	// App -.
+	App struct {
+		Name    string `env-required:"true" yaml:"name" toml:"name" env:"APP_NAME"`
+		Version string `env-required:"true" yaml:"version" toml:"version" env:"APP_VERSION"`
+	}
+
+	// HTTP -.
+	HTTP struct {
+		Addr      string `env-default:":8080" yaml:"addr" toml:"addr" env:"HTTP_ADDR"`
+		Advertise string `yaml:"advertise" toml:"advertise" env:"HTTP_ADVERTISE"`
+		Cert      string `yaml:"cert" toml:"cert" env:"HTTP_CERT"`
+		Key       string `yaml:"key" toml:"key" env:"HTTP_KEY"`
+	}
+
+	// Log -.
+	Log struct {
+		Level string `env-default:"info" yaml:"log_level" toml:"log_level" env:"LOG_LEVEL"`
+	}
+
+	// SFU ice和关键帧设置.
+	SFU struct {
+		KeyframeInterval time.Duration `env-default:"3s" yaml:"keyframe_interval" toml:"keyframe_interval" env:"SFU_KEYFRAME_INTERVAL"`
+		BufferSize       int           `env-default:"500" yaml:"buffer_size" toml:"buffer_size"`
+		AudioBufferSize  int           `env-default:"25" yaml:"audio_buffer_size" toml:"audio_buffer_size"`
+		BufferMemory     int64         `yaml:"buffer_memory" toml:"buffer_memory" env:"SFU_BUFFER_MEMORY"` // MB, 0不限制
+		BufferOrphan     time.Duration `env-default:"30s" yaml:"buffer_orphan_timeout" toml:"buffer_orphan_timeout"`
+		ICEServers       []ICEServer   `yaml:"ice_servers" toml:"ice_servers"`
+		ICEPortRange     []uint16      `yaml:"ice_port_range" toml:"ice_port_range"`
+		NAT1To1IPs       []string      `yaml:"nat1to1_ips" toml:"nat1to1_ips" env:"SFU_NAT1TO1_IPS"`
+		QualityInterval  time.Duration `env-default:"5s" yaml:"quality_interval" toml:"quality_interval"`
+		QualityThreshold float64       `env-default:"3" yaml:"quality_threshold" toml:"quality_threshold"`
+	}
+
+	// ICEServer -.
+	ICEServer struct {
+		URLs       []string `yaml:"urls" toml:"urls"`
+		Username   string   `yaml:"username" toml:"username"`
+		Credential string   `yaml:"credential" toml:"credential"`
+	}
+
+	// RTP rtp媒体中继和ng控制协议.
+	RTP struct {
+		IP           string        `yaml:"ip" toml:"ip" env:"RTP_IP"`
+		Advertise    string        `yaml:"advertise" toml:"advertise" env:"RTP_ADVERTISE"`
+		MinPort      int           `yaml:"min_port" toml:"min_port" env:"RTP_MIN_PORT"`
+		MaxPort      int           `yaml:"max_port" toml:"max_port" env:"RTP_MAX_PORT"`
+		MediaTimeout time.Duration `yaml:"media_timeout" toml:"media_timeout" env:"RTP_MEDIA_TIMEOUT" env-default:"1m"`
+		NGAddr       string        `yaml:"ng_addr" toml:"ng_addr" env:"RTP_NG_ADDR"`
+	}
+
+	// SIP 内置sip ua, 地址为空不启动.
+	SIP struct {
+		UDPAddr string `yaml:"udp_addr" toml:"udp_addr" env:"SIP_UDP_ADDR"`
+		TCPAddr string `yaml:"tcp_addr" toml:"tcp_addr" env:"SIP_TCP_ADDR"`
+	}
+
+	// RMQ 呼叫控制rpc和媒体事件, url为空不启动.
+	RMQ struct {
+		URL            string `yaml:"url" toml:"url" env:"RMQ_URL"`
+		ServerExchange string `env-default:"rpc_server" yaml:"rpc_server_exchange" toml:"rpc_server_exchange" env:"RMQ_RPC_SERVER"`
+		ClientExchange string `env-default:"rpc_client" yaml:"rpc_client_exchange" toml:"rpc_client_exchange" env:"RMQ_RPC_CLIENT"`
+		EventsExchange string `env-default:"media.events" yaml:"events_exchange" toml:"events_exchange" env:"RMQ_EVENTS_EXCHANGE"`
+	}
+
+	// MySQL 话单数据库, url为空不记录话单.
+	MySQL struct {
+		URL string `yaml:"url" toml:"url" env:"MYSQL_URL"`
+	}
+
+	// Cluster 节点注册和会话目录.
+	Cluster struct {
+		Register  bool     `yaml:"register" toml:"register" env:"CLUSTER_REGISTER"`
+		Directory string   `yaml:"directory" toml:"directory" env:"CLUSTER_DIRECTORY"`
+		Etcd      []string `yaml:"etcd" toml:"etcd" env:"CLUSTER_ETCD" env-default:"127.0.0.1:2379"`
+		Redis     string   `yaml:"redis" toml:"redis" env:"CLUSTER_REDIS" env-default:"127.0.0.1:6379"`
+		JoinMode  string   `yaml:"join_mode" toml:"join_mode" env:"CLUSTER_JOIN_MODE" env-default:"redirect"`
+	}
+)
+
+// NewConfig returns app config, the format of path is decided by its extension(.yml, .yaml or .toml).
+func NewConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	// ReadConfig 读取文件后再读取环境变量, 环境变量优先.
+	err := cleanenv.ReadConfig(path, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Reload 重新读取path, 只把可热更新的字段(日志级别、关键帧间隔、带宽上限、声音检测参数)合并到cur的副本.
+// 监听地址、端口范围等结构性字段的修改不生效, restart为true提示需要重启.
+func Reload(path string, cur *Config) (next *Config, restart bool, err error) {
+	loaded, err := NewConfig(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c := *cur
+	c.Log.Level = loaded.Log.Level
+	c.SFU.KeyframeInterval = loaded.SFU.KeyframeInterval
+	c.Router.MaxBandwidth = loaded.Router.MaxBandwidth
+	c.Router.AudioLevelInterval = loaded.Router.AudioLevelInterval
+	c.Router.AudioLevelThreshold = loaded.Router.AudioLevelThreshold
+	c.Router.AudioLevelFilter = loaded.Router.AudioLevelFilter
+	c.Router.AudioLevelEgress = loaded.Router.AudioLevelEgress
+
+	return &c, !reflect.DeepEqual(&c, loaded), nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `app:
+  name: mediasfu
+  version: 1.0.0
+http:
+  addr: ":8080"
+logger:
+  log_level: info
+sfu:
+  keyframe_interval: 3s
+router:
+  maxbandwidth: 1500
+  audiolevelthreshold: 40
+rtp:
+  min_port: 20000
+  max_port: 30000
+`
+
+func writeConfig(t *testing.T, path, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig(t, path, testConfig)
+	cur, err := NewConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1500), cur.Router.MaxBandwidth)
+
+	// 只修改可热更新的字段.
+	writeConfig(t, path, `app:
+  name: mediasfu
+  version: 1.0.0
+http:
+  addr: ":8080"
+logger:
+  log_level: debug
+sfu:
+  keyframe_interval: 5s
+router:
+  maxbandwidth: 3000
+  audiolevelthreshold: 60
+  audiolevelegress: true
+rtp:
+  min_port: 20000
+  max_port: 30000
+`)
+	next, restart, err := Reload(path, cur)
+	require.NoError(t, err)
+	assert.False(t, restart)
+	assert.Equal(t, "debug", next.Log.Level)
+	assert.Equal(t, 5*time.Second, next.SFU.KeyframeInterval)
+	assert.Equal(t, uint64(3000), next.Router.MaxBandwidth)
+	assert.Equal(t, uint8(60), next.Router.AudioLevelThreshold)
+	assert.True(t, next.Router.AudioLevelEgress)
+	// cur不变.
+	assert.Equal(t, "info", cur.Log.Level)
+	assert.Equal(t, uint64(1500), cur.Router.MaxBandwidth)
+
+	// 结构性字段不生效, 提示重启.
+	writeConfig(t, path, testConfig+"  ng_addr: 127.0.0.1:22222\n")
+	next, restart, err = Reload(path, next)
+	require.NoError(t, err)
+	assert.True(t, restart)
+	assert.Empty(t, next.RTP.NGAddr)
+	assert.Equal(t, uint64(1500), next.Router.MaxBandwidth)
+
+	_, _, err = Reload(filepath.Join(t.TempDir(), "missing.yml"), cur)
+	assert.Error(t, err)
+}
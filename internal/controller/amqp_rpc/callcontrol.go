@@ -0,0 +1,206 @@
+package amqprpc
+
+import (
+	"common/broker"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase"
+	"mediasfu/pkg/rabbitmq/rmq_rpc/server"
+)
+
+type callControlRoutes struct {
+	callControl usecase.CallControl
+}
+
+func newCallControlRoutes(routes map[string]server.CallHandler, cc usecase.CallControl) {
+	r := &callControlRoutes{cc}
+	{
+		routes["createSession"] = r.createSession()
+		routes["createRtpLeg"] = r.createRtpLeg()
+		routes["answerRtpLeg"] = r.answerRtpLeg()
+		routes["bridge"] = r.bridge()
+		routes["unbridge"] = r.unbridge()
+		routes["play"] = r.play()
+		routes["record"] = r.record()
+		routes["hangup"] = r.hangup()
+		routes["query"] = r.query()
+	}
+}
+
+type sessionRequest struct {
+	SessionID string `json:"sessionId"`
+}
+
+type sessionResponse struct {
+	Session entity.CallSession `json:"session"`
+}
+
+type legRequest struct {
+	SessionID string `json:"sessionId"`
+	LegID     string `json:"legId"`
+	SDP       string `json:"sdp"`
+}
+
+type legResponse struct {
+	Leg entity.RtpLeg `json:"leg"`
+	SDP string        `json:"sdp,omitempty"`
+}
+
+type bridgeRequest struct {
+	LegA string `json:"legA"`
+	LegB string `json:"legB"`
+}
+
+type playRequest struct {
+	LegID string `json:"legId"`
+	// File 为空时停止放音.
+	File   string `json:"file"`
+	Repeat int    `json:"repeat"`
+}
+
+type playResponse struct {
+	Duration int64 `json:"duration"` // ms
+}
+
+type recordRequest struct {
+	LegID string `json:"legId"`
+	Start bool   `json:"start"`
+}
+
+type resultResponse struct {
+	Result string `json:"result"`
+}
+
+var ok = resultResponse{Result: "ok"}
+
+func decode(d *broker.Message, v interface{}) error {
+	if err := json.Unmarshal(d.Body, v); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return nil
+}
+
+func (r *callControlRoutes) createSession() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request sessionRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - createSession - %w", err)
+		}
+		session, err := r.callControl.CreateSession(context.Background(), request.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - createSession - r.callControl.CreateSession: %w", err)
+		}
+		return sessionResponse{session}, nil
+	}
+}
+
+func (r *callControlRoutes) createRtpLeg() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request legRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - createRtpLeg - %w", err)
+		}
+		leg, sdp, err := r.callControl.CreateRtpLeg(context.Background(), request.SessionID, request.LegID, request.SDP)
+		if err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - createRtpLeg - r.callControl.CreateRtpLeg: %w", err)
+		}
+		return legResponse{leg, sdp}, nil
+	}
+}
+
+func (r *callControlRoutes) answerRtpLeg() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request legRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - answerRtpLeg - %w", err)
+		}
+		leg, err := r.callControl.AnswerRtpLeg(context.Background(), request.LegID, request.SDP)
+		if err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - answerRtpLeg - r.callControl.AnswerRtpLeg: %w", err)
+		}
+		return legResponse{Leg: leg}, nil
+	}
+}
+
+func (r *callControlRoutes) bridge() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request bridgeRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - bridge - %w", err)
+		}
+		if err := r.callControl.Bridge(context.Background(), request.LegA, request.LegB); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - bridge - r.callControl.Bridge: %w", err)
+		}
+		return ok, nil
+	}
+}
+
+func (r *callControlRoutes) unbridge() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request legRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - unbridge - %w", err)
+		}
+		if err := r.callControl.Unbridge(context.Background(), request.LegID); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - unbridge - r.callControl.Unbridge: %w", err)
+		}
+		return ok, nil
+	}
+}
+
+func (r *callControlRoutes) play() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request playRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - play - %w", err)
+		}
+		duration, err := r.callControl.Play(context.Background(), request.LegID, request.File, request.Repeat)
+		if err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - play - r.callControl.Play: %w", err)
+		}
+		return playResponse{duration.Milliseconds()}, nil
+	}
+}
+
+func (r *callControlRoutes) record() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request recordRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - record - %w", err)
+		}
+		if err := r.callControl.Record(context.Background(), request.LegID, request.Start); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - record - r.callControl.Record: %w", err)
+		}
+		return ok, nil
+	}
+}
+
+func (r *callControlRoutes) hangup() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request legRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - hangup - %w", err)
+		}
+		if err := r.callControl.Hangup(context.Background(), request.SessionID, request.LegID); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - hangup - r.callControl.Hangup: %w", err)
+		}
+		return ok, nil
+	}
+}
+
+func (r *callControlRoutes) query() server.CallHandler {
+	return func(d *broker.Message) (interface{}, error) {
+		var request sessionRequest
+		if err := decode(d, &request); err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - query - %w", err)
+		}
+		session, err := r.callControl.Query(context.Background(), request.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("amqp_rpc - callControlRoutes - query - r.callControl.Query: %w", err)
+		}
+		return sessionResponse{session}, nil
+	}
+}
@@ -0,0 +1,17 @@
+// Package amqprpc implements the rabbitmq rpc routes, the handler is selected by the "type" header.
+package amqprpc
+
+import (
+	"mediasfu/internal/usecase"
+	"mediasfu/pkg/rabbitmq/rmq_rpc/server"
+)
+
+// NewRouter -.
+func NewRouter(cc usecase.CallControl) map[string]server.CallHandler {
+	routes := make(map[string]server.CallHandler)
+	{
+		newCallControlRoutes(routes, cc)
+	}
+
+	return routes
+}
@@ -10,6 +10,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+
+	"mediasfu/internal/usecase"
+	"mediasfu/pkg/signal"
+	"mediasfu/pkg/sip"
+	sfu "mediasfu/pkg/webrtc"
 	// Swagger docs.
 )
 
@@ -20,7 +25,7 @@ import (
 // @version     1.0
 // @host        localhost:8080
 // @BasePath    /v1
-func NewRouter(handler *gin.Engine, upgrader *websocket.Upgrader, l log.Logger) {
+func NewRouter(handler *gin.Engine, upgrader *websocket.Upgrader, ua *sip.UA, s *sfu.SFU, p usecase.Placement, hub *signal.Hub, cdr usecase.Cdr, a usecase.SfuAdmin, l log.Logger) {
 	// Options
 	handler.Use(gin.Logger())
 	handler.Use(gin.Recovery())
@@ -38,6 +43,14 @@ func NewRouter(handler *gin.Engine, upgrader *websocket.Upgrader, l log.Logger)
 	// Routers:
 	h := handler.Group("/v1")
 	{
-		newTranslationRoutes(h, upgrader, l)
+		if ua != nil {
+			newSipRoutes(h, ua, l)
+		}
+		if s != nil {
+			newSignalRoutes(h, upgrader, s, p, hub, cdr, l)
+		}
+		if a != nil {
+			newSfuRoutes(h, a, l)
+		}
 	}
 }
@@ -0,0 +1,210 @@
+package v1
+
+import (
+	log "common/log/newlog"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase"
+	"mediasfu/internal/usecase/sfuadmin"
+)
+
+type sfuRoutes struct {
+	l  log.Logger
+	uc usecase.SfuAdmin
+}
+
+func newSfuRoutes(handler *gin.RouterGroup, uc usecase.SfuAdmin, l log.Logger) {
+	r := &sfuRoutes{l, uc}
+
+	h := handler.Group("/sessions")
+	{
+		h.GET("", r.sessions)
+		h.GET("/:id", r.session)
+		h.GET("/:id/peers/:peer", r.peer)
+		h.GET("/:id/peers/:peer/quality", r.quality)
+		h.DELETE("/:id/peers/:peer", r.kick)
+		h.POST("/:id/peers/:peer/downtracks/:track/mute", r.mute)
+		h.POST("/:id/peers/:peer/downtracks/:track/layers", r.layers)
+	}
+}
+
+type sfuSessionsResponse struct {
+	Sessions []entity.SfuSession `json:"sessions"`
+}
+
+// @Summary     Show sessions
+// @Description Show all webrtc sessions of this node with their peers and tracks
+// @ID          sfu-sessions
+// @Tags  	    sfu
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} sfuSessionsResponse
+// @Router      /sessions [get]
+func (r *sfuRoutes) sessions(c *gin.Context) {
+	c.JSON(http.StatusOK, sfuSessionsResponse{Sessions: r.uc.Sessions(c.Request.Context())})
+}
+
+// @Summary     Show a session
+// @Description Show the peers of a session, their publisher tracks, subscriber down tracks and ice state
+// @ID          sfu-session
+// @Tags  	    sfu
+// @Accept      json
+// @Produce     json
+// @Param       id path string true "Session id"
+// @Success     200 {object} entity.SfuSession
+// @Failure     404 {object} response
+// @Router      /sessions/{id} [get]
+func (r *sfuRoutes) session(c *gin.Context) {
+	s, err := r.uc.Session(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		r.errorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// @Summary     Show a peer
+// @Description Show the publisher tracks, subscriber down tracks and ice state of a peer
+// @ID          sfu-peer
+// @Tags  	    sfu
+// @Accept      json
+// @Produce     json
+// @Param       id   path string true "Session id"
+// @Param       peer path string true "Peer id"
+// @Success     200 {object} entity.SfuPeer
+// @Failure     404 {object} response
+// @Router      /sessions/{id}/peers/{peer} [get]
+func (r *sfuRoutes) peer(c *gin.Context) {
+	p, err := r.uc.Peer(c.Request.Context(), c.Param("id"), c.Param("peer"))
+	if err != nil {
+		r.errorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+type sfuQualityResponse struct {
+	Samples []entity.SfuQuality `json:"samples"`
+}
+
+// @Summary     Show peer quality
+// @Description Show the sampled MOS of a peer, oldest first. MOS is 1-5, 0 means no media in that direction
+// @ID          sfu-quality
+// @Tags  	    sfu
+// @Accept      json
+// @Produce     json
+// @Param       id   path string true "Session id"
+// @Param       peer path string true "Peer id"
+// @Success     200 {object} sfuQualityResponse
+// @Failure     404 {object} response
+// @Router      /sessions/{id}/peers/{peer}/quality [get]
+func (r *sfuRoutes) quality(c *gin.Context) {
+	samples, err := r.uc.Quality(c.Request.Context(), c.Param("id"), c.Param("peer"))
+	if err != nil {
+		r.errorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sfuQualityResponse{Samples: samples})
+}
+
+// @Summary     Kick a peer
+// @Description Remove the peer from the session and close its peer connections
+// @ID          sfu-kick
+// @Tags  	    sfu
+// @Param       id   path string true "Session id"
+// @Param       peer path string true "Peer id"
+// @Success     204
+// @Failure     404 {object} response
+// @Router      /sessions/{id}/peers/{peer} [delete]
+func (r *sfuRoutes) kick(c *gin.Context) {
+	if err := r.uc.Kick(c.Request.Context(), c.Param("id"), c.Param("peer")); err != nil {
+		r.errorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type sfuMuteRequest struct {
+	Mute *bool `json:"mute" binding:"required" example:"true"`
+}
+
+// @Summary     Mute a down track
+// @Description Mute or unmute the down tracks of track id subscribed by the peer
+// @ID          sfu-mute
+// @Tags  	    sfu
+// @Accept      json
+// @Param       id      path string         true "Session id"
+// @Param       peer    path string         true "Peer id"
+// @Param       track   path string         true "Track id"
+// @Param       request body sfuMuteRequest true "Mute or unmute"
+// @Success     204
+// @Failure     400 {object} response
+// @Failure     404 {object} response
+// @Router      /sessions/{id}/peers/{peer}/downtracks/{track}/mute [post]
+func (r *sfuRoutes) mute(c *gin.Context) {
+	var request sfuMuteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(err, "http - v1 - mute")
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	err := r.uc.Mute(c.Request.Context(), c.Param("id"), c.Param("peer"), c.Param("track"), *request.Mute)
+	if err != nil {
+		r.errorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type sfuLayersRequest struct {
+	Spatial  *int32 `json:"spatial"  binding:"required,min=0,max=7" example:"1"`
+	Temporal *int32 `json:"temporal" binding:"required,min=0,max=7" example:"2"`
+}
+
+// @Summary     Set down track layers
+// @Description Set the highest spatial and temporal layer of a video down track forwarded, the layers come from vp9, av1 dependency descriptor or frame marking to the peer
+// @ID          sfu-layers
+// @Tags  	    sfu
+// @Accept      json
+// @Param       id      path string           true "Session id"
+// @Param       peer    path string           true "Peer id"
+// @Param       track   path string           true "Track id"
+// @Param       request body sfuLayersRequest true "Target layers"
+// @Success     204
+// @Failure     400 {object} response
+// @Failure     404 {object} response
+// @Router      /sessions/{id}/peers/{peer}/downtracks/{track}/layers [post]
+func (r *sfuRoutes) layers(c *gin.Context) {
+	var request sfuLayersRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(err, "http - v1 - layers")
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	err := r.uc.Layers(c.Request.Context(), c.Param("id"), c.Param("peer"), c.Param("track"), *request.Spatial, *request.Temporal)
+	if err != nil {
+		r.errorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (r *sfuRoutes) errorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sfuadmin.ErrNotScalable):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, sfuadmin.ErrSessionNotFound),
+		errors.Is(err, sfuadmin.ErrPeerNotFound),
+		errors.Is(err, sfuadmin.ErrTrackNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	default:
+		r.l.Error(err, "http - v1 - sfu")
+		errorResponse(c, http.StatusInternalServerError, "sfu problems")
+	}
+}
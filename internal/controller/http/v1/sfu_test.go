@@ -0,0 +1,92 @@
+package v1
+
+import (
+	log "common/log/newlog"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase/sfuadmin"
+	sfu "mediasfu/pkg/webrtc"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// newTestSfu 会话s1中有两个peer, 没有媒体.
+func newTestSfu(t *testing.T) (*gin.Engine, *sfu.SFU) {
+	gin.SetMode(gin.TestMode)
+	node := sfu.NewSFU(sfu.WebRTCTransportConfig{
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+	})
+	for _, id := range []string{"p1", "p2"} {
+		peer := sfu.NewPeer(node)
+		require.NoError(t, peer.Join("s1", id, false))
+		t.Cleanup(func() { _ = peer.Close() })
+	}
+
+	handler := gin.New()
+	newSfuRoutes(handler.Group("/v1"), sfuadmin.New(node, nil, log.GetLogger()), log.GetLogger())
+	return handler, node
+}
+
+func serve(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestSfuRoutes_Sessions(t *testing.T) {
+	handler, _ := newTestSfu(t)
+
+	w := serve(handler, http.MethodGet, "/v1/sessions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var sessions sfuSessionsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+	require.Len(t, sessions.Sessions, 1)
+	assert.Equal(t, "s1", sessions.Sessions[0].ID)
+	assert.Len(t, sessions.Sessions[0].Peers, 2)
+
+	w = serve(handler, http.MethodGet, "/v1/sessions/s1/peers/p1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var peer entity.SfuPeer
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &peer))
+	assert.Equal(t, "p1", peer.ID)
+	assert.Equal(t, "new", peer.PublisherICE)
+
+	w = serve(handler, http.MethodGet, "/v1/sessions/s1/peers/p1/quality", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/v1/sessions/s2", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/v1/sessions/s1/peers/p3", "").Code)
+}
+
+func TestSfuRoutes_Kick(t *testing.T) {
+	handler, node := newTestSfu(t)
+
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodDelete, "/v1/sessions/s1/peers/p1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/v1/sessions/s1/peers/p1", "").Code)
+
+	// 最后一个peer离开后会话被注销.
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodDelete, "/v1/sessions/s1/peers/p2", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/v1/sessions/s1", "").Code)
+	assert.Empty(t, node.Sessions())
+}
+
+func TestSfuRoutes_DownTracks(t *testing.T) {
+	handler, _ := newTestSfu(t)
+
+	w := serve(handler, http.MethodPost, "/v1/sessions/s1/peers/p1/downtracks/audio/mute", `{"mute":true}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(handler, http.MethodPost, "/v1/sessions/s1/peers/p1/downtracks/audio/mute", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(handler, http.MethodPost, "/v1/sessions/s1/peers/p1/downtracks/video/layers", `{"spatial":1,"temporal":9}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
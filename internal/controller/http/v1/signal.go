@@ -0,0 +1,139 @@
+package v1
+
+import (
+	log "common/log/newlog"
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase"
+	"mediasfu/pkg/signal"
+	sfu "mediasfu/pkg/webrtc"
+)
+
+type signalRoutes struct {
+	l         log.Logger
+	u         *websocket.Upgrader
+	sfu       *sfu.SFU
+	placement usecase.Placement
+	hub       *signal.Hub
+	cdr       usecase.Cdr
+}
+
+// newSignalRoutes placement为空时所有会话都在本节点, hub和rtpengine共用, 会话的E2EE模式以它为准.
+// cdr为空时不记话单, 否则本节点加入的peer按join的id计费.
+func newSignalRoutes(handler *gin.RouterGroup, upgrader *websocket.Upgrader, s *sfu.SFU, p usecase.Placement, hub *signal.Hub, cdr usecase.Cdr, l log.Logger) {
+	r := &signalRoutes{l, upgrader, s, p, hub, cdr}
+
+	handler.GET("/signal", r.signal)
+}
+
+// signal json信令, join时按会话目录在本节点加入、重定向或中继到持有节点.
+// 本节点加入时一个连接对应sfu会话中的一个peer, 上行和下行各一个pc.
+func (r *signalRoutes) signal(c *gin.Context) {
+	conn, err := r.u.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		r.l.Error(err, "http - v1 - signal - upgrade")
+		return
+	}
+
+	s := signal.NewSignal(conn, nil)
+	peer := sfu.NewPeer(r.sfu)
+	var joined []string
+	// 密钥交换消息在peer加入的会话中转发.
+	var hubSession, hubPeer string
+	s.OnJoin = func(join *signal.JoinMessage) error {
+		sessionID := join.Session
+		if r.placement != nil {
+			p, err := r.placement.Join(context.Background(), join.Session)
+			if err != nil {
+				return err
+			}
+			switch {
+			case p.Local:
+				joined = append(joined, p.SessionID)
+				sessionID = p.SessionID
+			case p.Mode == entity.PlacementRelay:
+				return s.Relay(p.URL, join)
+			default:
+				return s.Redirect(p.SessionID, p.URL)
+			}
+		}
+
+		if err := r.hub.Join(sessionID, join.Id, join.E2EE, s); err != nil {
+			return err
+		}
+		if err := peer.Join(sessionID, join.Id, r.hub.E2EE(sessionID)); err != nil {
+			r.hub.Leave(sessionID, join.Id)
+			return err
+		}
+		hubSession, hubPeer = sessionID, join.Id
+		if r.cdr != nil {
+			r.cdr.Start(join.Id, sessionID)
+		}
+
+		answer, err := peer.Answer(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: join.SDP})
+		if err != nil {
+			return err
+		}
+		if r.cdr != nil {
+			r.cdr.Answer(join.Id)
+		}
+		return s.SendObject(answer)
+	}
+
+	// 下行重协商和服务端候选通过信令发给客户端.
+	peer.OnOffer = func(offer *webrtc.SessionDescription) {
+		if err := s.Offer(offer); err != nil {
+			r.l.Error(err, "http - v1 - signal - Offer", "peer", peer.ID())
+		}
+	}
+	peer.OnIceCandidate = func(candidate *webrtc.ICECandidateInit, target int) {
+		if err := s.Trickle(candidate, target); err != nil {
+			r.l.Error(err, "http - v1 - signal - Trickle", "peer", peer.ID())
+		}
+	}
+	s.OnNegotiate = func(offer *webrtc.SessionDescription) error {
+		answer, err := peer.Answer(*offer)
+		if err != nil {
+			return err
+		}
+		return s.SendObject(answer)
+	}
+	s.OnSetRemoteSDP = func(answer *webrtc.SessionDescription) error {
+		return peer.SetRemoteDescription(*answer)
+	}
+	s.OnTrickle = func(candidate *webrtc.ICECandidateInit, target int) {
+		if err := peer.Trickle(*candidate, target); err != nil {
+			r.l.Error(err, "http - v1 - signal - peer.Trickle", "peer", peer.ID())
+		}
+	}
+
+	s.OnKey = func(key *signal.KeyMessage) error {
+		return r.hub.RelayKey(hubSession, hubPeer, key)
+	}
+
+	go s.WriteWebrtcMessageLoop()
+	s.SignalMessageLoop()
+	if hubSession != "" {
+		r.hub.Leave(hubSession, hubPeer)
+		if r.cdr != nil {
+			if err = r.cdr.End(context.Background(), hubPeer, "leave"); err != nil {
+				r.l.Error(err, "http - v1 - signal - cdr.End", "session", hubSession, "peer", hubPeer)
+			}
+		}
+	}
+	if err = peer.Close(); err != nil {
+		r.l.Error(err, "http - v1 - signal - peer.Close", "peer", peer.ID())
+	}
+	s.Close()
+
+	for _, session := range joined {
+		if err = r.placement.Leave(context.Background(), session); err != nil {
+			r.l.Error(err, "http - v1 - signal - Leave", "session", session)
+		}
+	}
+}
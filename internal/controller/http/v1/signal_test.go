@@ -0,0 +1,94 @@
+package v1
+
+import (
+	log "common/log/newlog"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/internal/entity"
+	"mediasfu/pkg/signal"
+	sfu "mediasfu/pkg/webrtc"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// testCdr 记录话单调用, 按调用顺序.
+type testCdr struct {
+	sync.Mutex
+	calls []string
+}
+
+func (c *testCdr) add(call string) {
+	c.Lock()
+	c.calls = append(c.calls, call)
+	c.Unlock()
+}
+
+func (c *testCdr) Start(billID, sessionID string) { c.add("start " + billID + " " + sessionID) }
+
+func (c *testCdr) Answer(billID string) { c.add("answer " + billID) }
+
+func (c *testCdr) End(ctx context.Context, billID, reason string, legs ...entity.CdrLeg) error {
+	c.add("end " + billID + " " + reason)
+	return nil
+}
+
+func (c *testCdr) Record(ctx context.Context, cdr entity.Cdr) error {
+	c.add("record " + cdr.BillID)
+	return nil
+}
+
+func (c *testCdr) recorded() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func TestSignalRoutes_Cdr(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	node := sfu.NewSFU(sfu.WebRTCTransportConfig{
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+	})
+	cdr := &testCdr{}
+	handler := gin.New()
+	newSignalRoutes(handler.Group("/v1"), &websocket.Upgrader{}, node, nil, signal.NewHub(), cdr, log.GetLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer pc.Close()
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio)
+	require.NoError(t, err)
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/signal", nil)
+	require.NoError(t, err)
+	data, err := json.Marshal(signal.JoinMessage{Session: "s1", Id: "bill-1", SDP: offer.SDP})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteJSON(signal.WebsocketMessage{Event: signal.MessageTypeJoin, Data: data}))
+
+	// 收到应答后peer已经在会话中.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"start bill-1 s1", "answer bill-1"}, cdr.recorded())
+
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		return len(cdr.recorded()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "end bill-1 leave", cdr.recorded()[2])
+	assert.Empty(t, node.Sessions())
+}
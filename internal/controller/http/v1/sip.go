@@ -0,0 +1,134 @@
+package v1
+
+import (
+	log "common/log/newlog"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediasfu/pkg/sip"
+)
+
+const sipInviteTimeout = 60 * time.Second
+
+type sipRoutes struct {
+	l  log.Logger
+	ua *sip.UA
+}
+
+func newSipRoutes(handler *gin.RouterGroup, ua *sip.UA, l log.Logger) {
+	r := &sipRoutes{l, ua}
+
+	h := handler.Group("/sip")
+	{
+		h.GET("/calls", r.calls)
+		h.POST("/calls", r.invite)
+		h.DELETE("/calls/:id", r.hangup)
+	}
+}
+
+type sipCall struct {
+	ID        string `json:"id"         example:"3c26d1a5b2f0"`
+	Session   string `json:"session"    example:"room1"`
+	Direction string `json:"direction"  example:"outbound"`
+	Remote    string `json:"remote"     example:"sip:alice@10.0.0.2"`
+	State     string `json:"state"      example:"confirmed"`
+	Codec     string `json:"codec"      example:"PCMU"`
+	Held      bool   `json:"held"       example:"false"`
+	LocalPort int    `json:"local_port" example:"30000"`
+}
+
+func toSipCall(c *sip.Call) sipCall {
+	return sipCall{
+		ID:        c.ID(),
+		Session:   c.Session(),
+		Direction: c.Direction(),
+		Remote:    c.RemoteURI(),
+		State:     c.State().String(),
+		Codec:     c.Codec().Name,
+		Held:      c.Held(),
+		LocalPort: c.Leg().LocalPort(),
+	}
+}
+
+type sipCallsResponse struct {
+	Calls []sipCall `json:"calls"`
+}
+
+// @Summary     Show sip calls
+// @Description Show all active sip calls
+// @ID          sip-calls
+// @Tags  	    sip
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} sipCallsResponse
+// @Router      /sip/calls [get]
+func (r *sipRoutes) calls(c *gin.Context) {
+	calls := r.ua.Calls()
+	resp := sipCallsResponse{Calls: make([]sipCall, 0, len(calls))}
+	for _, call := range calls {
+		resp.Calls = append(resp.Calls, toSipCall(call))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type sipInviteRequest struct {
+	Target  string `json:"target"  binding:"required" example:"sip:alice@10.0.0.2:5060"`
+	Session string `json:"session" binding:"required" example:"room1"`
+}
+
+// @Summary     Place a sip call
+// @Description Send an INVITE to target and join the answered call to session
+// @ID          sip-invite
+// @Tags  	    sip
+// @Accept      json
+// @Produce     json
+// @Param       request body sipInviteRequest true "Call target"
+// @Success     200 {object} sipCall
+// @Failure     400 {object} response
+// @Failure     502 {object} response
+// @Router      /sip/calls [post]
+func (r *sipRoutes) invite(c *gin.Context) {
+	var request sipInviteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(err, "http - v1 - invite")
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), sipInviteTimeout)
+	defer cancel()
+	call, err := r.ua.Invite(ctx, request.Target, request.Session)
+	if err != nil {
+		r.l.Error(err, "http - v1 - invite", "target", request.Target)
+		var statusErr *sip.StatusError
+		if errors.As(err, &statusErr) {
+			errorResponse(c, http.StatusBadGateway, statusErr.Error())
+			return
+		}
+		errorResponse(c, http.StatusBadGateway, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toSipCall(call))
+}
+
+// @Summary     Hang up a sip call
+// @Description Send BYE, or CANCEL when the call is not answered yet
+// @ID          sip-hangup
+// @Tags  	    sip
+// @Param       id path string true "Call-ID"
+// @Success     204
+// @Failure     404 {object} response
+// @Router      /sip/calls/{id} [delete]
+func (r *sipRoutes) hangup(c *gin.Context) {
+	call := r.ua.Call(c.Param("id"))
+	if call == nil {
+		errorResponse(c, http.StatusNotFound, "call not found")
+		return
+	}
+	_ = call.Hangup()
+	c.Status(http.StatusNoContent)
+}
@@ -1,242 +0,0 @@
-package v1
-
-import (
-	log "common/log/newlog"
-	"encoding/json"
-	"github.com/gorilla/websocket"
-	"github.com/pion/webrtc/v3"
-	"io/ioutil"
-	"net/http"
-	"sync"
-	"text/template"
-
-	"github.com/gin-gonic/gin"
-
-	"mediasfu/internal/usecase"
-)
-
-
-type websocketRoutes struct {
-	l log.Logger
-	u *websocket.Upgrader
-	writer *usecase.ThreadSafeWriter
-}
-
-func newTranslationRoutes(handler *gin.RouterGroup, upgrader *websocket.Upgrader, l log.Logger) {
-	r := &websocketRoutes{l, upgrader, nil}
-
-	indexHTML, err := ioutil.ReadFile("index.html")
-	if err != nil {
-		panic(err)
-	}
-	indexTemplate := template.Must(template.New("").Parse(string(indexHTML)))
-	handler.GET("/", func(c *gin.Context) {
-		if err := indexTemplate.Execute(c.Writer, "ws://"+c.Request.Host+"/websocket"); err != nil {
-			log.Fatal(err)
-		}
-	})
-	handler.GET("/websocket", r.websocketHandler)
-}
-
-
-// Handle incoming websockets
-// use common web socket.
-func  (w *websocketRoutes) websocketHandler(c *gin.Context) {
-	// Upgrade HTTP request to Websocket
-	unsafeConn, err := w.u.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		w.l.Error("upgrade:", err)
-		return
-	}
-
-	w.writer = &usecase.ThreadSafeWriter{unsafeConn, sync.Mutex{}}
-
-	// When this frame returns close the Websocket
-	defer w.writer.Close() //nolint
-
-	// for web rtc point.
-
-	// Create new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
-	if err != nil {
-		w.l.Error(err)
-		return
-	}
-
-	// When this frame returns close the PeerConnection
-	defer peerConnection.Close() //nolint
-
-	// Accept one audio and one video track incoming
-	// only accept recv?...
-	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
-		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionRecvonly,
-		}); err != nil {
-			log.Error(err)
-			return
-		}
-	}
-
-	// Add our new PeerConnection to global list
-	listLock.Lock()
-	peerConnections = append(peerConnections, peerConnectionState{peerConnection, c})
-	listLock.Unlock()
-
-	// Trickle ICE. Emit server candidate to client
-	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
-		if i == nil {
-			return
-		}
-
-		candidateString, err := json.Marshal(i.ToJSON())
-		if err != nil {
-			log.Println(err)
-			return
-		}
-
-		if writeErr := c.WriteJSON(&websocketMessage{
-			Event: "candidate",
-			Data:  string(candidateString),
-		}); writeErr != nil {
-			log.Println(writeErr)
-		}
-	})
-
-	// If PeerConnection is closed remove it from global list
-	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
-		switch p {
-		case webrtc.PeerConnectionStateFailed:
-			if err := peerConnection.Close(); err != nil {
-				log.Print(err)
-			}
-		case webrtc.PeerConnectionStateClosed:
-			signalPeerConnections()
-		}
-	})
-
-	// remote track come.
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		// Create a track to fan out our incoming video to all peers
-		// trackLocal加入了所有peerConnection.
-		trackLocal := addTrack(t)
-		defer removeTrack(trackLocal)
-
-		buf := make([]byte, 1500)
-		for {
-			i, _, err := t.Read(buf)
-			if err != nil {
-				return
-			}
-
-			// receive from remote. send to local.
-			if _, err = trackLocal.Write(buf[:i]); err != nil {
-				return
-			}
-		}
-	})
-
-	// Signal for the new PeerConnection
-	signalPeerConnections()
-
-	message := &websocketMessage{}
-	for {
-		_, raw, err := c.ReadMessage()
-		if err != nil {
-			log.Println(err)
-			return
-		} else if err := json.Unmarshal(raw, &message); err != nil {
-			log.Println(err)
-			return
-		}
-
-		switch message.Event {
-		case "candidate":
-			candidate := webrtc.ICECandidateInit{}
-			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := peerConnection.AddICECandidate(candidate); err != nil {
-				log.Println(err)
-				return
-			}
-		case "answer":
-			answer := webrtc.SessionDescription{}
-			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
-				log.Println(err)
-				return
-			}
-		}
-	}
-}
-
-// @Summary     Show history
-// @Description Show all translation history
-// @ID          history
-// @Tags  	    translation
-// @Accept      json
-// @Produce     json
-// @Success     200 {object} historyResponse
-// @Failure     500 {object} response
-// @Router      /translation/history [get]
-func (r *websocketRoutes) history(c *gin.Context) {
-	translations, err := r.t.History(c.Request.Context())
-	if err != nil {
-		r.l.Error(err, "http - v1 - history")
-		errorResponse(c, http.StatusInternalServerError, "database problems")
-
-		return
-	}
-
-	c.JSON(http.StatusOK, historyResponse{translations})
-}
-
-type doTranslateRequest struct {
-	Source      string `json:"source"       binding:"required"  example:"auto"`
-	Destination string `json:"destination"  binding:"required"  example:"en"`
-	Original    string `json:"original"     binding:"required"  example:"текст для перевода"`
-}
-
-// @Summary     Translate
-// @Description Translate a text
-// @ID          do-translate
-// @Tags  	    translation
-// @Accept      json
-// @Produce     json
-// @Param       request body doTranslateRequest true "Set up translation"
-// @Success     200 {object} entity.Translation
-// @Failure     400 {object} response
-// @Failure     500 {object} response
-// @Router      /translation/do-translate [post]
-func (r *websocketRoutes) doTranslate(c *gin.Context) {
-	var request doTranslateRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		r.l.Error(err, "http - v1 - doTranslate")
-		errorResponse(c, http.StatusBadRequest, "invalid request body")
-
-		return
-	}
-
-	translation, err := r.t.Translate(
-		c.Request.Context(),
-		entity.Translation{
-			Source:      request.Source,
-			Destination: request.Destination,
-			Original:    request.Original,
-		},
-	)
-	if err != nil {
-		r.l.Error(err, "http - v1 - doTranslate")
-		errorResponse(c, http.StatusInternalServerError, "translation service problems")
-
-		return
-	}
-
-	c.JSON(http.StatusOK, translation)
-}
@@ -0,0 +1,24 @@
+package entity
+
+// CallSession 呼叫控制的会话, 包含若干路rtp leg.
+type CallSession struct {
+	ID      string   `json:"id"       example:"session-1"`
+	Created int64    `json:"created"  example:"1634567890"`
+	Legs    []RtpLeg `json:"legs"`
+}
+
+// RtpLeg 会话中的一路rtp媒体.
+type RtpLeg struct {
+	ID        string `json:"id"                  example:"leg-1"`
+	SessionID string `json:"sessionId"           example:"session-1"`
+	LocalIP   string `json:"localIp"             example:"10.0.0.1"`
+	LocalPort int    `json:"localPort"           example:"30000"`
+	Remote    string `json:"remote,omitempty"    example:"10.0.0.2:4000"`
+	Codec     string `json:"codec,omitempty"     example:"PCMU"`
+	BridgedTo string `json:"bridgedTo,omitempty" example:"leg-2"`
+	Playing   bool   `json:"playing"`
+	Recording bool   `json:"recording"`
+	Packets   uint64 `json:"packets"`
+	Bytes     uint64 `json:"bytes"`
+	Dropped   uint64 `json:"dropped"`
+}
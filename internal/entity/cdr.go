@@ -0,0 +1,23 @@
+package entity
+
+// Cdr 一个bill-id的话单, 时间为unix毫秒.
+type Cdr struct {
+	BillID     string   `json:"billId"     db:"bill_id"     example:"call-1"`
+	SessionID  string   `json:"sessionId"  db:"session_id"  example:"session-1"`
+	StartTime  int64    `json:"startTime"  db:"start_time"  example:"1634567890000"`
+	AnswerTime int64    `json:"answerTime" db:"answer_time" example:"1634567895000"` // 0表示未接通
+	EndTime    int64    `json:"endTime"    db:"end_time"    example:"1634567950000"`
+	Reason     string   `json:"reason"     db:"reason"      example:"hangup"`
+	Legs       []CdrLeg `json:"legs"       db:"-"`
+}
+
+// CdrLeg 话单中一路媒体的接收统计.
+type CdrLeg struct {
+	BillID  string  `json:"-"       db:"bill_id"`
+	LegID   string  `json:"legId"   db:"leg_id"  example:"leg-1"`
+	Codec   string  `json:"codec"   db:"codec"   example:"PCMU"`
+	Packets uint64  `json:"packets" db:"packets"`
+	Bytes   uint64  `json:"bytes"   db:"bytes"`
+	Lost    uint64  `json:"lost"    db:"lost"`
+	Jitter  float64 `json:"jitter"  db:"jitter"` // ms
+}
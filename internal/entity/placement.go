@@ -0,0 +1,20 @@
+package entity
+
+// 会话在其他节点时的处理方式.
+const (
+	// PlacementRedirect 让客户端重新连接持有节点.
+	PlacementRedirect = "redirect"
+	// PlacementRelay 本节点连接持有节点并转发信令, 对客户端透明.
+	PlacementRelay = "relay"
+)
+
+// Placement 参与者加入会话时的落点.
+type Placement struct {
+	SessionID string `json:"sessionId" example:"session-1"`
+	// Local 会话由本节点持有, 直接加入.
+	Local bool `json:"local"`
+	// Mode 会话在其他节点时的处理方式: redirect或relay.
+	Mode  string `json:"mode,omitempty"  example:"redirect"`
+	Owner string `json:"owner,omitempty" example:"node-1"`
+	URL   string `json:"url,omitempty"   example:"ws://10.0.0.2:8080/v1/signal"`
+}
@@ -0,0 +1,65 @@
+package entity
+
+// SfuSession 本节点的一个webrtc会话.
+type SfuSession struct {
+	ID    string    `json:"id"    example:"session-1"`
+	E2EE  bool      `json:"e2ee"  example:"false"`
+	Peers []SfuPeer `json:"peers"`
+}
+
+// SfuPeer 会话中的一个参与者, 上行Publisher和下行Subscriber各一个pc.
+type SfuPeer struct {
+	ID            string         `json:"id"            example:"peer-1"`
+	PublisherICE  string         `json:"publisherIce"  example:"connected"`
+	SubscriberICE string         `json:"subscriberIce" example:"connected"`
+	Tracks        []SfuTrack     `json:"tracks"`
+	DownTracks    []SfuDownTrack `json:"downTracks"`
+	Quality       *SfuQuality    `json:"quality,omitempty"`
+}
+
+// SfuTrack Publisher发布的上行track.
+type SfuTrack struct {
+	ID       string `json:"id"       example:"audio-1"`
+	StreamID string `json:"streamId" example:"stream-1"`
+	Kind     string `json:"kind"     example:"audio"`
+	Codec    string `json:"codec"    example:"audio/opus"`
+	SSRC     uint32 `json:"ssrc"     example:"12345678"`
+	Bitrate  uint64 `json:"bitrate"  example:"32000"`
+	RTT      int64  `json:"rtt"      example:"40"` // ms, 到发布端
+}
+
+// SfuDownTrack Subscriber订阅的下行track, 码率为源track的码率, 静音时为0.
+type SfuDownTrack struct {
+	ID       string `json:"id"       example:"audio-1"`
+	StreamID string `json:"streamId" example:"stream-1"`
+	Kind     string `json:"kind"     example:"audio"`
+	Codec    string `json:"codec"    example:"audio/opus"`
+	Muted    bool   `json:"muted"`
+	Bitrate  uint64 `json:"bitrate"  example:"32000"`
+	RTT      int64  `json:"rtt"      example:"40"` // ms, 到订阅端
+	// SVC流的目标层, 其他流为空.
+	Layers *SfuLayers `json:"layers,omitempty"`
+	// 纯rtp出口开启抖动缓冲时的统计, 其他为空.
+	Jitter *SfuJitter `json:"jitter,omitempty"`
+}
+
+// SfuJitter 出口抖动缓冲的统计.
+type SfuJitter struct {
+	Packets     uint32  `json:"packets"     example:"1500"`
+	Jitter      float64 `json:"jitter"      example:"80"` // rtp时间戳单位
+	LateDropped uint32  `json:"lateDropped" example:"2"`
+}
+
+// SfuLayers SVC下行转发的最高空间层和时间层.
+type SfuLayers struct {
+	Spatial  int32 `json:"spatial"  example:"1"`
+	Temporal int32 `json:"temporal" example:"2"`
+}
+
+// SfuQuality 一次质量采样, MOS 1-5, 0表示该方向没有媒体.
+type SfuQuality struct {
+	Time      int64   `json:"time"      example:"1634567890000"` // unix ms
+	Publish   float64 `json:"publish"   example:"4.3"`
+	Subscribe float64 `json:"subscribe" example:"3.9"`
+	Score     float64 `json:"score"     example:"3.9"`
+}
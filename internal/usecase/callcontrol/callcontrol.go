@@ -0,0 +1,355 @@
+package callcontrol
+
+import (
+	log "common/log/newlog"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase"
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/rtpengine"
+)
+
+var (
+	// ErrSessionExists -.
+	ErrSessionExists = errors.New("session already exists")
+	// ErrSessionNotFound -.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrLegNotFound -.
+	ErrLegNotFound = errors.New("rtp leg not found")
+)
+
+// CallControl 基于rtpengine.Endpoint的呼叫控制: 会话只是一组leg, 媒体在leg之间桥接.
+type CallControl struct {
+	sync.RWMutex
+	engine   *rtpengine.Engine
+	sessions map[string]*session
+	legs     map[string]string // leg id -> session id
+
+	events *events.Bus
+	cdr    usecase.Cdr
+	l      log.Logger
+}
+
+type session struct {
+	id      string
+	created time.Time
+	legs    []string
+}
+
+// New -. bus发布dtmf和媒体超时事件, cdr记录每一路的话单, bill-id都是leg id, 可以为空.
+func New(engine *rtpengine.Engine, bus *events.Bus, cdr usecase.Cdr, l log.Logger) *CallControl {
+	return &CallControl{
+		engine:   engine,
+		sessions: make(map[string]*session),
+		legs:     make(map[string]string),
+		events:   bus,
+		cdr:      cdr,
+		l:        l,
+	}
+}
+
+// CreateSession -.
+func (uc *CallControl) CreateSession(ctx context.Context, id string) (entity.CallSession, error) {
+	if id == "" {
+		id = uuid.New().String()
+	}
+	uc.Lock()
+	if _, ok := uc.sessions[id]; ok {
+		uc.Unlock()
+		return entity.CallSession{}, ErrSessionExists
+	}
+	s := &session{id: id, created: time.Now()}
+	uc.sessions[id] = s
+	uc.Unlock()
+
+	return uc.Query(ctx, id)
+}
+
+// CreateRtpLeg -.
+func (uc *CallControl) CreateRtpLeg(ctx context.Context, sessionID, legID, sdp string) (entity.RtpLeg, string, error) {
+	if legID == "" {
+		legID = uuid.New().String()
+	}
+	uc.RLock()
+	_, ok := uc.sessions[sessionID]
+	uc.RUnlock()
+	if !ok {
+		return entity.RtpLeg{}, "", ErrSessionNotFound
+	}
+
+	ep, localSDP, err := uc.engine.CreateEndpoint(rtpengine.EndpointOptions{ID: legID, SDP: sdp, Session: sessionID})
+	if err != nil {
+		return entity.RtpLeg{}, "", fmt.Errorf("CallControl - CreateRtpLeg - uc.engine.CreateEndpoint: %w", err)
+	}
+
+	uc.Lock()
+	s, ok := uc.sessions[sessionID]
+	if ok {
+		s.legs = append(s.legs, legID)
+		uc.legs[legID] = sessionID
+	}
+	uc.Unlock()
+	if !ok {
+		// 创建期间会话被挂断.
+		_ = uc.engine.DeleteEndpoint(legID)
+		return entity.RtpLeg{}, "", ErrSessionNotFound
+	}
+
+	ep.Leg().OnDTMF(func(digit string, duration time.Duration) {
+		uc.events.Publish(events.TypeDTMF, sessionID, legID, events.DTMFData{
+			Digit:    digit,
+			Duration: duration.Milliseconds(),
+		})
+	})
+	ep.Leg().OnMediaTimeout(func() {
+		uc.l.Info("rtp leg media timeout", "session", sessionID, "leg", legID)
+		uc.events.Publish(events.TypeMediaTimeout, sessionID, legID, events.MediaTimeoutData{LegID: legID})
+		go func() {
+			_ = uc.hangup(context.Background(), sessionID, legID, "media timeout")
+		}()
+	})
+	if uc.cdr != nil {
+		uc.cdr.Start(legID, sessionID)
+		if sdp != "" {
+			uc.cdr.Answer(legID)
+		}
+	}
+	// ng delete或engine关闭等不经过hangup的关闭, 也要移出会话并结束话单.
+	ep.Leg().OnClose(func() {
+		if uc.removeLeg(sessionID, legID) {
+			uc.endCdr(context.Background(), sessionID, ep, "closed")
+			uc.l.Info("rtp leg closed", "session", sessionID, "leg", legID)
+		}
+	})
+	return uc.leg(sessionID, ep), localSDP, nil
+}
+
+// AnswerRtpLeg -.
+func (uc *CallControl) AnswerRtpLeg(ctx context.Context, legID, sdp string) (entity.RtpLeg, error) {
+	ep, sessionID, err := uc.endpoint(legID)
+	if err != nil {
+		return entity.RtpLeg{}, err
+	}
+	if err = ep.Answer(sdp); err != nil {
+		return entity.RtpLeg{}, fmt.Errorf("CallControl - AnswerRtpLeg - ep.Answer: %w", err)
+	}
+	if uc.cdr != nil {
+		uc.cdr.Answer(legID)
+	}
+	return uc.leg(sessionID, ep), nil
+}
+
+// Bridge -.
+func (uc *CallControl) Bridge(ctx context.Context, legA, legB string) error {
+	a, _, err := uc.endpoint(legA)
+	if err != nil {
+		return err
+	}
+	b, _, err := uc.endpoint(legB)
+	if err != nil {
+		return err
+	}
+	if err = uc.engine.Bridge(a, b); err != nil {
+		return fmt.Errorf("CallControl - Bridge - uc.engine.Bridge: %w", err)
+	}
+	return nil
+}
+
+// Unbridge -.
+func (uc *CallControl) Unbridge(ctx context.Context, legID string) error {
+	ep, _, err := uc.endpoint(legID)
+	if err != nil {
+		return err
+	}
+	uc.engine.Unbridge(ep)
+	return nil
+}
+
+// Play -.
+func (uc *CallControl) Play(ctx context.Context, legID, file string, repeat int) (time.Duration, error) {
+	ep, _, err := uc.endpoint(legID)
+	if err != nil {
+		return 0, err
+	}
+	if file == "" {
+		ep.StopPlay()
+		return 0, nil
+	}
+	d, err := ep.Play(file, repeat)
+	if err != nil {
+		return 0, fmt.Errorf("CallControl - Play - ep.Play: %w", err)
+	}
+	return d, nil
+}
+
+// Record -.
+func (uc *CallControl) Record(ctx context.Context, legID string, start bool) error {
+	ep, sessionID, err := uc.endpoint(legID)
+	if err != nil {
+		return err
+	}
+	if !start {
+		ep.StopRecording()
+		return nil
+	}
+	if err = ep.StartRecording(sessionID); err != nil {
+		return fmt.Errorf("CallControl - Record - ep.StartRecording: %w", err)
+	}
+	return nil
+}
+
+// Hangup -.
+func (uc *CallControl) Hangup(ctx context.Context, sessionID, legID string) error {
+	return uc.hangup(ctx, sessionID, legID, "hangup")
+}
+
+func (uc *CallControl) hangup(ctx context.Context, sessionID, legID, reason string) error {
+	uc.Lock()
+	s, ok := uc.sessions[sessionID]
+	if !ok {
+		uc.Unlock()
+		return ErrSessionNotFound
+	}
+	var legs []string
+	if legID == "" {
+		legs = s.legs
+		delete(uc.sessions, sessionID)
+	} else {
+		if uc.legs[legID] != sessionID {
+			uc.Unlock()
+			return ErrLegNotFound
+		}
+		legs = []string{legID}
+		for i, id := range s.legs {
+			if id == legID {
+				s.legs = append(s.legs[:i:i], s.legs[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, id := range legs {
+		delete(uc.legs, id)
+	}
+	uc.Unlock()
+
+	for _, id := range legs {
+		if ep := uc.engine.Endpoint(id); ep != nil {
+			uc.endCdr(ctx, sessionID, ep, reason)
+		}
+		_ = uc.engine.DeleteEndpoint(id)
+	}
+	uc.l.Info("call control hangup", "session", sessionID, "leg", legID, "reason", reason)
+	return nil
+}
+
+// Query -.
+func (uc *CallControl) Query(ctx context.Context, sessionID string) (entity.CallSession, error) {
+	uc.RLock()
+	s, ok := uc.sessions[sessionID]
+	if !ok {
+		uc.RUnlock()
+		return entity.CallSession{}, ErrSessionNotFound
+	}
+	created, legs := s.created, append([]string(nil), s.legs...)
+	uc.RUnlock()
+
+	result := entity.CallSession{
+		ID:      sessionID,
+		Created: created.Unix(),
+		Legs:    make([]entity.RtpLeg, 0, len(legs)),
+	}
+	for _, id := range legs {
+		if ep := uc.engine.Endpoint(id); ep != nil {
+			result.Legs = append(result.Legs, uc.leg(sessionID, ep))
+		}
+	}
+	return result, nil
+}
+
+// Count returns the number of sessions and rtp legs, reported as the node load
+func (uc *CallControl) Count() (sessions, legs int) {
+	uc.RLock()
+	defer uc.RUnlock()
+	return len(uc.sessions), len(uc.legs)
+}
+
+// removeLeg 从会话中移除leg, leg已经被hangup移除时返回false.
+func (uc *CallControl) removeLeg(sessionID, legID string) bool {
+	uc.Lock()
+	defer uc.Unlock()
+	if uc.legs[legID] != sessionID {
+		return false
+	}
+	delete(uc.legs, legID)
+	if s, ok := uc.sessions[sessionID]; ok {
+		for i, id := range s.legs {
+			if id == legID {
+				s.legs = append(s.legs[:i:i], s.legs[i+1:]...)
+				break
+			}
+		}
+	}
+	return true
+}
+
+// endCdr 结束leg的话单, 需要在leg的Buffer释放前调用.
+func (uc *CallControl) endCdr(ctx context.Context, sessionID string, ep *rtpengine.Endpoint, reason string) {
+	if uc.cdr == nil {
+		return
+	}
+	stats := ep.Leg().Stats()
+	if err := uc.cdr.End(ctx, ep.ID(), reason, entity.CdrLeg{
+		LegID:   ep.ID(),
+		Codec:   ep.Codec(),
+		Packets: stats.Packets,
+		Bytes:   stats.Bytes,
+		Lost:    stats.Lost,
+		Jitter:  stats.Jitter,
+	}); err != nil {
+		uc.l.Error(err, "CallControl - endCdr - uc.cdr.End", "session", sessionID, "leg", ep.ID())
+	}
+}
+
+func (uc *CallControl) endpoint(legID string) (*rtpengine.Endpoint, string, error) {
+	uc.RLock()
+	sessionID, ok := uc.legs[legID]
+	uc.RUnlock()
+	if !ok {
+		return nil, "", ErrLegNotFound
+	}
+	ep := uc.engine.Endpoint(legID)
+	if ep == nil {
+		return nil, "", ErrLegNotFound
+	}
+	return ep, sessionID, nil
+}
+
+func (uc *CallControl) leg(sessionID string, ep *rtpengine.Endpoint) entity.RtpLeg {
+	stats := ep.Leg().Stats()
+	leg := entity.RtpLeg{
+		ID:        ep.ID(),
+		SessionID: sessionID,
+		LocalIP:   uc.engine.AdvertiseIP(),
+		LocalPort: ep.Leg().LocalPort(),
+		Codec:     ep.Codec(),
+		Playing:   ep.Playing(),
+		Recording: ep.Recording(),
+		Packets:   stats.Packets,
+		Bytes:     stats.Bytes,
+		Dropped:   stats.Dropped,
+	}
+	if remote := ep.Leg().Remote(); remote != nil {
+		leg.Remote = remote.String()
+	}
+	if peer := ep.Peer(); peer != nil {
+		leg.BridgedTo = peer.ID()
+	}
+	return leg
+}
@@ -0,0 +1,98 @@
+package callcontrol
+
+import (
+	log "common/log/newlog"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/internal/usecase/cdr"
+	"mediasfu/internal/usecase/repo"
+	"mediasfu/pkg/mysql"
+	"mediasfu/pkg/rtpengine"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+const testOffer = "v=0\r\n" +
+	"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 40000 RTP/AVP 0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n"
+
+// newTestCallControl 话单写入sqlite内存库, 表结构取自mysql的迁移文件.
+func newTestCallControl(t *testing.T) (*CallControl, *rtpengine.Engine, *repo.CdrRepo) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	schema, err := os.ReadFile("../../../migrations/mysql/20261019000000_create_cdr_tables.up.sql")
+	require.NoError(t, err)
+	_, err = db.Exec(string(schema))
+	require.NoError(t, err)
+	r := repo.NewCdrRepo(&mysql.Mysql{
+		Builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		Db:      db,
+	})
+
+	engine, err := rtpengine.NewEngine(rtpengine.Config{
+		IP:            "127.0.0.1",
+		MinPort:       42400,
+		MaxPort:       42500,
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+	})
+	require.NoError(t, err)
+	t.Cleanup(engine.Close)
+
+	return New(engine, nil, cdr.New(r, log.GetLogger()), log.GetLogger()), engine, r
+}
+
+func TestCallControl_Cdr(t *testing.T) {
+	uc, engine, r := newTestCallControl(t)
+	ctx := context.Background()
+
+	_, err := uc.CreateSession(ctx, "s1")
+	require.NoError(t, err)
+	_, _, err = uc.CreateRtpLeg(ctx, "s1", "a", testOffer)
+	require.NoError(t, err)
+	// 没有sdp时是本端offer, 应答后才算接通.
+	_, _, err = uc.CreateRtpLeg(ctx, "s1", "b", "")
+	require.NoError(t, err)
+	_, _, err = uc.CreateRtpLeg(ctx, "s1", "c", testOffer)
+	require.NoError(t, err)
+
+	require.NoError(t, uc.Hangup(ctx, "s1", "a"))
+	a, err := r.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "s1", a.SessionID)
+	assert.Equal(t, "hangup", a.Reason)
+	assert.NotZero(t, a.AnswerTime)
+	require.Len(t, a.Legs, 1)
+	assert.Equal(t, "PCMU", a.Legs[0].Codec)
+
+	// 不经过hangup关闭的leg也移出会话并结束话单.
+	require.NoError(t, engine.DeleteEndpoint("b"))
+	b, err := r.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "closed", b.Reason)
+	assert.Zero(t, b.AnswerTime)
+	s, err := uc.Query(ctx, "s1")
+	require.NoError(t, err)
+	require.Len(t, s.Legs, 1)
+	assert.Equal(t, "c", s.Legs[0].ID)
+	_, legs := uc.Count()
+	assert.Equal(t, 1, legs)
+
+	require.NoError(t, uc.Hangup(ctx, "s1", ""))
+	c, err := r.Get(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "hangup", c.Reason)
+	assert.ErrorIs(t, uc.Hangup(ctx, "s1", ""), ErrSessionNotFound)
+}
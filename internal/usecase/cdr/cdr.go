@@ -0,0 +1,88 @@
+package cdr
+
+import (
+	log "common/log/newlog"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mediasfu/internal/entity"
+	"mediasfu/internal/usecase"
+)
+
+// ErrCdrNotStarted -.
+var ErrCdrNotStarted = errors.New("cdr not started")
+
+// Recorder 在内存中保存进行中的话单, 结束时写入repo.
+type Recorder struct {
+	sync.Mutex
+	repo usecase.CdrRepo
+	open map[string]*entity.Cdr // bill id -> cdr
+
+	l log.Logger
+}
+
+// New -.
+func New(r usecase.CdrRepo, l log.Logger) *Recorder {
+	return &Recorder{
+		repo: r,
+		open: make(map[string]*entity.Cdr),
+		l:    l,
+	}
+}
+
+// Start 开始计费, 同一个bill-id重复调用时保留第一次的开始时间.
+func (uc *Recorder) Start(billID, sessionID string) {
+	uc.Lock()
+	defer uc.Unlock()
+	if _, ok := uc.open[billID]; ok {
+		return
+	}
+	uc.open[billID] = &entity.Cdr{
+		BillID:    billID,
+		SessionID: sessionID,
+		StartTime: now(),
+	}
+}
+
+// Answer 记录接通时间, 只记录第一次.
+func (uc *Recorder) Answer(billID string) {
+	uc.Lock()
+	defer uc.Unlock()
+	if c, ok := uc.open[billID]; ok && c.AnswerTime == 0 {
+		c.AnswerTime = now()
+	}
+}
+
+// End 结束话单并落库, 落库失败时话单丢弃, 由调用方记录日志.
+func (uc *Recorder) End(ctx context.Context, billID, reason string, legs ...entity.CdrLeg) error {
+	uc.Lock()
+	c, ok := uc.open[billID]
+	delete(uc.open, billID)
+	uc.Unlock()
+	if !ok {
+		return ErrCdrNotStarted
+	}
+
+	c.EndTime, c.Reason, c.Legs = now(), reason, legs
+	if err := uc.repo.Store(ctx, *c); err != nil {
+		return fmt.Errorf("Recorder - End - uc.repo.Store: %w", err)
+	}
+	uc.l.Info("cdr stored", "bill_id", billID, "session", c.SessionID, "reason", reason)
+	return nil
+}
+
+// Record -.
+func (uc *Recorder) Record(ctx context.Context, c entity.Cdr) error {
+	if err := uc.repo.Store(ctx, c); err != nil {
+		return fmt.Errorf("Recorder - Record - uc.repo.Store: %w", err)
+	}
+	uc.l.Info("cdr stored", "bill_id", c.BillID, "session", c.SessionID, "reason", c.Reason)
+	return nil
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"mediasfu/internal/entity"
+)
+
+type (
+	// CallControl 呼叫控制, softswitch通过rabbitmq rpc调用.
+	CallControl interface {
+		CreateSession(ctx context.Context, id string) (entity.CallSession, error)
+		// CreateRtpLeg 在会话中创建一路rtp, sdp为对端offer时返回answer, 为空时返回本端offer.
+		CreateRtpLeg(ctx context.Context, sessionID, legID, sdp string) (entity.RtpLeg, string, error)
+		// AnswerRtpLeg 设置对端对本端offer的answer.
+		AnswerRtpLeg(ctx context.Context, legID, sdp string) (entity.RtpLeg, error)
+		Bridge(ctx context.Context, legA, legB string) error
+		Unbridge(ctx context.Context, legID string) error
+		Play(ctx context.Context, legID, file string, repeat int) (time.Duration, error)
+		Record(ctx context.Context, legID string, start bool) error
+		// Hangup 挂断一路rtp, legID为空时挂断整个会话.
+		Hangup(ctx context.Context, sessionID, legID string) error
+		Query(ctx context.Context, sessionID string) (entity.CallSession, error)
+	}
+
+	// Cdr 按bill-id记录话单, End时落库.
+	Cdr interface {
+		Start(billID, sessionID string)
+		Answer(billID string)
+		End(ctx context.Context, billID, reason string, legs ...entity.CdrLeg) error
+		// Record 直接写入生命周期由调用方维护的话单, 如sip呼叫.
+		Record(ctx context.Context, c entity.Cdr) error
+	}
+
+	// Placement 集群中会话的落点, websocket join时调用.
+	Placement interface {
+		Join(ctx context.Context, sessionID string) (entity.Placement, error)
+		Leave(ctx context.Context, sessionID string) error
+	}
+
+	// SfuAdmin 运维接口, 查询本节点的会话和参与者, 踢人和静音下行track.
+	SfuAdmin interface {
+		Sessions(ctx context.Context) []entity.SfuSession
+		Session(ctx context.Context, id string) (entity.SfuSession, error)
+		Peer(ctx context.Context, sessionID, peerID string) (entity.SfuPeer, error)
+		// Quality 返回peer最近的质量采样, 按时间先后.
+		Quality(ctx context.Context, sessionID, peerID string) ([]entity.SfuQuality, error)
+		Kick(ctx context.Context, sessionID, peerID string) error
+		Mute(ctx context.Context, sessionID, peerID, trackID string, mute bool) error
+		// Layers 设置SVC下行转发的最高空间层和时间层.
+		Layers(ctx context.Context, sessionID, peerID, trackID string, spatial, temporal int32) error
+	}
+
+	// CdrRepo -.
+	CdrRepo interface {
+		Store(ctx context.Context, c entity.Cdr) error
+		Get(ctx context.Context, billID string) (entity.Cdr, error)
+		List(ctx context.Context, from, to int64) ([]entity.Cdr, error)
+	}
+)
@@ -0,0 +1,84 @@
+package placement
+
+import (
+	log "common/log/newlog"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"mediasfu/internal/entity"
+	"mediasfu/pkg/directory"
+)
+
+// ErrUnknownMode -.
+var ErrUnknownMode = errors.New("unknown placement mode")
+
+// Placement 通过会话目录决定参与者落在哪个节点, 本节点的最后一个参与者离开时释放会话.
+type Placement struct {
+	sync.Mutex
+	dir   directory.Directory
+	self  directory.Node
+	mode  string
+	local map[string]int // session id -> 本节点的参与者数
+
+	l log.Logger
+}
+
+// New -.
+func New(dir directory.Directory, self directory.Node, mode string, l log.Logger) (*Placement, error) {
+	if mode != entity.PlacementRedirect && mode != entity.PlacementRelay {
+		return nil, ErrUnknownMode
+	}
+	return &Placement{
+		dir:   dir,
+		self:  self,
+		mode:  mode,
+		local: make(map[string]int),
+		l:     l,
+	}, nil
+}
+
+// Join -. Join和Leave串行执行, 避免释放会话的同时又有参与者加入.
+func (uc *Placement) Join(ctx context.Context, sessionID string) (entity.Placement, error) {
+	uc.Lock()
+	defer uc.Unlock()
+
+	owner, err := uc.dir.Claim(ctx, sessionID, uc.self)
+	if errors.Is(err, directory.ErrNotFound) {
+		// 持有者刚好释放, 再抢一次.
+		owner, err = uc.dir.Claim(ctx, sessionID, uc.self)
+	}
+	if err != nil {
+		return entity.Placement{}, fmt.Errorf("Placement - Join - uc.dir.Claim: %w", err)
+	}
+
+	if owner.ID == uc.self.ID {
+		uc.local[sessionID]++
+		return entity.Placement{SessionID: sessionID, Local: true, Owner: owner.ID, URL: owner.URL}, nil
+	}
+
+	uc.l.Info("session owned by another node", "session", sessionID, "owner", owner.ID, "mode", uc.mode)
+	return entity.Placement{SessionID: sessionID, Mode: uc.mode, Owner: owner.ID, URL: owner.URL}, nil
+}
+
+// Leave 本节点的参与者离开, 最后一个离开时从目录释放会话.
+func (uc *Placement) Leave(ctx context.Context, sessionID string) error {
+	uc.Lock()
+	defer uc.Unlock()
+
+	n, ok := uc.local[sessionID]
+	if !ok {
+		return nil
+	}
+	if n > 1 {
+		uc.local[sessionID] = n - 1
+		return nil
+	}
+	delete(uc.local, sessionID)
+
+	if err := uc.dir.Release(ctx, sessionID, uc.self); err != nil && !errors.Is(err, directory.ErrNotFound) {
+		return fmt.Errorf("Placement - Leave - uc.dir.Release: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package placement
+
+import (
+	log "common/log/newlog"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/internal/entity"
+	"mediasfu/pkg/directory"
+)
+
+var (
+	nodeA = directory.Node{ID: "a", URL: "ws://10.0.0.1:8080/v1/signal"}
+	nodeB = directory.Node{ID: "b", URL: "ws://10.0.0.2:8080/v1/signal"}
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(directory.NewMemory(), nodeA, "proxy", log.GetLogger())
+	assert.ErrorIs(t, err, ErrUnknownMode)
+}
+
+func TestPlacement(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{name: "redirect", mode: entity.PlacementRedirect},
+		{name: "relay", mode: entity.PlacementRelay},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := directory.NewMemory()
+			a, err := New(dir, nodeA, tt.mode, log.GetLogger())
+			require.NoError(t, err)
+			b, err := New(dir, nodeB, tt.mode, log.GetLogger())
+			require.NoError(t, err)
+
+			// 第一个加入的节点持有会话.
+			p, err := a.Join(ctx, "s1")
+			require.NoError(t, err)
+			assert.Equal(t, entity.Placement{SessionID: "s1", Local: true, Owner: nodeA.ID, URL: nodeA.URL}, p)
+			_, err = a.Join(ctx, "s1")
+			require.NoError(t, err)
+
+			p, err = b.Join(ctx, "s1")
+			require.NoError(t, err)
+			assert.Equal(t, entity.Placement{SessionID: "s1", Mode: tt.mode, Owner: nodeA.ID, URL: nodeA.URL}, p)
+			// 不是本节点的会话, Leave不释放.
+			require.NoError(t, b.Leave(ctx, "s1"))
+
+			// 本节点最后一个参与者离开时才释放.
+			require.NoError(t, a.Leave(ctx, "s1"))
+			owner, err := dir.Lookup(ctx, "s1")
+			require.NoError(t, err)
+			assert.Equal(t, nodeA, owner)
+			require.NoError(t, a.Leave(ctx, "s1"))
+			_, err = dir.Lookup(ctx, "s1")
+			assert.ErrorIs(t, err, directory.ErrNotFound)
+
+			p, err = b.Join(ctx, "s1")
+			require.NoError(t, err)
+			assert.True(t, p.Local)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Package repo implements the repositories of usecase.
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+
+	"mediasfu/internal/entity"
+	"mediasfu/pkg/mysql"
+)
+
+const _defaultEntityCap = 64
+
+// CdrRepo -.
+type CdrRepo struct {
+	*mysql.Mysql
+}
+
+// NewCdrRepo -.
+func NewCdrRepo(ms *mysql.Mysql) *CdrRepo {
+	return &CdrRepo{ms}
+}
+
+// Store 在一个事务中写入话单和每一路的统计.
+func (r *CdrRepo) Store(ctx context.Context, c entity.Cdr) error {
+	tx, err := r.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("CdrRepo - Store - r.Db.BeginTxx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	sql, args, err := r.Builder.
+		Insert("cdr").
+		Columns("bill_id, session_id, start_time, answer_time, end_time, reason").
+		Values(c.BillID, c.SessionID, c.StartTime, c.AnswerTime, c.EndTime, c.Reason).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("CdrRepo - Store - r.Builder: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("CdrRepo - Store - tx.ExecContext: %w", err)
+	}
+
+	if len(c.Legs) > 0 {
+		builder := r.Builder.
+			Insert("cdr_leg").
+			Columns("bill_id, leg_id, codec, packets, bytes, lost, jitter")
+		for _, leg := range c.Legs {
+			builder = builder.Values(c.BillID, leg.LegID, leg.Codec, leg.Packets, leg.Bytes, leg.Lost, leg.Jitter)
+		}
+		if sql, args, err = builder.ToSql(); err != nil {
+			return fmt.Errorf("CdrRepo - Store - r.Builder: %w", err)
+		}
+		if _, err = tx.ExecContext(ctx, sql, args...); err != nil {
+			return fmt.Errorf("CdrRepo - Store - tx.ExecContext: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("CdrRepo - Store - tx.Commit: %w", err)
+	}
+	return nil
+}
+
+// Get returns the cdr of billID, the error wraps sql.ErrNoRows if not found
+func (r *CdrRepo) Get(ctx context.Context, billID string) (entity.Cdr, error) {
+	sql, args, err := r.Builder.
+		Select("bill_id, session_id, start_time, answer_time, end_time, reason").
+		From("cdr").
+		Where(squirrel.Eq{"bill_id": billID}).
+		ToSql()
+	if err != nil {
+		return entity.Cdr{}, fmt.Errorf("CdrRepo - Get - r.Builder: %w", err)
+	}
+
+	var c entity.Cdr
+	if err = r.Db.GetContext(ctx, &c, sql, args...); err != nil {
+		return entity.Cdr{}, fmt.Errorf("CdrRepo - Get - r.Db.GetContext: %w", err)
+	}
+
+	cdrs := []entity.Cdr{c}
+	if err = r.legs(ctx, cdrs); err != nil {
+		return entity.Cdr{}, fmt.Errorf("CdrRepo - Get - %w", err)
+	}
+	return cdrs[0], nil
+}
+
+// List returns the cdrs started in [from, to), unix ms, for billing reconciliation
+func (r *CdrRepo) List(ctx context.Context, from, to int64) ([]entity.Cdr, error) {
+	sql, args, err := r.Builder.
+		Select("bill_id, session_id, start_time, answer_time, end_time, reason").
+		From("cdr").
+		Where(squirrel.GtOrEq{"start_time": from}).
+		Where(squirrel.Lt{"start_time": to}).
+		OrderBy("start_time").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("CdrRepo - List - r.Builder: %w", err)
+	}
+
+	cdrs := make([]entity.Cdr, 0, _defaultEntityCap)
+	if err = r.Db.SelectContext(ctx, &cdrs, sql, args...); err != nil {
+		return nil, fmt.Errorf("CdrRepo - List - r.Db.SelectContext: %w", err)
+	}
+	if err = r.legs(ctx, cdrs); err != nil {
+		return nil, fmt.Errorf("CdrRepo - List - %w", err)
+	}
+	return cdrs, nil
+}
+
+// legs 查询并填充cdrs的每一路统计.
+func (r *CdrRepo) legs(ctx context.Context, cdrs []entity.Cdr) error {
+	if len(cdrs) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(cdrs))
+	ids := make([]string, 0, len(cdrs))
+	for i, c := range cdrs {
+		index[c.BillID] = i
+		ids = append(ids, c.BillID)
+	}
+
+	sql, args, err := r.Builder.
+		Select("bill_id, leg_id, codec, packets, bytes, lost, jitter").
+		From("cdr_leg").
+		Where(squirrel.Eq{"bill_id": ids}).
+		OrderBy("bill_id, leg_id").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("r.Builder: %w", err)
+	}
+
+	var legs []entity.CdrLeg
+	if err = r.Db.SelectContext(ctx, &legs, sql, args...); err != nil {
+		return fmt.Errorf("r.Db.SelectContext: %w", err)
+	}
+	for _, leg := range legs {
+		c := &cdrs[index[leg.BillID]]
+		c.Legs = append(c.Legs, leg)
+	}
+	return nil
+}
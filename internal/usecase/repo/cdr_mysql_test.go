@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/internal/entity"
+	"mediasfu/pkg/mysql"
+)
+
+// newTestRepo 用sqlite内存库代替mysql, 表结构取自mysql的迁移文件.
+func newTestRepo(t *testing.T) *CdrRepo {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// 每个连接是一个独立的内存库.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("../../../migrations/mysql/20261019000000_create_cdr_tables.up.sql")
+	require.NoError(t, err)
+	_, err = db.Exec(string(schema))
+	require.NoError(t, err)
+
+	return NewCdrRepo(&mysql.Mysql{
+		Builder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		Db:      db,
+	})
+}
+
+func TestCdrRepo(t *testing.T) {
+	r := newTestRepo(t)
+	ctx := context.Background()
+
+	call := entity.Cdr{
+		BillID:     "call-1",
+		SessionID:  "s1",
+		StartTime:  1000,
+		AnswerTime: 1500,
+		EndTime:    9000,
+		Reason:     "hangup",
+		Legs: []entity.CdrLeg{
+			{LegID: "a", Codec: "PCMU", Packets: 100, Bytes: 17200, Lost: 2, Jitter: 3.5},
+			{LegID: "b", Codec: "PCMU", Packets: 98, Bytes: 16856},
+		},
+	}
+	// 未接通, 没有媒体统计.
+	missed := entity.Cdr{BillID: "call-2", SessionID: "s1", StartTime: 2000, EndTime: 3000, Reason: "timeout"}
+	require.NoError(t, r.Store(ctx, call))
+	require.NoError(t, r.Store(ctx, missed))
+	assert.Error(t, r.Store(ctx, missed), "duplicate bill id")
+
+	got, err := r.Get(ctx, "call-1")
+	require.NoError(t, err)
+	for i := range call.Legs {
+		call.Legs[i].BillID = call.BillID
+	}
+	assert.Equal(t, call, got)
+
+	_, err = r.Get(ctx, "call-3")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	list, err := r.List(ctx, 1000, 2001)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "call-1", list[0].BillID)
+	assert.Len(t, list[0].Legs, 2)
+	assert.Equal(t, missed, list[1])
+
+	list, err = r.List(ctx, 1001, 2000)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
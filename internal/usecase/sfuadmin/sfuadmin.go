@@ -0,0 +1,243 @@
+package sfuadmin
+
+import (
+	log "common/log/newlog"
+	"context"
+	"errors"
+	"time"
+
+	"mediasfu/internal/entity"
+	"mediasfu/pkg/webrtc"
+)
+
+var (
+	// ErrSessionNotFound -.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrPeerNotFound -.
+	ErrPeerNotFound = errors.New("peer not found")
+	// ErrTrackNotFound -.
+	ErrTrackNotFound = errors.New("down track not found")
+	// ErrNotScalable -.
+	ErrNotScalable = errors.New("down track is not a video track")
+)
+
+// SfuAdmin 运维接口, 查询本节点的会话和参与者, 踢出参与者, 静音下行track.
+type SfuAdmin struct {
+	sfu     *webrtc.SFU
+	quality *webrtc.QualityMonitor
+	l       log.Logger
+}
+
+// New -. quality为空时不返回质量评分.
+func New(sfu *webrtc.SFU, quality *webrtc.QualityMonitor, l log.Logger) *SfuAdmin {
+	return &SfuAdmin{
+		sfu:     sfu,
+		quality: quality,
+		l:       l,
+	}
+}
+
+// Sessions -.
+func (uc *SfuAdmin) Sessions(ctx context.Context) []entity.SfuSession {
+	sessions := uc.sfu.Sessions()
+	result := make([]entity.SfuSession, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, uc.toSession(s))
+	}
+	return result
+}
+
+// Session -.
+func (uc *SfuAdmin) Session(ctx context.Context, id string) (entity.SfuSession, error) {
+	s, _ := uc.sfu.GetSession(id)
+	if s == nil {
+		return entity.SfuSession{}, ErrSessionNotFound
+	}
+	return uc.toSession(s), nil
+}
+
+// Peer -.
+func (uc *SfuAdmin) Peer(ctx context.Context, sessionID, peerID string) (entity.SfuPeer, error) {
+	p, err := uc.peer(sessionID, peerID)
+	if err != nil {
+		return entity.SfuPeer{}, err
+	}
+	return uc.toPeer(sessionID, p), nil
+}
+
+// Quality -.
+func (uc *SfuAdmin) Quality(ctx context.Context, sessionID, peerID string) ([]entity.SfuQuality, error) {
+	if _, err := uc.peer(sessionID, peerID); err != nil {
+		return nil, err
+	}
+	result := []entity.SfuQuality{}
+	if uc.quality == nil {
+		return result, nil
+	}
+	for _, q := range uc.quality.Samples(sessionID, peerID) {
+		result = append(result, toQuality(q))
+	}
+	return result, nil
+}
+
+// Kick 把参与者移出会话并关闭两个pc.
+func (uc *SfuAdmin) Kick(ctx context.Context, sessionID, peerID string) error {
+	p, err := uc.peer(sessionID, peerID)
+	if err != nil {
+		return err
+	}
+	p.Session().RemovePeer(p)
+	if err = p.Close(); err != nil {
+		uc.l.Error(err, "SfuAdmin - Kick - p.Close", "session", sessionID, "peer", peerID)
+	}
+	return nil
+}
+
+// Mute 静音或恢复参与者订阅的下行track, 同一track id的所有DownTrack一起修改.
+func (uc *SfuAdmin) Mute(ctx context.Context, sessionID, peerID, trackID string, mute bool) error {
+	p, err := uc.peer(sessionID, peerID)
+	if err != nil {
+		return err
+	}
+	if p.Subscriber() == nil {
+		return ErrTrackNotFound
+	}
+
+	found := false
+	for _, dt := range p.Subscriber().DownTracks() {
+		if dt.ID() == trackID {
+			dt.Mute(mute)
+			found = true
+		}
+	}
+	if !found {
+		return ErrTrackNotFound
+	}
+	return nil
+}
+
+// Layers 设置参与者订阅的SVC下行track转发的最高层, 同一track id的所有DownTrack一起修改.
+func (uc *SfuAdmin) Layers(ctx context.Context, sessionID, peerID, trackID string, spatial, temporal int32) error {
+	p, err := uc.peer(sessionID, peerID)
+	if err != nil {
+		return err
+	}
+	if p.Subscriber() == nil {
+		return ErrTrackNotFound
+	}
+
+	found := false
+	for _, dt := range p.Subscriber().DownTracks() {
+		if dt.ID() != trackID {
+			continue
+		}
+		found = true
+		if !dt.SetTargetLayers(spatial, temporal) {
+			return ErrNotScalable
+		}
+	}
+	if !found {
+		return ErrTrackNotFound
+	}
+	return nil
+}
+
+func (uc *SfuAdmin) peer(sessionID, peerID string) (webrtc.Peer, error) {
+	s, _ := uc.sfu.GetSession(sessionID)
+	if s == nil {
+		return nil, ErrSessionNotFound
+	}
+	p := s.GetPeer(peerID)
+	if p == nil {
+		return nil, ErrPeerNotFound
+	}
+	return p, nil
+}
+
+func (uc *SfuAdmin) toSession(s webrtc.Session) entity.SfuSession {
+	peers := s.Peers()
+	result := entity.SfuSession{
+		ID:    s.ID(),
+		E2EE:  s.E2EE(),
+		Peers: make([]entity.SfuPeer, 0, len(peers)),
+	}
+	for _, p := range peers {
+		result.Peers = append(result.Peers, uc.toPeer(s.ID(), p))
+	}
+	return result
+}
+
+func (uc *SfuAdmin) toPeer(sessionID string, p webrtc.Peer) entity.SfuPeer {
+	result := entity.SfuPeer{
+		ID:         p.ID(),
+		Tracks:     []entity.SfuTrack{},
+		DownTracks: []entity.SfuDownTrack{},
+	}
+	if uc.quality != nil {
+		if q, ok := uc.quality.Latest(sessionID, p.ID()); ok {
+			quality := toQuality(q)
+			result.Quality = &quality
+		}
+	}
+
+	if pub := p.Publisher(); pub != nil {
+		result.PublisherICE = pub.PeerConnection().ICEConnectionState().String()
+		for _, t := range pub.PublisherTracks() {
+			result.Tracks = append(result.Tracks, entity.SfuTrack{
+				ID:       t.Receiver.TrackID(),
+				StreamID: t.Receiver.StreamID(),
+				Kind:     t.Receiver.Kind().String(),
+				Codec:    t.Receiver.Codec().MimeType,
+				SSRC:     t.Receiver.SSRC(),
+				Bitrate:  t.Receiver.GetBitrate(),
+				RTT:      t.Receiver.RTT().Milliseconds(),
+			})
+		}
+	}
+
+	if sub := p.Subscriber(); sub != nil {
+		result.SubscriberICE = sub.ICEConnectionState().String()
+		for _, dt := range sub.DownTracks() {
+			result.DownTracks = append(result.DownTracks, toDownTrack(dt))
+		}
+	}
+	// sip等rtp peer没有pc, 下行直接写到leg.
+	if rp, ok := p.(*webrtc.RTPPeer); ok {
+		for _, dt := range rp.DownTracks() {
+			result.DownTracks = append(result.DownTracks, toDownTrack(dt))
+		}
+	}
+	return result
+}
+
+func toDownTrack(dt *webrtc.DownTrack) entity.SfuDownTrack {
+	downTrack := entity.SfuDownTrack{
+		ID:       dt.ID(),
+		StreamID: dt.StreamID(),
+		Kind:     dt.Kind().String(),
+		Codec:    dt.Codec().MimeType,
+		Muted:    !dt.Enabled(),
+		Bitrate:  dt.Bitrate(),
+		RTT:      dt.RTT().Milliseconds(),
+	}
+	if spatial, temporal, ok := dt.TargetLayers(); ok {
+		downTrack.Layers = &entity.SfuLayers{Spatial: spatial, Temporal: temporal}
+	}
+	if stats, ok := dt.JitterStats(); ok {
+		downTrack.Jitter = &entity.SfuJitter{
+			Packets:     stats.PacketCount,
+			Jitter:      stats.Jitter,
+			LateDropped: stats.LateDropped,
+		}
+	}
+	return downTrack
+}
+
+func toQuality(q webrtc.QualitySample) entity.SfuQuality {
+	return entity.SfuQuality{
+		Time:      q.Time.UnixNano() / int64(time.Millisecond),
+		Publish:   q.Publish,
+		Subscribe: q.Subscribe,
+		Score:     q.Score,
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS cdr_leg;
+DROP TABLE IF EXISTS cdr;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS cdr (
+    bill_id     VARCHAR(128) NOT NULL PRIMARY KEY,
+    session_id  VARCHAR(128) NOT NULL,
+    start_time  BIGINT       NOT NULL,
+    answer_time BIGINT       NOT NULL DEFAULT 0,
+    end_time    BIGINT       NOT NULL DEFAULT 0,
+    reason      VARCHAR(64)  NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_cdr_session ON cdr (session_id);
+CREATE INDEX idx_cdr_start ON cdr (start_time);
+
+CREATE TABLE IF NOT EXISTS cdr_leg (
+    bill_id VARCHAR(128) NOT NULL,
+    leg_id  VARCHAR(128) NOT NULL,
+    codec   VARCHAR(32)  NOT NULL DEFAULT '',
+    packets BIGINT       NOT NULL DEFAULT 0,
+    bytes   BIGINT       NOT NULL DEFAULT 0,
+    lost    BIGINT       NOT NULL DEFAULT 0,
+    jitter  DOUBLE       NOT NULL DEFAULT 0,
+    PRIMARY KEY (bill_id, leg_id)
+);
@@ -0,0 +1,40 @@
+// Package directory 集群的会话目录: 记录每个session由哪个网关节点持有,
+// 后加入的参与者据此重定向或中继到持有节点.
+package directory
+
+import (
+	log "common/log/newlog"
+	"context"
+	"errors"
+	"time"
+)
+
+const _defaultTTL = 30 * time.Second
+
+var (
+	// ErrNotFound -.
+	ErrNotFound = errors.New("session not in directory")
+	// ErrNotOwner -.
+	ErrNotOwner = errors.New("session owned by another node")
+)
+
+// Logger is an implementation of log.Logger. If is not provided - will be turned off.
+var Logger log.Logger = log.GetLogger()
+
+// Node is a gateway node owning sessions
+type Node struct {
+	ID string `json:"id"`
+	// URL 客户端重定向和中继使用的websocket地址, 如 ws://10.0.0.2:8080/v1/signal
+	URL string `json:"url"`
+}
+
+// Directory maps session ids to owning nodes
+type Directory interface {
+	// Claim 把session分配给node, 已被其他节点持有时返回持有者, 返回的Node等于node表示本节点持有.
+	Claim(ctx context.Context, sessionID string, node Node) (Node, error)
+	// Lookup returns the owner of session, ErrNotFound if nobody owns it
+	Lookup(ctx context.Context, sessionID string) (Node, error)
+	// Release 释放node持有的session, 不是持有者时返回ErrNotOwner.
+	Release(ctx context.Context, sessionID string, node Node) error
+	Close() error
+}
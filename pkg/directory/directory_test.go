@@ -0,0 +1,103 @@
+package directory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/internal/testutil"
+)
+
+var (
+	nodeA = Node{ID: "a", URL: "ws://10.0.0.1:8080/v1/signal"}
+	nodeB = Node{ID: "b", URL: "ws://10.0.0.2:8080/v1/signal"}
+)
+
+// testDirectory 各实现共同的行为, dir和other是两个节点看到的同一个目录.
+func testDirectory(t *testing.T, dir, other Directory) {
+	ctx := context.Background()
+
+	_, err := dir.Lookup(ctx, "s1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, dir.Release(ctx, "s1", nodeA), ErrNotFound)
+
+	owner, err := dir.Claim(ctx, "s1", nodeA)
+	require.NoError(t, err)
+	assert.Equal(t, nodeA, owner)
+	// 已经持有时再次Claim返回自己.
+	owner, err = dir.Claim(ctx, "s1", nodeA)
+	require.NoError(t, err)
+	assert.Equal(t, nodeA, owner)
+
+	owner, err = other.Claim(ctx, "s1", nodeB)
+	require.NoError(t, err)
+	assert.Equal(t, nodeA, owner)
+	owner, err = other.Lookup(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, nodeA, owner)
+	assert.ErrorIs(t, other.Release(ctx, "s1", nodeB), ErrNotOwner)
+
+	require.NoError(t, dir.Release(ctx, "s1", nodeA))
+	_, err = other.Lookup(ctx, "s1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	owner, err = other.Claim(ctx, "s1", nodeB)
+	require.NoError(t, err)
+	assert.Equal(t, nodeB, owner)
+}
+
+func TestMemory(t *testing.T) {
+	dir := NewMemory()
+	testDirectory(t, dir, dir)
+	assert.NoError(t, dir.Close())
+}
+
+func TestRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+	newRedis := func() *Redis {
+		return NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisTTL(30*time.Millisecond))
+	}
+	a, b := newRedis(), newRedis()
+	defer b.Close()
+	testDirectory(t, a, b)
+
+	ctx := context.Background()
+	_, err = a.Claim(ctx, "s2", nodeA)
+	require.NoError(t, err)
+	// 持有期间定期续期.
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		mr.FastForward(20 * time.Millisecond)
+	}
+	owner, err := b.Lookup(ctx, "s2")
+	require.NoError(t, err)
+	assert.Equal(t, nodeA, owner)
+
+	// 关闭后不再续期, ttl后过期.
+	require.NoError(t, a.Close())
+	mr.FastForward(30 * time.Millisecond)
+	_, err = b.Lookup(ctx, "s2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestEtcd(t *testing.T) {
+	client := testutil.StartEtcd(t)
+	a, b := NewEtcd(client, EtcdPrefix("/test/")), NewEtcd(client, EtcdPrefix("/test/"))
+	defer b.Close()
+	testDirectory(t, a, b)
+
+	ctx := context.Background()
+	_, err := a.Claim(ctx, "s2", nodeA)
+	require.NoError(t, err)
+	// 关闭时撤销lease, 持有的会话一起释放.
+	require.NoError(t, a.Close())
+	_, err = b.Lookup(ctx, "s2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
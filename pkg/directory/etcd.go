@@ -0,0 +1,183 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const _defaultEtcdPrefix = "/mediasfu/sessions/"
+
+// Etcd 基于etcd的目录, 节点持有的session挂在同一个lease上, 节点宕机后lease过期自动释放.
+type Etcd struct {
+	client *clientv3.Client
+	prefix string
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	lease clientv3.LeaseID
+	stop  context.CancelFunc
+}
+
+// EtcdOption -.
+type EtcdOption func(*Etcd)
+
+// EtcdPrefix -.
+func EtcdPrefix(prefix string) EtcdOption {
+	return func(e *Etcd) {
+		e.prefix = prefix
+	}
+}
+
+// EtcdTTL lease的有效期, 节点失联后持有的session在ttl后释放.
+func EtcdTTL(ttl time.Duration) EtcdOption {
+	return func(e *Etcd) {
+		e.ttl = ttl
+	}
+}
+
+// NewEtcd -.
+func NewEtcd(client *clientv3.Client, opts ...EtcdOption) *Etcd {
+	e := &Etcd{
+		client: client,
+		prefix: _defaultEtcdPrefix,
+		ttl:    _defaultTTL,
+	}
+
+	// Custom options
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Claim -.
+func (e *Etcd) Claim(ctx context.Context, sessionID string, node Node) (Node, error) {
+	lease, err := e.grant(ctx)
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Etcd - Claim - grant: %w", err)
+	}
+	value, err := json.Marshal(node)
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Etcd - Claim - json.Marshal: %w", err)
+	}
+
+	key := e.prefix + sessionID
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(lease))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Etcd - Claim - Txn: %w", err)
+	}
+	if resp.Succeeded {
+		return node, nil
+	}
+
+	kvs := resp.Responses[0].GetResponseRange().Kvs
+	if len(kvs) == 0 {
+		// 读取前被释放, 由调用方重试.
+		return Node{}, ErrNotFound
+	}
+	var owner Node
+	if err = json.Unmarshal(kvs[0].Value, &owner); err != nil {
+		return Node{}, fmt.Errorf("directory - Etcd - Claim - json.Unmarshal: %w", err)
+	}
+	return owner, nil
+}
+
+// Lookup -.
+func (e *Etcd) Lookup(ctx context.Context, sessionID string) (Node, error) {
+	resp, err := e.client.Get(ctx, e.prefix+sessionID)
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Etcd - Lookup - Get: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return Node{}, ErrNotFound
+	}
+	var owner Node
+	if err = json.Unmarshal(resp.Kvs[0].Value, &owner); err != nil {
+		return Node{}, fmt.Errorf("directory - Etcd - Lookup - json.Unmarshal: %w", err)
+	}
+	return owner, nil
+}
+
+// Release -.
+func (e *Etcd) Release(ctx context.Context, sessionID string, node Node) error {
+	value, err := json.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("directory - Etcd - Release - json.Marshal: %w", err)
+	}
+
+	key := e.prefix + sessionID
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", string(value))).
+		Then(clientv3.OpDelete(key)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("directory - Etcd - Release - Txn: %w", err)
+	}
+	if resp.Succeeded {
+		return nil
+	}
+	if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+		return ErrNotFound
+	}
+	return ErrNotOwner
+}
+
+// Close revokes the lease, all sessions of this node are released
+func (e *Etcd) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stop == nil {
+		return nil
+	}
+	e.stop()
+	e.stop = nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := e.client.Revoke(ctx, e.lease)
+	return err
+}
+
+// grant 第一次Claim时申请lease并保持续约, lease失效后重新申请.
+func (e *Etcd) grant(ctx context.Context) (clientv3.LeaseID, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stop != nil {
+		return e.lease, nil
+	}
+
+	resp, err := e.client.Grant(ctx, int64(e.ttl/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	keepCtx, stop := context.WithCancel(context.Background())
+	ch, err := e.client.KeepAlive(keepCtx, resp.ID)
+	if err != nil {
+		stop()
+		return 0, err
+	}
+	e.lease, e.stop = resp.ID, stop
+
+	go func() {
+		for range ch {
+		}
+		// 续约中断(etcd失联或lease过期), 下次Claim重新申请.
+		e.mu.Lock()
+		if e.lease == resp.ID && e.stop != nil {
+			Logger.Warn("directory - Etcd - lease keepalive stopped", "lease", int64(resp.ID))
+			e.stop()
+			e.stop = nil
+		}
+		e.mu.Unlock()
+	}()
+	return e.lease, nil
+}
@@ -0,0 +1,59 @@
+package directory
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory 单进程的目录, 用于单节点部署和测试.
+type Memory struct {
+	sync.Mutex
+	owners map[string]Node
+}
+
+// NewMemory -.
+func NewMemory() *Memory {
+	return &Memory{owners: make(map[string]Node)}
+}
+
+// Claim -.
+func (m *Memory) Claim(ctx context.Context, sessionID string, node Node) (Node, error) {
+	m.Lock()
+	defer m.Unlock()
+	if owner, ok := m.owners[sessionID]; ok {
+		return owner, nil
+	}
+	m.owners[sessionID] = node
+	return node, nil
+}
+
+// Lookup -.
+func (m *Memory) Lookup(ctx context.Context, sessionID string) (Node, error) {
+	m.Lock()
+	defer m.Unlock()
+	owner, ok := m.owners[sessionID]
+	if !ok {
+		return Node{}, ErrNotFound
+	}
+	return owner, nil
+}
+
+// Release -.
+func (m *Memory) Release(ctx context.Context, sessionID string, node Node) error {
+	m.Lock()
+	defer m.Unlock()
+	owner, ok := m.owners[sessionID]
+	if !ok {
+		return ErrNotFound
+	}
+	if owner.ID != node.ID {
+		return ErrNotOwner
+	}
+	delete(m.owners, sessionID)
+	return nil
+}
+
+// Close -.
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const _defaultRedisPrefix = "mediasfu:session:"
+
+// 值相等时才删除/续期, 避免释放别的节点重新持有的session.
+var (
+	_releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return -1`)
+	_refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Redis 基于redis的目录, 每个session一个带过期时间的key, 本节点持有的key定期续期.
+type Redis struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	owned map[string]string // key -> value
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// RedisOption -.
+type RedisOption func(*Redis)
+
+// RedisPrefix -.
+func RedisPrefix(prefix string) RedisOption {
+	return func(r *Redis) {
+		r.prefix = prefix
+	}
+}
+
+// RedisTTL key的过期时间, 每ttl/3续期一次.
+func RedisTTL(ttl time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.ttl = ttl
+	}
+}
+
+// NewRedis -.
+func NewRedis(client redis.UniversalClient, opts ...RedisOption) *Redis {
+	r := &Redis{
+		client: client,
+		prefix: _defaultRedisPrefix,
+		ttl:    _defaultTTL,
+		owned:  make(map[string]string),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	// Custom options
+	for _, opt := range opts {
+		opt(r)
+	}
+	go r.refresh()
+	return r
+}
+
+// Claim -.
+func (r *Redis) Claim(ctx context.Context, sessionID string, node Node) (Node, error) {
+	value, err := json.Marshal(node)
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Redis - Claim - json.Marshal: %w", err)
+	}
+
+	key := r.prefix + sessionID
+	ok, err := r.client.SetNX(ctx, key, value, r.ttl).Result()
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Redis - Claim - SetNX: %w", err)
+	}
+	if ok {
+		r.mu.Lock()
+		r.owned[key] = string(value)
+		r.mu.Unlock()
+		return node, nil
+	}
+	return r.Lookup(ctx, sessionID)
+}
+
+// Lookup -.
+func (r *Redis) Lookup(ctx context.Context, sessionID string) (Node, error) {
+	value, err := r.client.Get(ctx, r.prefix+sessionID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Node{}, ErrNotFound
+	}
+	if err != nil {
+		return Node{}, fmt.Errorf("directory - Redis - Lookup - Get: %w", err)
+	}
+	var owner Node
+	if err = json.Unmarshal(value, &owner); err != nil {
+		return Node{}, fmt.Errorf("directory - Redis - Lookup - json.Unmarshal: %w", err)
+	}
+	return owner, nil
+}
+
+// Release -.
+func (r *Redis) Release(ctx context.Context, sessionID string, node Node) error {
+	value, err := json.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("directory - Redis - Release - json.Marshal: %w", err)
+	}
+
+	key := r.prefix + sessionID
+	r.mu.Lock()
+	delete(r.owned, key)
+	r.mu.Unlock()
+
+	n, err := _releaseScript.Run(ctx, r.client, []string{key}, string(value)).Int()
+	if err != nil {
+		return fmt.Errorf("directory - Redis - Release - releaseScript: %w", err)
+	}
+	if n == 1 {
+		return nil
+	}
+	// key不存在和值不等都返回-1, 再区分一次.
+	if _, err = r.Lookup(ctx, sessionID); errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	return ErrNotOwner
+}
+
+// Close stops refreshing, sessions of this node expire after ttl
+func (r *Redis) Close() error {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+		<-r.done
+	}
+	return nil
+}
+
+func (r *Redis) refresh() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		owned := make(map[string]string, len(r.owned))
+		for k, v := range r.owned {
+			owned[k] = v
+		}
+		r.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.ttl/3)
+		for key, value := range owned {
+			n, err := _refreshScript.Run(ctx, r.client, []string{key}, value, r.ttl.Milliseconds()).Int()
+			if err != nil {
+				Logger.Error(err, "directory - Redis - refresh", "key", key)
+				continue
+			}
+			if n == 0 {
+				// 已过期或被其他节点持有.
+				r.mu.Lock()
+				if r.owned[key] == value {
+					delete(r.owned, key)
+				}
+				r.mu.Unlock()
+			}
+		}
+		cancel()
+	}
+}
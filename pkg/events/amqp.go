@@ -0,0 +1,245 @@
+package events
+
+import (
+	"common/broker"
+	"common/rabbitmq"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultBufferSize        = 10000
+	_defaultReconnectInterval = time.Second
+	_maxReconnectInterval     = 30 * time.Second
+)
+
+// NewRabbitMQBroker creates a broker publishing to a topic exchange
+func NewRabbitMQBroker(url, exchange string) broker.Broker {
+	b := rabbitmq.NewBroker()
+	_ = b.Init(broker.Addrs(url), rabbitmq.Exchange(exchange), rabbitmq.KindExchange("topic"))
+	return b
+}
+
+// Option -.
+type Option func(*AMQPPublisher)
+
+// BufferSize 断线期间本地缓存的事件数, 超过后丢弃最早的事件.
+func BufferSize(size int) Option {
+	return func(p *AMQPPublisher) {
+		p.bufferSize = size
+	}
+}
+
+// ReconnectInterval 第一次重连的间隔, 之后翻倍直到30秒.
+func ReconnectInterval(d time.Duration) Option {
+	return func(p *AMQPPublisher) {
+		p.reconnectInterval = d
+	}
+}
+
+// AMQPPublisher 把总线上的事件发布到topic exchange, routing key为 <session>.<bill-id>.<type>.
+// 发送失败时缓存在本地并重连, 连上后按顺序补发.
+type AMQPPublisher struct {
+	broker            broker.Broker
+	bufferSize        int
+	reconnectInterval time.Duration
+
+	mu        sync.Mutex
+	pending   []Event
+	dropped   uint64
+	connected bool
+
+	notify      chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	unsubscribe func()
+	closeOnce   sync.Once
+}
+
+// NewAMQPPublisher -.
+func NewAMQPPublisher(b broker.Broker, opts ...Option) *AMQPPublisher {
+	p := &AMQPPublisher{
+		broker:            b,
+		bufferSize:        _defaultBufferSize,
+		reconnectInterval: _defaultReconnectInterval,
+		notify:            make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+
+	// Custom options
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Start connects the broker and publishes all events of bus
+func (p *AMQPPublisher) Start(bus *Bus) {
+	if err := p.broker.Connect(); err != nil {
+		Logger.Error(err, "events - AMQPPublisher - Start - broker.Connect")
+	} else {
+		p.connected = true
+	}
+	p.unsubscribe = bus.Subscribe(p.enqueue)
+	go p.run()
+}
+
+// Close stops publishing, events still buffered are flushed once if the broker is connected.
+func (p *AMQPPublisher) Close() error {
+	p.closeOnce.Do(func() {
+		if p.unsubscribe != nil {
+			p.unsubscribe()
+		}
+		close(p.stop)
+		<-p.done
+	})
+	return p.broker.Disconnect()
+}
+
+// Stats returns the number of buffered and dropped events
+func (p *AMQPPublisher) Stats() (pending int, dropped uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending), p.dropped
+}
+
+func (p *AMQPPublisher) enqueue(e Event) {
+	p.mu.Lock()
+	if len(p.pending) >= p.bufferSize {
+		p.pending = p.pending[1:]
+		p.dropped++
+	}
+	p.pending = append(p.pending, e)
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *AMQPPublisher) run() {
+	defer close(p.done)
+
+	interval := p.reconnectInterval
+	retry := time.NewTimer(interval)
+	defer retry.Stop()
+	for {
+		select {
+		case <-p.stop:
+			if p.isConnected() {
+				p.flush()
+			}
+			return
+		case <-p.notify:
+			if !p.isConnected() {
+				continue
+			}
+		case <-retry.C:
+			if !p.isConnected() {
+				if err := p.reconnect(); err != nil {
+					Logger.Error(err, "events - AMQPPublisher - reconnect", "retry", interval.String())
+					if interval *= 2; interval > _maxReconnectInterval {
+						interval = _maxReconnectInterval
+					}
+					retry.Reset(interval)
+					continue
+				}
+				interval = p.reconnectInterval
+			}
+		}
+
+		if p.flush() {
+			continue
+		}
+		// 发送失败, 等待重连.
+		if !retry.Stop() {
+			select {
+			case <-retry.C:
+			default:
+			}
+		}
+		retry.Reset(interval)
+	}
+}
+
+// flush 按顺序发送缓存的事件, 失败时保留剩余的事件并返回false.
+func (p *AMQPPublisher) flush() bool {
+	for {
+		p.mu.Lock()
+		if len(p.pending) == 0 {
+			p.mu.Unlock()
+			return true
+		}
+		e := p.pending[0]
+		p.mu.Unlock()
+
+		if err := p.publish(e); err != nil {
+			Logger.Error(err, "events - AMQPPublisher - publish", "type", e.Type, "session", e.SessionID)
+			p.mu.Lock()
+			p.connected = false
+			p.mu.Unlock()
+			return false
+		}
+
+		p.mu.Lock()
+		// 发送期间缓存满了丢弃过最早的事件, 这时第一个已经不是e.
+		if len(p.pending) > 0 && p.pending[0].ID == e.ID {
+			p.pending = p.pending[1:]
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *AMQPPublisher) publish(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		// 无法编码的事件直接丢弃.
+		Logger.Error(err, "events - AMQPPublisher - json.Marshal", "type", e.Type)
+		return nil
+	}
+	return p.broker.Publish(RoutingKey(e), &broker.Message{
+		Header: map[string]string{
+			"type":      e.Type,
+			"version":   strconv.Itoa(e.Version),
+			"sessionId": e.SessionID,
+			"billId":    e.BillID,
+		},
+		Body: body,
+	})
+}
+
+func (p *AMQPPublisher) reconnect() error {
+	_ = p.broker.Disconnect()
+	if err := p.broker.Connect(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.connected = true
+	p.mu.Unlock()
+	Logger.Info("events - AMQPPublisher - reconnected")
+	return nil
+}
+
+func (p *AMQPPublisher) isConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+// RoutingKey returns <session>.<bill-id>.<type>, consumers bind e.g. "*.*.dtmf" or "room1.#"
+func RoutingKey(e Event) string {
+	return routingWord(e.SessionID) + "." + routingWord(e.BillID) + "." + e.Type
+}
+
+// routingWord topic的单词不能包含点, 空值用"-".
+func routingWord(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.NewReplacer(".", "_", "*", "_", "#", "_").Replace(s)
+}
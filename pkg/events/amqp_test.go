@@ -0,0 +1,163 @@
+package events
+
+import (
+	"common/broker"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBrokerDown = errors.New("broker down")
+
+// fakeBroker 记录发布的事件, down时Connect和Publish失败.
+type fakeBroker struct {
+	broker.Broker
+	sync.Mutex
+	down         bool
+	connects     int
+	disconnected bool
+	keys         []string
+	events       []Event
+}
+
+func (b *fakeBroker) setDown(down bool) {
+	b.Lock()
+	b.down = down
+	b.Unlock()
+}
+
+func (b *fakeBroker) Connect() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.down {
+		return errBrokerDown
+	}
+	b.connects++
+	b.disconnected = false
+	return nil
+}
+
+func (b *fakeBroker) Disconnect() error {
+	b.Lock()
+	b.disconnected = true
+	b.Unlock()
+	return nil
+}
+
+func (b *fakeBroker) Publish(topic string, m *broker.Message, _ ...broker.PublishOption) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.down {
+		return errBrokerDown
+	}
+	var e Event
+	if err := json.Unmarshal(m.Body, &e); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, topic)
+	b.events = append(b.events, e)
+	return nil
+}
+
+// billIDs 按发布顺序返回事件的bill id.
+func (b *fakeBroker) billIDs() []string {
+	b.Lock()
+	defer b.Unlock()
+	ids := make([]string, 0, len(b.events))
+	for _, e := range b.events {
+		ids = append(ids, e.BillID)
+	}
+	return ids
+}
+
+func newTestPublisher(t *testing.T, b *fakeBroker, opts ...Option) (*AMQPPublisher, *Bus) {
+	bus := NewBus()
+	p := NewAMQPPublisher(b, append([]Option{ReconnectInterval(10 * time.Millisecond)}, opts...)...)
+	p.Start(bus)
+	t.Cleanup(func() { _ = p.Close() })
+	return p, bus
+}
+
+func TestAMQPPublisher_Reconnect(t *testing.T) {
+	b := &fakeBroker{down: true}
+	p, bus := newTestPublisher(t, b, BufferSize(3))
+
+	// 断线期间缓存, 超过BufferSize丢弃最早的.
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		bus.Publish(TypeDTMF, "room.1", id, DTMFData{Digit: id})
+	}
+	pending, dropped := p.Stats()
+	assert.Equal(t, 3, pending)
+	assert.Equal(t, uint64(2), dropped)
+	assert.Empty(t, b.billIDs())
+
+	b.setDown(false)
+	assert.Eventually(t, func() bool { return len(b.billIDs()) == 3 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"3", "4", "5"}, b.billIDs())
+	assert.Equal(t, "room_1.3.dtmf", b.keys[0])
+	pending, dropped = p.Stats()
+	assert.Equal(t, 0, pending)
+	assert.Equal(t, uint64(2), dropped)
+}
+
+func TestAMQPPublisher_PublishFailure(t *testing.T) {
+	b := &fakeBroker{}
+	p, bus := newTestPublisher(t, b)
+
+	bus.Publish(TypeDTMF, "room1", "1", nil)
+	assert.Eventually(t, func() bool { return len(b.billIDs()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// 发送失败的事件保留, 重连后按顺序补发.
+	b.setDown(true)
+	bus.Publish(TypeDTMF, "room1", "2", nil)
+	bus.Publish(TypeDTMF, "room1", "3", nil)
+	assert.Eventually(t, func() bool { return !p.isConnected() }, time.Second, 10*time.Millisecond)
+	pending, _ := p.Stats()
+	assert.Equal(t, 2, pending)
+
+	b.setDown(false)
+	assert.Eventually(t, func() bool { return len(b.billIDs()) == 3 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"1", "2", "3"}, b.billIDs())
+	b.Lock()
+	assert.GreaterOrEqual(t, b.connects, 2)
+	b.Unlock()
+}
+
+func TestAMQPPublisher_Close(t *testing.T) {
+	b := &fakeBroker{}
+	p := NewAMQPPublisher(b, ReconnectInterval(time.Hour))
+	p.Start(NewBus())
+
+	// 不通知发送协程, 只有Close时的flush能发出.
+	p.mu.Lock()
+	p.pending = append(p.pending, New(TypeDTMF, "room1", "1", nil), New(TypeDTMF, "room1", "2", nil))
+	p.mu.Unlock()
+	require.NoError(t, p.Close())
+	assert.Equal(t, []string{"1", "2"}, b.billIDs())
+	pending, _ := p.Stats()
+	assert.Equal(t, 0, pending)
+	b.Lock()
+	assert.True(t, b.disconnected)
+	b.Unlock()
+
+	// 断线时Close不阻塞, 事件留在缓存里.
+	down := &fakeBroker{down: true}
+	p = NewAMQPPublisher(down, ReconnectInterval(time.Hour))
+	bus := NewBus()
+	p.Start(bus)
+	bus.Publish(TypeDTMF, "room1", "1", nil)
+	require.NoError(t, p.Close())
+	assert.Empty(t, down.billIDs())
+	pending, _ = p.Stats()
+	assert.Equal(t, 1, pending)
+
+	// 关闭后不再接收总线上的事件.
+	bus.Publish(TypeDTMF, "room1", "2", nil)
+	pending, _ = p.Stats()
+	assert.Equal(t, 1, pending)
+}
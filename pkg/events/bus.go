@@ -0,0 +1,54 @@
+package events
+
+import "sync"
+
+// Bus 进程内的事件分发, 订阅者在Emit的goroutine中被调用, 不能阻塞.
+// nil的Bus可以直接使用, 事件被丢弃.
+type Bus struct {
+	sync.RWMutex
+	subs map[int]func(Event)
+	next int
+}
+
+// NewBus -.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]func(Event))}
+}
+
+// Subscribe registers f and returns the function to unsubscribe
+func (b *Bus) Subscribe(f func(Event)) func() {
+	b.Lock()
+	id := b.next
+	b.next++
+	b.subs[id] = f
+	b.Unlock()
+	return func() {
+		b.Lock()
+		delete(b.subs, id)
+		b.Unlock()
+	}
+}
+
+// Emit delivers e to all subscribers
+func (b *Bus) Emit(e Event) {
+	if b == nil {
+		return
+	}
+	b.RLock()
+	subs := make([]func(Event), 0, len(b.subs))
+	for _, f := range b.subs {
+		subs = append(subs, f)
+	}
+	b.RUnlock()
+	for _, f := range subs {
+		f(e)
+	}
+}
+
+// Publish creates and emits an event
+func (b *Bus) Publish(typ, sessionID, billID string, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.Emit(New(typ, sessionID, billID, data))
+}
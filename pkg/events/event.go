@@ -0,0 +1,88 @@
+// Package events 网关内部的媒体事件总线, 计费和监控通过rabbitmq消费.
+package events
+
+import (
+	log "common/log/newlog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version 事件格式版本, 字段不兼容变化时增加.
+const Version = 1
+
+// 事件类型.
+const (
+	TypeICEState         = "ice.state"
+	TypeTrackPublished   = "track.published"
+	TypeTrackUnpublished = "track.unpublished"
+	TypeActiveSpeaker    = "speaker.active"
+	TypeDTMF             = "dtmf"
+	TypeMediaTimeout     = "media.timeout"
+	TypeQualityDrop      = "peer.quality_drop"
+)
+
+// Logger is an implementation of log.Logger. If is not provided - will be turned off.
+var Logger log.Logger = log.GetLogger()
+
+// Event is a versioned media event, routed by session and bill id
+type Event struct {
+	Version   int         `json:"version"`
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Time      int64       `json:"time"` // unix ms
+	SessionID string      `json:"sessionId"`
+	BillID    string      `json:"billId"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// ICEStateData -.
+type ICEStateData struct {
+	State string `json:"state"`
+}
+
+// TrackData -.
+type TrackData struct {
+	TrackID  string `json:"trackId"`
+	StreamID string `json:"streamId"`
+	Kind     string `json:"kind"`
+}
+
+// ActiveSpeakerData -.
+type ActiveSpeakerData struct {
+	Speakers []string `json:"speakers"`
+}
+
+// DTMFData -.
+type DTMFData struct {
+	Digit    string `json:"digit"`
+	Duration int64  `json:"duration"` // ms
+}
+
+// MediaTimeoutData -.
+type MediaTimeoutData struct {
+	LegID   string `json:"legId"`
+	Timeout int64  `json:"timeout,omitempty"` // ms
+}
+
+// QualityData MOS 1-5, 0表示该方向没有媒体.
+type QualityData struct {
+	PeerID    string  `json:"peerId"`
+	Score     float64 `json:"score"`
+	Publish   float64 `json:"publish"`
+	Subscribe float64 `json:"subscribe"`
+	Threshold float64 `json:"threshold"`
+}
+
+// New creates an event of typ
+func New(typ, sessionID, billID string, data interface{}) Event {
+	return Event{
+		Version:   Version,
+		ID:        uuid.New().String(),
+		Type:      typ,
+		Time:      time.Now().UnixNano() / int64(time.Millisecond),
+		SessionID: sessionID,
+		BillID:    billID,
+		Data:      data,
+	}
+}
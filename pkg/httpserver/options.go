@@ -0,0 +1,37 @@
+package httpserver
+
+import (
+	"common/registry"
+	"time"
+)
+
+// Option -.
+type Option func(*Server)
+
+// Registry registers the node, e.g. registry.DefaultRegistry for etcd
+func Registry(reg registry.Registry) Option {
+	return func(s *Server) {
+		s.registry = reg
+	}
+}
+
+// Advertise is the host written to the node, defaults to the host of the listen address
+func Advertise(host string) Option {
+	return func(s *Server) {
+		s.advertise = host
+	}
+}
+
+// LoadReport is called every heartbeat, the load is written to the node metadata
+func LoadReport(f func() Load) Option {
+	return func(s *Server) {
+		s.load = f
+	}
+}
+
+// HeartBeat -.
+func HeartBeat(interval time.Duration) Option {
+	return func(s *Server) {
+		s.heartBeat = interval
+	}
+}
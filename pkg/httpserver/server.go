@@ -7,6 +7,11 @@ import (
 	"common/registry"
 	"common/service-wrapper"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net"
+	"os"
+	"strconv"
+	"time"
 )
 
 const (
@@ -35,9 +40,91 @@ var (
 	}
 )
 
+// 节点负载写入metadata的key, 控制面按load选择最空闲的网关.
+const (
+	MetaSessions   = "sessions"
+	MetaPeers      = "peers"
+	MetaPortsUsed  = "portsUsed"
+	MetaPortsTotal = "portsTotal"
+	MetaBandwidth  = "bandwidth"
+	MetaLoad       = "load"
+)
+
+// StateActive 在线节点的serviceStatus, 模板中的monitor.DeleteState只用于注销.
+const StateActive = "active"
+
+// Load is the live load of a gateway node
+type Load struct {
+	Sessions   int
+	Peers      int
+	PortsUsed  int
+	PortsTotal int
+	Bandwidth  uint64 // bps, rtp收发之和
+}
+
+// Percent 0-100, 端口是网关的硬上限, 按端口使用率计算.
+func (l Load) Percent() int {
+	if l.PortsTotal <= 0 {
+		return 0
+	}
+	return l.PortsUsed * 100 / l.PortsTotal
+}
+
+func (l Load) metadata(m map[string]string) {
+	m[MetaSessions] = strconv.Itoa(l.Sessions)
+	m[MetaPeers] = strconv.Itoa(l.Peers)
+	m[MetaPortsUsed] = strconv.Itoa(l.PortsUsed)
+	m[MetaPortsTotal] = strconv.Itoa(l.PortsTotal)
+	m[MetaBandwidth] = strconv.FormatUint(l.Bandwidth, 10)
+	m[MetaLoad] = strconv.Itoa(l.Percent())
+}
+
 // Server -.
 type Server struct {
 	service service_wrapper.Service
+
+	registry  registry.Registry
+	register  func(*registry.Service) error
+	advertise string
+	heartBeat time.Duration
+	load      func() Load
+	info      *registry.Service
+	l         log.Logger
+}
+
+// New registers the node with the registry of opts, every heartbeat re-registers it carrying the live load.
+// Without Registry it is the same as NewNoEtcd.
+func New(handler *gin.Engine, l log.Logger, address string, opts ...Option) *Server {
+	s := &Server{
+		heartBeat: monitor.HeartBeatCheck,
+		l:         l,
+	}
+
+	// Custom options
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.registry == nil {
+		return NewNoEtcd(handler, l, address)
+	}
+	s.register = func(info *registry.Service) error {
+		return s.registry.Register(info)
+	}
+
+	s.info = s.serviceInfo(address)
+	// wrapper负责首次注册和退出时注销, 心跳由keepAlive发送新的副本, 避免wrapper读metadata时被并发修改.
+	s.service = service_wrapper.NewService(service_wrapper.Address(address),
+		service_wrapper.Engine(handler),
+		service_wrapper.ServiceInfo(s.info),
+		service_wrapper.Registry(s.registry))
+
+	stop := make(chan struct{})
+	go s.keepAlive(stop)
+	if err := s.service.Run(); err != nil {
+		l.Error("service StartFail:%v", err)
+	}
+	close(stop)
+	return s
 }
 
 // New -.
@@ -51,10 +138,70 @@ func NewNoEtcd(handler *gin.Engine, l log.Logger, address string) *Server {
 	if err := service.Run(); err != nil {
 		l.Error("service StartFail:%v", err)
 	}
-	return &Server{service}
+	return &Server{service: service}
 }
 
 // Shutdown -.
 func (s *Server) Shutdown() error {
 	return s.service.Shutdown()
 }
+
+// serviceInfo 基于Service生成本节点的注册信息, 节点id加上随机后缀.
+func (s *Server) serviceInfo(address string) *registry.Service {
+	host, port, _ := net.SplitHostPort(address)
+	if s.advertise != "" {
+		host = s.advertise
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	p, _ := strconv.Atoi(port)
+
+	tmpl := Service.Nodes[0]
+	node := &registry.Node{
+		Id:       tmpl.Id + uuid.New().String(),
+		Address:  host,
+		Port:     p,
+		Metadata: make(map[string]string, len(tmpl.Metadata)),
+	}
+	for k, v := range tmpl.Metadata {
+		node.Metadata[k] = v
+	}
+	node.Metadata[monitor.ServiceStatus] = StateActive
+	return &registry.Service{
+		Name:     Service.Name,
+		Metadata: Service.Metadata,
+		Nodes:    []*registry.Node{node},
+		Version:  Service.Version,
+	}
+}
+
+// keepAlive 每个心跳周期带上负载重新注册.
+func (s *Server) keepAlive(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.heartBeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.register(s.withLoad()); err != nil {
+				s.l.Error(err, "httpserver - keepAlive - registry.Register")
+			}
+		}
+	}
+}
+
+func (s *Server) withLoad() *registry.Service {
+	node := *s.info.Nodes[0]
+	node.Metadata = make(map[string]string, len(s.info.Nodes[0].Metadata)+6)
+	for k, v := range s.info.Nodes[0].Metadata {
+		node.Metadata[k] = v
+	}
+	if s.load != nil {
+		s.load().metadata(node.Metadata)
+	}
+	info := *s.info
+	info.Nodes = []*registry.Node{&node}
+	return &info
+}
@@ -0,0 +1,107 @@
+package httpserver
+
+import (
+	"common/monitor"
+	"common/registry"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"mediasfu/internal/testutil"
+)
+
+func TestLoad(t *testing.T) {
+	assert.Equal(t, 0, Load{}.Percent())
+	assert.Equal(t, 0, Load{PortsUsed: 5}.Percent())
+	assert.Equal(t, 25, Load{PortsUsed: 250, PortsTotal: 1000}.Percent())
+	assert.Equal(t, 100, Load{PortsUsed: 1000, PortsTotal: 1000}.Percent())
+
+	m := map[string]string{"serverTag": "media-proxy"}
+	Load{Sessions: 2, Peers: 5, PortsUsed: 10, PortsTotal: 40, Bandwidth: 64000}.metadata(m)
+	assert.Equal(t, map[string]string{
+		"serverTag":    "media-proxy",
+		MetaSessions:   "2",
+		MetaPeers:      "5",
+		MetaPortsUsed:  "10",
+		MetaPortsTotal: "40",
+		MetaBandwidth:  "64000",
+		MetaLoad:       "25",
+	}, m)
+}
+
+func TestServer_serviceInfo(t *testing.T) {
+	s := &Server{advertise: "10.0.0.1"}
+	info := s.serviceInfo(":8080")
+	assert.Equal(t, Service.Name, info.Name)
+	require.Len(t, info.Nodes, 1)
+	node := info.Nodes[0]
+	assert.True(t, strings.HasPrefix(node.Id, Service.Nodes[0].Id))
+	assert.NotEqual(t, Service.Nodes[0].Id, node.Id)
+	assert.Equal(t, "10.0.0.1", node.Address)
+	assert.Equal(t, 8080, node.Port)
+	assert.Equal(t, StateActive, node.Metadata[monitor.ServiceStatus])
+	// 模板不变.
+	assert.Equal(t, monitor.DeleteState, Service.Nodes[0].Metadata[monitor.ServiceStatus])
+	assert.NotEqual(t, node.Id, (&Server{}).serviceInfo(":8080").Nodes[0].Id)
+}
+
+func TestServer_keepAlive(t *testing.T) {
+	client := testutil.StartEtcd(t)
+	ctx := context.Background()
+
+	// 按etcd注册中心的方式写入 /micro/registry/<name>/<node id>, 带ttl.
+	load := Load{Sessions: 1, PortsUsed: 10, PortsTotal: 100}
+	s := &Server{
+		heartBeat: 10 * time.Millisecond,
+		load:      func() Load { return load },
+		register: func(info *registry.Service) error {
+			b, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			lease, err := client.Grant(ctx, 10)
+			if err != nil {
+				return err
+			}
+			_, err = client.Put(ctx, "/micro/registry/"+info.Name+"/"+info.Nodes[0].Id, string(b), clientv3.WithLease(lease.ID))
+			return err
+		},
+	}
+	s.info = s.serviceInfo("127.0.0.1:8080")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.keepAlive(stop)
+		close(done)
+	}()
+
+	key := "/micro/registry/" + Service.Name + "/" + s.info.Nodes[0].Id
+	var registered registry.Service
+	require.Eventually(t, func() bool {
+		res, err := client.Get(ctx, key)
+		if err != nil || len(res.Kvs) == 0 {
+			return false
+		}
+		return json.Unmarshal(res.Kvs[0].Value, &registered) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, registered.Nodes, 1)
+	assert.Equal(t, StateActive, registered.Nodes[0].Metadata[monitor.ServiceStatus])
+	assert.Equal(t, "10", registered.Nodes[0].Metadata[MetaLoad])
+	assert.Equal(t, "media-proxy", registered.Nodes[0].Metadata["serverTag"])
+	// 负载不写回模板.
+	_, ok := s.info.Nodes[0].Metadata[MetaLoad]
+	assert.False(t, ok)
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keepAlive did not stop")
+	}
+}
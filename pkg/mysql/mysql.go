@@ -47,14 +47,11 @@ func New(url string, opts ...Option) (*Mysql, error) {
 	ms.Builder = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question)
 
 	// poolConfig, err := pgxpool.ParseConfig(url)
+	// url为go-sql-driver的dsn, 如 user:pass@tcp(127.0.0.1:3306)/mediasfu?charset=utf8mb4
 	var err error
-	ms.Db, err = sqlx.Open("mysql", "root:L0v1!@#$@tcp(10.153.90.12:3306)/mid_bussops_prod?charset=utf8")
+	ms.Db, err = sqlx.Open("mysql", url)
 	if err != nil {
-		fmt.Printf("mysql connect failed, detail is [%v]", err.Error())
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("mysql - NewPostgres - pgxpool.ParseConfig: %w", err)
+		return nil, fmt.Errorf("mysql - NewMysql - sqlx.Open: %w", err)
 	}
 
     // set man connections and con attempts
@@ -73,8 +70,9 @@ func New(url string, opts ...Option) (*Mysql, error) {
 	//	time.Sleep(ms.idleTimeout)
 	//	connAttemps--
 	//}
-	if err != nil {
-		return nil, fmt.Errorf("mysql - NewMysql - maxIdleCons == 0: %w", err)
+	if err = ms.Db.Ping(); err != nil {
+		ms.Db.Close()
+		return nil, fmt.Errorf("mysql - NewMysql - Db.Ping: %w", err)
 	}
 
 	return ms, nil
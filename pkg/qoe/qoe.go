@@ -0,0 +1,119 @@
+// Package qoe 把丢包、抖动、时延等网络指标换算成MOS(1-5), 便于运维判断通话质量.
+package qoe
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	// MinMOS -.
+	MinMOS = 1.0
+	// MaxMOS E-model在R=100时的MOS.
+	MaxMOS = 4.5
+)
+
+// 视频码率评分区间, 低于videoMinBitrate为最低分, 达到videoGoodBitrate为满分.
+const (
+	videoMinBitrate  = 150e3
+	videoGoodBitrate = 1500e3
+	videoGoodFPS     = 24
+)
+
+// codecImpairment G.113的设备损伤因子Ie和丢包鲁棒因子Bpl, 带PLC, 宽带编码按近似值.
+type codecImpairment struct {
+	ie  float64
+	bpl float64
+}
+
+var (
+	_defaultImpairment = codecImpairment{ie: 0, bpl: 25.1}
+	_impairments       = map[string]codecImpairment{
+		"pcmu": {ie: 0, bpl: 25.1},
+		"pcma": {ie: 0, bpl: 25.1},
+		"g722": {ie: 0, bpl: 25.1},
+		"g729": {ie: 11, bpl: 19},
+		"opus": {ie: 0, bpl: 30},
+	}
+)
+
+// AudioParams -.
+type AudioParams struct {
+	Codec    string  // mime type or encoding name, e.g. audio/opus or PCMU
+	LossRate float64 // 0-1
+	JitterMs float64
+	RTTMs    float64
+}
+
+// VideoParams 视频没有标准模型, 按码率、帧率、卡顿和丢包估算, FPS为0表示未知.
+type VideoParams struct {
+	Bitrate     uint64 // bps
+	FPS         float64
+	FreezeRatio float64 // 采样周期内卡顿时长的占比, 0-1
+	LossRate    float64 // 0-1
+}
+
+// AudioMOS 简化的E-model(ITU-T G.107): R = 93.2 - Id - Ie-eff.
+func AudioMOS(p AudioParams) float64 {
+	imp, ok := _impairments[codecName(p.Codec)]
+	if !ok {
+		imp = _defaultImpairment
+	}
+
+	// 单向时延: 网络时延 + 抖动缓冲(2倍抖动) + 打包时延.
+	d := p.RTTMs/2 + 2*p.JitterMs + 20
+	id := 0.024 * d
+	if d > 177.3 {
+		id += 0.11 * (d - 177.3)
+	}
+
+	ppl := clamp(p.LossRate, 0, 1) * 100
+	ieEff := imp.ie + (95-imp.ie)*ppl/(ppl+imp.bpl)
+
+	return rToMOS(93.2 - id - ieEff)
+}
+
+// VideoMOS -.
+func VideoMOS(p VideoParams) float64 {
+	if p.Bitrate == 0 {
+		return MinMOS
+	}
+
+	q := clamp(math.Log(float64(p.Bitrate)/videoMinBitrate)/math.Log(videoGoodBitrate/videoMinBitrate), 0, 1)
+	if p.FPS > 0 {
+		q *= math.Sqrt(clamp(p.FPS/videoGoodFPS, 0, 1))
+	}
+	q *= 1 - clamp(p.FreezeRatio*2, 0, 1)
+	q *= 1 - clamp(p.LossRate*10, 0, 1)
+
+	return MinMOS + (MaxMOS-MinMOS)*q
+}
+
+// rToMOS G.107 Annex B.
+func rToMOS(r float64) float64 {
+	switch {
+	case r <= 0:
+		return MinMOS
+	case r >= 100:
+		return MaxMOS
+	}
+	return 1 + 0.035*r + 7e-6*r*(r-60)*(100-r)
+}
+
+func codecName(codec string) string {
+	codec = strings.ToLower(codec)
+	if i := strings.IndexByte(codec, '/'); i >= 0 {
+		codec = codec[i+1:]
+	}
+	return codec
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min || math.IsNaN(v) {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+package qoe
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudioMOS(t *testing.T) {
+	tests := []struct {
+		name string
+		p    AudioParams
+		want float64
+	}{
+		{"perfect pcmu", AudioParams{Codec: "PCMU"}, 4.40},
+		{"mime type", AudioParams{Codec: "audio/PCMU"}, 4.40},
+		{"unknown codec", AudioParams{Codec: "audio/unknown"}, 4.40},
+		{"g729 impairment", AudioParams{Codec: "G729"}, 4.09},
+		{"light loss", AudioParams{Codec: "PCMU", LossRate: 0.01, JitterMs: 10, RTTMs: 100}, 4.27},
+		{"opus with loss", AudioParams{Codec: "audio/opus", LossRate: 0.05, JitterMs: 20, RTTMs: 200}, 3.86},
+		// 单向时延超过177.3ms后Id增加更快.
+		{"long delay", AudioParams{Codec: "PCMU", JitterMs: 50, RTTMs: 400}, 3.59},
+		{"total loss", AudioParams{Codec: "PCMU", LossRate: 1}, 1.16},
+		{"loss clamped", AudioParams{Codec: "PCMU", LossRate: 2}, 1.16},
+		{"nan loss", AudioParams{Codec: "PCMU", LossRate: math.NaN()}, 4.40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, AudioMOS(tt.p), 0.01)
+		})
+	}
+}
+
+func TestAudioMOS_Codec(t *testing.T) {
+	// 相同丢包下opus的Bpl更大, 评分比pcmu高.
+	opus := AudioMOS(AudioParams{Codec: "opus", LossRate: 0.05})
+	pcmu := AudioMOS(AudioParams{Codec: "PCMU", LossRate: 0.05})
+	assert.Greater(t, opus, pcmu)
+}
+
+func TestVideoMOS(t *testing.T) {
+	tests := []struct {
+		name string
+		p    VideoParams
+		want float64
+	}{
+		{"no bitrate", VideoParams{FPS: 30}, MinMOS},
+		{"good bitrate", VideoParams{Bitrate: 1500e3, FPS: 30}, MaxMOS},
+		{"above good bitrate", VideoParams{Bitrate: 4000e3, FPS: 30}, MaxMOS},
+		{"min bitrate", VideoParams{Bitrate: 150e3, FPS: 30}, MinMOS},
+		{"below min bitrate", VideoParams{Bitrate: 50e3, FPS: 30}, MinMOS},
+		// 码率按对数换算, 两个端点的几何平均值得一半的分.
+		{"log bitrate", VideoParams{Bitrate: 474342}, 2.75},
+		{"unknown fps", VideoParams{Bitrate: 1500e3}, MaxMOS},
+		{"low fps", VideoParams{Bitrate: 1500e3, FPS: 6}, 2.75},
+		{"freeze", VideoParams{Bitrate: 1500e3, FPS: 30, FreezeRatio: 0.25}, 2.75},
+		{"frozen", VideoParams{Bitrate: 3000e3, FPS: 30, FreezeRatio: 0.6}, MinMOS},
+		{"loss", VideoParams{Bitrate: 1500e3, FPS: 30, LossRate: 0.05}, 2.75},
+		{"heavy loss", VideoParams{Bitrate: 1500e3, FPS: 30, LossRate: 0.1}, MinMOS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, VideoMOS(tt.p), 0.01)
+		})
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"sync"
 	"time"
 
-	rmqrpc "github.com/evrone/go-clean-template/pkg/rabbitmq/rmq_rpc"
+	rmqrpc "mediasfu/pkg/rabbitmq/rmq_rpc"
 	"github.com/google/uuid"
 )
 
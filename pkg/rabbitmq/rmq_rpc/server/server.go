@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"time"
 
-	rmqrpc "github.com/evrone/go-clean-template/pkg/rabbitmq/rmq_rpc"
+	rmqrpc "mediasfu/pkg/rabbitmq/rmq_rpc"
 )
 
 const (
@@ -0,0 +1,166 @@
+package rtpengine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// bencode 编解码, ng协议的消息体是一个bencode dict.
+// 解码结果: string, int64, []interface{}, map[string]interface{}.
+
+const maxBencodeString = 1 << 20
+
+var errInvalidBencode = errors.New("invalid bencode")
+
+func bencodeDecode(b []byte) (interface{}, error) {
+	r := bufio.NewReader(bytes.NewReader(b))
+	v, err := bencodeRead(r)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// bencodeRead reads exactly one value from r, used by the tcp stream as well.
+func bencodeRead(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == 'i':
+		s, err := r.ReadString('e')
+		if err != nil {
+			return nil, errInvalidBencode
+		}
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, errInvalidBencode
+		}
+		return n, nil
+	case c == 'l':
+		list := make([]interface{}, 0)
+		for {
+			next, err := r.Peek(1)
+			if err != nil {
+				return nil, errInvalidBencode
+			}
+			if next[0] == 'e' {
+				_, _ = r.ReadByte()
+				return list, nil
+			}
+			v, err := bencodeRead(r)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		dict := make(map[string]interface{})
+		for {
+			next, err := r.Peek(1)
+			if err != nil {
+				return nil, errInvalidBencode
+			}
+			if next[0] == 'e' {
+				_, _ = r.ReadByte()
+				return dict, nil
+			}
+			k, err := bencodeRead(r)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errInvalidBencode
+			}
+			v, err := bencodeRead(r)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+	case c >= '0' && c <= '9':
+		_ = r.UnreadByte()
+		s, err := r.ReadString(':')
+		if err != nil {
+			return nil, errInvalidBencode
+		}
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 || n > maxBencodeString {
+			return nil, errInvalidBencode
+		}
+		buf := make([]byte, n)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, errInvalidBencode
+		}
+		return string(buf), nil
+	}
+	return nil, errInvalidBencode
+}
+
+func bencodeEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := bencodeWrite(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func bencodeWrite(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(t)))
+		buf.WriteByte(':')
+		buf.WriteString(t)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(t)))
+		buf.WriteByte(':')
+		buf.Write(t)
+	case int:
+		fmt.Fprintf(buf, "i%de", t)
+	case int64:
+		fmt.Fprintf(buf, "i%de", t)
+	case uint32:
+		fmt.Fprintf(buf, "i%de", t)
+	case uint64:
+		fmt.Fprintf(buf, "i%de", t)
+	case []string:
+		buf.WriteByte('l')
+		for _, s := range t {
+			_ = bencodeWrite(buf, s)
+		}
+		buf.WriteByte('e')
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, e := range t {
+			if err := bencodeWrite(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		// key必须排序.
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			_ = bencodeWrite(buf, k)
+			if err := bencodeWrite(buf, t[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
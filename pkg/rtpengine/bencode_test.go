@@ -0,0 +1,78 @@
+package rtpengine
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBencode(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   interface{}
+		encoded string
+	}{
+		{"string", "spam", "4:spam"},
+		{"empty string", "", "0:"},
+		{"int", int64(-42), "i-42e"},
+		{"list", []interface{}{"a", int64(1)}, "l1:ai1ee"},
+		{"empty list", []interface{}{}, "le"},
+		{"dict sorted keys", map[string]interface{}{"sdp": "v=0", "command": "offer", "flags": []interface{}{"trust-address"}},
+			"d7:command5:offer5:flagsl13:trust-addresse3:sdp3:v=0e"},
+		{"nested", map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{map[string]interface{}{}}}}, "d1:ad1:bldeeee"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := bencodeEncode(tc.value)
+			require.NoError(t, err)
+			assert.Equal(t, tc.encoded, string(b))
+			v, err := bencodeDecode(b)
+			require.NoError(t, err)
+			assert.Equal(t, tc.value, v)
+		})
+	}
+}
+
+func TestBencode_Encode(t *testing.T) {
+	// 其他整数和[]string编码后按int64和[]interface{}解码.
+	b, err := bencodeEncode(map[string]interface{}{"n": 1, "u": uint32(2), "l": []string{"x"}, "raw": []byte("yz")})
+	require.NoError(t, err)
+	assert.Equal(t, "d1:ll1:xe1:ni1e3:raw2:yz1:ui2ee", string(b))
+
+	_, err = bencodeEncode(map[string]interface{}{"f": 1.5})
+	assert.Error(t, err)
+}
+
+func TestBencode_Invalid(t *testing.T) {
+	for _, invalid := range []string{
+		"",
+		"x",
+		"i12",
+		"iabce",
+		"5:abc",
+		"-1:a",
+		"l1:a",
+		"d1:a",
+		"di1e1:ae",
+		"99999999:a",
+	} {
+		_, err := bencodeDecode([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestBencodeRead_Stream(t *testing.T) {
+	// tcp上连续的多个值逐个读出.
+	r := bufio.NewReader(bytes.NewReader([]byte("d4:ping1:ye3:abci7e")))
+	v, err := bencodeRead(r)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ping": "y"}, v)
+	v, err = bencodeRead(r)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", v)
+	v, err = bencodeRead(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), v)
+}
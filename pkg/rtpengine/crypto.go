@@ -0,0 +1,229 @@
+package rtpengine
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pion/srtp/v2"
+)
+
+// SDES crypto suites, RFC 4568 and RFC 7714.
+const (
+	SuiteAESCM128HMACSHA180 = "AES_CM_128_HMAC_SHA1_80"
+	SuiteAESCM128HMACSHA132 = "AES_CM_128_HMAC_SHA1_32"
+	SuiteAEADAES128GCM      = "AEAD_AES_128_GCM"
+)
+
+var (
+	errInvalidCrypto     = errors.New("invalid a=crypto attribute")
+	errUnsupportedSuite  = errors.New("unsupported srtp crypto suite")
+	errInvalidKeyLength  = errors.New("invalid srtp master key length")
+	errSRTPNotNegotiated = errors.New("srtp keys not negotiated")
+	errInvalidMKI        = errors.New("invalid srtp mki")
+)
+
+// maxMKILength RFC 4568, MKI长度为1-128字节.
+const maxMKILength = 128
+
+// 不支持的session params, 带这些参数的a=crypto不会被选中.
+var unsupportedSessionParams = []string{"UNENCRYPTED_SRTP", "UNENCRYPTED_SRTCP", "UNAUTHENTICATED_SRTP", "FEC_ORDER", "FEC_KEY"}
+
+// 按优先级排序, offer时按这个顺序生成.
+var supportedSuites = []string{
+	SuiteAEADAES128GCM,
+	SuiteAESCM128HMACSHA180,
+	SuiteAESCM128HMACSHA132,
+}
+
+// CryptoAttribute is a SDP a=crypto line:
+// a=crypto:<tag> <crypto-suite> inline:<key||salt>[|lifetime][|MKI:length] [session-params]
+type CryptoAttribute struct {
+	Tag      int
+	Suite    string
+	Key      []byte
+	Salt     []byte
+	Lifetime string // 原样保留, 例如 2^31
+	// MKI 按MKI长度编码的值, nil表示srtp包中没有MKI.
+	MKI []byte
+	// Params session params, 原样保留.
+	Params []string
+}
+
+// SupportedSuites returns the crypto suites in preference order
+func SupportedSuites() []string {
+	return append([]string(nil), supportedSuites...)
+}
+
+// NewCryptoAttribute generates a random master key and salt for suite
+func NewCryptoAttribute(tag int, suite string) (*CryptoAttribute, error) {
+	keyLen, saltLen, err := suiteKeyLen(suite)
+	if err != nil {
+		return nil, err
+	}
+	keySalt := make([]byte, keyLen+saltLen)
+	if _, err = rand.Read(keySalt); err != nil {
+		return nil, err
+	}
+	return &CryptoAttribute{
+		Tag:   tag,
+		Suite: suite,
+		Key:   keySalt[:keyLen],
+		Salt:  keySalt[keyLen:],
+	}, nil
+}
+
+// ParseCrypto parses the value of a a=crypto attribute, without the "crypto:" prefix.
+func ParseCrypto(value string) (*CryptoAttribute, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "crypto:")
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, errInvalidCrypto
+	}
+	tag, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, errInvalidCrypto
+	}
+	suite := strings.ToUpper(fields[1])
+	keyLen, saltLen, err := suiteKeyLen(suite)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只使用第一个key.
+	keyParam := strings.SplitN(fields[2], ";", 2)[0]
+	if !strings.HasPrefix(keyParam, "inline:") {
+		return nil, errInvalidCrypto
+	}
+	parts := strings.SplitN(strings.TrimPrefix(keyParam, "inline:"), "|", 2)
+	keySalt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		if keySalt, err = base64.RawStdEncoding.DecodeString(parts[0]); err != nil {
+			return nil, errInvalidCrypto
+		}
+	}
+	if len(keySalt) != keyLen+saltLen {
+		return nil, errInvalidKeyLength
+	}
+
+	c := &CryptoAttribute{
+		Tag:    tag,
+		Suite:  suite,
+		Key:    keySalt[:keyLen],
+		Salt:   keySalt[keyLen:],
+		Params: fields[3:],
+	}
+	if len(parts) > 1 {
+		for _, p := range strings.Split(parts[1], "|") {
+			if !strings.Contains(p, ":") {
+				c.Lifetime = p
+				continue
+			}
+			if c.MKI, err = parseMKI(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// String encodes the attribute value, without the "crypto:" prefix.
+func (c *CryptoAttribute) String() string {
+	keySalt := make([]byte, 0, len(c.Key)+len(c.Salt))
+	keySalt = append(keySalt, c.Key...)
+	keySalt = append(keySalt, c.Salt...)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s inline:%s", c.Tag, c.Suite, base64.StdEncoding.EncodeToString(keySalt))
+	if c.Lifetime != "" {
+		b.WriteString("|" + c.Lifetime)
+	}
+	if len(c.MKI) > 0 {
+		fmt.Fprintf(&b, "|%s:%d", new(big.Int).SetBytes(c.MKI).String(), len(c.MKI))
+	}
+	for _, p := range c.Params {
+		b.WriteString(" " + p)
+	}
+	return b.String()
+}
+
+// supported 是否可以按这个a=crypto收发srtp.
+func (c *CryptoAttribute) supported() bool {
+	for _, p := range c.Params {
+		kv := strings.SplitN(p, "=", 2)
+		name := strings.ToUpper(kv[0])
+		for _, unsupported := range unsupportedSessionParams {
+			if name == unsupported {
+				return false
+			}
+		}
+		// pion/srtp只支持密钥派生率0.
+		if name == "KDR" && (len(kv) != 2 || kv[1] != "0") {
+			return false
+		}
+	}
+	return true
+}
+
+// Profile returns the srtp protection profile of the suite
+func (c *CryptoAttribute) Profile() (srtp.ProtectionProfile, error) {
+	return suiteProfile(c.Suite)
+}
+
+// SelectCrypto picks the first offered attribute with a supported suite and
+// generates the local answer attribute with the same tag and suite.
+func SelectCrypto(offered []string) (remote, local *CryptoAttribute, err error) {
+	for _, suite := range supportedSuites {
+		for _, value := range offered {
+			c, err := ParseCrypto(value)
+			if err != nil || c.Suite != suite || !c.supported() {
+				continue
+			}
+			if local, err = NewCryptoAttribute(c.Tag, c.Suite); err != nil {
+				return nil, nil, err
+			}
+			return c, local, nil
+		}
+	}
+	return nil, nil, errUnsupportedSuite
+}
+
+// parseMKI 解析 <MKI value>:<MKI length>, value为十进制.
+func parseMKI(s string) ([]byte, error) {
+	parts := strings.SplitN(s, ":", 2)
+	length, err := strconv.Atoi(parts[1])
+	if err != nil || length < 1 || length > maxMKILength {
+		return nil, errInvalidMKI
+	}
+	value, ok := new(big.Int).SetString(parts[0], 10)
+	if !ok || value.Sign() < 0 || value.BitLen() > length*8 {
+		return nil, errInvalidMKI
+	}
+	return value.FillBytes(make([]byte, length)), nil
+}
+
+func suiteProfile(suite string) (srtp.ProtectionProfile, error) {
+	switch suite {
+	case SuiteAESCM128HMACSHA180:
+		return srtp.ProtectionProfileAes128CmHmacSha1_80, nil
+	case SuiteAESCM128HMACSHA132:
+		return srtp.ProtectionProfileAes128CmHmacSha1_32, nil
+	case SuiteAEADAES128GCM:
+		return srtp.ProtectionProfileAeadAes128Gcm, nil
+	}
+	return 0, errUnsupportedSuite
+}
+
+func suiteKeyLen(suite string) (keyLen, saltLen int, err error) {
+	switch suite {
+	case SuiteAESCM128HMACSHA180, SuiteAESCM128HMACSHA132:
+		return 16, 14, nil
+	case SuiteAEADAES128GCM:
+		return 16, 12, nil
+	}
+	return 0, 0, errUnsupportedSuite
+}
@@ -0,0 +1,46 @@
+package rtpengine
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// rfc4733 telephone-event, 事件码0-15对应的按键.
+const dtmfEvents = "0123456789*#ABCD"
+
+// SetDTMFPayloadType sets the telephone-event payload type to detect, a negative value disables detection
+func (l *Leg) SetDTMFPayloadType(pt int) {
+	atomic.StoreInt32(&l.dtmfPT, int32(pt))
+}
+
+// OnDTMF is called once for every telephone-event received, when the end of the event arrives
+func (l *Leg) OnDTMF(f func(digit string, duration time.Duration)) {
+	l.onDTMF.Store(f)
+}
+
+// handleDTMF 每个事件的结束包(E位)会重发3次, 按rtp时间戳去重.
+func (l *Leg) handleDTMF(timestamp uint32, payload []byte) {
+	if len(payload) < 4 || payload[1]&0x80 == 0 {
+		return
+	}
+	l.Lock()
+	if l.dtmfEnded && l.dtmfTimestamp == timestamp {
+		l.Unlock()
+		return
+	}
+	l.dtmfEnded, l.dtmfTimestamp = true, timestamp
+	l.Unlock()
+
+	event := int(payload[0])
+	if event >= len(dtmfEvents) {
+		return
+	}
+	// 时长单位是rtp时钟, telephone-event是8000Hz.
+	duration := time.Duration(binary.BigEndian.Uint16(payload[2:4])) * time.Second / 8000
+	digit := string(dtmfEvents[event])
+	Logger.Info("rtp leg dtmf", "leg_id", l.id, "digit", digit, "duration", duration.String())
+	if f, ok := l.onDTMF.Load().(func(string, time.Duration)); ok && f != nil {
+		f(digit, duration)
+	}
+}
@@ -0,0 +1,542 @@
+package rtpengine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// telephone-event 在本端生成的offer中使用的payload type.
+const payloadTypeTelephoneEvent = 101
+
+var (
+	errEndpointExists   = errors.New("endpoint already exists")
+	errEndpointNotFound = errors.New("endpoint not found")
+	errNotNegotiated    = errors.New("endpoint has no remote sdp")
+	errCodecMismatch    = errors.New("endpoints have no common codec")
+	errBridgeSelf       = errors.New("can not bridge endpoint to itself")
+	errUnsupportedProto = errors.New("endpoint only supports plain rtp")
+)
+
+// EndpointOptions 呼叫控制(rabbitmq)创建一路rtp的参数.
+type EndpointOptions struct {
+	ID string
+	// SDP 对端的offer, 为空时由本端生成offer, 对端的answer通过Endpoint.Answer设置.
+	SDP string
+	// MediaAddress 替换写入SDP的地址.
+	MediaAddress string
+	// Asymmetric 不做对称rtp锁定, 按SDP中的地址发送.
+	Asymmetric   bool
+	StrictSource bool
+	// Session 所属的会话, 用Config.E2EE判断是否端到端加密, 声音大小计入它的说话者检测.
+	Session string
+}
+
+// Endpoint 由呼叫控制直接管理的一路音频rtp, 和Call不同, 它只有一个Leg,
+// 通过Bridge和另一个Endpoint互相转发, 可以在呼叫过程中拆开、重新桥接.
+type Endpoint struct {
+	sync.RWMutex
+	id      string
+	engine  *Engine
+	leg     *Leg
+	created time.Time
+	e2ee    bool
+	session string
+
+	offered  *sdpMedia // 本端offer中的媒体, 对端answer前用于绑定Buffer
+	remote   *sdpMedia
+	peer     *Endpoint
+	player   *player
+	recorder *recorder
+	observer AudioObserver
+}
+
+// CreateEndpoint allocates a leg, it answers o.SDP if present or returns a local offer.
+func (e *Engine) CreateEndpoint(o EndpointOptions) (*Endpoint, string, error) {
+	e.Lock()
+	if _, ok := e.endpoints[o.ID]; ok {
+		e.Unlock()
+		return nil, "", errEndpointExists
+	}
+	e.endpoints[o.ID] = nil
+	e.Unlock()
+
+	ep, sdp, err := e.createEndpoint(o)
+	e.Lock()
+	if err != nil {
+		delete(e.endpoints, o.ID)
+	} else {
+		e.endpoints[o.ID] = ep
+	}
+	e.Unlock()
+	return ep, sdp, err
+}
+
+func (e *Engine) createEndpoint(o EndpointOptions) (*Endpoint, string, error) {
+	var (
+		sd     *sdp.SessionDescription
+		remote *sdpMedia
+	)
+	if o.SDP != "" {
+		var medias []*sdpMedia
+		var err error
+		if sd, medias, err = parseSDP(o.SDP); err != nil {
+			return nil, "", err
+		}
+		for _, m := range medias {
+			if m.kind == "audio" {
+				remote = m
+				break
+			}
+		}
+		if remote == nil {
+			return nil, "", errNoAudioStream
+		}
+	}
+
+	leg, err := NewLeg(o.ID, e.pool, e.cfg.BufferFactory, LegConfig{
+		Latching:     !o.Asymmetric,
+		Relatch:      !o.Asymmetric,
+		StrictSource: o.StrictSource,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	ep := &Endpoint{
+		id:      o.ID,
+		engine:  e,
+		leg:     leg,
+		created: time.Now(),
+		e2ee:    e.e2ee(o.Session),
+		session: o.Session,
+	}
+	ep.bind()
+
+	ip := e.cfg.AdvertiseIP
+	if o.MediaAddress != "" {
+		ip = o.MediaAddress
+	}
+	local := localMedia{ip: ip, port: leg.LocalPort(), proto: "RTP/AVP"}
+
+	if remote == nil {
+		ep.offered = &sdpMedia{kind: "audio", proto: local.proto, codecs: []sdpCodec{
+			staticCodecs[payloadTypePCMU],
+			staticCodecs[payloadTypePCMA],
+			{payloadType: payloadTypeTelephoneEvent, name: "telephone-event", clockRate: 8000},
+		}}
+		return ep, offerSDP(local, ep.offered.codecs), nil
+	}
+
+	// 只应答音频, 其他m=段端口置0拒绝.
+	if err = ep.setRemote(remote); err != nil {
+		_ = leg.Close()
+		return nil, "", err
+	}
+	local.rtcpMux = remote.rtcpMux
+	for i, md := range sd.MediaDescriptions {
+		if i == remote.index {
+			rewriteMedia(md, local)
+			continue
+		}
+		rewriteMedia(md, localMedia{ip: ip, proto: strings.Join(md.MediaName.Protos, "/")})
+	}
+	rewriteSession(sd, ip)
+	b, err := sd.Marshal()
+	if err != nil {
+		_ = leg.Close()
+		return nil, "", err
+	}
+	leg.WatchMediaTimeout(e.cfg.MediaTimeout)
+	return ep, string(b), nil
+}
+
+// Endpoint returns the endpoint by id
+func (e *Engine) Endpoint(id string) *Endpoint {
+	e.RLock()
+	defer e.RUnlock()
+	return e.endpoints[id]
+}
+
+// DeleteEndpoint unbridges and closes the endpoint
+func (e *Engine) DeleteEndpoint(id string) error {
+	e.Lock()
+	ep, ok := e.endpoints[id]
+	if ok && ep != nil {
+		delete(e.endpoints, id)
+	}
+	e.Unlock()
+	if !ok || ep == nil {
+		return errEndpointNotFound
+	}
+	ep.close()
+	return nil
+}
+
+// Bridge relays media between a and b, previous bridges of both are removed.
+func (e *Engine) Bridge(a, b *Endpoint) error {
+	if a == b {
+		return errBridgeSelf
+	}
+	if !commonCodec(a.media(), b.media()) {
+		return errCodecMismatch
+	}
+	e.Unbridge(a)
+	e.Unbridge(b)
+	a.setPeer(b)
+	b.setPeer(a)
+	Logger.Info("endpoints bridged", "a", a.id, "b", b.id)
+	return nil
+}
+
+// Unbridge stops relaying between ep and its peer
+func (e *Engine) Unbridge(ep *Endpoint) {
+	peer := ep.Peer()
+	if peer == nil {
+		return
+	}
+	ep.setPeer(nil)
+	if peer.Peer() == ep {
+		peer.setPeer(nil)
+	}
+}
+
+// ID returns the endpoint id
+func (ep *Endpoint) ID() string { return ep.id }
+
+// Created returns the creation time
+func (ep *Endpoint) Created() time.Time { return ep.created }
+
+// Leg returns the rtp leg
+func (ep *Endpoint) Leg() *Leg { return ep.leg }
+
+// Peer returns the bridged endpoint
+func (ep *Endpoint) Peer() *Endpoint {
+	ep.RLock()
+	defer ep.RUnlock()
+	return ep.peer
+}
+
+// Codec returns the negotiated codec name, empty before the remote sdp is known
+func (ep *Endpoint) Codec() string {
+	ep.RLock()
+	defer ep.RUnlock()
+	if ep.remote == nil {
+		return ""
+	}
+	c, _ := ep.remote.codec(0)
+	return c.name
+}
+
+// Playing returns true while a file is played to the endpoint
+func (ep *Endpoint) Playing() bool {
+	ep.RLock()
+	defer ep.RUnlock()
+	return ep.player != nil && ep.player.playing()
+}
+
+// Recording returns true while the received media is recorded
+func (ep *Endpoint) Recording() bool {
+	ep.RLock()
+	defer ep.RUnlock()
+	return ep.recorder != nil
+}
+
+// Answer applies the remote answer to the local offer
+func (ep *Endpoint) Answer(raw string) error {
+	_, medias, err := parseSDP(raw)
+	if err != nil {
+		return err
+	}
+	for _, m := range medias {
+		if m.kind != "audio" {
+			continue
+		}
+		if err = ep.setRemote(m); err != nil {
+			return err
+		}
+		ep.leg.WatchMediaTimeout(ep.engine.cfg.MediaTimeout)
+		return nil
+	}
+	return errNoAudioStream
+}
+
+// Play plays a wav file to the endpoint, in place of the bridged audio.
+func (ep *Endpoint) Play(file string, repeat int) (time.Duration, error) {
+	if ep.e2ee {
+		return 0, errE2EE
+	}
+	ep.RLock()
+	m := ep.remote
+	ep.RUnlock()
+	if m == nil {
+		return 0, errNotNegotiated
+	}
+	p, err := newPlayer(ep.leg, m, file, repeat)
+	if err != nil {
+		return 0, err
+	}
+	ep.Lock()
+	old := ep.player
+	ep.player = p
+	ep.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	p.start()
+	return p.duration(), nil
+}
+
+// StopPlay stops the playing file
+func (ep *Endpoint) StopPlay() {
+	ep.Lock()
+	p := ep.player
+	ep.player = nil
+	ep.Unlock()
+	if p != nil {
+		p.stop()
+	}
+}
+
+// StartRecording records the received rtp into the engine record directory, name is used as file prefix.
+func (ep *Endpoint) StartRecording(name string) error {
+	if ep.e2ee {
+		return errE2EE
+	}
+	ep.Lock()
+	defer ep.Unlock()
+	if ep.recorder != nil {
+		return nil
+	}
+	r, err := newRecorder(ep.engine.cfg.RecordDir, name+"-"+ep.id)
+	if err != nil {
+		return err
+	}
+	ep.recorder = r
+	return nil
+}
+
+// StopRecording stops the recording
+func (ep *Endpoint) StopRecording() {
+	ep.Lock()
+	r := ep.recorder
+	ep.recorder = nil
+	ep.Unlock()
+	if r != nil {
+		r.close()
+	}
+}
+
+func (ep *Endpoint) setRemote(m *sdpMedia) error {
+	if m.proto != "RTP/AVP" && m.proto != "RTP/AVPF" {
+		return errUnsupportedProto
+	}
+	ep.Lock()
+	ep.remote = m
+	ep.Unlock()
+	if m.addr != nil {
+		ep.leg.SetRemote(m.addr, m.rtcpAddr)
+	}
+	ep.leg.SetRTCPMux(m.rtcpMux)
+	ep.leg.SetDTMFPayloadType(m.dtmfPayloadType())
+	return nil
+}
+
+func (ep *Endpoint) setPeer(peer *Endpoint) {
+	ep.Lock()
+	ep.peer = peer
+	ep.Unlock()
+}
+
+func (ep *Endpoint) media() *sdpMedia {
+	ep.RLock()
+	defer ep.RUnlock()
+	if ep.remote != nil {
+		return ep.remote
+	}
+	return ep.offered
+}
+
+// bind 收到的rtp转发给桥接的对端, 没有桥接时丢弃.
+func (ep *Endpoint) bind() {
+	ep.leg.OnNewSSRC(func(ssrc uint32) {
+		buff := ep.leg.Buffer(ssrc)
+		if buff == nil {
+			return
+		}
+		buff.Bind(ep.parameters(), buffer.Options{E2EE: ep.e2ee})
+		ep.observe(buff)
+		go ep.forward(buff)
+	})
+	ep.leg.OnRTCP(func(pkts []rtcp.Packet) {
+		if peer := ep.Peer(); peer != nil {
+			_ = peer.leg.WriteRTCP(pkts)
+		}
+	})
+}
+
+// observe 声音大小计入所属会话的说话者检测, stream id为endpoint id.
+func (ep *Endpoint) observe(buff *buffer.Buffer) {
+	ep.Lock()
+	if ep.observer == nil {
+		if ep.observer = ep.engine.audioObserver(ep.session); ep.observer == nil {
+			ep.Unlock()
+			return
+		}
+		ep.observer.AddStream(ep.id)
+	}
+	o := ep.observer
+	ep.Unlock()
+	buff.OnAudioLevel(func(level uint8) {
+		o.Observe(ep.id, level)
+	})
+}
+
+// forward 两端同一编码协商的payload type可能不同, 按对端协商的改写, 对端没有的编码丢弃.
+func (ep *Endpoint) forward(buff *buffer.Buffer) {
+	var (
+		src, dst *sdpMedia
+		pts      map[uint8]uint8
+	)
+	for {
+		pkt, err := buff.ReadExtended()
+		if err != nil {
+			return
+		}
+		ep.RLock()
+		peer, r := ep.peer, ep.recorder
+		ep.RUnlock()
+		if r != nil {
+			r.write(0, 0, &pkt.Packet)
+		}
+		if peer == nil {
+			continue
+		}
+		// 对端放音时不转发.
+		if peer.Playing() {
+			continue
+		}
+		// answer或重新桥接后媒体变化, 重新计算映射.
+		if m, pm := ep.media(), peer.media(); m != src || pm != dst {
+			src, dst, pts = m, pm, payloadTypeMap(m, pm)
+		}
+		pt, ok := pts[pkt.Packet.PayloadType]
+		if !ok {
+			continue
+		}
+		hdr := pkt.Packet.Header
+		hdr.PayloadType = pt
+		_, _ = peer.leg.WriteRTP(&hdr, pkt.Packet.Payload)
+	}
+}
+
+func (ep *Endpoint) parameters() webrtc.RTPParameters {
+	codec, _ := ep.media().codec(0)
+	return webrtc.RTPParameters{
+		Codecs: []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:  "audio/" + codec.name,
+				ClockRate: codec.clockRate,
+			},
+			PayloadType: webrtc.PayloadType(codec.payloadType),
+		}},
+	}
+}
+
+func (ep *Endpoint) close() {
+	ep.engine.Unbridge(ep)
+	ep.StopPlay()
+	ep.StopRecording()
+	_ = ep.leg.Close()
+
+	ep.Lock()
+	o := ep.observer
+	ep.observer = nil
+	ep.Unlock()
+	if o != nil {
+		o.RemoveStream(ep.id)
+	}
+}
+
+// commonCodec 不转码, 两端至少要有一个相同的编码.
+func commonCodec(a, b *sdpMedia) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	for _, ca := range a.codecs {
+		for _, cb := range b.codecs {
+			if sameCodec(ca, cb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// payloadTypeMap a的payload type到b协商的同一编码的payload type, telephone-event也按名字映射.
+func payloadTypeMap(a, b *sdpMedia) map[uint8]uint8 {
+	pts := make(map[uint8]uint8)
+	if a == nil || b == nil {
+		return pts
+	}
+	for _, ca := range a.codecs {
+		for _, cb := range b.codecs {
+			if sameCodec(ca, cb) {
+				pts[ca.payloadType] = cb.payloadType
+				break
+			}
+		}
+	}
+	return pts
+}
+
+// sameCodec 编码名不区分大小写, 采样率相同.
+func sameCodec(a, b sdpCodec) bool {
+	return a.name != "" && strings.EqualFold(a.name, b.name) && a.clockRate == b.clockRate
+}
+
+// offerSDP 本端生成的音频offer.
+func offerSDP(local localMedia, codecs []sdpCodec) string {
+	formats := make([]string, 0, len(codecs))
+	attrs := make([]sdp.Attribute, 0, len(codecs)+2)
+	for _, c := range codecs {
+		formats = append(formats, strconv.Itoa(int(c.payloadType)))
+		attrs = append(attrs, sdp.NewAttribute("rtpmap", fmt.Sprintf("%d %s/%d", c.payloadType, c.name, c.clockRate)))
+		if c.payloadType == payloadTypeTelephoneEvent {
+			attrs = append(attrs, sdp.NewAttribute("fmtp", fmt.Sprintf("%d 0-16", c.payloadType)))
+		}
+	}
+	attrs = append(attrs, sdp.NewAttribute("ptime", "20"), sdp.NewPropertyAttribute("sendrecv"))
+
+	md := &sdp.MediaDescription{
+		MediaName: sdp.MediaName{
+			Media:   "audio",
+			Formats: formats,
+		},
+		Attributes: attrs,
+	}
+	rewriteMedia(md, local)
+	sd := &sdp.SessionDescription{
+		Origin: sdp.Origin{
+			Username:       "-",
+			SessionID:      uint64(time.Now().UnixNano()),
+			SessionVersion: 1,
+			NetworkType:    "IN",
+		},
+		SessionName:           "mediasfu",
+		ConnectionInformation: &sdp.ConnectionInformation{},
+		TimeDescriptions:      []sdp.TimeDescription{{}},
+		MediaDescriptions:     []*sdp.MediaDescription{md},
+	}
+	rewriteSession(sd, local.ip)
+	sd.Origin.SessionVersion = 1
+	b, _ := sd.Marshal()
+	return string(b)
+}
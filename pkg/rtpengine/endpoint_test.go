@@ -0,0 +1,232 @@
+package rtpengine
+
+import (
+	"bytes"
+	log "common/log/newlog"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+const testOffer = "v=0\r\n" +
+	"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 40000 RTP/AVP 0 101\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:101 telephone-event/8000\r\n"
+
+// newTestEngine 在本机回环地址上分配端口, e2ee中的会话是端到端加密的.
+func newTestEngine(t *testing.T, e2ee ...string) *Engine {
+	e, err := NewEngine(Config{
+		IP:            "127.0.0.1",
+		MinPort:       42000,
+		MaxPort:       42100,
+		RecordDir:     t.TempDir(),
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+		E2EE: func(session string) bool {
+			for _, s := range e2ee {
+				if s == session {
+					return true
+				}
+			}
+			return false
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(e.Close)
+	return e
+}
+
+func TestEndpoint_E2EE(t *testing.T) {
+	e := newTestEngine(t, "secret")
+
+	plain, _, err := e.CreateEndpoint(EndpointOptions{ID: "l1", SDP: testOffer, Session: "open"})
+	require.NoError(t, err)
+	require.NoError(t, plain.StartRecording("open"))
+	assert.True(t, plain.Recording())
+
+	ep, _, err := e.CreateEndpoint(EndpointOptions{ID: "l2", SDP: testOffer, Session: "secret"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, ep.StartRecording("secret"), errE2EE)
+	assert.False(t, ep.Recording())
+	_, err = ep.Play("prompt.wav", 1)
+	assert.ErrorIs(t, err, errE2EE)
+}
+
+func TestCall_E2EE(t *testing.T) {
+	e := newTestEngine(t)
+
+	_, err := e.Offer(OfferOptions{CallID: "c1", FromTag: "a", SDP: testOffer, E2EE: true})
+	require.NoError(t, err)
+	assert.ErrorIs(t, e.StartRecording("c1"), errE2EE)
+	_, err = e.PlayMedia("c1", "a", "prompt.wav", 1)
+	assert.ErrorIs(t, err, errE2EE)
+}
+
+// testObserver 记录每个stream收到的声音大小.
+type testObserver struct {
+	sync.Mutex
+	levels map[string][]uint8
+}
+
+func (o *testObserver) AddStream(id string) {
+	o.Lock()
+	o.levels[id] = nil
+	o.Unlock()
+}
+
+func (o *testObserver) RemoveStream(id string) {
+	o.Lock()
+	delete(o.levels, id)
+	o.Unlock()
+}
+
+func (o *testObserver) Observe(id string, dBov uint8) {
+	o.Lock()
+	o.levels[id] = append(o.levels[id], dBov)
+	o.Unlock()
+}
+
+func (o *testObserver) observed(id string) (levels []uint8, ok bool) {
+	o.Lock()
+	defer o.Unlock()
+	levels, ok = o.levels[id]
+	return append([]uint8(nil), levels...), ok
+}
+
+func TestEndpoint_AudioObserver(t *testing.T) {
+	observer := &testObserver{levels: make(map[string][]uint8)}
+	e, err := NewEngine(Config{
+		IP:            "127.0.0.1",
+		MinPort:       42200,
+		MaxPort:       42300,
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+		AudioObserver: func(session string) AudioObserver {
+			if session != "s1" {
+				return nil
+			}
+			return observer
+		},
+	})
+	require.NoError(t, err)
+	defer e.Close()
+
+	ep, _, err := e.CreateEndpoint(EndpointOptions{ID: "l1", SDP: testOffer, Session: "s1"})
+	require.NoError(t, err)
+	other, _, err := e.CreateEndpoint(EndpointOptions{ID: "l2", SDP: testOffer, Session: "s2"})
+	require.NoError(t, err)
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: ep.Leg().LocalPort()})
+	require.NoError(t, err)
+	defer conn.Close()
+	toOther, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: other.Leg().LocalPort()})
+	require.NoError(t, err)
+	defer toOther.Close()
+	for i := 0; i < 5; i++ {
+		// PCMU 0x80附近是最大幅度.
+		pkt := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: uint16(i), Timestamp: uint32(160 * i), SSRC: 1},
+			Payload: bytes.Repeat([]byte{0x80}, 160),
+		}
+		raw, err := pkt.Marshal()
+		require.NoError(t, err)
+		_, err = conn.Write(raw)
+		require.NoError(t, err)
+		// 同一个BufferFactory中按ssrc区分.
+		pkt.SSRC = 2
+		raw, err = pkt.Marshal()
+		require.NoError(t, err)
+		_, err = toOther.Write(raw)
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		levels, _ := observer.observed("l1")
+		return len(levels) > 0
+	}, time.Second, 10*time.Millisecond)
+	levels, _ := observer.observed("l1")
+	assert.Less(t, levels[0], uint8(10), "loud payload")
+	_, ok := observer.observed("l2")
+	assert.False(t, ok, "session without observer")
+
+	require.NoError(t, e.DeleteEndpoint("l1"))
+	_, ok = observer.observed("l1")
+	assert.False(t, ok)
+}
+
+func TestEndpoint_BridgePayloadType(t *testing.T) {
+	e := newTestEngine(t)
+	recv, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer recv.Close()
+
+	a, _, err := e.CreateEndpoint(EndpointOptions{ID: "a", SDP: testOffer})
+	require.NoError(t, err)
+	// b的telephone-event协商为96, 没有PCMA.
+	b, _, err := e.CreateEndpoint(EndpointOptions{ID: "b", SDP: "v=0\r\n" +
+		"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio " + strconv.Itoa(recv.LocalAddr().(*net.UDPAddr).Port) + " RTP/AVP 0 96\r\n" +
+		"a=rtpmap:0 pcmu/8000\r\n" +
+		"a=rtpmap:96 telephone-event/8000\r\n"})
+	require.NoError(t, err)
+	require.NoError(t, e.Bridge(a, b))
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: a.Leg().LocalPort()})
+	require.NoError(t, err)
+	defer conn.Close()
+	for i, pt := range []uint8{0, 101, 8, 0} {
+		raw, err := (&rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: pt, SequenceNumber: uint16(i), Timestamp: uint32(160 * i), SSRC: 1},
+			Payload: bytes.Repeat([]byte{0xff}, 160),
+		}).Marshal()
+		require.NoError(t, err)
+		_, err = conn.Write(raw)
+		require.NoError(t, err)
+	}
+
+	var received []uint8
+	buf := make([]byte, 1500)
+	for {
+		require.NoError(t, recv.SetReadDeadline(time.Now().Add(500*time.Millisecond)))
+		n, err := recv.Read(buf)
+		if err != nil {
+			break
+		}
+		var pkt rtp.Packet
+		require.NoError(t, pkt.Unmarshal(buf[:n]))
+		received = append(received, pkt.PayloadType)
+	}
+	// PCMA b没有协商, 不转发.
+	assert.Equal(t, []uint8{0, 96, 0}, received)
+}
+
+func TestPayloadTypeMap(t *testing.T) {
+	a := &sdpMedia{codecs: []sdpCodec{
+		{payloadType: 0, name: "PCMU", clockRate: 8000},
+		{payloadType: 97, name: "opus", clockRate: 48000},
+		{payloadType: 101, name: "telephone-event", clockRate: 8000},
+		{payloadType: 102, name: "telephone-event", clockRate: 48000},
+	}}
+	b := &sdpMedia{codecs: []sdpCodec{
+		{payloadType: 111, name: "OPUS", clockRate: 48000},
+		{payloadType: 96, name: "telephone-event", clockRate: 8000},
+	}}
+	assert.Equal(t, map[uint8]uint8{97: 111, 101: 96}, payloadTypeMap(a, b))
+	assert.Equal(t, map[uint8]uint8{111: 97, 96: 101}, payloadTypeMap(b, a))
+	assert.Empty(t, payloadTypeMap(a, nil))
+	assert.True(t, commonCodec(a, b))
+	assert.False(t, commonCodec(b, &sdpMedia{codecs: a.codecs[:1]}))
+}
@@ -0,0 +1,850 @@
+package rtpengine
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+var (
+	errCallNotFound   = errors.New("call not found")
+	errTagNotFound    = errors.New("tag not found")
+	errMediaMismatch  = errors.New("number of media sections does not match the offer")
+	errNoAudioStream  = errors.New("call has no audio stream")
+	errNoPendingOffer = errors.New("no pending offer")
+	errNoAdvertiseIP  = errors.New("no media address to advertise in sdp")
+	errE2EE           = errors.New("media is end-to-end encrypted")
+)
+
+// Config defines the media relay options
+type Config struct {
+	// IP rtp端口绑定的地址.
+	IP string
+	// AdvertiseIP 写入SDP的地址, NAT后部署时为公网地址, 为空时使用IP.
+	AdvertiseIP string
+	MinPort     int
+	MaxPort     int
+	// MediaTimeout 没有媒体多久后删除呼叫, 0表示不检测.
+	MediaTimeout time.Duration
+	// RecordDir 录音文件目录.
+	RecordDir     string
+	BufferFactory *buffer.Factory
+	// Events 发布dtmf和媒体超时事件, 为空不发布.
+	Events *events.Bus
+	// E2EE 返回会话是否端到端加密(信令hub中第一个peer决定), 加密会话的Endpoint不解析payload,
+	// 也不能录音和放音. 为空时都不加密.
+	E2EE func(session string) bool
+	// AudioObserver 返回会话的说话者检测, 音频leg的声音大小计入其中, 为空或返回nil时不检测.
+	AudioObserver func(session string) AudioObserver
+}
+
+// AudioObserver 接收leg的声音大小(dBov), webrtc.AudioObserver实现它.
+type AudioObserver interface {
+	AddStream(streamID string)
+	RemoveStream(streamID string)
+	Observe(streamID string, dBov uint8)
+}
+
+// OfferOptions 对应ng协议offer/answer的参数, sip和rabbitmq的呼叫控制也使用它.
+type OfferOptions struct {
+	CallID  string
+	FromTag string
+	ToTag   string
+	SDP     string
+	// TransportProtocol 改写后SDP使用的传输协议, 例如RTP/AVP转UDP/TLS/RTP/SAVP, 为空保持不变.
+	TransportProtocol string
+	// MediaAddress 替换写入SDP的地址.
+	MediaAddress string
+	// Asymmetric 不做对称rtp锁定, 按SDP中的地址发送.
+	Asymmetric   bool
+	StrictSource bool
+	// E2EE payload是端到端加密的密文, 只转发, 不录音也不放音.
+	E2EE bool
+	// Session 所属的会话, 音频leg计入它的说话者检测, 为空不检测.
+	Session string
+}
+
+// Engine 管理所有呼叫: 每个呼叫的每个m=段分配两个Leg, 分别面向主叫和被叫, 中间转发明文rtp.
+type Engine struct {
+	sync.RWMutex
+	cfg         Config
+	pool        *PortPool
+	cert        tls.Certificate
+	fingerprint string
+	calls       map[string]*Call
+	endpoints   map[string]*Endpoint
+}
+
+// NewEngine creates a media relay engine
+func NewEngine(cfg Config) (*Engine, error) {
+	if cfg.AdvertiseIP == "" {
+		cfg.AdvertiseIP = cfg.IP
+	}
+	if ip := net.ParseIP(cfg.AdvertiseIP); ip == nil || ip.IsUnspecified() {
+		return nil, errNoAdvertiseIP
+	}
+	cert, fingerprint, err := NewDTLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{
+		cfg:         cfg,
+		pool:        NewPortPool(cfg.IP, cfg.MinPort, cfg.MaxPort),
+		cert:        cert,
+		fingerprint: fingerprint,
+		calls:       make(map[string]*Call),
+		endpoints:   make(map[string]*Endpoint),
+	}, nil
+}
+
+// PortPool returns the rtp port pool
+func (e *Engine) PortPool() *PortPool { return e.pool }
+
+// AdvertiseIP returns the media address written to sdp
+func (e *Engine) AdvertiseIP() string { return e.cfg.AdvertiseIP }
+
+// Call returns the call by id
+func (e *Engine) Call(id string) *Call {
+	e.RLock()
+	defer e.RUnlock()
+	return e.calls[id]
+}
+
+// Calls returns all calls
+func (e *Engine) Calls() []*Call {
+	e.RLock()
+	defer e.RUnlock()
+	calls := make([]*Call, 0, len(e.calls))
+	for _, c := range e.calls {
+		calls = append(calls, c)
+	}
+	return calls
+}
+
+// Offer allocates media for an offer and returns the rewritten SDP
+func (e *Engine) Offer(o OfferOptions) (_ string, err error) {
+	sd, medias, err := parseSDP(o.SDP)
+	if err != nil {
+		return "", err
+	}
+	c, created := e.getOrNewCall(o.CallID)
+	// 新建的call offer失败时删除, 释放已经分配的leg和端口.
+	// 在c.Unlock之后执行.
+	defer func() {
+		if err != nil && created {
+			e.deleteCall(c)
+		}
+	}()
+
+	c.Lock()
+	defer c.Unlock()
+	side := c.side(o.FromTag, true)
+	other := 1 - side
+	c.offerer = side
+	if o.ToTag != "" {
+		c.tags[other] = o.ToTag
+	}
+
+	for i, m := range medias {
+		s, err := c.stream(i, m.kind, o)
+		if err != nil {
+			return "", err
+		}
+		if err = s.setRemote(side, m, true); err != nil {
+			return "", err
+		}
+		if o.TransportProtocol != "" {
+			s.protos[other] = o.TransportProtocol
+		} else if s.protos[other] == "" {
+			s.protos[other] = m.proto
+		}
+		if err = s.prepareOffer(other); err != nil {
+			return "", err
+		}
+		rewriteMedia(sd.MediaDescriptions[i], s.local(other, e.advertise(o)))
+	}
+	rewriteSession(sd, e.advertise(o))
+
+	b, err := sd.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Answer applies the answer to the pending offer and returns the rewritten SDP
+func (e *Engine) Answer(o OfferOptions) (string, error) {
+	c := e.Call(o.CallID)
+	if c == nil {
+		return "", errCallNotFound
+	}
+	sd, medias, err := parseSDP(o.SDP)
+	if err != nil {
+		return "", err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.offerer < 0 {
+		return "", errNoPendingOffer
+	}
+	offerer := c.offerer
+	answerer := 1 - offerer
+	if o.ToTag != "" {
+		c.tags[answerer] = o.ToTag
+	}
+	if len(medias) > len(c.streams) {
+		return "", errMediaMismatch
+	}
+
+	for i, m := range medias {
+		s := c.streams[i]
+		if offered := s.remoteMedia(offerer); offered != nil {
+			negotiateFmtp(sd.MediaDescriptions[i], offered, m)
+		}
+		if err = s.setRemote(answerer, m, false); err != nil {
+			return "", err
+		}
+		local := s.local(offerer, e.advertise(o))
+		if md := sd.MediaDescriptions[i]; md.MediaName.Port.Value == 0 || (m.addr == nil && m.iceUfrag == "") {
+			// 被叫拒绝了这一路媒体, 使用ice时地址由连接检查得到.
+			local.port = 0
+		}
+		rewriteMedia(sd.MediaDescriptions[i], local)
+		// 接通后才检测媒体超时, 振铃时间可能很长.
+		for _, leg := range s.legs {
+			leg.WatchMediaTimeout(e.cfg.MediaTimeout)
+		}
+	}
+	rewriteSession(sd, e.advertise(o))
+	c.offerer = -1
+
+	b, err := sd.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DeleteCall closes all legs of the call
+func (e *Engine) DeleteCall(id string) error {
+	e.Lock()
+	c, ok := e.calls[id]
+	delete(e.calls, id)
+	e.Unlock()
+	if !ok {
+		return errCallNotFound
+	}
+	c.close()
+	return nil
+}
+
+// deleteCall 只删除c本身, 同一个id可能已经是新的call.
+func (e *Engine) deleteCall(c *Call) {
+	e.Lock()
+	if e.calls[c.id] == c {
+		delete(e.calls, c.id)
+	}
+	e.Unlock()
+	c.close()
+}
+
+// PlayMedia plays a wav file to the party identified by tag, in place of the relayed audio.
+func (e *Engine) PlayMedia(callID, tag, file string, repeat int) (time.Duration, error) {
+	c := e.Call(callID)
+	if c == nil {
+		return 0, errCallNotFound
+	}
+	return c.PlayMedia(tag, file, repeat)
+}
+
+// StartRecording records all streams of the call into e.cfg.RecordDir
+func (e *Engine) StartRecording(callID string) error {
+	c := e.Call(callID)
+	if c == nil {
+		return errCallNotFound
+	}
+	return c.StartRecording(e.cfg.RecordDir)
+}
+
+// StopRecording stops the recording of the call
+func (e *Engine) StopRecording(callID string) error {
+	c := e.Call(callID)
+	if c == nil {
+		return errCallNotFound
+	}
+	c.StopRecording()
+	return nil
+}
+
+// Close deletes all calls and endpoints
+func (e *Engine) Close() {
+	for _, c := range e.Calls() {
+		_ = e.DeleteCall(c.id)
+	}
+	e.RLock()
+	ids := make([]string, 0, len(e.endpoints))
+	for id := range e.endpoints {
+		ids = append(ids, id)
+	}
+	e.RUnlock()
+	for _, id := range ids {
+		_ = e.DeleteEndpoint(id)
+	}
+}
+
+// getOrNewCall returns the call by id, created reports whether it is new
+func (e *Engine) getOrNewCall(id string) (c *Call, created bool) {
+	e.Lock()
+	defer e.Unlock()
+	if c, ok := e.calls[id]; ok {
+		return c, false
+	}
+	c = &Call{
+		id:      id,
+		engine:  e,
+		created: time.Now(),
+		offerer: -1,
+	}
+	e.calls[id] = c
+	Logger.Info("new call", "call_id", id)
+	return c, true
+}
+
+func (e *Engine) e2ee(session string) bool {
+	return e.cfg.E2EE != nil && session != "" && e.cfg.E2EE(session)
+}
+
+func (e *Engine) audioObserver(session string) AudioObserver {
+	if e.cfg.AudioObserver == nil || session == "" {
+		return nil
+	}
+	return e.cfg.AudioObserver(session)
+}
+
+func (e *Engine) advertise(o OfferOptions) string {
+	if o.MediaAddress != "" {
+		return o.MediaAddress
+	}
+	return e.cfg.AdvertiseIP
+}
+
+// Call 一个呼叫的两方: side 0 是第一个offer的from-tag, side 1 是对端.
+type Call struct {
+	sync.Mutex
+	id       string
+	engine   *Engine
+	created  time.Time
+	tags     [2]string
+	streams  []*Stream
+	offerer  int // 等待answer的offer方, -1表示没有
+	recorder *recorder
+	closed   bool
+}
+
+// ID returns the call id
+func (c *Call) ID() string { return c.id }
+
+// Created returns the creation time of the call
+func (c *Call) Created() time.Time { return c.created }
+
+// Tags returns the tags of both parties, the caller first
+func (c *Call) Tags() [2]string {
+	c.Lock()
+	defer c.Unlock()
+	return c.tags
+}
+
+// Streams returns the media streams of the call
+func (c *Call) Streams() []*Stream {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*Stream(nil), c.streams...)
+}
+
+// PlayMedia plays file to the party identified by tag, returns the play duration
+func (c *Call) PlayMedia(tag, file string, repeat int) (time.Duration, error) {
+	c.Lock()
+	defer c.Unlock()
+	side := c.side(tag, false)
+	if side < 0 {
+		return 0, errTagNotFound
+	}
+	for _, s := range c.streams {
+		if s.kind != "audio" {
+			continue
+		}
+		if s.e2ee {
+			return 0, errE2EE
+		}
+		return s.play(side, file, repeat)
+	}
+	return 0, errNoAudioStream
+}
+
+// StartRecording writes the relayed rtp of every stream into dir
+func (c *Call) StartRecording(dir string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.recorder != nil {
+		return nil
+	}
+	for _, s := range c.streams {
+		if s.e2ee {
+			return errE2EE
+		}
+	}
+	r, err := newRecorder(dir, c.id)
+	if err != nil {
+		return err
+	}
+	c.recorder = r
+	for _, s := range c.streams {
+		s.setRecorder(r)
+	}
+	Logger.Info("call recording started", "call_id", c.id, "dir", dir)
+	return nil
+}
+
+// StopRecording closes the recording files
+func (c *Call) StopRecording() {
+	c.Lock()
+	r := c.recorder
+	c.recorder = nil
+	for _, s := range c.streams {
+		s.setRecorder(nil)
+	}
+	c.Unlock()
+	if r != nil {
+		r.close()
+	}
+}
+
+// side 返回tag对应的一方, create为true时分配空闲的一方.
+func (c *Call) side(tag string, create bool) int {
+	for i, t := range c.tags {
+		if t == tag && t != "" {
+			return i
+		}
+	}
+	if !create {
+		return -1
+	}
+	for i, t := range c.tags {
+		if t == "" {
+			c.tags[i] = tag
+			return i
+		}
+	}
+	return 0
+}
+
+func (c *Call) stream(index int, kind string, o OfferOptions) (*Stream, error) {
+	if index < len(c.streams) {
+		return c.streams[index], nil
+	}
+	s := &Stream{
+		index:   index,
+		kind:    kind,
+		call:    c,
+		e2ee:    o.E2EE,
+		session: o.Session,
+	}
+	cfg := LegConfig{
+		Latching:     !o.Asymmetric,
+		Relatch:      true,
+		StrictSource: o.StrictSource,
+	}
+	for side := range s.legs {
+		leg, err := NewLeg(fmt.Sprintf("%s-%d-%d", c.id, index, side), c.engine.pool, c.engine.cfg.BufferFactory, cfg)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.legs[side] = leg
+	}
+	for side := range s.legs {
+		s.bind(side)
+	}
+	if !s.e2ee {
+		s.recorder = c.recorder
+	}
+	c.streams = append(c.streams, s)
+	return s, nil
+}
+
+func (c *Call) close() {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return
+	}
+	c.closed = true
+	streams := c.streams
+	r := c.recorder
+	c.recorder = nil
+	c.Unlock()
+
+	for _, s := range streams {
+		s.close()
+	}
+	if r != nil {
+		r.close()
+	}
+	Logger.Info("call deleted", "call_id", c.id)
+}
+
+// Stream 一个m=段: legs[i]面向第i方, 从legs[i]收到的rtp转发到legs[1-i].
+type Stream struct {
+	sync.RWMutex
+	index    int
+	kind     string
+	call     *Call
+	legs     [2]*Leg
+	media    [2]*sdpMedia          // 双方最近一次的SDP
+	protos   [2]string             // 双方使用的传输协议
+	crypto   [2][]*CryptoAttribute // 发给该方的a=crypto
+	setup    [2]string             // 本端对该方的dtls角色
+	ice      [2]*ICECredentials    // 本端对该方的ice-lite凭据, nil表示该方不使用ice
+	players  [2]*player
+	recorder *recorder
+	e2ee     bool
+	session  string
+	observer [2]AudioObserver
+}
+
+// Index returns the m= line index
+func (s *Stream) Index() int { return s.index }
+
+// Kind returns the media type, audio or video
+func (s *Stream) Kind() string { return s.kind }
+
+// Leg returns the leg facing side, 0 for the caller and 1 for the callee
+func (s *Stream) Leg(side int) *Leg { return s.legs[side] }
+
+// Protocol returns the transport protocol used with side
+func (s *Stream) Protocol(side int) string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.protos[side]
+}
+
+// remoteMedia returns the latest sdp media of side
+func (s *Stream) remoteMedia(side int) *sdpMedia {
+	s.RLock()
+	defer s.RUnlock()
+	return s.media[side]
+}
+
+// setRemote 应用一方的SDP: 地址和加密参数.
+func (s *Stream) setRemote(side int, m *sdpMedia, offer bool) error {
+	leg := s.legs[side]
+	leg.SetRemote(m.addr, m.rtcpAddr)
+	leg.SetRTCPMux(m.rtcpMux)
+	leg.SetDTMFPayloadType(m.dtmfPayloadType())
+
+	s.Lock()
+	s.media[side] = m
+	s.protos[side] = m.proto
+	s.Unlock()
+	if err := s.setupICE(side, m.iceUfrag); err != nil {
+		return err
+	}
+
+	switch SRTPModeFromProto(m.proto) {
+	case SRTPSDES:
+		return s.setupSDES(side, m, offer)
+	case SRTPDTLS:
+		return s.setupDTLS(side, m, offer)
+	}
+	return nil
+}
+
+func (s *Stream) setupSDES(side int, m *sdpMedia, offer bool) error {
+	if offer {
+		remote, local, err := SelectCrypto(m.crypto)
+		if err != nil {
+			return err
+		}
+		s.Lock()
+		s.crypto[side] = []*CryptoAttribute{local}
+		s.Unlock()
+		return s.legs[side].SetSDES(local, remote)
+	}
+
+	if len(m.crypto) == 0 {
+		return errSRTPNotNegotiated
+	}
+	remote, err := ParseCrypto(m.crypto[0])
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, local := range s.crypto[side] {
+		if local.Tag == remote.Tag && local.Suite == remote.Suite {
+			s.crypto[side] = []*CryptoAttribute{local}
+			return s.legs[side].SetSDES(local, remote)
+		}
+	}
+	return errUnsupportedSuite
+}
+
+func (s *Stream) setupDTLS(side int, m *sdpMedia, offer bool) error {
+	var client bool
+	if offer {
+		// 对方actpass或passive时本端主动发起握手.
+		client = m.setup != "active"
+		s.Lock()
+		if client {
+			s.setup[side] = "active"
+		} else {
+			s.setup[side] = "passive"
+		}
+		s.Unlock()
+	} else {
+		client = m.setup == "passive"
+	}
+	return s.legs[side].StartDTLS(DTLSConfig{
+		Certificate:       s.call.engine.cert,
+		Client:            client,
+		RemoteFingerprint: m.fingerprint,
+	})
+}
+
+// setupICE 对方使用ice时本端作为ice-lite应答连接检查, 对方不使用时关闭.
+func (s *Stream) setupICE(side int, remoteUfrag string) error {
+	s.Lock()
+	defer s.Unlock()
+	if remoteUfrag == "" {
+		s.ice[side] = nil
+		s.legs[side].SetICE(ICECredentials{}, "")
+		return nil
+	}
+	if s.ice[side] == nil {
+		creds, err := NewICECredentials()
+		if err != nil {
+			return err
+		}
+		s.ice[side] = &creds
+	}
+	s.legs[side].SetICE(*s.ice[side], remoteUfrag)
+	return nil
+}
+
+// prepareOffer 生成发给side的加密参数, dtls-srtp的一方(webrtc)需要ice.
+func (s *Stream) prepareOffer(side int) error {
+	s.Lock()
+	defer s.Unlock()
+	if SRTPModeFromProto(s.protos[side]) == SRTPDTLS && s.ice[side] == nil {
+		creds, err := NewICECredentials()
+		if err != nil {
+			return err
+		}
+		s.ice[side] = &creds
+		s.legs[side].SetICE(creds, "")
+	}
+	switch SRTPModeFromProto(s.protos[side]) {
+	case SRTPSDES:
+		if len(s.crypto[side]) > 0 {
+			return nil
+		}
+		for i, suite := range supportedSuites {
+			c, err := NewCryptoAttribute(i+1, suite)
+			if err != nil {
+				return err
+			}
+			s.crypto[side] = append(s.crypto[side], c)
+		}
+	case SRTPDTLS:
+		if s.setup[side] == "" {
+			s.setup[side] = "actpass"
+		}
+	}
+	return nil
+}
+
+// local 写给side的本端媒体参数.
+func (s *Stream) local(side int, ip string) localMedia {
+	s.RLock()
+	defer s.RUnlock()
+	mode := SRTPModeFromProto(s.protos[side])
+	l := localMedia{
+		ip:    ip,
+		port:  s.legs[side].LocalPort(),
+		proto: s.protos[side],
+		ice:   s.ice[side],
+	}
+	if m := s.media[side]; m != nil && m.rtcpMux {
+		l.rtcpMux = true
+	}
+	switch mode {
+	case SRTPSDES:
+		l.crypto = s.crypto[side]
+	case SRTPDTLS:
+		l.rtcpMux = true
+		l.fingerprint = s.call.engine.fingerprint
+		l.setup = s.setup[side]
+	}
+	return l
+}
+
+// bind 转发从legs[side]收到的rtp和rtcp.
+func (s *Stream) bind(side int) {
+	leg := s.legs[side]
+	leg.OnNewSSRC(func(ssrc uint32) {
+		buff := leg.Buffer(ssrc)
+		if buff == nil {
+			return
+		}
+		buff.Bind(s.parameters(side), buffer.Options{E2EE: s.e2ee})
+		s.observe(side, buff)
+		go s.forward(side, buff)
+	})
+	leg.OnRTCP(func(pkts []rtcp.Packet) {
+		_ = s.legs[1-side].WriteRTCP(pkts)
+	})
+	leg.OnDTMF(func(digit string, duration time.Duration) {
+		s.call.engine.cfg.Events.Publish(events.TypeDTMF, s.call.id, s.call.Tags()[side], events.DTMFData{
+			Digit:    digit,
+			Duration: duration.Milliseconds(),
+		})
+	})
+	leg.OnMediaTimeout(func() {
+		s.call.engine.cfg.Events.Publish(events.TypeMediaTimeout, s.call.id, s.call.Tags()[side], events.MediaTimeoutData{
+			LegID:   leg.ID(),
+			Timeout: s.call.engine.cfg.MediaTimeout.Milliseconds(),
+		})
+		go func() {
+			_ = s.call.engine.DeleteCall(s.call.id)
+		}()
+	})
+}
+
+func (s *Stream) forward(side int, buff *buffer.Buffer) {
+	to := s.legs[1-side]
+	for {
+		ep, err := buff.ReadExtended()
+		if err != nil {
+			return
+		}
+		s.RLock()
+		p, r := s.players[1-side], s.recorder
+		s.RUnlock()
+		if r != nil {
+			r.write(s.index, side, &ep.Packet)
+		}
+		// 放音时不转发对端的媒体.
+		if p != nil && p.playing() {
+			continue
+		}
+		_, _ = to.WriteRTP(&ep.Packet.Header, ep.Packet.Payload)
+	}
+}
+
+// parameters 用SDP中的第一个codec绑定Buffer.
+func (s *Stream) parameters(side int) webrtc.RTPParameters {
+	s.RLock()
+	defer s.RUnlock()
+	kind := "audio"
+	if s.kind == "video" {
+		kind = "video"
+	}
+	var codec sdpCodec
+	for _, m := range []*sdpMedia{s.media[side], s.media[1-side]} {
+		if m == nil {
+			continue
+		}
+		if c, ok := m.codec(0); ok {
+			codec = c
+			break
+		}
+	}
+	return webrtc.RTPParameters{
+		Codecs: []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:  kind + "/" + codec.name,
+				ClockRate: codec.clockRate,
+			},
+			PayloadType: webrtc.PayloadType(codec.payloadType),
+		}},
+	}
+}
+
+func (s *Stream) play(side int, file string, repeat int) (time.Duration, error) {
+	s.RLock()
+	m := s.media[side]
+	s.RUnlock()
+	if m == nil {
+		return 0, errNoPendingOffer
+	}
+	p, err := newPlayer(s.legs[side], m, file, repeat)
+	if err != nil {
+		return 0, err
+	}
+	s.Lock()
+	old := s.players[side]
+	s.players[side] = p
+	s.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	p.start()
+	return p.duration(), nil
+}
+
+// observe 音频的声音大小计入所属会话的说话者检测, stream id为leg id.
+func (s *Stream) observe(side int, buff *buffer.Buffer) {
+	if s.kind != "audio" {
+		return
+	}
+	id := s.legs[side].ID()
+	s.Lock()
+	if s.observer[side] == nil {
+		if s.observer[side] = s.call.engine.audioObserver(s.session); s.observer[side] == nil {
+			s.Unlock()
+			return
+		}
+		s.observer[side].AddStream(id)
+	}
+	o := s.observer[side]
+	s.Unlock()
+	buff.OnAudioLevel(func(level uint8) {
+		o.Observe(id, level)
+	})
+}
+
+func (s *Stream) setRecorder(r *recorder) {
+	s.Lock()
+	s.recorder = r
+	s.Unlock()
+}
+
+func (s *Stream) close() {
+	s.Lock()
+	players, observers := s.players, s.observer
+	s.observer = [2]AudioObserver{}
+	s.Unlock()
+	for _, p := range players {
+		if p != nil {
+			p.stop()
+		}
+	}
+	for side, leg := range s.legs {
+		if leg == nil {
+			continue
+		}
+		_ = leg.Close()
+		if observers[side] != nil {
+			observers[side].RemoveStream(leg.ID())
+		}
+	}
+}
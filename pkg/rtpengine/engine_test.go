@@ -0,0 +1,39 @@
+package rtpengine
+
+import (
+	log "common/log/newlog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func TestEngine_OfferFailed(t *testing.T) {
+	// 两对端口只够一个m=段的两个leg.
+	e, err := NewEngine(Config{
+		IP:            "127.0.0.1",
+		MinPort:       42140,
+		MaxPort:       42143,
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+	})
+	require.NoError(t, err)
+	defer e.Close()
+	twoMedias := testOffer + "m=audio 40002 RTP/AVP 0\r\na=rtpmap:0 PCMU/8000\r\n"
+
+	_, err = e.Offer(OfferOptions{CallID: "c1", FromTag: "a", SDP: twoMedias})
+	assert.ErrorIs(t, err, errNoPortAvailable)
+	assert.Nil(t, e.Call("c1"), "new call is deleted")
+	used, _ := e.PortPool().Usage()
+	assert.Zero(t, used)
+
+	// 已经存在的call重新offer失败时保留.
+	_, err = e.Offer(OfferOptions{CallID: "c2", FromTag: "a", SDP: testOffer})
+	require.NoError(t, err)
+	_, err = e.Offer(OfferOptions{CallID: "c2", FromTag: "a", SDP: twoMedias})
+	assert.ErrorIs(t, err, errNoPortAvailable)
+	require.NotNil(t, e.Call("c2"))
+	used, _ = e.PortPool().Usage()
+	assert.Equal(t, 2, used)
+}
@@ -0,0 +1,79 @@
+package rtpengine
+
+// G.711 编解码, 放音时把wav转成PCMU/PCMA.
+
+const (
+	ulawBias = 0x84
+	ulawClip = 32635
+)
+
+func linearToUlaw(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > ulawClip {
+		s = ulawClip
+	}
+	s += ulawBias
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	sign := u & 0x80
+	exponent := int(u>>4) & 0x07
+	mantissa := int(u & 0x0f)
+	s := ((mantissa << 3) + ulawBias) << exponent
+	s -= ulawBias
+	if sign != 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
+
+func linearToAlaw(sample int16) byte {
+	s := int(sample)
+	sign := 0x80
+	if s < 0 {
+		s = -s - 1
+		sign = 0
+	}
+	if s > 0x7fff {
+		s = 0x7fff
+	}
+	var a int
+	if s < 256 {
+		a = s >> 4
+	} else {
+		exponent := 7
+		for mask := 0x4000; s&mask == 0 && exponent > 1; mask >>= 1 {
+			exponent--
+		}
+		a = exponent<<4 | (s>>(exponent+3))&0x0f
+	}
+	return byte(a|sign) ^ 0x55
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	exponent := int(a>>4) & 0x07
+	mantissa := int(a & 0x0f)
+	var s int
+	if exponent == 0 {
+		s = mantissa<<4 + 8
+	} else {
+		s = (mantissa<<4 + 0x108) << (exponent - 1)
+	}
+	if a&0x80 == 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
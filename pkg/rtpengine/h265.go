@@ -0,0 +1,185 @@
+package rtpengine
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+const codecH265 = "H265"
+
+// rfc7798 没有携带时的默认值: Main profile, Main tier, level 3.1.
+const (
+	h265DefaultProfileSpace = 0
+	h265DefaultProfileID    = 1
+	h265DefaultTierFlag     = 0
+	h265DefaultLevelID      = 93
+)
+
+// fmtpParams 保持参数原来的顺序.
+type fmtpParams struct {
+	keys   []string
+	values map[string]string
+}
+
+func parseFmtp(line string) *fmtpParams {
+	f := &fmtpParams{values: make(map[string]string)}
+	for _, p := range strings.Split(line, ";") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if kv[0] == "" {
+			continue
+		}
+		key := strings.ToLower(kv[0])
+		if _, ok := f.values[key]; !ok {
+			f.keys = append(f.keys, key)
+		}
+		if len(kv) == 2 {
+			f.values[key] = kv[1]
+		} else {
+			f.values[key] = ""
+		}
+	}
+	return f
+}
+
+func (f *fmtpParams) int(key string, def int) int {
+	v, ok := f.values[key]
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func (f *fmtpParams) set(key string, v int) {
+	if _, ok := f.values[key]; !ok {
+		f.keys = append(f.keys, key)
+	}
+	f.values[key] = strconv.Itoa(v)
+}
+
+func (f *fmtpParams) String() string {
+	parts := make([]string, 0, len(f.keys))
+	for _, k := range f.keys {
+		if v := f.values[k]; v != "" {
+			parts = append(parts, k+"="+v)
+		} else {
+			parts = append(parts, k)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// intersectH265 不转码, 两端的profile必须相同, tier和level取较低的一方. ok为false表示profile不同.
+func intersectH265(offer, answer string) (string, bool) {
+	o, a := parseFmtp(offer), parseFmtp(answer)
+	if o.int("profile-space", h265DefaultProfileSpace) != a.int("profile-space", h265DefaultProfileSpace) ||
+		o.int("profile-id", h265DefaultProfileID) != a.int("profile-id", h265DefaultProfileID) {
+		return answer, false
+	}
+
+	tier := a.int("tier-flag", h265DefaultTierFlag)
+	if t := o.int("tier-flag", h265DefaultTierFlag); t < tier {
+		tier = t
+	}
+	level := a.int("level-id", h265DefaultLevelID)
+	if l := o.int("level-id", h265DefaultLevelID); l < level {
+		level = l
+	}
+	if tier != a.int("tier-flag", h265DefaultTierFlag) {
+		a.set("tier-flag", tier)
+	}
+	if level != a.int("level-id", h265DefaultLevelID) {
+		a.set("level-id", level)
+	}
+	return a.String(), true
+}
+
+// negotiateFmtp 把answer中H.265的fmtp改成两端的交集, profile不同的H.265从answer中去掉.
+// 去掉后没有编码时这一路媒体按拒绝处理.
+func negotiateFmtp(md *sdp.MediaDescription, offer, answer *sdpMedia) {
+	removed := make(map[string]bool)
+	for i := range answer.codecs {
+		c := &answer.codecs[i]
+		if !strings.EqualFold(c.name, codecH265) {
+			continue
+		}
+		o, ok := offer.offered(c)
+		if !ok {
+			continue
+		}
+		fmtp, ok := intersectH265(o.fmtp, c.fmtp)
+		if !ok {
+			removed[strconv.Itoa(int(c.payloadType))] = true
+			continue
+		}
+		if fmtp != c.fmtp {
+			c.fmtp = fmtp
+			setFmtp(md, c.payloadType, fmtp)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	if len(removed) == len(md.MediaName.Formats) {
+		answer.addr = nil
+		return
+	}
+
+	codecs := answer.codecs[:0]
+	for _, c := range answer.codecs {
+		if !removed[strconv.Itoa(int(c.payloadType))] {
+			codecs = append(codecs, c)
+		}
+	}
+	answer.codecs = codecs
+	formats := md.MediaName.Formats[:0]
+	for _, f := range md.MediaName.Formats {
+		if !removed[f] {
+			formats = append(formats, f)
+		}
+	}
+	md.MediaName.Formats = formats
+	attrs := md.Attributes[:0]
+	for _, a := range md.Attributes {
+		switch a.Key {
+		case "rtpmap", "fmtp", "rtcp-fb":
+			if removed[strings.SplitN(a.Value, " ", 2)[0]] {
+				continue
+			}
+		}
+		attrs = append(attrs, a)
+	}
+	md.Attributes = attrs
+}
+
+// offered 按payload type查找answer中的编码在offer中对应的编码, 没有时按名称查找.
+func (m *sdpMedia) offered(c *sdpCodec) (sdpCodec, bool) {
+	for _, o := range m.codecs {
+		if o.payloadType == c.payloadType && strings.EqualFold(o.name, c.name) {
+			return o, true
+		}
+	}
+	for _, o := range m.codecs {
+		if strings.EqualFold(o.name, c.name) && o.clockRate == c.clockRate {
+			return o, true
+		}
+	}
+	return sdpCodec{}, false
+}
+
+func setFmtp(md *sdp.MediaDescription, pt uint8, fmtp string) {
+	prefix := strconv.Itoa(int(pt))
+	value := prefix + " " + fmtp
+	for i, a := range md.Attributes {
+		if a.Key == "fmtp" && strings.SplitN(a.Value, " ", 2)[0] == prefix {
+			md.Attributes[i].Value = value
+			return
+		}
+	}
+	md.Attributes = append(md.Attributes, sdp.NewAttribute("fmtp", value))
+}
@@ -0,0 +1,117 @@
+package rtpengine
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/stun"
+)
+
+// ICE: 对使用ice的一方(webrtc)本端作为ice-lite, 在rtp/rtcp端口上只有一个host候选,
+// 应答对方的连接检查, 对方提名(USE-CANDIDATE)的地址作为发送地址.
+
+// ICECredentials ice-ufrag和ice-pwd.
+type ICECredentials struct {
+	Ufrag string
+	Pwd   string
+}
+
+// NewICECredentials generates random credentials, base64 characters are valid ice-chars
+func NewICECredentials() (ICECredentials, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return ICECredentials{}, err
+	}
+	// ufrag至少4个字符, pwd至少22个字符.
+	return ICECredentials{
+		Ufrag: base64.RawStdEncoding.EncodeToString(b[:6]),
+		Pwd:   base64.RawStdEncoding.EncodeToString(b[6:]),
+	}, nil
+}
+
+// SetICE answers the connectivity checks of remoteUfrag with local, an empty remoteUfrag accepts any remote.
+func (l *Leg) SetICE(local ICECredentials, remoteUfrag string) {
+	l.Lock()
+	l.ice = local
+	l.iceRemoteUfrag = remoteUfrag
+	l.Unlock()
+}
+
+// handleSTUN 应答Binding请求, 没有设置ice或校验失败时丢弃.
+func (l *Leg) handleSTUN(conn *net.UDPConn, pkt []byte, src *net.UDPAddr) {
+	l.RLock()
+	local, remoteUfrag := l.ice, l.iceRemoteUfrag
+	l.RUnlock()
+	if local.Ufrag == "" || !l.allowSource(src) {
+		return
+	}
+
+	m := &stun.Message{Raw: append([]byte(nil), pkt...)}
+	if err := m.Decode(); err != nil || m.Type != stun.BindingRequest {
+		return
+	}
+	var username stun.Username
+	if err := username.GetFrom(m); err != nil {
+		return
+	}
+	// USERNAME是 本端ufrag:对方ufrag.
+	ufrags := strings.SplitN(username.String(), ":", 2)
+	if len(ufrags) != 2 || ufrags[0] != local.Ufrag || (remoteUfrag != "" && ufrags[1] != remoteUfrag) {
+		return
+	}
+	integrity := stun.NewShortTermIntegrity(local.Pwd)
+	if err := integrity.Check(m); err != nil {
+		return
+	}
+
+	res, err := stun.Build(stun.NewTransactionIDSetter(m.TransactionID), stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: src.IP, Port: src.Port}, integrity, stun.Fingerprint)
+	if err != nil {
+		return
+	}
+	if _, err = conn.WriteToUDP(res.Raw, src); err != nil {
+		return
+	}
+
+	if conn == l.rtpConn && m.Contains(stun.AttrUseCandidate) {
+		l.nominate(src)
+	}
+}
+
+// nominate 对方提名的地址作为rtp的发送地址, 之后的rtp从这个地址来时直接通过锁定.
+func (l *Leg) nominate(src *net.UDPAddr) {
+	l.Lock()
+	if addrEqual(l.remote, src) {
+		l.Unlock()
+		return
+	}
+	l.setRemote(src)
+	if l.rtcpMux {
+		l.remoteRTCP = l.remote
+	}
+	l.Unlock()
+	Logger.Info("rtp leg ice nominated", "leg_id", l.id, "addr", src.String())
+}
+
+// iceAttributes 写入m=段的ice属性, rtcp不复用时rtcp端口是component 2.
+func iceAttributes(local localMedia) []sdp.Attribute {
+	attrs := []sdp.Attribute{
+		sdp.NewAttribute("ice-ufrag", local.ice.Ufrag),
+		sdp.NewAttribute("ice-pwd", local.ice.Pwd),
+		sdp.NewAttribute("candidate", hostCandidate(1, local.ip, local.port)),
+	}
+	if !local.rtcpMux {
+		attrs = append(attrs, sdp.NewAttribute("candidate", hostCandidate(2, local.ip, local.port+1)))
+	}
+	return append(attrs, sdp.NewPropertyAttribute("end-of-candidates"))
+}
+
+// hostCandidate RFC 8445的优先级: type preference 126, local preference 65535.
+func hostCandidate(component int, ip string, port int) string {
+	priority := 126<<24 | 65535<<8 | (256 - component)
+	return fmt.Sprintf("%d %d udp %d %s %d typ host", component, component, priority, ip, port)
+}
@@ -0,0 +1,125 @@
+package rtpengine
+
+import (
+	log "common/log/newlog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func bindingRequest(t *testing.T, username, pwd string, nominate bool) []byte {
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest, stun.NewUsername(username)}
+	if nominate {
+		setters = append(setters, stun.RawAttribute{Type: stun.AttrUseCandidate})
+	}
+	setters = append(setters, stun.NewShortTermIntegrity(pwd), stun.Fingerprint)
+	m, err := stun.Build(setters...)
+	require.NoError(t, err)
+	return m.Raw
+}
+
+func TestLeg_ICE(t *testing.T) {
+	pool := NewPortPool("127.0.0.1", 42110, 42120)
+	leg, err := NewLeg("l1", pool, buffer.NewBufferFactory(100, log.GetLogger()), LegConfig{Latching: true})
+	require.NoError(t, err)
+	defer leg.Close()
+	local, err := NewICECredentials()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(local.Ufrag), 4)
+	assert.GreaterOrEqual(t, len(local.Pwd), 22)
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: leg.LocalPort()})
+	require.NoError(t, err)
+	defer conn.Close()
+	response := func() *stun.Message {
+		buf := make([]byte, 1500)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil
+		}
+		m := &stun.Message{Raw: buf[:n]}
+		require.NoError(t, m.Decode())
+		return m
+	}
+
+	// 没有设置ice时不应答.
+	_, err = conn.Write(bindingRequest(t, local.Ufrag+":remote", local.Pwd, false))
+	require.NoError(t, err)
+	assert.Nil(t, response())
+
+	leg.SetICE(local, "remote")
+	for _, invalid := range [][]byte{
+		bindingRequest(t, local.Ufrag+":remote", "wrong password is long enough", false),
+		bindingRequest(t, local.Ufrag+":other", local.Pwd, false),
+		bindingRequest(t, "other:remote", local.Pwd, false),
+	} {
+		_, err = conn.Write(invalid)
+		require.NoError(t, err)
+		assert.Nil(t, response())
+	}
+
+	_, err = conn.Write(bindingRequest(t, local.Ufrag+":remote", local.Pwd, false))
+	require.NoError(t, err)
+	res := response()
+	require.NotNil(t, res)
+	assert.Equal(t, stun.BindingSuccess, res.Type)
+	require.NoError(t, stun.NewShortTermIntegrity(local.Pwd).Check(res))
+	var mapped stun.XORMappedAddress
+	require.NoError(t, mapped.GetFrom(res))
+	assert.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, mapped.Port)
+	assert.Nil(t, leg.Remote(), "not nominated")
+
+	// 提名后发往对方的地址.
+	_, err = conn.Write(bindingRequest(t, local.Ufrag+":remote", local.Pwd, true))
+	require.NoError(t, err)
+	require.NotNil(t, response())
+	require.NotNil(t, leg.Remote())
+	assert.Equal(t, conn.LocalAddr().String(), leg.Remote().String())
+}
+
+func TestEngine_OfferICE(t *testing.T) {
+	e := newTestEngine(t)
+
+	// sip侧没有ice, 转成dtls-srtp给webrtc时本端是ice-lite.
+	offer, err := e.Offer(OfferOptions{CallID: "c1", FromTag: "a", SDP: testOffer, TransportProtocol: "UDP/TLS/RTP/SAVPF"})
+	require.NoError(t, err)
+	assert.Contains(t, offer, "a=ice-lite\r\n")
+	assert.Contains(t, offer, "a=ice-ufrag:")
+	assert.Contains(t, offer, "a=ice-pwd:")
+	assert.Contains(t, offer, " typ host\r\n")
+	assert.Contains(t, offer, "a=end-of-candidates\r\n")
+	assert.Contains(t, offer, "a=fingerprint:sha-256 ")
+	s := e.Call("c1").Streams()[0]
+	port := s.Leg(1).LocalPort()
+	assert.Contains(t, offer, "127.0.0.1 "+strconv.Itoa(port)+" typ host")
+
+	// webrtc应答的地址是0.0.0.0, 地址由连接检查得到, 不是拒绝.
+	answer := "v=0\r\n" +
+		"o=- 2 1 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"a=ice-ufrag:abcd\r\n" +
+		"a=ice-pwd:abcdefghijklmnopqrstuv\r\n" +
+		"a=fingerprint:sha-256 " + strings.Repeat("AB:", 31) + "AB\r\n" +
+		"a=setup:active\r\n" +
+		"a=rtcp-mux\r\n"
+	sdp, err := e.Answer(OfferOptions{CallID: "c1", FromTag: "a", ToTag: "b", SDP: answer})
+	require.NoError(t, err)
+	assert.NotContains(t, sdp, "m=audio 0 ")
+	assert.NotContains(t, sdp, "ice-ufrag", "sip side has no ice")
+	s.Leg(1).RLock()
+	assert.Equal(t, "abcd", s.Leg(1).iceRemoteUfrag)
+	s.Leg(1).RUnlock()
+}
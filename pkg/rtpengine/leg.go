@@ -0,0 +1,639 @@
+package rtpengine
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/stun"
+	"github.com/pion/transport/packetio"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+const (
+	maxPktSize = 1500
+
+	// 源地址变化后需要连续收到的包数, 防止乱序的旧包造成来回切换.
+	relatchPackets = 3
+)
+
+var (
+	errNoRemote       = errors.New("rtp leg has no remote address")
+	errInvalidPacket  = errors.New("invalid rtp packet")
+	errSSRCNotAllowed = errors.New("ssrc not allowed")
+	errSourceNotAllow = errors.New("source ip not allowed")
+	errSourceChanged  = errors.New("source address changed")
+	errSSRCChanged    = errors.New("ssrc changed with source address")
+)
+
+// LegConfig defines plain rtp leg options
+type LegConfig struct {
+	// Latching 对称rtp: 以第一个合法包的源地址作为发送地址, NAT后的终端发送地址和SDP中的不一致.
+	Latching bool
+	// Relatch 锁定后源地址变化时重新锁定, 需要ssrc和锁定时一致.
+	Relatch bool
+	// SSRC 仅接受指定ssrc的包, 0表示不限制.
+	SSRC uint32
+	// StrictSource 仅接受SDP中地址(IP)发来的包.
+	StrictSource bool
+	// MediaTimeout 超过该时间没有收到rtp则认为媒体超时, 0表示不检测.
+	MediaTimeout time.Duration
+	// HangupOnTimeout 媒体超时后关闭leg.
+	HangupOnTimeout bool
+}
+
+// Leg 纯rtp一路媒体: 一对rtp/rtcp udp端口.
+// 收到的rtp写入bufferFactory对应ssrc的Buffer, 和webrtc的srtp流程一致;
+// 同时实现webrtc.TrackLocalWriter, DownTrack可以直接往Leg写包.
+// 开启SRTP(SetSDES/StartDTLS)后收包先解密, 发包时加密, Buffer里始终是明文rtp.
+type Leg struct {
+	sync.RWMutex
+	id  string
+	cfg LegConfig
+
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+	pool     *PortPool
+	port     int
+
+	sdpAddr     *net.UDPAddr // SDP中的地址
+	remote      *net.UDPAddr // 当前发送地址
+	remoteRTCP  *net.UDPAddr
+	latched     bool
+	latchedSSRC uint32
+	pendingAddr *net.UDPAddr
+	pendingCnt  int
+
+	bufferFactory *buffer.Factory
+	ssrcs         map[uint32]*buffer.Buffer
+
+	mode     SRTPMode
+	srtp     *srtpSession // nil表示明文或dtls握手未完成
+	dtlsConn *dtlsConn
+	dtls     *dtls.Conn // 握手完成后的dtls连接, 用于导出密钥
+	rtcpMux  bool
+
+	ice            ICECredentials // 本端ice-lite的凭据, 为空时不应答stun
+	iceRemoteUfrag string
+
+	dtmfPT        int32 // telephone-event的payload type, -1表示不检测
+	dtmfTimestamp uint32
+	dtmfEnded     bool
+
+	lastActivity int64
+	dropped      uint64
+	watching     bool
+	closed       atomicBool
+	closeOnce    sync.Once
+	done         chan struct{}
+
+	onLatch        atomic.Value // func(addr *net.UDPAddr, relatch bool)
+	onMediaTimeout atomic.Value // func()
+	onNewSSRC      atomic.Value // func(ssrc uint32)
+	onRTCP         atomic.Value // func([]rtcp.Packet)
+	onClose        atomic.Value // func()
+	onDTMF         atomic.Value // func(digit string, duration time.Duration)
+}
+
+// NewLeg allocates a port pair from pool and starts reading
+func NewLeg(id string, pool *PortPool, bf *buffer.Factory, cfg LegConfig) (*Leg, error) {
+	rtpConn, rtcpConn, err := pool.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	l := &Leg{
+		id:            id,
+		cfg:           cfg,
+		rtpConn:       rtpConn,
+		rtcpConn:      rtcpConn,
+		pool:          pool,
+		port:          rtpConn.LocalAddr().(*net.UDPAddr).Port,
+		bufferFactory: bf,
+		ssrcs:         make(map[uint32]*buffer.Buffer),
+		lastActivity:  time.Now().UnixNano(),
+		dtmfPT:        -1,
+		done:          make(chan struct{}),
+	}
+
+	go l.readRTP()
+	go l.readRTCP()
+	l.WatchMediaTimeout(cfg.MediaTimeout)
+	return l, nil
+}
+
+// WatchMediaTimeout starts the media timeout check if it is not running yet,
+// used when the leg should only time out once the call is answered.
+func (l *Leg) WatchMediaTimeout(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.watching {
+		return
+	}
+	l.watching = true
+	l.cfg.MediaTimeout = d
+	atomic.StoreInt64(&l.lastActivity, time.Now().UnixNano())
+	go l.checkTimeout()
+}
+
+// ID returns the leg id
+func (l *Leg) ID() string { return l.id }
+
+// LocalPort returns the local rtp port, rtcp is LocalPort()+1
+func (l *Leg) LocalPort() int { return l.port }
+
+// SetRemote sets the remote address from SDP, media is sent there until latched.
+func (l *Leg) SetRemote(addr *net.UDPAddr, rtcpAddr *net.UDPAddr) {
+	l.Lock()
+	defer l.Unlock()
+	l.sdpAddr = addr
+	if rtcpAddr == nil && addr != nil {
+		rtcpAddr = &net.UDPAddr{IP: addr.IP, Port: addr.Port + 1}
+	}
+	if !l.latched || !l.cfg.Latching {
+		l.remote = addr
+		l.remoteRTCP = rtcpAddr
+	}
+}
+
+// Remote returns the current send address
+func (l *Leg) Remote() *net.UDPAddr {
+	l.RLock()
+	defer l.RUnlock()
+	return l.remote
+}
+
+// Latched returns true once the leg has latched on a source address
+func (l *Leg) Latched() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.latched
+}
+
+// OnLatch is called when the leg latches or relatches to a new source address
+func (l *Leg) OnLatch(f func(addr *net.UDPAddr, relatch bool)) {
+	l.onLatch.Store(f)
+}
+
+// OnMediaTimeout is called once no rtp has been received for LegConfig.MediaTimeout
+func (l *Leg) OnMediaTimeout(f func()) {
+	l.onMediaTimeout.Store(f)
+}
+
+// OnNewSSRC is called when the first packet of a new ssrc is received
+func (l *Leg) OnNewSSRC(f func(ssrc uint32)) {
+	l.onNewSSRC.Store(f)
+}
+
+// OnRTCP is called with every rtcp compound packet received
+func (l *Leg) OnRTCP(f func(pkts []rtcp.Packet)) {
+	l.onRTCP.Store(f)
+}
+
+// SetRTCPMux sends rtcp on the rtp port, negotiated with a=rtcp-mux
+func (l *Leg) SetRTCPMux(mux bool) {
+	l.Lock()
+	l.rtcpMux = mux
+	l.Unlock()
+}
+
+// OnClose is called when leg is closed, before its buffers are released
+func (l *Leg) OnClose(f func()) {
+	l.onClose.Store(f)
+}
+
+// Buffer returns the receive buffer of ssrc
+func (l *Leg) Buffer(ssrc uint32) *buffer.Buffer {
+	l.RLock()
+	defer l.RUnlock()
+	return l.ssrcs[ssrc]
+}
+
+// Dropped returns the number of rtp packets rejected by the latching rules or srtp
+func (l *Leg) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// LegStats is the receive statistics of a leg
+type LegStats struct {
+	Packets uint64
+	Bytes   uint64
+	Dropped uint64
+	Lost    uint64
+	Jitter  float64 // ms, 取所有ssrc中的最大值
+}
+
+// Stats sums the buffer statistics of all received ssrcs
+func (l *Leg) Stats() LegStats {
+	stats := LegStats{Dropped: atomic.LoadUint64(&l.dropped)}
+	l.RLock()
+	defer l.RUnlock()
+	for _, buff := range l.ssrcs {
+		s := buff.GetStats()
+		stats.Packets += uint64(s.PacketCount)
+		stats.Bytes += s.TotalByte
+		stats.Lost += uint64(s.PacketsLost)
+		if clockRate := buff.GetClockRate(); clockRate != 0 {
+			if jitter := s.Jitter * 1000 / float64(clockRate); jitter > stats.Jitter {
+				stats.Jitter = jitter
+			}
+		}
+	}
+	return stats
+}
+
+// WriteRTP implements webrtc.TrackLocalWriter
+func (l *Leg) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	pkt := rtp.Packet{Header: *header, Payload: payload}
+	b, err := pkt.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	return l.Write(b)
+}
+
+// Write implements webrtc.TrackLocalWriter, b is a full rtp packet
+func (l *Leg) Write(b []byte) (int, error) {
+	if l.closed.get() {
+		return 0, io.ErrClosedPipe
+	}
+	l.RLock()
+	remote, mode, s := l.remote, l.mode, l.srtp
+	l.RUnlock()
+	if remote == nil {
+		return 0, errNoRemote
+	}
+	if mode != SRTPNone {
+		if s == nil {
+			return 0, errSRTPNotNegotiated
+		}
+		encrypted, err := s.encryptRTP(b)
+		if err != nil {
+			return 0, err
+		}
+		n, err := l.rtpConn.WriteToUDP(encrypted, remote)
+		if err != nil {
+			return 0, err
+		}
+		atomic.AddUint64(&l.pool.txBytes, uint64(n))
+		return len(b), nil
+	}
+	n, err := l.rtpConn.WriteToUDP(b, remote)
+	if err == nil {
+		atomic.AddUint64(&l.pool.txBytes, uint64(n))
+	}
+	return n, err
+}
+
+// WriteRTCP sends rtcp packets to the remote rtcp address
+func (l *Leg) WriteRTCP(pkts []rtcp.Packet) error {
+	if l.closed.get() {
+		return io.ErrClosedPipe
+	}
+	b, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return err
+	}
+	l.RLock()
+	remote, mode, s, mux := l.remoteRTCP, l.mode, l.srtp, l.rtcpMux
+	if mux {
+		remote = l.remote
+	}
+	l.RUnlock()
+	if remote == nil {
+		return errNoRemote
+	}
+	if mode != SRTPNone {
+		if s == nil {
+			return errSRTPNotNegotiated
+		}
+		if b, err = s.encryptRTCP(b); err != nil {
+			return err
+		}
+	}
+	if mux {
+		_, err = l.rtpConn.WriteToUDP(b, remote)
+		return err
+	}
+	_, err = l.rtcpConn.WriteToUDP(b, remote)
+	return err
+}
+
+// Close releases sockets and buffers
+func (l *Leg) Close() error {
+	l.closeOnce.Do(func() {
+		l.closed.set(true)
+		close(l.done)
+		_ = l.rtpConn.Close()
+		_ = l.rtcpConn.Close()
+		l.pool.Release(l.port)
+
+		// 回调中还能取到Stats.
+		if f, ok := l.onClose.Load().(func()); ok && f != nil {
+			f()
+		}
+
+		l.Lock()
+		if l.dtls != nil {
+			_ = l.dtls.Close()
+		}
+		if l.dtlsConn != nil {
+			_ = l.dtlsConn.Close()
+		}
+		for ssrc, buff := range l.ssrcs {
+			_ = buff.Close()
+			delete(l.ssrcs, ssrc)
+		}
+		l.Unlock()
+	})
+	return nil
+}
+
+func (l *Leg) readRTP() {
+	buf := make([]byte, maxPktSize)
+	for {
+		n, src, err := l.rtpConn.ReadFromUDP(buf)
+		if err != nil {
+			if l.closed.get() {
+				return
+			}
+			Logger.Error(err, "rtp leg read err", "leg_id", l.id)
+			continue
+		}
+		atomic.AddUint64(&l.pool.rxBytes, uint64(n))
+		if n < 2 {
+			continue
+		}
+		// RFC 7983: 0-3 是stun, 20-63 是dtls.
+		if stun.IsMessage(buf[:n]) {
+			l.handleSTUN(l.rtpConn, buf[:n], src)
+			continue
+		}
+		if buf[0] >= 20 && buf[0] <= 63 {
+			l.RLock()
+			conn := l.dtlsConn
+			l.RUnlock()
+			if conn != nil && l.allowSource(src) {
+				conn.push(buf[:n], src)
+			}
+			continue
+		}
+		// rtcp-mux: payload type 192-223 是rtcp.
+		if buf[1] >= 192 && buf[1] <= 223 {
+			l.handleRTCP(buf[:n], src)
+			continue
+		}
+		if err = l.handleRTP(buf[:n], src); err != nil {
+			atomic.AddUint64(&l.dropped, 1)
+		}
+	}
+}
+
+func (l *Leg) readRTCP() {
+	buf := make([]byte, maxPktSize)
+	for {
+		n, src, err := l.rtcpConn.ReadFromUDP(buf)
+		if err != nil {
+			if l.closed.get() {
+				return
+			}
+			Logger.Error(err, "rtcp leg read err", "leg_id", l.id)
+			continue
+		}
+		if stun.IsMessage(buf[:n]) {
+			l.handleSTUN(l.rtcpConn, buf[:n], src)
+			continue
+		}
+		l.handleRTCP(buf[:n], src)
+	}
+}
+
+func (l *Leg) handleRTP(pkt []byte, src *net.UDPAddr) error {
+	l.RLock()
+	mode, s := l.mode, l.srtp
+	l.RUnlock()
+	if mode != SRTPNone {
+		if s == nil {
+			return errSRTPNotNegotiated
+		}
+		var err error
+		if pkt, err = s.decryptRTP(pkt); err != nil {
+			return err
+		}
+	}
+
+	var h rtp.Header
+	n, err := h.Unmarshal(pkt)
+	if err != nil || h.Version != 2 {
+		return errInvalidPacket
+	}
+	if err = l.latch(h.SSRC, src); err != nil {
+		return err
+	}
+	if pt := atomic.LoadInt32(&l.dtmfPT); pt >= 0 && int32(h.PayloadType) == pt {
+		l.handleDTMF(h.Timestamp, pkt[n:])
+	}
+
+	l.Lock()
+	buff, found := l.ssrcs[h.SSRC]
+	if !found {
+		var ok bool
+		if buff, ok = l.bufferFactory.GetOrNew(packetio.RTPBufferPacket, h.SSRC).(*buffer.Buffer); !ok || buff == nil {
+			l.Unlock()
+			return errInvalidPacket
+		}
+		l.ssrcs[h.SSRC] = buff
+	}
+	l.Unlock()
+	atomic.StoreInt64(&l.lastActivity, time.Now().UnixNano())
+
+	if !found {
+		if f, ok := l.onNewSSRC.Load().(func(uint32)); ok && f != nil {
+			f(h.SSRC)
+		}
+	}
+	_, err = buff.Write(pkt)
+	return err
+}
+
+// latch 锁定对称rtp地址, 返回错误表示丢弃.
+func (l *Leg) latch(ssrc uint32, src *net.UDPAddr) error {
+	if !l.allowSource(src) {
+		return errSourceNotAllow
+	}
+	l.Lock()
+	if l.cfg.SSRC != 0 && ssrc != l.cfg.SSRC {
+		l.Unlock()
+		return errSSRCNotAllowed
+	}
+	if !l.cfg.Latching {
+		l.Unlock()
+		return nil
+	}
+
+	relatch := false
+	switch {
+	case !l.latched:
+		l.setRemote(src)
+		l.latched = true
+		l.latchedSSRC = ssrc
+	case addrEqual(l.remote, src):
+		l.pendingAddr = nil
+		l.pendingCnt = 0
+		l.Unlock()
+		return nil
+	case !l.cfg.Relatch:
+		l.Unlock()
+		return errSourceChanged
+	case ssrc != l.latchedSSRC:
+		l.Unlock()
+		return errSSRCChanged
+	default:
+		if !addrEqual(l.pendingAddr, src) {
+			l.pendingAddr = src
+			l.pendingCnt = 0
+		}
+		l.pendingCnt++
+		if l.pendingCnt < relatchPackets {
+			// 仍然接收媒体, 只是暂不切换发送地址.
+			l.Unlock()
+			return nil
+		}
+		l.setRemote(src)
+		l.pendingAddr = nil
+		l.pendingCnt = 0
+		relatch = true
+	}
+	l.Unlock()
+
+	Logger.Info("rtp leg latched", "leg_id", l.id, "addr", src.String(), "relatch", relatch)
+	if f, ok := l.onLatch.Load().(func(*net.UDPAddr, bool)); ok && f != nil {
+		f(src, relatch)
+	}
+	return nil
+}
+
+// allowSource StrictSource时只接受SDP中地址发来的包.
+func (l *Leg) allowSource(src *net.UDPAddr) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return !l.cfg.StrictSource || l.sdpAddr == nil || l.sdpAddr.IP.Equal(src.IP)
+}
+
+func (l *Leg) setRemote(src *net.UDPAddr) {
+	addr := *src
+	l.remote = &addr
+	// rtcp-mux 的情况下rtcp也从rtp端口发送, 这里按rtp+1处理, 收到rtcp时再修正.
+	l.remoteRTCP = &net.UDPAddr{IP: addr.IP, Port: addr.Port + 1, Zone: addr.Zone}
+}
+
+func (l *Leg) handleRTCP(pkt []byte, src *net.UDPAddr) {
+	l.RLock()
+	mode, s := l.mode, l.srtp
+	l.RUnlock()
+	if mode != SRTPNone {
+		if s == nil {
+			return
+		}
+		var err error
+		if pkt, err = s.decryptRTCP(pkt); err != nil {
+			return
+		}
+	}
+
+	pkts, err := rtcp.Unmarshal(pkt)
+	if err != nil {
+		return
+	}
+
+	l.Lock()
+	if l.cfg.Latching && l.latched && l.remote != nil && src.IP.Equal(l.remote.IP) {
+		addr := *src
+		l.remoteRTCP = &addr
+	}
+	l.Unlock()
+
+	ssrcs := make(map[uint32]struct{})
+	for _, p := range pkts {
+		for _, ssrc := range p.DestinationSSRC() {
+			ssrcs[ssrc] = struct{}{}
+		}
+	}
+	for ssrc := range ssrcs {
+		if r, ok := l.bufferFactory.GetOrNew(packetio.RTCPBufferPacket, ssrc).(*buffer.RTCPReader); ok && r != nil {
+			_, _ = r.Write(pkt)
+		}
+	}
+	if f, ok := l.onRTCP.Load().(func([]rtcp.Packet)); ok && f != nil {
+		f(pkts)
+	}
+}
+
+// checkTimeout 根据Buffer的统计判断是否还有媒体.
+func (l *Leg) checkTimeout() {
+	l.RLock()
+	timeout, hangup := l.cfg.MediaTimeout, l.cfg.HangupOnTimeout
+	l.RUnlock()
+
+	interval := timeout / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastCount := make(map[uint32]uint32)
+	timedOut := false
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UnixNano()
+		l.RLock()
+		for ssrc, buff := range l.ssrcs {
+			stats := buff.GetStats()
+			if stats.PacketCount != lastCount[ssrc] {
+				lastCount[ssrc] = stats.PacketCount
+				atomic.StoreInt64(&l.lastActivity, now)
+			}
+		}
+		l.RUnlock()
+
+		if now-atomic.LoadInt64(&l.lastActivity) < int64(timeout) {
+			timedOut = false
+			continue
+		}
+		// 只通知一次, 直到媒体恢复.
+		if timedOut {
+			continue
+		}
+		timedOut = true
+
+		Logger.Info("rtp leg media timeout", "leg_id", l.id, "timeout", timeout.String())
+		if f, ok := l.onMediaTimeout.Load().(func()); ok && f != nil {
+			f()
+		}
+		if hangup {
+			_ = l.Close()
+			return
+		}
+	}
+}
+
+func addrEqual(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,169 @@
+package rtpengine
+
+import (
+	log "common/log/newlog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func newTestLeg(t *testing.T, pool *PortPool, cfg LegConfig) *Leg {
+	leg, err := NewLeg("l1", pool, buffer.NewBufferFactory(100, log.GetLogger()), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = leg.Close() })
+	return leg
+}
+
+// dialLeg 模拟终端, 每个连接使用不同的源端口.
+func dialLeg(t *testing.T, leg *Leg) *net.UDPConn {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: leg.LocalPort()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func sendRTP(t *testing.T, conn *net.UDPConn, ssrc uint32, seq uint16) {
+	pkt := rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: seq, Timestamp: uint32(seq) * 160, SSRC: ssrc},
+		Payload: make([]byte, 160),
+	}
+	b, err := pkt.Marshal()
+	require.NoError(t, err)
+	_, err = conn.Write(b)
+	require.NoError(t, err)
+}
+
+func TestLeg_Latching(t *testing.T) {
+	leg := newTestLeg(t, NewPortPool("127.0.0.1", 42510, 42520), LegConfig{Latching: true})
+	latched := make(chan bool, 4)
+	leg.OnLatch(func(addr *net.UDPAddr, relatch bool) { latched <- relatch })
+
+	sdpAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	leg.SetRemote(sdpAddr, nil)
+	assert.Equal(t, sdpAddr.String(), leg.Remote().String())
+	assert.False(t, leg.Latched())
+
+	// 第一个包的源地址作为发送地址.
+	a := dialLeg(t, leg)
+	sendRTP(t, a, 1111, 1)
+	select {
+	case relatch := <-latched:
+		assert.False(t, relatch)
+	case <-time.After(time.Second):
+		t.Fatal("not latched")
+	}
+	assert.True(t, leg.Latched())
+	assert.Equal(t, a.LocalAddr().String(), leg.Remote().String())
+	assert.Eventually(t, func() bool { return leg.Buffer(1111) != nil }, time.Second, 10*time.Millisecond)
+
+	// 锁定后re-invite中的地址不覆盖发送地址.
+	leg.SetRemote(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11}, nil)
+	assert.Equal(t, a.LocalAddr().String(), leg.Remote().String())
+
+	// 发出的包到达锁定的地址.
+	_, err := leg.WriteRTP(&rtp.Header{Version: 2, SequenceNumber: 1, SSRC: 2222}, make([]byte, 160))
+	require.NoError(t, err)
+	buf := make([]byte, maxPktSize)
+	require.NoError(t, a.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := a.Read(buf)
+	require.NoError(t, err)
+	var h rtp.Header
+	_, err = h.Unmarshal(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2222), h.SSRC)
+
+	// 没有开启Relatch时其他地址的包被丢弃.
+	b := dialLeg(t, leg)
+	sendRTP(t, b, 1111, 2)
+	assert.Eventually(t, func() bool { return leg.Dropped() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, a.LocalAddr().String(), leg.Remote().String())
+	assert.Empty(t, latched)
+}
+
+func TestLeg_Relatch(t *testing.T) {
+	leg := newTestLeg(t, NewPortPool("127.0.0.1", 42520, 42530), LegConfig{Latching: true, Relatch: true})
+	var relatched []*net.UDPAddr
+	leg.OnLatch(func(addr *net.UDPAddr, relatch bool) {
+		if relatch {
+			relatched = append(relatched, addr)
+		}
+	})
+	a := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+
+	require.NoError(t, leg.latch(1111, a))
+	require.NoError(t, leg.latch(1111, a))
+	assert.Equal(t, a.String(), leg.Remote().String())
+
+	// 新地址的ssrc必须和锁定时一致.
+	assert.Equal(t, errSSRCChanged, leg.latch(2222, b))
+
+	// 连续收到relatchPackets个包后才切换, 期间仍然接收媒体.
+	for i := 1; i < relatchPackets; i++ {
+		require.NoError(t, leg.latch(1111, b))
+		assert.Equal(t, a.String(), leg.Remote().String())
+	}
+	// 中间夹杂旧地址的包时重新计数.
+	require.NoError(t, leg.latch(1111, a))
+	for i := 1; i < relatchPackets; i++ {
+		require.NoError(t, leg.latch(1111, b))
+	}
+	assert.Equal(t, a.String(), leg.Remote().String())
+	assert.Empty(t, relatched)
+
+	require.NoError(t, leg.latch(1111, b))
+	assert.Equal(t, b.String(), leg.Remote().String())
+	require.Len(t, relatched, 1)
+	assert.Equal(t, b.String(), relatched[0].String())
+}
+
+func TestLeg_StrictSource(t *testing.T) {
+	leg := newTestLeg(t, NewPortPool("127.0.0.1", 42530, 42540), LegConfig{Latching: true, StrictSource: true})
+
+	// 没有SDP地址时不限制.
+	assert.True(t, leg.allowSource(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000}))
+
+	leg.SetRemote(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}, nil)
+	assert.Equal(t, errSourceNotAllow, leg.latch(1111, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}))
+	assert.False(t, leg.Latched())
+
+	// 只比较IP, NAT后端口可以不同.
+	assert.True(t, leg.allowSource(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6000}))
+
+	conn := dialLeg(t, leg)
+	sendRTP(t, conn, 1111, 1)
+	assert.Eventually(t, func() bool { return leg.Dropped() == 1 }, time.Second, 10*time.Millisecond)
+	assert.False(t, leg.Latched())
+	assert.Nil(t, leg.Buffer(1111))
+	assert.Equal(t, "10.0.0.1:4000", leg.Remote().String())
+}
+
+func TestLeg_SSRC(t *testing.T) {
+	leg := newTestLeg(t, NewPortPool("127.0.0.1", 42540, 42550), LegConfig{Latching: true, SSRC: 1111})
+	ssrcs := make(chan uint32, 4)
+	leg.OnNewSSRC(func(ssrc uint32) { ssrcs <- ssrc })
+
+	conn := dialLeg(t, leg)
+	sendRTP(t, conn, 2222, 1)
+	assert.Eventually(t, func() bool { return leg.Dropped() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, leg.Buffer(2222))
+	// 其他ssrc的包也不能用于锁定地址.
+	assert.False(t, leg.Latched())
+
+	sendRTP(t, conn, 1111, 2)
+	select {
+	case ssrc := <-ssrcs:
+		assert.Equal(t, uint32(1111), ssrc)
+	case <-time.After(time.Second):
+		t.Fatal("ssrc not received")
+	}
+	assert.NotNil(t, leg.Buffer(1111))
+	assert.True(t, leg.Latched())
+	assert.Equal(t, uint64(1), leg.Dropped())
+}
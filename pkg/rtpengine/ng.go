@@ -0,0 +1,411 @@
+package rtpengine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rtpengine "ng" 控制协议, kamailio/opensips的rtpengine模块可以直接使用本网关作为媒体中继.
+// 每条消息是 "<cookie> <bencode dict>", 回复带上相同的cookie.
+// https://github.com/sipwise/rtpengine#the-ng-control-protocol
+
+const (
+	ngMaxPacket   = 65535
+	ngCacheTTL    = 30 * time.Second
+	ngDefaultList = 32
+)
+
+var errInvalidNGMessage = errors.New("invalid ng message")
+
+// NGConfig defines the ng control listeners, an empty address disables the listener
+type NGConfig struct {
+	UDPAddr string
+	TCPAddr string
+}
+
+// NGServer serves the rtpengine ng protocol on top of Engine
+type NGServer struct {
+	engine *Engine
+	cfg    NGConfig
+
+	udp *net.UDPConn
+	tcp net.Listener
+
+	// udp重传的请求直接回复缓存的结果, 避免重复offer.
+	cacheMu sync.Mutex
+	cache   map[string]ngCached
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type ngCached struct {
+	resp    []byte
+	created time.Time
+}
+
+// NewNGServer creates a ng protocol server
+func NewNGServer(engine *Engine, cfg NGConfig) *NGServer {
+	return &NGServer{
+		engine: engine,
+		cfg:    cfg,
+		cache:  make(map[string]ngCached),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start starts the udp and tcp listeners
+func (s *NGServer) Start() error {
+	if s.cfg.UDPAddr != "" {
+		addr, err := net.ResolveUDPAddr("udp", s.cfg.UDPAddr)
+		if err != nil {
+			return err
+		}
+		if s.udp, err = net.ListenUDP("udp", addr); err != nil {
+			return err
+		}
+		go s.serveUDP()
+	}
+	if s.cfg.TCPAddr != "" {
+		var err error
+		if s.tcp, err = net.Listen("tcp", s.cfg.TCPAddr); err != nil {
+			return err
+		}
+		go s.serveTCP()
+	}
+	go s.expireCache()
+	Logger.Info("ng control started", "udp", s.cfg.UDPAddr, "tcp", s.cfg.TCPAddr)
+	return nil
+}
+
+// Close stops the listeners, calls are kept
+func (s *NGServer) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.udp != nil {
+			_ = s.udp.Close()
+		}
+		if s.tcp != nil {
+			_ = s.tcp.Close()
+		}
+	})
+	return nil
+}
+
+func (s *NGServer) serveUDP() {
+	buf := make([]byte, ngMaxPacket)
+	for {
+		n, src, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			Logger.Error(err, "ng udp read err")
+			continue
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.handle(msg); resp != nil {
+				_, _ = s.udp.WriteToUDP(resp, src)
+			}
+		}()
+	}
+}
+
+func (s *NGServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			Logger.Error(err, "ng tcp accept err")
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn tcp上消息首尾相接, 按 cookie + 一个完整的bencode dict 切分.
+func (s *NGServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		cookie, err := r.ReadString(' ')
+		if err != nil {
+			if err != io.EOF {
+				Logger.Error(err, "ng tcp read err", "remote", conn.RemoteAddr().String())
+			}
+			return
+		}
+		cookie = strings.TrimSpace(cookie[:len(cookie)-1])
+		v, err := bencodeRead(r)
+		if err != nil {
+			return
+		}
+		resp := s.reply(cookie, s.dispatch(v))
+		if _, err = conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle 处理一个udp消息, 返回回复.
+func (s *NGServer) handle(msg []byte) []byte {
+	idx := bytes.IndexByte(msg, ' ')
+	if idx <= 0 {
+		return nil
+	}
+	cookie := string(msg[:idx])
+
+	s.cacheMu.Lock()
+	cached, ok := s.cache[cookie]
+	s.cacheMu.Unlock()
+	if ok {
+		return cached.resp
+	}
+
+	var result map[string]interface{}
+	v, err := bencodeDecode(msg[idx+1:])
+	if err != nil {
+		result = ngError(errInvalidNGMessage)
+	} else {
+		result = s.dispatch(v)
+	}
+	resp := s.reply(cookie, result)
+
+	s.cacheMu.Lock()
+	s.cache[cookie] = ngCached{resp: resp, created: time.Now()}
+	s.cacheMu.Unlock()
+	return resp
+}
+
+func (s *NGServer) reply(cookie string, result map[string]interface{}) []byte {
+	b, err := bencodeEncode(result)
+	if err != nil {
+		b, _ = bencodeEncode(ngError(err))
+	}
+	return append([]byte(cookie+" "), b...)
+}
+
+func (s *NGServer) expireCache() {
+	ticker := time.NewTicker(ngCacheTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.cacheMu.Lock()
+		for cookie, c := range s.cache {
+			if time.Since(c.created) > ngCacheTTL {
+				delete(s.cache, cookie)
+			}
+		}
+		s.cacheMu.Unlock()
+	}
+}
+
+func (s *NGServer) dispatch(v interface{}) map[string]interface{} {
+	req, ok := v.(map[string]interface{})
+	if !ok {
+		return ngError(errInvalidNGMessage)
+	}
+	command := ngString(req, "command")
+	Logger.Info("ng command", "command", command, "call_id", ngString(req, "call-id"))
+
+	switch command {
+	case "ping":
+		return map[string]interface{}{"result": "pong"}
+	case "offer":
+		sdp, err := s.engine.Offer(ngOfferOptions(req))
+		if err != nil {
+			return ngError(err)
+		}
+		if ngFlag(req, "record call") {
+			_ = s.engine.StartRecording(ngString(req, "call-id"))
+		}
+		return map[string]interface{}{"result": "ok", "sdp": sdp}
+	case "answer":
+		sdp, err := s.engine.Answer(ngOfferOptions(req))
+		if err != nil {
+			return ngError(err)
+		}
+		return map[string]interface{}{"result": "ok", "sdp": sdp}
+	case "delete":
+		if err := s.engine.DeleteCall(ngString(req, "call-id")); err != nil {
+			return ngError(err)
+		}
+		return map[string]interface{}{"result": "ok"}
+	case "query":
+		c := s.engine.Call(ngString(req, "call-id"))
+		if c == nil {
+			return ngError(errCallNotFound)
+		}
+		return ngQuery(c)
+	case "list":
+		return s.list(ngInt(req, "limit", ngDefaultList))
+	case "play media":
+		d, err := s.engine.PlayMedia(ngString(req, "call-id"), ngString(req, "from-tag"), ngString(req, "file"), ngInt(req, "repeat-times", 1))
+		if err != nil {
+			return ngError(err)
+		}
+		return map[string]interface{}{"result": "ok", "duration": int64(d / time.Millisecond)}
+	case "start recording":
+		if err := s.engine.StartRecording(ngString(req, "call-id")); err != nil {
+			return ngError(err)
+		}
+		return map[string]interface{}{"result": "ok"}
+	case "stop recording":
+		if err := s.engine.StopRecording(ngString(req, "call-id")); err != nil {
+			return ngError(err)
+		}
+		return map[string]interface{}{"result": "ok"}
+	}
+	return ngError(errors.New("unknown command: " + command))
+}
+
+func (s *NGServer) list(limit int) map[string]interface{} {
+	calls := s.engine.Calls()
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].Created().Before(calls[j].Created())
+	})
+	ids := make([]string, 0, len(calls))
+	for _, c := range calls {
+		if len(ids) >= limit {
+			break
+		}
+		ids = append(ids, c.ID())
+	}
+	return map[string]interface{}{"result": "ok", "calls": ids}
+}
+
+func ngQuery(c *Call) map[string]interface{} {
+	tags := c.Tags()
+	streams := c.Streams()
+
+	var total LegStats
+	tagDict := make(map[string]interface{})
+	for side, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		medias := make([]interface{}, 0, len(streams))
+		for _, st := range streams {
+			leg := st.Leg(side)
+			stats := leg.Stats()
+			total.Packets += stats.Packets
+			total.Bytes += stats.Bytes
+			total.Dropped += stats.Dropped
+
+			stream := map[string]interface{}{
+				"local port": leg.LocalPort(),
+				"stats": map[string]interface{}{
+					"packets": stats.Packets,
+					"bytes":   stats.Bytes,
+					"errors":  stats.Dropped,
+				},
+			}
+			if remote := leg.Remote(); remote != nil {
+				stream["endpoint"] = map[string]interface{}{
+					"family":  addressType(remote.IP.String()),
+					"address": remote.IP.String(),
+					"port":    remote.Port,
+				}
+			}
+			medias = append(medias, map[string]interface{}{
+				"index":    st.Index() + 1,
+				"type":     st.Kind(),
+				"protocol": st.Protocol(side),
+				"streams":  []interface{}{stream},
+			})
+		}
+		tagDict[tag] = map[string]interface{}{
+			"tag":    tag,
+			"medias": medias,
+		}
+	}
+
+	return map[string]interface{}{
+		"result":  "ok",
+		"created": c.Created().Unix(),
+		"tags":    tagDict,
+		"totals": map[string]interface{}{
+			"RTP": map[string]interface{}{
+				"packets": total.Packets,
+				"bytes":   total.Bytes,
+				"errors":  total.Dropped,
+			},
+		},
+	}
+}
+
+// ngOfferOptions "e2ee"标志和"session"是本网关的扩展参数.
+func ngOfferOptions(req map[string]interface{}) OfferOptions {
+	return OfferOptions{
+		CallID:            ngString(req, "call-id"),
+		FromTag:           ngString(req, "from-tag"),
+		ToTag:             ngString(req, "to-tag"),
+		SDP:               ngString(req, "sdp"),
+		TransportProtocol: ngString(req, "transport-protocol"),
+		MediaAddress:      ngString(req, "media-address"),
+		Asymmetric:        ngFlag(req, "asymmetric"),
+		StrictSource:      ngFlag(req, "strict-source"),
+		E2EE:              ngFlag(req, "e2ee"),
+		Session:           ngString(req, "session"),
+	}
+}
+
+func ngError(err error) map[string]interface{} {
+	return map[string]interface{}{"result": "error", "error-reason": err.Error()}
+}
+
+func ngString(req map[string]interface{}, key string) string {
+	s, _ := req[key].(string)
+	return s
+}
+
+func ngInt(req map[string]interface{}, key string, def int) int {
+	switch v := req[key].(type) {
+	case int64:
+		return int(v)
+	case string:
+		n := 0
+		for _, c := range v {
+			if c < '0' || c > '9' {
+				return def
+			}
+			n = n*10 + int(c-'0')
+		}
+		if v != "" {
+			return n
+		}
+	}
+	return def
+}
+
+// ngFlag 查找 "flags" 列表, 以及 "record call": "yes" 这类单独的key.
+func ngFlag(req map[string]interface{}, flag string) bool {
+	if v, ok := req[flag].(string); ok {
+		return v == "yes" || v == "on" || v == "true"
+	}
+	flags, _ := req["flags"].([]interface{})
+	for _, f := range flags {
+		if s, ok := f.(string); ok && strings.EqualFold(s, flag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package rtpengine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ngRequest 发送一个ng消息, 返回解码后的回复.
+func ngRequest(t *testing.T, s *NGServer, cookie string, req map[string]interface{}) map[string]interface{} {
+	b, err := bencodeEncode(req)
+	require.NoError(t, err)
+	resp := s.handle(append([]byte(cookie+" "), b...))
+	require.True(t, bytes.HasPrefix(resp, []byte(cookie+" ")), string(resp))
+	v, err := bencodeDecode(resp[len(cookie)+1:])
+	require.NoError(t, err)
+	result, ok := v.(map[string]interface{})
+	require.True(t, ok)
+	return result
+}
+
+func TestNGServer(t *testing.T) {
+	e := newTestEngine(t)
+	s := NewNGServer(e, NGConfig{})
+
+	assert.Equal(t, map[string]interface{}{"result": "pong"}, ngRequest(t, s, "c1", map[string]interface{}{"command": "ping"}))
+
+	offer := ngRequest(t, s, "c2", map[string]interface{}{
+		"command": "offer", "call-id": "call-1", "from-tag": "a", "sdp": testOffer,
+		"flags": []interface{}{"asymmetric"},
+	})
+	require.Equal(t, "ok", offer["result"], offer["error-reason"])
+	sdp, _ := offer["sdp"].(string)
+	assert.Contains(t, sdp, "c=IN IP4 127.0.0.1\r\n")
+	assert.NotContains(t, sdp, "m=audio 40000 ")
+
+	answer := ngRequest(t, s, "c3", map[string]interface{}{
+		"command": "answer", "call-id": "call-1", "from-tag": "a", "to-tag": "b",
+		"sdp": strings.Replace(testOffer, "40000", "40010", 1),
+	})
+	require.Equal(t, "ok", answer["result"], answer["error-reason"])
+
+	query := ngRequest(t, s, "c4", map[string]interface{}{"command": "query", "call-id": "call-1"})
+	require.Equal(t, "ok", query["result"])
+	tags, _ := query["tags"].(map[string]interface{})
+	assert.Contains(t, tags, "a")
+	assert.Contains(t, tags, "b")
+
+	list := ngRequest(t, s, "c5", map[string]interface{}{"command": "list"})
+	assert.Equal(t, []interface{}{"call-1"}, list["calls"])
+
+	assert.Equal(t, "ok", ngRequest(t, s, "c6", map[string]interface{}{"command": "delete", "call-id": "call-1"})["result"])
+	assert.Nil(t, e.Call("call-1"))
+	deleted := ngRequest(t, s, "c7", map[string]interface{}{"command": "delete", "call-id": "call-1"})
+	assert.Equal(t, "error", deleted["result"])
+	assert.Equal(t, errCallNotFound.Error(), deleted["error-reason"])
+}
+
+func TestNGServer_Invalid(t *testing.T) {
+	s := NewNGServer(newTestEngine(t), NGConfig{})
+
+	assert.Nil(t, s.handle([]byte("no-cookie")))
+	for cookie, msg := range map[string]string{
+		"c1": "not bencode",
+		"c2": "l4:pinge",
+		"c3": "d7:command7:unknowne",
+	} {
+		resp := s.handle([]byte(cookie + " " + msg))
+		v, err := bencodeDecode(bytes.TrimPrefix(resp, []byte(cookie+" ")))
+		require.NoError(t, err)
+		assert.Equal(t, "error", v.(map[string]interface{})["result"], msg)
+	}
+}
+
+func TestNGServer_Retransmit(t *testing.T) {
+	e := newTestEngine(t)
+	s := NewNGServer(e, NGConfig{})
+
+	// 同一个cookie的重传回复缓存的结果, 不会再分配端口.
+	req := map[string]interface{}{"command": "offer", "call-id": "call-1", "from-tag": "a", "sdp": testOffer}
+	first := ngRequest(t, s, "c1", req)
+	used, _ := e.PortPool().Usage()
+	assert.Equal(t, first, ngRequest(t, s, "c1", req))
+	again, _ := e.PortPool().Usage()
+	assert.Equal(t, used, again)
+}
+
+func TestNGFlag(t *testing.T) {
+	req := map[string]interface{}{
+		"record call": "yes",
+		"e2ee":        "no",
+		"flags":       []interface{}{"Strict-Source", int64(1)},
+		"limit":       "12",
+		"repeat":      int64(3),
+		"bad":         "1x",
+	}
+	assert.True(t, ngFlag(req, "record call"))
+	assert.False(t, ngFlag(req, "e2ee"))
+	assert.True(t, ngFlag(req, "strict-source"))
+	assert.False(t, ngFlag(req, "asymmetric"))
+	assert.Equal(t, 12, ngInt(req, "limit", 0))
+	assert.Equal(t, 3, ngInt(req, "repeat", 0))
+	assert.Equal(t, 5, ngInt(req, "bad", 5))
+	assert.Equal(t, 5, ngInt(req, "missing", 5))
+}
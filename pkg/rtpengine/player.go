@@ -0,0 +1,212 @@
+package rtpengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// 20ms一帧, 8000Hz.
+	playPtime       = 20 * time.Millisecond
+	playFrameSize   = 160
+	playSampleRate  = 8000
+	wavFormatPCM    = 1
+	wavFormatALaw   = 6
+	wavFormatMuLaw  = 7
+	payloadTypePCMU = 0
+	payloadTypePCMA = 8
+)
+
+var (
+	errInvalidWav       = errors.New("invalid wav file")
+	errUnsupportedWav   = errors.New("wav must be 8000Hz mono pcm16, alaw or mulaw")
+	errUnsupportedCodec = errors.New("no PCMU or PCMA codec negotiated")
+)
+
+// player 向Leg发送wav文件, 放音期间对端转发过来的媒体被丢弃.
+type player struct {
+	leg    *Leg
+	pt     uint8
+	frames [][]byte
+	repeat int
+	ssrc   uint32
+	active atomicBool
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newPlayer(leg *Leg, m *sdpMedia, file string, repeat int) (*player, error) {
+	pt := -1
+	for _, c := range m.codecs {
+		if c.payloadType == payloadTypePCMU || c.payloadType == payloadTypePCMA {
+			pt = int(c.payloadType)
+			break
+		}
+	}
+	if pt < 0 {
+		return nil, errUnsupportedCodec
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := decodeWav(data, uint8(pt))
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([][]byte, 0, len(samples)/playFrameSize+1)
+	for i := 0; i < len(samples); i += playFrameSize {
+		frame := make([]byte, playFrameSize)
+		// 最后一帧用静音补齐.
+		silence := linearToUlaw(0)
+		if pt == payloadTypePCMA {
+			silence = linearToAlaw(0)
+		}
+		for j := range frame {
+			frame[j] = silence
+		}
+		copy(frame, samples[i:])
+		frames = append(frames, frame)
+	}
+	if repeat < 1 {
+		repeat = 1
+	}
+	return &player{
+		leg:    leg,
+		pt:     uint8(pt),
+		frames: frames,
+		repeat: repeat,
+		ssrc:   rand.Uint32(),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (p *player) start() {
+	p.active.set(true)
+	go p.run()
+}
+
+func (p *player) run() {
+	defer p.active.set(false)
+
+	ticker := time.NewTicker(playPtime)
+	defer ticker.Stop()
+
+	sn := uint16(rand.Uint32())
+	ts := rand.Uint32()
+	marker := true
+	for r := 0; r < p.repeat; r++ {
+		for _, frame := range p.frames {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+			h := &rtp.Header{
+				Version:        2,
+				Marker:         marker,
+				PayloadType:    p.pt,
+				SequenceNumber: sn,
+				Timestamp:      ts,
+				SSRC:           p.ssrc,
+			}
+			if _, err := p.leg.WriteRTP(h, frame); err != nil && p.leg.closed.get() {
+				return
+			}
+			marker = false
+			sn++
+			ts += playFrameSize
+		}
+	}
+}
+
+func (p *player) playing() bool {
+	return p.active.get()
+}
+
+func (p *player) duration() time.Duration {
+	return time.Duration(len(p.frames)*p.repeat) * playPtime
+}
+
+func (p *player) stop() {
+	p.once.Do(func() {
+		close(p.done)
+	})
+}
+
+// decodeWav 把wav的data块转成pt对应的G.711字节流.
+func decodeWav(data []byte, pt uint8) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errInvalidWav
+	}
+
+	var (
+		format, channels, bits uint16
+		rate                   uint32
+		body                   []byte
+	)
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if size < 0 || pos+size > len(data) {
+			size = len(data) - pos
+		}
+		chunk := data[pos : pos+size]
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, errInvalidWav
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = binary.LittleEndian.Uint16(chunk[2:4])
+			rate = binary.LittleEndian.Uint32(chunk[4:8])
+			bits = binary.LittleEndian.Uint16(chunk[14:16])
+		case "data":
+			body = chunk
+		}
+		pos += size + size%2
+	}
+	if body == nil || channels != 1 || rate != playSampleRate {
+		return nil, errUnsupportedWav
+	}
+
+	switch {
+	case format == wavFormatMuLaw && pt == payloadTypePCMU, format == wavFormatALaw && pt == payloadTypePCMA:
+		return body, nil
+	case format == wavFormatMuLaw || format == wavFormatALaw:
+		out := make([]byte, len(body))
+		for i, b := range body {
+			var s int16
+			if format == wavFormatMuLaw {
+				s = ulawToLinear(b)
+			} else {
+				s = alawToLinear(b)
+			}
+			out[i] = encodeG711(s, pt)
+		}
+		return out, nil
+	case format == wavFormatPCM && bits == 16:
+		out := make([]byte, len(body)/2)
+		for i := range out {
+			out[i] = encodeG711(int16(binary.LittleEndian.Uint16(body[i*2:])), pt)
+		}
+		return out, nil
+	}
+	return nil, errUnsupportedWav
+}
+
+func encodeG711(s int16, pt uint8) byte {
+	if pt == payloadTypePCMA {
+		return linearToAlaw(s)
+	}
+	return linearToUlaw(s)
+}
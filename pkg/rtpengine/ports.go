@@ -0,0 +1,94 @@
+package rtpengine
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// 和webrtc的sfu端口段保持一致.
+	defaultMinPort = 46884
+	defaultMaxPort = 60999
+)
+
+var errNoPortAvailable = errors.New("no rtp port available")
+
+// PortPool 分配rtp/rtcp端口对, rtp使用偶数端口, rtcp使用rtp+1.
+type PortPool struct {
+	// 所有leg收发的rtp字节数, 用于上报节点带宽.
+	rxBytes uint64
+	txBytes uint64
+
+	sync.Mutex
+	ip   net.IP
+	min  int
+	max  int
+	next int
+	used map[int]bool
+}
+
+// NewPortPool creates a pool of rtp ports in [min, max] bound to ip.
+func NewPortPool(ip string, min, max int) *PortPool {
+	if min <= 0 || max <= min {
+		min, max = defaultMinPort, defaultMaxPort
+	}
+	if min%2 != 0 {
+		min++
+	}
+	return &PortPool{
+		ip:   net.ParseIP(ip),
+		min:  min,
+		max:  max,
+		next: min,
+		used: make(map[int]bool),
+	}
+}
+
+// Allocate binds a rtp/rtcp socket pair, ports that are busy on the host are skipped.
+func (p *PortPool) Allocate() (rtpConn, rtcpConn *net.UDPConn, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	total := (p.max - p.min + 1) / 2
+	for i := 0; i < total; i++ {
+		port := p.next
+		p.next += 2
+		if p.next+1 > p.max {
+			p.next = p.min
+		}
+		if p.used[port] {
+			continue
+		}
+		if rtpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: p.ip, Port: port}); err != nil {
+			continue
+		}
+		if rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: p.ip, Port: port + 1}); err != nil {
+			_ = rtpConn.Close()
+			continue
+		}
+		p.used[port] = true
+		return rtpConn, rtcpConn, nil
+	}
+	return nil, nil, errNoPortAvailable
+}
+
+// Release returns the rtp port to the pool
+func (p *PortPool) Release(port int) {
+	p.Lock()
+	delete(p.used, port)
+	p.Unlock()
+}
+
+// Usage returns the number of allocated port pairs and the pool size
+func (p *PortPool) Usage() (used, total int) {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.used), (p.max - p.min + 1) / 2
+}
+
+// Traffic returns the rtp bytes received and sent by all legs of the pool
+func (p *PortPool) Traffic() (rx, tx uint64) {
+	return atomic.LoadUint64(&p.rxBytes), atomic.LoadUint64(&p.txBytes)
+}
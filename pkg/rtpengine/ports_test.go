@@ -0,0 +1,93 @@
+package rtpengine
+
+import (
+	log "common/log/newlog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func TestPortPool(t *testing.T) {
+	pool := NewPortPool("127.0.0.1", 42550, 42555)
+	used, total := pool.Usage()
+	assert.Equal(t, 0, used)
+	assert.Equal(t, 3, total)
+
+	var conns [][2]*net.UDPConn
+	for i := 0; i < total; i++ {
+		rtpConn, rtcpConn, err := pool.Allocate()
+		require.NoError(t, err)
+		port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		assert.Equal(t, 42550+2*i, port)
+		assert.Equal(t, port+1, rtcpConn.LocalAddr().(*net.UDPAddr).Port)
+		conns = append(conns, [2]*net.UDPConn{rtpConn, rtcpConn})
+	}
+	defer func() {
+		for _, c := range conns {
+			_ = c[0].Close()
+			_ = c[1].Close()
+		}
+	}()
+
+	_, _, err := pool.Allocate()
+	assert.Equal(t, errNoPortAvailable, err)
+	used, _ = pool.Usage()
+	assert.Equal(t, 3, used)
+
+	// 释放后端口可以再次分配.
+	_ = conns[1][0].Close()
+	_ = conns[1][1].Close()
+	pool.Release(42552)
+	used, _ = pool.Usage()
+	assert.Equal(t, 2, used)
+	rtpConn, rtcpConn, err := pool.Allocate()
+	require.NoError(t, err)
+	conns[1] = [2]*net.UDPConn{rtpConn, rtcpConn}
+	assert.Equal(t, 42552, rtpConn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestPortPool_Busy(t *testing.T) {
+	// 奇数起始端口调整为偶数.
+	pool := NewPortPool("127.0.0.1", 42557, 42561)
+	_, total := pool.Usage()
+	assert.Equal(t, 2, total)
+
+	// 被其他进程占用的端口跳过.
+	busy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 42559})
+	require.NoError(t, err)
+	defer busy.Close()
+
+	rtpConn, rtcpConn, err := pool.Allocate()
+	require.NoError(t, err)
+	defer rtpConn.Close()
+	defer rtcpConn.Close()
+	assert.Equal(t, 42560, rtpConn.LocalAddr().(*net.UDPAddr).Port)
+
+	_, _, err = pool.Allocate()
+	assert.Equal(t, errNoPortAvailable, err)
+}
+
+func TestLeg_ReleasePort(t *testing.T) {
+	pool := NewPortPool("127.0.0.1", 42562, 42565)
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	l1, err := NewLeg("l1", pool, bf, LegConfig{})
+	require.NoError(t, err)
+	l2, err := NewLeg("l2", pool, bf, LegConfig{})
+	require.NoError(t, err)
+	defer l2.Close()
+	_, err = NewLeg("l3", pool, bf, LegConfig{})
+	assert.Equal(t, errNoPortAvailable, err)
+
+	// 关闭leg归还端口.
+	require.NoError(t, l1.Close())
+	used, _ := pool.Usage()
+	assert.Equal(t, 1, used)
+	l3, err := NewLeg("l3", pool, bf, LegConfig{})
+	require.NoError(t, err)
+	defer l3.Close()
+	assert.Equal(t, l1.LocalPort(), l3.LocalPort())
+}
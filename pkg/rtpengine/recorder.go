@@ -0,0 +1,119 @@
+package rtpengine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// recorder 按rtpdump格式(rtpplay 1.0)保存转发的rtp, 每个m=段每个方向一个文件,
+// wireshark和rtpplay都可以直接打开.
+type recorder struct {
+	sync.Mutex
+	dir    string
+	callID string
+	start  time.Time
+	files  map[int]*recordFile
+}
+
+type recordFile struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newRecorder(dir, callID string) (*recorder, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &recorder{
+		dir:    dir,
+		callID: callID,
+		start:  time.Now(),
+		files:  make(map[int]*recordFile),
+	}, nil
+}
+
+func (r *recorder) write(index, side int, p *rtp.Packet) {
+	b, err := p.Marshal()
+	if err != nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.files == nil {
+		return
+	}
+	key := index*2 + side
+	rf, ok := r.files[key]
+	if !ok {
+		if rf, err = r.create(index, side); err != nil {
+			Logger.Error(err, "create recording file failed", "call_id", r.callID)
+			// 不再重试.
+			r.files[key] = nil
+			return
+		}
+		r.files[key] = rf
+	}
+	if rf == nil {
+		return
+	}
+
+	// RD_packet_t: length(含8字节头), plen, offset(ms).
+	var hdr [8]byte
+	binary.BigEndian.PutUint16(hdr[0:2], uint16(len(b)+8))
+	binary.BigEndian.PutUint16(hdr[2:4], uint16(len(b)))
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(time.Since(r.start)/time.Millisecond))
+	_, _ = rf.w.Write(hdr[:])
+	_, _ = rf.w.Write(b)
+}
+
+func (r *recorder) create(index, side int) (*recordFile, error) {
+	name := fmt.Sprintf("%s-%d-%d.rtpdump", sanitizeFileName(r.callID), index, side)
+	f, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	_, _ = w.WriteString("#!rtpplay1.0 0.0.0.0/0\n")
+
+	// RD_hdr_t: start sec, start usec, source, port, padding.
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(r.start.Unix()))
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(r.start.Nanosecond()/1000))
+	_, _ = w.Write(hdr[:])
+	return &recordFile{f: f, w: w}, nil
+}
+
+func (r *recorder) close() {
+	r.Lock()
+	defer r.Unlock()
+	for _, rf := range r.files {
+		if rf == nil {
+			continue
+		}
+		_ = rf.w.Flush()
+		_ = rf.f.Close()
+	}
+	r.files = nil
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '@':
+			return c
+		}
+		return '_'
+	}, s)
+}
@@ -1,5 +1,32 @@
 package rtpengine
 
+import (
+	log "common/log/newlog"
+	"sync/atomic"
+)
+
+// 纯rtp媒体的收发: 端口池、对称rtp锁定、媒体超时检测、SDES/DTLS-SRTP,
+// 以及兼容rtpengine ng协议的呼叫媒体中继(Engine + NGServer).
+
+var (
+	// Logger is an implementation of log.Logger. If is not provided - will be turned off.
+	Logger log.Logger = log.GetLogger()
+)
+
+type atomicBool int32
+
+func (a *atomicBool) set(value bool) {
+	var i int32
+	if value {
+		i = 1
+	}
+	atomic.StoreInt32((*int32)(a), i)
+}
+
+func (a *atomicBool) get() bool {
+	return atomic.LoadInt32((*int32)(a)) != 0
+}
+
 // webrtc\src\webrtc\api\peerconnectioninterface.h
 //
 // disable_encryption = true 取消SRTP
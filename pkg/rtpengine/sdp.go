@@ -0,0 +1,300 @@
+package rtpengine
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+var (
+	errInvalidSDP   = errors.New("invalid sdp")
+	errNoConnection = errors.New("sdp has no connection address")
+)
+
+// 静态payload type, rfc3551.
+var staticCodecs = map[uint8]sdpCodec{
+	0:  {payloadType: 0, name: "PCMU", clockRate: 8000},
+	3:  {payloadType: 3, name: "GSM", clockRate: 8000},
+	4:  {payloadType: 4, name: "G723", clockRate: 8000},
+	8:  {payloadType: 8, name: "PCMA", clockRate: 8000},
+	9:  {payloadType: 9, name: "G722", clockRate: 8000},
+	13: {payloadType: 13, name: "CN", clockRate: 8000},
+	18: {payloadType: 18, name: "G729", clockRate: 8000},
+}
+
+// 媒体由本机转发后, 这些属性不再有意义; 对方使用ice时由本端重新生成ice属性.
+var strippedAttributes = map[string]bool{
+	"ice-ufrag":         true,
+	"ice-pwd":           true,
+	"ice-options":       true,
+	"ice-lite":          true,
+	"candidate":         true,
+	"end-of-candidates": true,
+	"remote-candidates": true,
+	"crypto":            true,
+	"fingerprint":       true,
+	"setup":             true,
+	"rtcp":              true,
+	"rtcp-mux":          true,
+}
+
+type sdpCodec struct {
+	payloadType uint8
+	name        string
+	clockRate   uint32
+	channels    string
+	fmtp        string
+}
+
+// sdpMedia 从一个m=段中取出的媒体信息.
+type sdpMedia struct {
+	index       int
+	kind        string
+	addr        *net.UDPAddr
+	rtcpAddr    *net.UDPAddr
+	proto       string
+	rtcpMux     bool
+	crypto      []string
+	fingerprint string
+	setup       string
+	iceUfrag    string
+	codecs      []sdpCodec
+}
+
+// localMedia 写回SDP的本端参数.
+type localMedia struct {
+	ip          string
+	port        int
+	proto       string
+	rtcpMux     bool
+	crypto      []*CryptoAttribute
+	fingerprint string
+	setup       string
+	ice         *ICECredentials
+}
+
+func parseSDP(raw string) (*sdp.SessionDescription, []*sdpMedia, error) {
+	sd := &sdp.SessionDescription{}
+	if err := sd.Unmarshal([]byte(raw)); err != nil {
+		return nil, nil, errInvalidSDP
+	}
+
+	sessionIP := ""
+	if sd.ConnectionInformation != nil && sd.ConnectionInformation.Address != nil {
+		sessionIP = sd.ConnectionInformation.Address.Address
+	}
+	sessionFingerprint, _ := sd.Attribute("fingerprint")
+	sessionSetup, _ := sd.Attribute("setup")
+	sessionUfrag, _ := sd.Attribute("ice-ufrag")
+
+	medias := make([]*sdpMedia, 0, len(sd.MediaDescriptions))
+	for i, md := range sd.MediaDescriptions {
+		m := &sdpMedia{
+			index:       i,
+			kind:        md.MediaName.Media,
+			proto:       strings.Join(md.MediaName.Protos, "/"),
+			fingerprint: sessionFingerprint,
+			setup:       sessionSetup,
+			iceUfrag:    sessionUfrag,
+		}
+		ip := sessionIP
+		if md.ConnectionInformation != nil && md.ConnectionInformation.Address != nil {
+			ip = md.ConnectionInformation.Address.Address
+		}
+		if ip == "" {
+			return nil, nil, errNoConnection
+		}
+		// c=IN IP4 0.0.0.0 或端口0表示hold/拒绝, 地址保持nil.
+		if parsed := net.ParseIP(ip); parsed != nil && !parsed.IsUnspecified() && md.MediaName.Port.Value > 0 {
+			m.addr = &net.UDPAddr{IP: parsed, Port: md.MediaName.Port.Value}
+		}
+
+		codecs := make(map[uint8]int)
+		for _, f := range md.MediaName.Formats {
+			pt, err := strconv.ParseUint(f, 10, 8)
+			if err != nil {
+				continue
+			}
+			c := staticCodecs[uint8(pt)]
+			c.payloadType = uint8(pt)
+			m.codecs = append(m.codecs, c)
+			codecs[uint8(pt)] = len(m.codecs) - 1
+		}
+
+		for _, a := range md.Attributes {
+			switch a.Key {
+			case "rtpmap":
+				// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+				fields := strings.Fields(a.Value)
+				if len(fields) != 2 {
+					continue
+				}
+				pt, err := strconv.ParseUint(fields[0], 10, 8)
+				if err != nil {
+					continue
+				}
+				idx, ok := codecs[uint8(pt)]
+				if !ok {
+					continue
+				}
+				c := &m.codecs[idx]
+				parts := strings.Split(fields[1], "/")
+				c.name = parts[0]
+				if len(parts) > 1 {
+					rate, _ := strconv.ParseUint(parts[1], 10, 32)
+					c.clockRate = uint32(rate)
+				}
+				if len(parts) > 2 {
+					c.channels = parts[2]
+				}
+			case "fmtp":
+				// a=fmtp:<payload type> <format specific parameters>
+				fields := strings.SplitN(a.Value, " ", 2)
+				if len(fields) != 2 {
+					continue
+				}
+				pt, err := strconv.ParseUint(fields[0], 10, 8)
+				if err != nil {
+					continue
+				}
+				if idx, ok := codecs[uint8(pt)]; ok {
+					m.codecs[idx].fmtp = strings.TrimSpace(fields[1])
+				}
+			case "rtcp":
+				// a=rtcp:<port> [IN IP4 <addr>]
+				fields := strings.Fields(a.Value)
+				if len(fields) == 0 || m.addr == nil {
+					continue
+				}
+				port, err := strconv.Atoi(fields[0])
+				if err != nil {
+					continue
+				}
+				rtcpIP := m.addr.IP
+				if len(fields) == 4 {
+					if parsed := net.ParseIP(fields[3]); parsed != nil {
+						rtcpIP = parsed
+					}
+				}
+				m.rtcpAddr = &net.UDPAddr{IP: rtcpIP, Port: port}
+			case "rtcp-mux":
+				m.rtcpMux = true
+			case "crypto":
+				m.crypto = append(m.crypto, a.Value)
+			case "fingerprint":
+				m.fingerprint = a.Value
+			case "setup":
+				m.setup = a.Value
+			case "ice-ufrag":
+				m.iceUfrag = a.Value
+			}
+		}
+		if m.rtcpMux && m.addr != nil {
+			m.rtcpAddr = m.addr
+		}
+		medias = append(medias, m)
+	}
+	return sd, medias, nil
+}
+
+// rewriteSession 把地址改成本机地址, 去掉ice和加密相关的session级属性,
+// 有m=段使用ice时本端是ice-lite.
+func rewriteSession(sd *sdp.SessionDescription, ip string) {
+	addrType := addressType(ip)
+	sd.Origin.AddressType = addrType
+	sd.Origin.UnicastAddress = ip
+	sd.Origin.SessionVersion++
+	if sd.ConnectionInformation != nil {
+		sd.ConnectionInformation = &sdp.ConnectionInformation{
+			NetworkType: "IN",
+			AddressType: addrType,
+			Address:     &sdp.Address{Address: ip},
+		}
+	}
+	sd.Attributes = stripAttributes(sd.Attributes)
+	for _, md := range sd.MediaDescriptions {
+		if _, ok := md.Attribute("ice-ufrag"); ok {
+			sd.Attributes = append(sd.Attributes, sdp.NewPropertyAttribute("ice-lite"))
+			break
+		}
+	}
+}
+
+// rewriteMedia 把m=段改成本端的端口、传输协议和加密参数.
+func rewriteMedia(md *sdp.MediaDescription, local localMedia) {
+	md.MediaName.Port = sdp.RangedPort{Value: local.port}
+	md.MediaName.Protos = strings.Split(local.proto, "/")
+	md.ConnectionInformation = &sdp.ConnectionInformation{
+		NetworkType: "IN",
+		AddressType: addressType(local.ip),
+		Address:     &sdp.Address{Address: local.ip},
+	}
+
+	attrs := stripAttributes(md.Attributes)
+	if local.port == 0 {
+		md.Attributes = attrs
+		return
+	}
+	if local.rtcpMux {
+		attrs = append(attrs, sdp.NewPropertyAttribute("rtcp-mux"))
+	} else {
+		attrs = append(attrs, sdp.NewAttribute("rtcp", strconv.Itoa(local.port+1)))
+	}
+	for _, c := range local.crypto {
+		attrs = append(attrs, sdp.NewAttribute("crypto", c.String()))
+	}
+	if local.fingerprint != "" {
+		attrs = append(attrs, sdp.NewAttribute("fingerprint", local.fingerprint))
+		attrs = append(attrs, sdp.NewAttribute("setup", local.setup))
+	}
+	if local.ice != nil {
+		attrs = append(attrs, iceAttributes(local)...)
+	}
+	md.Attributes = attrs
+}
+
+func stripAttributes(attrs []sdp.Attribute) []sdp.Attribute {
+	out := make([]sdp.Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		if strippedAttributes[a.Key] {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func addressType(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "IP6"
+	}
+	return "IP4"
+}
+
+// codec 按payload type查找, 找不到时返回第一个.
+func (m *sdpMedia) codec(pt uint8) (sdpCodec, bool) {
+	for _, c := range m.codecs {
+		if c.payloadType == pt && c.name != "" {
+			return c, true
+		}
+	}
+	for _, c := range m.codecs {
+		if c.name != "" {
+			return c, true
+		}
+	}
+	return sdpCodec{}, false
+}
+
+// dtmfPayloadType 返回telephone-event的payload type, 没有时返回-1.
+func (m *sdpMedia) dtmfPayloadType() int {
+	for _, c := range m.codecs {
+		if strings.EqualFold(c.name, "telephone-event") && (c.clockRate == 0 || c.clockRate == 8000) {
+			return int(c.payloadType)
+		}
+	}
+	return -1
+}
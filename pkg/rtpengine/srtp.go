@@ -0,0 +1,448 @@
+package rtpengine
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+	"github.com/pion/srtp/v2"
+)
+
+// 加密方式, 对应SDP中的传输协议:
+// RTP/AVP -> SRTPNone, RTP/SAVP -> SRTPSDES, UDP/TLS/RTP/SAVP -> SRTPDTLS.
+// Leg内部收到的包解密后写入Buffer, 发送时加密, 所以SRTP<->SRTP、SRTP<->RTP、WebRTC<->SRTP
+// 之间的桥接都只是明文rtp在sfu内部转发.
+type SRTPMode int
+
+const (
+	SRTPNone SRTPMode = iota
+	SRTPSDES
+	SRTPDTLS
+)
+
+const dtlsHandshakeTimeout = 10 * time.Second
+
+var (
+	errFingerprintMismatch = errors.New("dtls fingerprint mismatch")
+	errNoCertificate       = errors.New("dtls remote certificate missing")
+)
+
+// TransportProto returns the SDP transport protocol for mode
+func (m SRTPMode) TransportProto() string {
+	switch m {
+	case SRTPSDES:
+		return "RTP/SAVP"
+	case SRTPDTLS:
+		return "UDP/TLS/RTP/SAVP"
+	}
+	return "RTP/AVP"
+}
+
+// SRTPModeFromProto maps a SDP transport protocol to the mode, RTP/SAVPF and DTLS/SAVPF included.
+func SRTPModeFromProto(proto string) SRTPMode {
+	proto = strings.ToUpper(proto)
+	switch {
+	case strings.Contains(proto, "TLS"):
+		return SRTPDTLS
+	case strings.Contains(proto, "SAVP"):
+		return SRTPSDES
+	}
+	return SRTPNone
+}
+
+// DTLSConfig defines the DTLS-SRTP parameters negotiated in SDP
+type DTLSConfig struct {
+	Certificate tls.Certificate
+	// Client 对应 a=setup:active.
+	Client bool
+	// RemoteFingerprint a=fingerprint的值, 例如 "sha-256 AB:CD:...".
+	RemoteFingerprint string
+}
+
+// NewDTLSCertificate generates a self signed certificate and returns the a=fingerprint value
+func NewDTLSCertificate() (tls.Certificate, string, error) {
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	fp, err := fingerprint.Fingerprint(x509Cert, crypto.SHA256)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	return cert, "sha-256 " + strings.ToUpper(fp), nil
+}
+
+// srtpSession 保存一个Leg的加解密上下文, srtp.Context不是并发安全的.
+// pion/srtp不支持MKI, 发送时在认证标签前插入本端的MKI, 接收时校验并去掉对端的MKI.
+type srtpSession struct {
+	sync.Mutex
+	local     *srtp.Context
+	remote    *srtp.Context
+	localMKI  []byte
+	remoteMKI []byte
+	rtpTag    int // MKI之后的认证标签长度, AEAD的MKI在包尾
+	rtcpTag   int
+}
+
+func newSRTPSession(profile srtp.ProtectionProfile, keys srtp.SessionKeys, localMKI, remoteMKI []byte) (*srtpSession, error) {
+	local, err := srtp.CreateContext(keys.LocalMasterKey, keys.LocalMasterSalt, profile)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := srtp.CreateContext(keys.RemoteMasterKey, keys.RemoteMasterSalt, profile, srtp.SRTPReplayProtection(64), srtp.SRTCPReplayProtection(64))
+	if err != nil {
+		return nil, err
+	}
+	s := &srtpSession{local: local, remote: remote, localMKI: localMKI, remoteMKI: remoteMKI}
+	switch profile {
+	case srtp.ProtectionProfileAes128CmHmacSha1_80:
+		s.rtpTag, s.rtcpTag = 10, 10
+	case srtp.ProtectionProfileAes128CmHmacSha1_32:
+		// SRTCP的认证标签总是80位.
+		s.rtpTag, s.rtcpTag = 4, 10
+	}
+	return s, nil
+}
+
+func (s *srtpSession) encryptRTP(pkt []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := s.local.EncryptRTP(nil, pkt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return insertMKI(b, s.localMKI, s.rtpTag), nil
+}
+
+func (s *srtpSession) decryptRTP(pkt []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := stripMKI(pkt, s.remoteMKI, s.rtpTag)
+	if err != nil {
+		return nil, err
+	}
+	return s.remote.DecryptRTP(nil, b, nil)
+}
+
+func (s *srtpSession) encryptRTCP(pkt []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := s.local.EncryptRTCP(nil, pkt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return insertMKI(b, s.localMKI, s.rtcpTag), nil
+}
+
+func (s *srtpSession) decryptRTCP(pkt []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := stripMKI(pkt, s.remoteMKI, s.rtcpTag)
+	if err != nil {
+		return nil, err
+	}
+	return s.remote.DecryptRTCP(nil, b, nil)
+}
+
+// insertMKI 把mki插入到最后tagLen字节之前.
+func insertMKI(pkt, mki []byte, tagLen int) []byte {
+	if len(mki) == 0 {
+		return pkt
+	}
+	out := make([]byte, 0, len(pkt)+len(mki))
+	out = append(out, pkt[:len(pkt)-tagLen]...)
+	out = append(out, mki...)
+	return append(out, pkt[len(pkt)-tagLen:]...)
+}
+
+// stripMKI 去掉最后tagLen字节之前的mki, mki不一致时返回错误.
+func stripMKI(pkt, mki []byte, tagLen int) ([]byte, error) {
+	if len(mki) == 0 {
+		return pkt, nil
+	}
+	end := len(pkt) - tagLen
+	start := end - len(mki)
+	if start < 0 || !bytes.Equal(pkt[start:end], mki) {
+		return nil, errInvalidMKI
+	}
+	out := make([]byte, 0, len(pkt)-len(mki))
+	out = append(out, pkt[:start]...)
+	return append(out, pkt[end:]...), nil
+}
+
+// SetSDES enables RTP/SAVP with keys exchanged in a=crypto, local encrypts sent packets, remote decrypts received ones.
+func (l *Leg) SetSDES(local, remote *CryptoAttribute) error {
+	if local == nil || remote == nil {
+		return errSRTPNotNegotiated
+	}
+	profile, err := local.Profile()
+	if err != nil {
+		return err
+	}
+	if remote.Suite != local.Suite {
+		return errUnsupportedSuite
+	}
+	s, err := newSRTPSession(profile, srtp.SessionKeys{
+		LocalMasterKey:   local.Key,
+		LocalMasterSalt:  local.Salt,
+		RemoteMasterKey:  remote.Key,
+		RemoteMasterSalt: remote.Salt,
+	}, local.MKI, remote.MKI)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	l.mode = SRTPSDES
+	l.srtp = s
+	l.Unlock()
+	return nil
+}
+
+// StartDTLS starts the DTLS-SRTP handshake on the rtp port, rtcp-mux is required.
+// Media is dropped until the handshake completes.
+func (l *Leg) StartDTLS(cfg DTLSConfig) error {
+	l.Lock()
+	if l.dtlsConn != nil {
+		l.Unlock()
+		return nil
+	}
+	l.mode = SRTPDTLS
+	// dtls-srtp 强制rtcp-mux.
+	l.rtcpMux = true
+	l.dtlsConn = newDTLSConn(l)
+	conn := l.dtlsConn
+	l.Unlock()
+
+	go func() {
+		if err := l.handshake(conn, cfg); err != nil {
+			Logger.Error(err, "dtls handshake failed", "leg_id", l.id)
+			_ = l.Close()
+		}
+	}()
+	return nil
+}
+
+func (l *Leg) handshake(conn *dtlsConn, cfg DTLSConfig) error {
+	config := &dtls.Config{
+		Certificates: []tls.Certificate{cfg.Certificate},
+		SRTPProtectionProfiles: []dtls.SRTPProtectionProfile{
+			dtls.SRTP_AEAD_AES_128_GCM,
+			dtls.SRTP_AES128_CM_HMAC_SHA1_80,
+			dtls.SRTP_AES128_CM_HMAC_SHA1_32,
+		},
+		ClientAuth:           dtls.RequireAnyClientCert,
+		InsecureSkipVerify:   true,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyFingerprint(rawCerts, cfg.RemoteFingerprint)
+		},
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), dtlsHandshakeTimeout)
+		},
+	}
+
+	var (
+		dc  *dtls.Conn
+		err error
+	)
+	if cfg.Client {
+		dc, err = dtls.Client(conn, config)
+	} else {
+		dc, err = dtls.Server(conn, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	var profile srtp.ProtectionProfile
+	selected, ok := dc.SelectedSRTPProtectionProfile()
+	if !ok {
+		return errSRTPNotNegotiated
+	}
+	switch selected {
+	case dtls.SRTP_AEAD_AES_128_GCM:
+		profile = srtp.ProtectionProfileAeadAes128Gcm
+	case dtls.SRTP_AES128_CM_HMAC_SHA1_80:
+		profile = srtp.ProtectionProfileAes128CmHmacSha1_80
+	case dtls.SRTP_AES128_CM_HMAC_SHA1_32:
+		profile = srtp.ProtectionProfileAes128CmHmacSha1_32
+	default:
+		return errUnsupportedSuite
+	}
+
+	srtpConfig := &srtp.Config{Profile: profile}
+	state := dc.ConnectionState()
+	if err = srtpConfig.ExtractSessionKeysFromDTLS(&state, cfg.Client); err != nil {
+		return err
+	}
+	s, err := newSRTPSession(profile, srtpConfig.Keys, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	if l.closed.get() {
+		l.Unlock()
+		return dc.Close()
+	}
+	l.srtp = s
+	l.dtls = dc
+	l.Unlock()
+	Logger.Info("dtls-srtp established", "leg_id", l.id, "profile", uint16(selected))
+	return nil
+}
+
+// ExportKeyingMaterial exports keying material from the DTLS association, RFC 5705
+func (l *Leg) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	l.RLock()
+	dc := l.dtls
+	l.RUnlock()
+	if dc == nil {
+		return nil, errSRTPNotNegotiated
+	}
+	state := dc.ConnectionState()
+	return state.ExportKeyingMaterial(label, context, length)
+}
+
+func verifyFingerprint(rawCerts [][]byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	if len(rawCerts) == 0 {
+		return errNoCertificate
+	}
+	parts := strings.Fields(expected)
+	if len(parts) != 2 {
+		return errFingerprintMismatch
+	}
+	hash, err := fingerprint.HashFromString(parts[0])
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprint.Fingerprint(cert, hash)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fp, parts[1]) {
+		return errFingerprintMismatch
+	}
+	return nil
+}
+
+// dtlsConn 把rtp端口上收到的dtls包转给dtls握手, 实现net.Conn.
+// 发往最近一次收到dtls包的地址, 没有收到时发往leg的发送地址(锁定的地址或SDP中的地址).
+type dtlsConn struct {
+	leg    *Leg
+	ch     chan []byte
+	done   chan struct{}
+	once   sync.Once
+	dlMu   sync.Mutex
+	readDL time.Time
+	addr   atomic.Value // *net.UDPAddr
+}
+
+func newDTLSConn(l *Leg) *dtlsConn {
+	return &dtlsConn{
+		leg:  l,
+		ch:   make(chan []byte, 16),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *dtlsConn) push(pkt []byte, src *net.UDPAddr) {
+	addr := *src
+	c.addr.Store(&addr)
+	b := append([]byte(nil), pkt...)
+	select {
+	case c.ch <- b:
+	default:
+	}
+}
+
+func (c *dtlsConn) Read(p []byte) (int, error) {
+	c.dlMu.Lock()
+	dl := c.readDL
+	c.dlMu.Unlock()
+
+	var timeout <-chan time.Time
+	if !dl.IsZero() {
+		timer := time.NewTimer(time.Until(dl))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case b := <-c.ch:
+		return copy(p, b), nil
+	case <-c.done:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, errDeadline
+	}
+}
+
+func (c *dtlsConn) Write(p []byte) (int, error) {
+	remote, _ := c.RemoteAddr().(*net.UDPAddr)
+	if remote == nil {
+		// ice完成前还没有地址, dtls会重传.
+		return len(p), nil
+	}
+	return c.leg.rtpConn.WriteToUDP(p, remote)
+}
+
+func (c *dtlsConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *dtlsConn) LocalAddr() net.Addr { return c.leg.rtpConn.LocalAddr() }
+
+func (c *dtlsConn) RemoteAddr() net.Addr {
+	if addr, ok := c.addr.Load().(*net.UDPAddr); ok && addr != nil {
+		return addr
+	}
+	c.leg.RLock()
+	defer c.leg.RUnlock()
+	if c.leg.remote == nil {
+		return nil
+	}
+	return c.leg.remote
+}
+
+func (c *dtlsConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *dtlsConn) SetReadDeadline(t time.Time) error {
+	c.dlMu.Lock()
+	c.readDL = t
+	c.dlMu.Unlock()
+	return nil
+}
+
+func (c *dtlsConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "i/o timeout" }
+func (deadlineError) Timeout() bool   { return true }
+func (deadlineError) Temporary() bool { return true }
+
+var errDeadline net.Error = deadlineError{}
@@ -0,0 +1,151 @@
+package rtpengine
+
+import (
+	"bytes"
+	log "common/log/newlog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func TestCryptoAttribute(t *testing.T) {
+	const value = "1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|258:2 KDR=0 WSH=64"
+	c, err := ParseCrypto("crypto:" + value)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Tag)
+	assert.Equal(t, SuiteAESCM128HMACSHA180, c.Suite)
+	assert.Len(t, c.Key, 16)
+	assert.Len(t, c.Salt, 14)
+	assert.Equal(t, "2^20", c.Lifetime)
+	assert.Equal(t, []byte{1, 2}, c.MKI)
+	assert.Equal(t, []string{"KDR=0", "WSH=64"}, c.Params)
+	assert.Equal(t, value, c.String())
+	assert.True(t, c.supported())
+
+	// MKI可以没有lifetime.
+	c, err = ParseCrypto("2 AEAD_AES_128_GCM inline:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGw==|1:4")
+	require.NoError(t, err)
+	assert.Empty(t, c.Lifetime)
+	assert.Equal(t, []byte{0, 0, 0, 1}, c.MKI)
+	assert.Equal(t, "2 AEAD_AES_128_GCM inline:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGw==|1:4", c.String())
+
+	for _, invalid := range []string{
+		"1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|256:1",
+		"1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|1:0",
+		"1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|x:1",
+	} {
+		_, err = ParseCrypto(invalid)
+		assert.ErrorIs(t, err, errInvalidMKI, invalid)
+	}
+}
+
+func TestSelectCrypto(t *testing.T) {
+	remote, local, err := SelectCrypto([]string{
+		"1 AEAD_AES_128_GCM inline:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGw== UNENCRYPTED_SRTP",
+		"2 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR KDR=1",
+		"3 AES_CM_128_HMAC_SHA1_32 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|1:1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, remote.Tag)
+	assert.Equal(t, []byte{1}, remote.MKI)
+	assert.Equal(t, 3, local.Tag)
+	assert.Equal(t, SuiteAESCM128HMACSHA132, local.Suite)
+	assert.Nil(t, local.MKI)
+
+	_, _, err = SelectCrypto([]string{"1 AEAD_AES_128_GCM inline:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGw== UNENCRYPTED_SRTCP"})
+	assert.ErrorIs(t, err, errUnsupportedSuite)
+}
+
+func TestSRTPSession_MKI(t *testing.T) {
+	pkt, err := (&rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1, SSRC: 1},
+		Payload: bytes.Repeat([]byte{0xff}, 160),
+	}).Marshal()
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		suite  string
+		rtpTag int
+	}{
+		{SuiteAESCM128HMACSHA180, 10},
+		{SuiteAESCM128HMACSHA132, 4},
+		{SuiteAEADAES128GCM, 0},
+	} {
+		t.Run(tc.suite, func(t *testing.T) {
+			a, err := NewCryptoAttribute(1, tc.suite)
+			require.NoError(t, err)
+			b, err := NewCryptoAttribute(1, tc.suite)
+			require.NoError(t, err)
+			a.MKI = []byte{0xa, 0xb}
+			profile, err := a.Profile()
+			require.NoError(t, err)
+
+			sender, err := newSRTPSession(profile, srtp.SessionKeys{
+				LocalMasterKey: a.Key, LocalMasterSalt: a.Salt, RemoteMasterKey: b.Key, RemoteMasterSalt: b.Salt,
+			}, a.MKI, nil)
+			require.NoError(t, err)
+			receiver, err := newSRTPSession(profile, srtp.SessionKeys{
+				LocalMasterKey: b.Key, LocalMasterSalt: b.Salt, RemoteMasterKey: a.Key, RemoteMasterSalt: a.Salt,
+			}, nil, a.MKI)
+			require.NoError(t, err)
+
+			encrypted, err := sender.encryptRTP(pkt)
+			require.NoError(t, err)
+			// MKI在认证标签之前, AEAD时在包尾.
+			end := len(encrypted) - tc.rtpTag
+			assert.Equal(t, a.MKI, encrypted[end-2:end])
+			decrypted, err := receiver.decryptRTP(encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, pkt, decrypted)
+
+			encrypted, err = sender.encryptRTP(pkt)
+			require.NoError(t, err)
+			encrypted[end-1] ^= 0xff
+			_, err = receiver.decryptRTP(encrypted)
+			assert.ErrorIs(t, err, errInvalidMKI)
+		})
+	}
+}
+
+func TestLeg_DTLS(t *testing.T) {
+	pool := NewPortPool("127.0.0.1", 42120, 42130)
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	client, err := NewLeg("client", pool, bf, LegConfig{Latching: true})
+	require.NoError(t, err)
+	defer client.Close()
+	server, err := NewLeg("server", pool, bf, LegConfig{Latching: true})
+	require.NoError(t, err)
+	defer server.Close()
+
+	clientCert, clientFingerprint, err := NewDTLSCertificate()
+	require.NoError(t, err)
+	serverCert, serverFingerprint, err := NewDTLSCertificate()
+	require.NoError(t, err)
+
+	// 服务端没有对方地址, 按收到的dtls包的来源应答.
+	client.SetRemote(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: server.LocalPort()}, nil)
+	require.NoError(t, server.StartDTLS(DTLSConfig{Certificate: serverCert, RemoteFingerprint: clientFingerprint}))
+	require.NoError(t, client.StartDTLS(DTLSConfig{Certificate: clientCert, Client: true, RemoteFingerprint: serverFingerprint}))
+
+	var clientKey, serverKey []byte
+	require.Eventually(t, func() bool {
+		clientKey, _ = client.ExportKeyingMaterial("EXTRACTOR-test", nil, 16)
+		serverKey, _ = server.ExportKeyingMaterial("EXTRACTOR-test", nil, 16)
+		return clientKey != nil && serverKey != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, clientKey, serverKey)
+	assert.Nil(t, server.Remote(), "dtls does not latch rtp")
+
+	plain, err := NewLeg("plain", pool, bf, LegConfig{})
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.ExportKeyingMaterial("EXTRACTOR-test", nil, 16)
+	assert.ErrorIs(t, err, errSRTPNotNegotiated)
+}
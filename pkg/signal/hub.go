@@ -0,0 +1,116 @@
+package signal
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var (
+	errE2EEMismatch  = errors.New("session e2ee mode mismatch")
+	errNotE2EE       = errors.New("session is not e2ee")
+	errNotJoined     = errors.New("peer has not joined the session")
+	errPeerNotJoined = errors.New("key receiver has not joined the session")
+	errClosed        = errors.New("signal connection closed")
+)
+
+// Hub 本节点各会话的信令连接, 在同一会话的peer之间转发E2EE密钥交换消息.
+// 服务端只转发, 不解析也不保存密钥.
+type Hub struct {
+	sync.Mutex
+	sessions map[string]*hubSession
+}
+
+type hubSession struct {
+	e2ee  bool
+	peers map[string]*Signal
+}
+
+// NewHub -.
+func NewHub() *Hub {
+	return &Hub{sessions: make(map[string]*hubSession)}
+}
+
+// Join 第一个peer决定会话是否E2EE, 之后的peer模式不同时拒绝加入.
+func (h *Hub) Join(session, peer string, e2ee bool, s *Signal) error {
+	h.Lock()
+	defer h.Unlock()
+	hs, ok := h.sessions[session]
+	if !ok {
+		hs = &hubSession{e2ee: e2ee, peers: make(map[string]*Signal)}
+		h.sessions[session] = hs
+	}
+	if hs.e2ee != e2ee {
+		return errE2EEMismatch
+	}
+	hs.peers[peer] = s
+	return nil
+}
+
+// Leave 最后一个peer离开时删除会话.
+func (h *Hub) Leave(session, peer string) {
+	h.Lock()
+	defer h.Unlock()
+	hs, ok := h.sessions[session]
+	if !ok {
+		return
+	}
+	delete(hs.peers, peer)
+	if len(hs.peers) == 0 {
+		delete(h.sessions, session)
+	}
+}
+
+// E2EE returns if the session is in e2ee mode, false if no peer joined
+func (h *Hub) E2EE(session string) bool {
+	h.Lock()
+	defer h.Unlock()
+	hs, ok := h.sessions[session]
+	return ok && hs.e2ee
+}
+
+// RelayKey 把from的密钥交换消息转发给msg.To或会话中的其他peer.
+func (h *Hub) RelayKey(session, from string, msg *KeyMessage) error {
+	msg.From = from
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(WebsocketMessage{Event: MessageTypeKey, Data: data})
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	hs, ok := h.sessions[session]
+	if !ok || hs.peers[from] == nil {
+		h.Unlock()
+		return errNotJoined
+	}
+	if !hs.e2ee {
+		h.Unlock()
+		return errNotE2EE
+	}
+	var to []*Signal
+	if msg.To != "" {
+		s, ok := hs.peers[msg.To]
+		if !ok {
+			h.Unlock()
+			return errPeerNotJoined
+		}
+		to = append(to, s)
+	} else {
+		for id, s := range hs.peers {
+			if id != from {
+				to = append(to, s)
+			}
+		}
+	}
+	h.Unlock()
+
+	// 不持有锁发送, 对端写阻塞时不影响其他会话.
+	for _, s := range to {
+		s.Deliver(raw)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package signal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_Join(t *testing.T) {
+	h := NewHub()
+	s, _ := newTestSignal(t)
+
+	assert.False(t, h.E2EE("s1"))
+	require.NoError(t, h.Join("s1", "p1", true, s))
+	assert.True(t, h.E2EE("s1"))
+	assert.ErrorIs(t, h.Join("s1", "p2", false, s), errE2EEMismatch)
+	require.NoError(t, h.Join("s1", "p2", true, s))
+
+	// 最后一个peer离开后会话删除, 下一个peer重新决定模式.
+	h.Leave("s1", "p1")
+	assert.True(t, h.E2EE("s1"))
+	h.Leave("s1", "p2")
+	assert.False(t, h.E2EE("s1"))
+	require.NoError(t, h.Join("s1", "p3", false, s))
+	assert.False(t, h.E2EE("s1"))
+}
+
+func TestHub_RelayKey(t *testing.T) {
+	h := NewHub()
+	s1, received1 := newTestSignal(t)
+	s2, received2 := newTestSignal(t)
+	s3, received3 := newTestSignal(t)
+	require.NoError(t, h.Join("s1", "p1", true, s1))
+	require.NoError(t, h.Join("s1", "p2", true, s2))
+	require.NoError(t, h.Join("s1", "p3", true, s3))
+
+	key := func(received <-chan []byte) KeyMessage {
+		var msg WebsocketMessage
+		select {
+		case raw := <-received:
+			require.NoError(t, json.Unmarshal(raw, &msg))
+		case <-time.After(time.Second):
+			t.Fatal("key message not received")
+		}
+		assert.Equal(t, MessageTypeKey, msg.Event)
+		var k KeyMessage
+		require.NoError(t, json.Unmarshal(msg.Data, &k))
+		return k
+	}
+
+	// 指定接收方.
+	require.NoError(t, h.RelayKey("s1", "p1", &KeyMessage{From: "p3", To: "p2", Data: json.RawMessage(`"k1"`)}))
+	k := key(received2)
+	assert.Equal(t, "p1", k.From, "from is filled by the hub")
+	assert.JSONEq(t, `"k1"`, string(k.Data))
+
+	// 广播给其他peer, 不发回给自己.
+	require.NoError(t, h.RelayKey("s1", "p2", &KeyMessage{Data: json.RawMessage(`"k2"`)}))
+	assert.Equal(t, "p2", key(received1).From)
+	assert.Equal(t, "p2", key(received3).From)
+	select {
+	case <-received2:
+		t.Fatal("key relayed back to the sender")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	assert.ErrorIs(t, h.RelayKey("s1", "p1", &KeyMessage{To: "p4"}), errPeerNotJoined)
+	assert.ErrorIs(t, h.RelayKey("s1", "p4", &KeyMessage{}), errNotJoined)
+	assert.ErrorIs(t, h.RelayKey("s2", "p1", &KeyMessage{}), errNotJoined)
+
+	require.NoError(t, h.Join("s2", "p1", false, s1))
+	assert.ErrorIs(t, h.RelayKey("s2", "p1", &KeyMessage{}), errNotE2EE)
+}
@@ -21,6 +21,10 @@ const (
 
 	// join 会议系统，参考ion sfu.
 	MessageTypeJoin      = "join"
+	// 会话在其他节点, 客户端重新连接到url再join.
+	MessageTypeRedirect  = "redirect"
+	// E2EE会话的密钥交换, 服务端不解析data, 原样转发给同一会话的其他peer.
+	MessageTypeKey       = "key"
 
 	// message start or ready needed?.
 	// TODO: 准备阶段暂时不考虑start和ready的处理.（如果是webclient发起呼叫就需要处理这两类消息.）.
@@ -34,10 +38,32 @@ const (
 	_pingPeriod      = time.Minute
 )
 
-// TODO: add Join message..
+// join message.
 type JoinMessage struct {
-	Id   string			`json:"id"`  // for bill_id.
-	Description  []byte `json:"sdp"`
+	Session string `json:"session"`
+	Id      string `json:"id"`  // for bill_id.
+	SDP     string `json:"sdp"` // offer.
+	// E2EE 端到端加密(insertable streams), 第一个加入的peer决定会话的模式.
+	E2EE bool `json:"e2ee,omitempty"`
+}
+
+// key exchange message, opaque to the server.
+type KeyMessage struct {
+	From string          `json:"from"`         // 服务端按发送方的join id填写.
+	To   string          `json:"to,omitempty"` // 为空时发给会话中的其他所有peer.
+	Data json.RawMessage `json:"data"`
+}
+
+// candidate message, target is the pc of the candidate, 0 for publisher and 1 for subscriber.
+type TrickleMessage struct {
+	webrtc.ICECandidateInit
+	Target int `json:"target"`
+}
+
+// redirect message, server to client.
+type RedirectMessage struct {
+	Session string `json:"session"`
+	URL     string `json:"url"`
 }
 
 // for all messages.
@@ -60,13 +86,24 @@ type Signal struct {
 	OnNegotiate    func(*webrtc.SessionDescription) error
 	OnSetRemoteSDP func(*webrtc.SessionDescription) error
 	OnError        func(error)
-	OnTrickle      func(*webrtc.ICECandidateInit)
+	OnTrickle      func(*webrtc.ICECandidateInit, int)
+	// 返回错误时发送给客户端, 未设置时sdp作为offer直接应答.
+	OnJoin func(*JoinMessage) error
+	// 返回错误时发送给客户端, 未设置时忽略密钥交换消息.
+	OnKey func(*KeyMessage) error
 
 
 
 	// use Session  instead.
 	PeerConnection *webrtc.PeerConnection
 	once sync.Once
+	// 其他连接通过Deliver发送, 关闭后不再写Send.
+	closeMu sync.RWMutex
+	closed  bool
+
+	// 中继到会话持有节点的连接, 设置后客户端消息原样转发.
+	relayMu sync.Mutex
+	relay   *websocket.Conn
 }
 
 
@@ -91,6 +128,9 @@ func (s *Signal) SignalMessageLoop() {
 	defer func() {
 		// deregister
 		_ = s.conn.Close()
+		if relay := s.relayConn(); relay != nil {
+			_ = relay.Close()
+		}
 	}()
 	s.conn.SetReadLimit(_maxMessageSize)
 	_ = s.conn.SetReadDeadline(time.Now().Add(_pongWait))
@@ -114,6 +154,14 @@ func (s *Signal) SignalMessageLoop() {
 			return
 		}
 
+		if relay := s.relayConn(); relay != nil {
+			if err = relay.WriteMessage(websocket.TextMessage, raw); err != nil {
+				log.Printf("could not relay message: %s", err)
+				return
+			}
+			continue
+		}
+
 		err = json.Unmarshal(raw, &message)
 		if err != nil {
 			log.Printf("could not unmarshal ws message: %s", err)
@@ -125,8 +173,8 @@ func (s *Signal) SignalMessageLoop() {
 		switch message.Event { //
 		case MessageTypeCandidate: // receive ice server, ignored.(需要turn server的时候打开). 、、当offer端接收到来自对方的candidate时，pc.addIceCandidate(candidate);//将来自对方的candidate设置给本地.
 			// only need candidate.
-			candidate := webrtc.ICECandidateInit{}
-			if err := json.Unmarshal(message.Data, &candidate); err != nil {
+			trickle := TrickleMessage{}
+			if err := json.Unmarshal(message.Data, &trickle); err != nil {
 				errMessage := fmt.Sprintf("could not unmarshal candidate msg: %s", err)
 				log.Printf("could not unmarshal candidate msg: %s", err)
 				_ = s.sendWarning(errMessage)
@@ -134,10 +182,9 @@ func (s *Signal) SignalMessageLoop() {
 			}
 
 			if s.OnTrickle != nil {
-				s.OnTrickle(&candidate)
-				return
+				s.OnTrickle(&trickle.ICECandidateInit, trickle.Target)
 			} else {
-				if err := s.PeerConnection.AddICECandidate(candidate); err != nil {
+				if err := s.PeerConnection.AddICECandidate(trickle.ICECandidateInit); err != nil {
 					errMessage := fmt.Sprintf("Error taking candidate: %s", err)
 					log.Printf("[webrtc=%v] %s", s.conn, errMessage)
 					_ = s.sendWarning(errMessage)
@@ -153,8 +200,10 @@ func (s *Signal) SignalMessageLoop() {
 			}
 
 			if s.OnSetRemoteSDP != nil {
-				_ = s.OnSetRemoteSDP(&answer)
-				return
+				if err := s.OnSetRemoteSDP(&answer); err != nil {
+					log.Printf("could not set remote description: %s", err)
+					_ = s.sendError(err.Error())
+				}
 			} else {
 				if err := s.PeerConnection.SetRemoteDescription(answer); err != nil {
 					log.Printf("could not set remote description: %s", err)
@@ -170,8 +219,11 @@ func (s *Signal) SignalMessageLoop() {
 			}
 
 			if s.OnNegotiate != nil {
-				_ = s.OnNegotiate(&offer)
-				return
+				// 由回调发送answer.
+				if err := s.OnNegotiate(&offer); err != nil {
+					log.Printf("could not negotiate: %s", err)
+					_ = s.sendError(err.Error())
+				}
 			} else { // default handle.
 				err := s.takeOffer(&offer)
 				if nil != err {
@@ -184,10 +236,36 @@ func (s *Signal) SignalMessageLoop() {
 				_ = s.SendObject(*answer)
 			}
 
-		// TODO: 增加join命令支持会议.
 		case MessageTypeJoin:
+			join := JoinMessage{}
+			if err := json.Unmarshal(message.Data, &join); err != nil {
+				log.Printf("could not unmarshal join msg: %s", err)
+				return
+			}
+
+			if s.OnJoin != nil {
+				if err := s.OnJoin(&join); err != nil {
+					log.Printf("could not join session %s: %s", join.Session, err)
+					_ = s.sendError(err.Error())
+				}
+			} else if err := s.Answer(&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: join.SDP}); err != nil {
+				log.Printf("could not takeOffer: %s", err)
+				return
+			}
 
-			fallthrough
+		case MessageTypeKey:
+			key := KeyMessage{}
+			if err := json.Unmarshal(message.Data, &key); err != nil {
+				log.Printf("could not unmarshal key msg: %s", err)
+				return
+			}
+
+			if s.OnKey == nil {
+				_ = s.sendWarning("key exchange is not supported")
+			} else if err := s.OnKey(&key); err != nil {
+				log.Printf("could not relay key msg: %s", err)
+				_ = s.sendError(err.Error())
+			}
 
 		default:
 			errMessage := fmt.Sprintf("Received unknown command '%s'. Ignored.", message.Event)
@@ -197,6 +275,70 @@ func (s *Signal) SignalMessageLoop() {
 	}
 }
 
+// Answer takes the offer and sends the answer
+func (s *Signal) Answer(offer *webrtc.SessionDescription) error {
+	if err := s.takeOffer(offer); err != nil {
+		return err
+	}
+	return s.SendObject(*s.PeerConnection.LocalDescription())
+}
+
+// Redirect tells the client to join session at url
+func (s *Signal) Redirect(session, url string) error {
+	data, err := json.Marshal(RedirectMessage{Session: session, URL: url})
+	if err != nil {
+		return err
+	}
+	return s.SendObject(WebsocketMessage{Event: MessageTypeRedirect, Data: data})
+}
+
+// Relay 连接会话持有节点并重发join, 之后双向原样转发信令, 媒体直接和持有节点的ice候选建立.
+func (s *Signal) Relay(url string, join *JoinMessage) error {
+	data, err := json.Marshal(join)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(WebsocketMessage{Event: MessageTypeJoin, Data: data})
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+	if err = conn.WriteMessage(websocket.TextMessage, raw); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	s.relayMu.Lock()
+	s.relay = conn
+	s.relayMu.Unlock()
+
+	go func() {
+		defer s.Close()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				log.Printf("relay closed: %s", err)
+				return
+			}
+			// 客户端连接可能已经关闭, 通过Deliver发送.
+			if !s.Deliver(message) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Signal) relayConn() *websocket.Conn {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+	return s.relay
+}
+
 func (s *Signal) takeOffer(offer *webrtc.SessionDescription) error {
 	if err := s.PeerConnection.SetRemoteDescription(*offer); nil != err {
 		log.Printf("could not set remote description: %s", err)
@@ -268,21 +410,67 @@ func (s *Signal) WriteWebrtcMessageLoop() {
 // TODO: Send message.
 
 func (s *Signal) Close()  {
-	s.once.Do( func() {close(s.Send)} )
+	s.once.Do(func() {
+		if relay := s.relayConn(); relay != nil {
+			_ = relay.Close()
+		}
+		s.closeMu.Lock()
+		s.closed = true
+		close(s.Send)
+		s.closeMu.Unlock()
+	})
+}
+
+// Deliver sends message from another connection's goroutine, false if closed or the write loop is stuck
+func (s *Signal) Deliver(message []byte) bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.Send <- message:
+		return true
+	case <-time.After(_writeWait):
+		return false
+	}
 }
 
 func (s *Signal) SendObject(messageStruct interface{}) error {
 	message, err := json.Marshal(messageStruct)
-	s.Send <- message
-	return err
+	if err != nil {
+		return err
+	}
+	if !s.Deliver(message) {
+		return errClosed
+	}
+	return nil
 	// _ = s.conn.WriteMessage(websocket.TextMessage, message)
 }
 
 func (s *Signal) SendMessage(message []byte) {
-	s.Send <- message
+	s.Deliver(message)
 	// _ = s.conn.WriteMessage(websocket.TextMessage, message)
 }
 
+// Offer sends a server offer, used for the subscriber pc renegotiation
+func (s *Signal) Offer(offer *webrtc.SessionDescription) error {
+	data, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	return s.SendObject(WebsocketMessage{Event: MessageTypeOffer, Data: data})
+}
+
+// Trickle sends a server ice candidate of the target pc
+func (s *Signal) Trickle(candidate *webrtc.ICECandidateInit, target int) error {
+	data, err := json.Marshal(TrickleMessage{ICECandidateInit: *candidate, Target: target})
+	if err != nil {
+		return err
+	}
+	return s.SendObject(WebsocketMessage{Event: MessageTypeCandidate, Data: data})
+}
+
 func (s *Signal) sendWarning(text string) error {
 	return s.SendObject(struct {
 		Warning string `json:"warning"`
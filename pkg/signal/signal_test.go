@@ -0,0 +1,82 @@
+package signal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// wsServer 每个连接交给handle处理, 返回ws地址.
+func wsServer(t *testing.T, handle func(*websocket.Conn)) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// newTestSignal 返回连接到客户端的Signal和客户端收到的消息.
+func newTestSignal(t *testing.T) (*Signal, <-chan []byte) {
+	received := make(chan []byte, 16)
+	url := wsServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- message
+		}
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	s := NewSignal(conn, nil)
+	go s.WriteWebrtcMessageLoop()
+	return s, received
+}
+
+func TestSignal_Relay(t *testing.T) {
+	joins := make(chan WebsocketMessage, 1)
+	owner := wsServer(t, func(conn *websocket.Conn) {
+		var join WebsocketMessage
+		if err := conn.ReadJSON(&join); err != nil {
+			return
+		}
+		joins <- join
+		// 持续发送, 客户端连接关闭后中继不能再写Send.
+		for {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"offer"}`)); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	s, received := newTestSignal(t)
+	require.NoError(t, s.Relay(owner, &JoinMessage{Session: "s1", Id: "p1"}))
+
+	join := <-joins
+	assert.Equal(t, MessageTypeJoin, join.Event)
+	var msg JoinMessage
+	require.NoError(t, json.Unmarshal(join.Data, &msg))
+	assert.Equal(t, "s1", msg.Session)
+	assert.Equal(t, `{"event":"offer"}`, string(<-received))
+
+	s.Close()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, s.Deliver([]byte("{}")))
+	assert.ErrorIs(t, s.SendObject(struct{}{}), errClosed)
+}
@@ -0,0 +1,437 @@
+package sip
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mediasfu/pkg/rtpengine"
+)
+
+// CallState is the dialog state of a call
+type CallState int
+
+const (
+	CallStateInit CallState = iota
+	CallStateEarly
+	CallStateConfirmed
+	CallStateTerminated
+)
+
+func (s CallState) String() string {
+	switch s {
+	case CallStateInit:
+		return "init"
+	case CallStateEarly:
+		return "early"
+	case CallStateConfirmed:
+		return "confirmed"
+	case CallStateTerminated:
+		return "terminated"
+	}
+	return "unknown"
+}
+
+// Call is a sip dialog with its rtp leg
+type Call struct {
+	sync.Mutex
+	ua        *UA
+	id        string
+	session   string
+	direction string
+	state     CallState
+
+	localTag, remoteTag   string
+	localSeq, remoteSeq   uint32
+	localAddr, remoteAddr string // From/To的name-addr, 不带tag
+	remoteTarget          string
+	routeSet              []string
+	to                    Addr // 对话内请求的发送地址
+
+	leg        *rtpengine.Leg
+	codec      Codec
+	dtmfPT     int
+	held       bool
+	sdpVersion uint64
+
+	created  time.Time
+	answered time.Time
+	stats    rtpengine.LegStats // leg关闭前的统计
+
+	invite   *Message  // 呼出的INVITE, 用于CANCEL
+	inviteTx *serverTx // 呼入的INVITE事务
+	acked    chan struct{}
+	ackOnce  *sync.Once
+
+	onHold        atomic.Value // func(bool)
+	onCodecChange atomic.Value // func(Codec)
+	onTerminated  atomic.Value // func(string)
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// ID returns the Call-ID
+func (c *Call) ID() string { return c.id }
+
+// Session returns the session name, the user part of the Request-URI for inbound calls
+func (c *Call) Session() string { return c.session }
+
+// Direction returns "inbound" or "outbound"
+func (c *Call) Direction() string { return c.direction }
+
+// RemoteURI returns the address of the remote party
+func (c *Call) RemoteURI() string { return addrURI(c.remoteAddr) }
+
+// Leg returns the rtp leg carrying the call media
+func (c *Call) Leg() *rtpengine.Leg { return c.leg }
+
+// State returns the dialog state
+func (c *Call) State() CallState {
+	c.Lock()
+	defer c.Unlock()
+	return c.state
+}
+
+// Codec returns the negotiated audio codec
+func (c *Call) Codec() Codec {
+	c.Lock()
+	defer c.Unlock()
+	return c.codec
+}
+
+// Held returns true if the remote party put the call on hold
+func (c *Call) Held() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.held
+}
+
+// Created returns the time the call was created
+func (c *Call) Created() time.Time { return c.created }
+
+// Answered returns the time the call was confirmed, zero if never answered
+func (c *Call) Answered() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.answered
+}
+
+// Stats returns the statistics of the rtp leg, frozen when the call terminates
+func (c *Call) Stats() rtpengine.LegStats {
+	c.Lock()
+	defer c.Unlock()
+	if c.state == CallStateTerminated {
+		return c.stats
+	}
+	return c.leg.Stats()
+}
+
+// Done is closed when the call is terminated
+func (c *Call) Done() <-chan struct{} { return c.done }
+
+// OnHold is called when the remote party holds or resumes the call
+func (c *Call) OnHold(f func(held bool)) { c.onHold.Store(f) }
+
+// OnCodecChange is called when a re-INVITE/UPDATE switches the codec
+func (c *Call) OnCodecChange(f func(codec Codec)) { c.onCodecChange.Store(f) }
+
+// OnTerminated is called once when the call ends
+func (c *Call) OnTerminated(f func(reason string)) { c.onTerminated.Store(f) }
+
+// Hangup ends the call: BYE when answered, CANCEL for outbound ringing calls,
+// 487 for inbound calls not answered yet.
+func (c *Call) Hangup() error {
+	c.hangup("local hangup")
+	return nil
+}
+
+// Hold puts the call on hold (sendonly) or resumes it with a re-INVITE
+func (c *Call) Hold(held bool) error {
+	c.Lock()
+	if c.state != CallStateConfirmed {
+		c.Unlock()
+		return errCallTerminated
+	}
+	direction := "sendrecv"
+	if held {
+		direction = "sendonly"
+	}
+	req := c.newRequest("INVITE")
+	req.SetHeader("Content-Type", "application/sdp")
+	req.Body = localSDP(c.ua.mediaIP(), c.leg.LocalPort(), []Codec{c.codec}, c.dtmfPT, direction, c.nextVersion())
+	to := c.destination()
+	c.Unlock()
+
+	tx, err := c.ua.request(req, to)
+	if err != nil {
+		return err
+	}
+	for resp := range tx.responses {
+		switch {
+		case resp.StatusCode < 200:
+			continue
+		case resp.StatusCode < 300:
+			seq, _ := req.CSeq()
+			c.Lock()
+			ack := c.newRequestSeq("ACK", seq)
+			c.Unlock()
+			tx.sendACK(ack, to)
+			return nil
+		default:
+			return &StatusError{Code: resp.StatusCode, Reason: resp.Reason}
+		}
+	}
+	return errTimeout
+}
+
+func (c *Call) hangup(reason string) {
+	c.Lock()
+	state := c.state
+	if state != CallStateConfirmed {
+		c.state = CallStateTerminated
+	}
+	c.Unlock()
+
+	switch {
+	case state == CallStateTerminated:
+		return
+	case state == CallStateConfirmed:
+		c.bye()
+	case c.direction == "outbound":
+		c.cancel()
+	case c.inviteTx != nil:
+		c.ua.respond(c.inviteTx, c.response(c.inviteTx.req, 487))
+	}
+	c.terminate(reason)
+}
+
+func (c *Call) bye() {
+	c.Lock()
+	req := c.newRequest("BYE")
+	to := c.destination()
+	c.Unlock()
+	tx, err := c.ua.request(req, to)
+	if err != nil {
+		Logger.Error(err, "send bye failed", "call_id", c.id)
+		return
+	}
+	go func() {
+		for range tx.responses {
+		}
+	}()
+}
+
+// cancel 取消还没有应答的呼出.
+func (c *Call) cancel() {
+	if c.invite == nil {
+		return
+	}
+	req := NewRequest("CANCEL", c.invite.RequestURI)
+	req.AddHeader("Via", c.invite.Header("Via"))
+	req.AddHeader("Max-Forwards", maxForwards)
+	for _, r := range c.invite.Headers("Route") {
+		req.AddHeader("Route", r)
+	}
+	req.AddHeader("From", c.invite.Header("From"))
+	req.AddHeader("To", c.invite.Header("To"))
+	req.AddHeader("Call-ID", c.id)
+	seq, _ := c.invite.CSeq()
+	req.AddHeader("CSeq", fmt.Sprintf("%d CANCEL", seq))
+	req.AddHeader("User-Agent", c.ua.cfg.UserAgent)
+	tx, err := c.ua.request(req, c.to)
+	if err != nil {
+		Logger.Error(err, "send cancel failed", "call_id", c.id)
+		return
+	}
+	go func() {
+		for range tx.responses {
+		}
+	}()
+}
+
+// terminate 结束呼叫, 释放rtp leg.
+func (c *Call) terminate(reason string) {
+	c.closeOnce.Do(func() {
+		c.Lock()
+		c.state = CallStateTerminated
+		c.stats = c.leg.Stats()
+		c.Unlock()
+		close(c.done)
+		_ = c.leg.Close()
+		c.ua.removeCall(c)
+		Logger.Info("sip call terminated", "call_id", c.id, "reason", reason)
+		if f, ok := c.onTerminated.Load().(func(string)); ok && f != nil {
+			f(reason)
+		}
+		if f, ok := c.ua.onTerminated.Load().(func(*Call, string)); ok && f != nil {
+			f(c, reason)
+		}
+	})
+}
+
+// early 呼出收到1xx.
+func (c *Call) early(resp *Message) {
+	c.Lock()
+	defer c.Unlock()
+	if c.state == CallStateInit && resp.ToTag() != "" {
+		c.state = CallStateEarly
+		c.remoteTag = resp.ToTag()
+	}
+}
+
+// confirm 呼出收到2xx, 建立对话并发送ACK.
+func (c *Call) confirm(resp *Message, tx *clientTx) error {
+	remote, err := parseRemoteSDP(resp.Body)
+	if err != nil {
+		return err
+	}
+	codec, err := remote.selectCodec()
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	c.state = CallStateConfirmed
+	c.answered = time.Now()
+	c.remoteTag = resp.ToTag()
+	if contact := addrURI(resp.Header("Contact")); contact != "" {
+		c.remoteTarget = contact
+	}
+	// 呼出方向的route set是Record-Route的逆序.
+	routes := resp.Headers("Record-Route")
+	c.routeSet = make([]string, 0, len(routes))
+	for i := len(routes) - 1; i >= 0; i-- {
+		c.routeSet = append(c.routeSet, routes[i])
+	}
+	seq, _ := c.invite.CSeq()
+	ack := c.newRequestSeq("ACK", seq)
+	to := c.destination()
+	c.Unlock()
+
+	tx.sendACK(ack, to)
+
+	c.applyRemote(remote, codec, false)
+	Logger.Info("sip outbound call answered", "call_id", c.id, "session", c.session, "codec", codec.Name)
+	return nil
+}
+
+// applyRemote 应用对端SDP: 更新rtp地址, 检测hold和换编码.
+func (c *Call) applyRemote(remote *remoteMedia, codec Codec, notify bool) {
+	if remote.addr != nil {
+		c.leg.SetRemote(remote.addr, remote.rtcpAddr)
+	}
+	c.leg.SetDTMFPayloadType(remote.dtmfPT)
+
+	c.Lock()
+	held, changed := remote.held(), c.codec.Name != "" && c.codec != codec
+	heldChanged := held != c.held
+	c.held, c.codec, c.dtmfPT = held, codec, remote.dtmfPT
+	c.Unlock()
+
+	if !notify {
+		return
+	}
+	if heldChanged {
+		Logger.Info("sip call hold", "call_id", c.id, "held", held)
+		if f, ok := c.onHold.Load().(func(bool)); ok && f != nil {
+			f(held)
+		}
+	}
+	if changed {
+		Logger.Info("sip call codec changed", "call_id", c.id, "codec", codec.Name)
+		if f, ok := c.onCodecChange.Load().(func(Codec)); ok && f != nil {
+			f(codec)
+		}
+	}
+}
+
+// resetAck 发送200前重置ACK等待.
+func (c *Call) resetAck() chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	c.acked = make(chan struct{})
+	c.ackOnce = &sync.Once{}
+	return c.acked
+}
+
+func (c *Call) ack() {
+	c.Lock()
+	acked, once := c.acked, c.ackOnce
+	c.Unlock()
+	if once != nil {
+		once.Do(func() { close(acked) })
+	}
+}
+
+// response 带本端tag和Contact的响应, 调用方持有锁或call还未共享.
+func (c *Call) response(req *Message, code int) *Message {
+	resp := NewResponse(req, code, "")
+	if req.ToTag() == "" && code > 100 {
+		resp.SetHeader("To", req.Header("To")+";tag="+c.localTag)
+	}
+	if code >= 200 && code < 300 {
+		resp.SetHeader("Contact", c.contact())
+		resp.SetHeader("Allow", allow)
+	}
+	resp.SetHeader("User-Agent", c.ua.cfg.UserAgent)
+	return resp
+}
+
+// newRequest 生成对话内请求, 调用方持有锁.
+func (c *Call) newRequest(method string) *Message {
+	c.localSeq++
+	return c.newRequestSeq(method, c.localSeq)
+}
+
+func (c *Call) newRequestSeq(method string, seq uint32) *Message {
+	req := NewRequest(method, c.remoteTarget)
+	req.AddHeader("Via", fmt.Sprintf("SIP/2.0/%s %s;branch=%s;rport", c.to.Transport, c.ua.hostport(c.to.Transport), newBranch()))
+	req.AddHeader("Max-Forwards", maxForwards)
+	for _, r := range c.routeSet {
+		req.AddHeader("Route", r)
+	}
+	to := c.remoteAddr
+	if c.remoteTag != "" {
+		to += ";tag=" + c.remoteTag
+	}
+	req.AddHeader("From", c.localAddr+";tag="+c.localTag)
+	req.AddHeader("To", to)
+	req.AddHeader("Call-ID", c.id)
+	req.AddHeader("CSeq", fmt.Sprintf("%d %s", seq, method))
+	if method == "INVITE" || method == "UPDATE" {
+		req.AddHeader("Contact", c.contact())
+		req.AddHeader("Allow", allow)
+	}
+	req.AddHeader("User-Agent", c.ua.cfg.UserAgent)
+	return req
+}
+
+// destination 对话内请求的目的地址: 呼入发回信令来源(NAT), 呼出按route set或Contact.
+func (c *Call) destination() Addr {
+	if c.direction == "inbound" {
+		return c.to
+	}
+	target := c.remoteTarget
+	if len(c.routeSet) > 0 {
+		target = c.routeSet[0]
+	}
+	if _, to, err := parseURI(target); err == nil {
+		return to
+	}
+	return c.to
+}
+
+func (c *Call) contact() string {
+	uri := fmt.Sprintf("sip:%s@%s", c.session, c.ua.hostport(c.to.Transport))
+	if c.to.Transport == transportTCP {
+		uri += ";transport=tcp"
+	}
+	return "<" + uri + ">"
+}
+
+func (c *Call) nextVersion() uint64 {
+	c.sdpVersion++
+	return c.sdpVersion
+}
@@ -0,0 +1,373 @@
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxMessageSize = 65535
+
+var (
+	errInvalidMessage = errors.New("invalid sip message")
+	errMessageTooBig  = errors.New("sip message too big")
+)
+
+// 紧凑形式的头域, rfc3261 7.3.3.
+var compactHeaders = map[string]string{
+	"i": "Call-ID",
+	"m": "Contact",
+	"e": "Content-Encoding",
+	"l": "Content-Length",
+	"c": "Content-Type",
+	"f": "From",
+	"s": "Subject",
+	"k": "Supported",
+	"t": "To",
+	"v": "Via",
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+// Message is a SIP request or response
+type Message struct {
+	// request
+	Method     string
+	RequestURI string
+	// response
+	StatusCode int
+	Reason     string
+
+	headers []header
+	Body    []byte
+}
+
+// NewRequest creates a request without headers
+func NewRequest(method, uri string) *Message {
+	return &Message{Method: method, RequestURI: uri}
+}
+
+// NewResponse creates a response to req, copying Via, From, To, Call-ID and CSeq
+func NewResponse(req *Message, code int, reason string) *Message {
+	if reason == "" {
+		reason = statusText(code)
+	}
+	resp := &Message{StatusCode: code, Reason: reason}
+	for _, h := range req.headers {
+		switch h.name {
+		case "Via", "From", "To", "Call-ID", "CSeq", "Record-Route":
+			resp.headers = append(resp.headers, h)
+		}
+	}
+	return resp
+}
+
+// IsRequest returns true for requests
+func (m *Message) IsRequest() bool { return m.Method != "" }
+
+// Header returns the first value of the header
+func (m *Message) Header(name string) string {
+	name = canonicalHeader(name)
+	for _, h := range m.headers {
+		if h.name == name {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// Headers returns all values of the header, comma separated values are split
+func (m *Message) Headers(name string) []string {
+	name = canonicalHeader(name)
+	var values []string
+	for _, h := range m.headers {
+		if h.name == name {
+			values = append(values, splitHeaderValues(h.value)...)
+		}
+	}
+	return values
+}
+
+// SetHeader replaces all values of the header
+func (m *Message) SetHeader(name, value string) {
+	m.RemoveHeader(name)
+	m.AddHeader(name, value)
+}
+
+// AddHeader appends a header value
+func (m *Message) AddHeader(name, value string) {
+	m.headers = append(m.headers, header{name: canonicalHeader(name), value: value})
+}
+
+// PrependHeader inserts a header value before the others, used for Via
+func (m *Message) PrependHeader(name, value string) {
+	m.headers = append([]header{{name: canonicalHeader(name), value: value}}, m.headers...)
+}
+
+// RemoveHeader removes all values of the header
+func (m *Message) RemoveHeader(name string) {
+	name = canonicalHeader(name)
+	headers := m.headers[:0]
+	for _, h := range m.headers {
+		if h.name != name {
+			headers = append(headers, h)
+		}
+	}
+	m.headers = headers
+}
+
+// CallID returns the Call-ID header
+func (m *Message) CallID() string { return m.Header("Call-ID") }
+
+// CSeq returns the CSeq number and method
+func (m *Message) CSeq() (uint32, string) {
+	fields := strings.Fields(m.Header("CSeq"))
+	if len(fields) != 2 {
+		return 0, ""
+	}
+	n, _ := strconv.ParseUint(fields[0], 10, 32)
+	return uint32(n), fields[1]
+}
+
+// FromTag returns the tag parameter of From
+func (m *Message) FromTag() string { return headerParam(m.Header("From"), "tag") }
+
+// ToTag returns the tag parameter of To
+func (m *Message) ToTag() string { return headerParam(m.Header("To"), "tag") }
+
+// Branch returns the branch of the top Via
+func (m *Message) Branch() string { return headerParam(m.Header("Via"), "branch") }
+
+// Bytes encodes the message, Content-Length is set from the body
+func (m *Message) Bytes() []byte {
+	var buf bytes.Buffer
+	if m.IsRequest() {
+		fmt.Fprintf(&buf, "%s %s SIP/2.0\r\n", m.Method, m.RequestURI)
+	} else {
+		fmt.Fprintf(&buf, "SIP/2.0 %d %s\r\n", m.StatusCode, m.Reason)
+	}
+	for _, h := range m.headers {
+		if h.name == "Content-Length" {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.name, h.value)
+	}
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(m.Body))
+	buf.Write(m.Body)
+	return buf.Bytes()
+}
+
+func (m *Message) String() string {
+	return string(m.Bytes())
+}
+
+// ParseMessage parses a datagram
+func ParseMessage(b []byte) (*Message, error) {
+	return readMessage(bufio.NewReader(bytes.NewReader(b)), true)
+}
+
+// readMessage reads one message, on stream transports the body length comes from Content-Length.
+func readMessage(r *bufio.Reader, datagram bool) (*Message, error) {
+	var line string
+	var err error
+	// 跳过keepalive的空行.
+	for line == "" {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Message{}
+	if strings.HasPrefix(line, "SIP/2.0 ") {
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 2 {
+			return nil, errInvalidMessage
+		}
+		if m.StatusCode, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, errInvalidMessage
+		}
+		if len(parts) == 3 {
+			m.Reason = parts[2]
+		}
+	} else {
+		parts := strings.Split(line, " ")
+		if len(parts) != 3 || parts[2] != "SIP/2.0" {
+			return nil, errInvalidMessage
+		}
+		m.Method, m.RequestURI = parts[0], parts[1]
+	}
+
+	size := len(line)
+	for {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, errInvalidMessage
+		}
+		if line == "" {
+			break
+		}
+		size += len(line)
+		if size > maxMessageSize {
+			return nil, errMessageTooBig
+		}
+		// 折行.
+		if (line[0] == ' ' || line[0] == '\t') && len(m.headers) > 0 {
+			m.headers[len(m.headers)-1].value += " " + strings.TrimSpace(line)
+			continue
+		}
+		idx := strings.IndexByte(line, ':')
+		if idx <= 0 {
+			return nil, errInvalidMessage
+		}
+		m.AddHeader(strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:]))
+	}
+
+	length := -1
+	if v := m.Header("Content-Length"); v != "" {
+		if length, err = strconv.Atoi(v); err != nil || length < 0 || length > maxMessageSize {
+			return nil, errInvalidMessage
+		}
+	}
+	switch {
+	case length >= 0:
+		m.Body = make([]byte, length)
+		if _, err = io.ReadFull(r, m.Body); err != nil {
+			return nil, errInvalidMessage
+		}
+	case datagram:
+		m.Body, _ = io.ReadAll(r)
+	}
+	return m, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func canonicalHeader(name string) string {
+	if full, ok := compactHeaders[strings.ToLower(name)]; ok {
+		return full
+	}
+	switch strings.ToLower(name) {
+	case "call-id":
+		return "Call-ID"
+	case "cseq":
+		return "CSeq"
+	case "www-authenticate":
+		return "WWW-Authenticate"
+	}
+	parts := strings.Split(strings.ToLower(name), "-")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// splitHeaderValues 按逗号拆分, 忽略引号和<>中的逗号.
+func splitHeaderValues(v string) []string {
+	var (
+		values  []string
+		quoted  bool
+		bracket bool
+		start   int
+	)
+	for i, c := range v {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '<':
+			bracket = true
+		case '>':
+			bracket = false
+		case ',':
+			if !quoted && !bracket {
+				values = append(values, strings.TrimSpace(v[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(values, strings.TrimSpace(v[start:]))
+}
+
+// headerParam 取 ;name=value 参数, <>内的uri参数不算.
+func headerParam(v, name string) string {
+	if idx := strings.LastIndexByte(v, '>'); idx >= 0 {
+		v = v[idx+1:]
+	}
+	for _, p := range strings.Split(v, ";")[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if strings.EqualFold(kv[0], name) {
+			if len(kv) == 2 {
+				return kv[1]
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// addrURI 取name-addr中的uri: "Bob" <sip:bob@host>;tag=1 -> sip:bob@host
+func addrURI(v string) string {
+	if start := strings.IndexByte(v, '<'); start >= 0 {
+		if end := strings.IndexByte(v[start:], '>'); end > 0 {
+			return v[start+1 : start+end]
+		}
+	}
+	if idx := strings.IndexByte(v, ';'); idx >= 0 {
+		v = v[:idx]
+	}
+	return strings.TrimSpace(v)
+}
+
+func statusText(code int) string {
+	switch code {
+	case 100:
+		return "Trying"
+	case 180:
+		return "Ringing"
+	case 183:
+		return "Session Progress"
+	case 200:
+		return "OK"
+	case 400:
+		return "Bad Request"
+	case 404:
+		return "Not Found"
+	case 405:
+		return "Method Not Allowed"
+	case 408:
+		return "Request Timeout"
+	case 481:
+		return "Call/Transaction Does Not Exist"
+	case 486:
+		return "Busy Here"
+	case 487:
+		return "Request Terminated"
+	case 488:
+		return "Not Acceptable Here"
+	case 491:
+		return "Request Pending"
+	case 500:
+		return "Server Internal Error"
+	case 501:
+		return "Not Implemented"
+	case 503:
+		return "Service Unavailable"
+	case 603:
+		return "Decline"
+	}
+	return "Unknown"
+}
@@ -0,0 +1,206 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/sdp/v3"
+)
+
+var (
+	errInvalidSDP = errors.New("invalid sdp")
+	errNoCodec    = errors.New("no common codec")
+)
+
+// Codec 协商出的音频编码.
+type Codec struct {
+	PayloadType uint8
+	Name        string
+	ClockRate   uint32
+}
+
+// 本端支持的音频编码, 按优先级排序. rtp leg只转发, 不转码.
+var supportedCodecs = []Codec{
+	{PayloadType: 0, Name: "PCMU", ClockRate: 8000},
+	{PayloadType: 8, Name: "PCMA", ClockRate: 8000},
+}
+
+const telephoneEventPT = 101
+
+// remoteMedia 对端SDP中的音频参数.
+type remoteMedia struct {
+	addr      *net.UDPAddr // nil表示hold(c=0.0.0.0)或拒绝
+	rtcpAddr  *net.UDPAddr
+	direction string
+	codecs    []Codec
+	dtmfPT    int
+}
+
+// held 对端是否把呼叫hold住.
+func (r *remoteMedia) held() bool {
+	return r.addr == nil || r.direction == "sendonly" || r.direction == "inactive"
+}
+
+func parseRemoteSDP(body []byte) (*remoteMedia, error) {
+	sd := &sdp.SessionDescription{}
+	if err := sd.Unmarshal(body); err != nil {
+		return nil, errInvalidSDP
+	}
+	sessionIP := ""
+	if sd.ConnectionInformation != nil && sd.ConnectionInformation.Address != nil {
+		sessionIP = sd.ConnectionInformation.Address.Address
+	}
+	direction := "sendrecv"
+	for _, a := range sd.Attributes {
+		if isDirection(a.Key) {
+			direction = a.Key
+		}
+	}
+
+	for _, md := range sd.MediaDescriptions {
+		if md.MediaName.Media != "audio" {
+			continue
+		}
+		r := &remoteMedia{direction: direction, dtmfPT: -1}
+		ip := sessionIP
+		if md.ConnectionInformation != nil && md.ConnectionInformation.Address != nil {
+			ip = md.ConnectionInformation.Address.Address
+		}
+		if parsed := net.ParseIP(ip); parsed != nil && !parsed.IsUnspecified() && md.MediaName.Port.Value > 0 {
+			r.addr = &net.UDPAddr{IP: parsed, Port: md.MediaName.Port.Value}
+			r.rtcpAddr = &net.UDPAddr{IP: parsed, Port: md.MediaName.Port.Value + 1}
+		}
+
+		names := make(map[uint8]string)
+		for _, a := range md.Attributes {
+			switch {
+			case isDirection(a.Key):
+				r.direction = a.Key
+			case a.Key == "rtcp-mux" && r.addr != nil:
+				r.rtcpAddr = r.addr
+			case a.Key == "rtpmap":
+				fields := strings.Fields(a.Value)
+				if len(fields) != 2 {
+					continue
+				}
+				pt, err := strconv.ParseUint(fields[0], 10, 8)
+				if err != nil {
+					continue
+				}
+				names[uint8(pt)] = strings.ToUpper(strings.Split(fields[1], "/")[0])
+			}
+		}
+		for _, f := range md.MediaName.Formats {
+			pt, err := strconv.ParseUint(f, 10, 8)
+			if err != nil {
+				continue
+			}
+			name, ok := names[uint8(pt)]
+			if !ok {
+				// 静态payload type可以没有rtpmap.
+				for _, c := range supportedCodecs {
+					if c.PayloadType == uint8(pt) {
+						name = c.Name
+					}
+				}
+			}
+			if name == "TELEPHONE-EVENT" {
+				r.dtmfPT = int(pt)
+				continue
+			}
+			r.codecs = append(r.codecs, Codec{PayloadType: uint8(pt), Name: name, ClockRate: 8000})
+		}
+		return r, nil
+	}
+	return nil, errInvalidSDP
+}
+
+// selectCodec 按对端的顺序选第一个本端支持的编码.
+func (r *remoteMedia) selectCodec() (Codec, error) {
+	for _, c := range r.codecs {
+		for _, s := range supportedCodecs {
+			if strings.EqualFold(c.Name, s.Name) {
+				return c, nil
+			}
+		}
+	}
+	return Codec{}, errNoCodec
+}
+
+// answerDirection 对offer中方向的应答.
+func answerDirection(offer string) string {
+	switch offer {
+	case "sendonly":
+		return "recvonly"
+	case "recvonly":
+		return "sendonly"
+	case "inactive":
+		return "inactive"
+	}
+	return "sendrecv"
+}
+
+// localSDP 生成本端SDP, codecs为空时带上全部支持的编码(offer).
+func localSDP(ip string, port int, codecs []Codec, dtmfPT int, direction string, version uint64) []byte {
+	if len(codecs) == 0 {
+		codecs = supportedCodecs
+	}
+	formats := make([]string, 0, len(codecs)+1)
+	attrs := make([]sdp.Attribute, 0, len(codecs)+4)
+	for _, c := range codecs {
+		formats = append(formats, strconv.Itoa(int(c.PayloadType)))
+		attrs = append(attrs, sdp.NewAttribute("rtpmap", fmt.Sprintf("%d %s/%d", c.PayloadType, c.Name, c.ClockRate)))
+	}
+	if dtmfPT >= 0 {
+		formats = append(formats, strconv.Itoa(dtmfPT))
+		attrs = append(attrs,
+			sdp.NewAttribute("rtpmap", fmt.Sprintf("%d telephone-event/8000", dtmfPT)),
+			sdp.NewAttribute("fmtp", fmt.Sprintf("%d 0-16", dtmfPT)))
+	}
+	attrs = append(attrs, sdp.NewAttribute("ptime", "20"), sdp.NewPropertyAttribute(direction))
+
+	addrType := "IP4"
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		addrType = "IP6"
+	}
+	sd := &sdp.SessionDescription{
+		Origin: sdp.Origin{
+			Username:       "-",
+			SessionID:      uint64(time.Now().Unix()),
+			SessionVersion: version,
+			NetworkType:    "IN",
+			AddressType:    addrType,
+			UnicastAddress: ip,
+		},
+		SessionName: "mediasfu",
+		ConnectionInformation: &sdp.ConnectionInformation{
+			NetworkType: "IN",
+			AddressType: addrType,
+			Address:     &sdp.Address{Address: ip},
+		},
+		TimeDescriptions: []sdp.TimeDescription{{}},
+		MediaDescriptions: []*sdp.MediaDescription{{
+			MediaName: sdp.MediaName{
+				Media:   "audio",
+				Port:    sdp.RangedPort{Value: port},
+				Protos:  []string{"RTP", "AVP"},
+				Formats: formats,
+			},
+			Attributes: attrs,
+		}},
+	}
+	b, _ := sd.Marshal()
+	return b
+}
+
+func isDirection(key string) bool {
+	switch key {
+	case "sendrecv", "sendonly", "recvonly", "inactive":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package sip
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	transportUDP = "UDP"
+	transportTCP = "TCP"
+
+	tcpDialTimeout = 5 * time.Second
+)
+
+var errUnsupportedTransport = errors.New("unsupported sip transport")
+
+// Addr 消息的来源或目的地址, tcp时conn为已建立的连接.
+type Addr struct {
+	Transport string
+	Addr      string
+	conn      net.Conn
+}
+
+func (a Addr) String() string {
+	return strings.ToLower(a.Transport) + ":" + a.Addr
+}
+
+func (a Addr) reliable() bool { return a.Transport == transportTCP }
+
+// transport 收发udp/tcp上的sip消息.
+type transport struct {
+	udp *net.UDPConn
+	tcp net.Listener
+
+	sync.Mutex
+	conns map[string]net.Conn // tcp连接, key为对端地址
+
+	handler func(m *Message, from Addr)
+	done    chan struct{}
+}
+
+func newTransport(handler func(m *Message, from Addr)) *transport {
+	return &transport{
+		conns:   make(map[string]net.Conn),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+}
+
+func (t *transport) listenUDP(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	if t.udp, err = net.ListenUDP("udp", udpAddr); err != nil {
+		return err
+	}
+	go t.readUDP()
+	return nil
+}
+
+func (t *transport) listenTCP(addr string) error {
+	var err error
+	if t.tcp, err = net.Listen("tcp", addr); err != nil {
+		return err
+	}
+	go t.accept()
+	return nil
+}
+
+func (t *transport) readUDP() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, src, err := t.udp.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			Logger.Error(err, "sip udp read err")
+			continue
+		}
+		// 4字节以内的是keepalive.
+		if n <= 4 {
+			continue
+		}
+		m, err := ParseMessage(buf[:n])
+		if err != nil {
+			Logger.Info("invalid sip message", "from", src.String(), "err", err.Error())
+			continue
+		}
+		go t.handler(m, Addr{Transport: transportUDP, Addr: src.String()})
+	}
+}
+
+func (t *transport) accept() {
+	for {
+		conn, err := t.tcp.Accept()
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			Logger.Error(err, "sip tcp accept err")
+			continue
+		}
+		t.Lock()
+		t.conns[conn.RemoteAddr().String()] = conn
+		t.Unlock()
+		go t.readTCP(conn)
+	}
+}
+
+func (t *transport) readTCP(conn net.Conn) {
+	remote := conn.RemoteAddr().String()
+	defer func() {
+		t.Lock()
+		if t.conns[remote] == conn {
+			delete(t.conns, remote)
+		}
+		t.Unlock()
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		m, err := readMessage(r, false)
+		if err != nil {
+			if err != io.EOF {
+				Logger.Info("sip tcp connection closed", "remote", remote, "err", err.Error())
+			}
+			return
+		}
+		go t.handler(m, Addr{Transport: transportTCP, Addr: remote, conn: conn})
+	}
+}
+
+func (t *transport) send(m *Message, to Addr) error {
+	b := m.Bytes()
+	switch to.Transport {
+	case transportUDP:
+		addr, err := net.ResolveUDPAddr("udp", to.Addr)
+		if err != nil {
+			return err
+		}
+		_, err = t.udp.WriteToUDP(b, addr)
+		return err
+	case transportTCP:
+		conn, err := t.tcpConn(to)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write(b)
+		return err
+	}
+	return errUnsupportedTransport
+}
+
+func (t *transport) tcpConn(to Addr) (net.Conn, error) {
+	if to.conn != nil {
+		return to.conn, nil
+	}
+	t.Lock()
+	conn, ok := t.conns[to.Addr]
+	t.Unlock()
+	if ok {
+		return conn, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", to.Addr, tcpDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	t.conns[to.Addr] = conn
+	t.Unlock()
+	go t.readTCP(conn)
+	return conn, nil
+}
+
+// localAddr 写入Via/Contact的本机地址.
+func (t *transport) localAddr(transport string) (string, int) {
+	var addr net.Addr
+	switch transport {
+	case transportTCP:
+		if t.tcp != nil {
+			addr = t.tcp.Addr()
+		}
+	default:
+		if t.udp != nil {
+			addr = t.udp.LocalAddr()
+		}
+	}
+	if addr == nil {
+		return "", 0
+	}
+	host, port, _ := net.SplitHostPort(addr.String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func (t *transport) close() {
+	close(t.done)
+	if t.udp != nil {
+		_ = t.udp.Close()
+	}
+	if t.tcp != nil {
+		_ = t.tcp.Close()
+	}
+	t.Lock()
+	for _, conn := range t.conns {
+		_ = conn.Close()
+	}
+	t.Unlock()
+}
+
+// parseURI 解析 sip:user@host[:port][;transport=tcp], 返回user和发送地址.
+func parseURI(uri string) (user string, to Addr, err error) {
+	uri = addrURI(uri)
+	if !strings.HasPrefix(strings.ToLower(uri), "sip:") {
+		return "", Addr{}, errInvalidURI
+	}
+	rest := uri[4:]
+	params := ""
+	if idx := strings.IndexByte(rest, ';'); idx >= 0 {
+		rest, params = rest[:idx], rest[idx:]
+	}
+	if idx := strings.IndexByte(rest, '?'); idx >= 0 {
+		rest = rest[:idx]
+	}
+	if idx := strings.LastIndexByte(rest, '@'); idx >= 0 {
+		user, rest = rest[:idx], rest[idx+1:]
+		if i := strings.IndexByte(user, ':'); i >= 0 {
+			user = user[:i]
+		}
+	}
+	if rest == "" {
+		return "", Addr{}, errInvalidURI
+	}
+	host, port := rest, "5060"
+	if h, p, splitErr := net.SplitHostPort(rest); splitErr == nil {
+		host, port = h, p
+	}
+
+	to = Addr{Transport: transportUDP, Addr: net.JoinHostPort(strings.Trim(host, "[]"), port)}
+	if strings.EqualFold(headerParam(params, "transport"), "tcp") {
+		to.Transport = transportTCP
+	}
+	return user, to, nil
+}
@@ -0,0 +1,799 @@
+package sip
+
+import (
+	log "common/log/newlog"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/rtpengine"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// 最小化的sip用户代理: udp/tcp传输, 呼入呼出INVITE, re-INVITE/UPDATE(hold、换编码), BYE/CANCEL.
+// 媒体使用rtpengine.Leg, 呼叫接入哪个会话由Request-URI的user部分决定.
+
+const (
+	t1          = 500 * time.Millisecond
+	t2          = 4 * time.Second
+	timerB      = 64 * t1
+	maxForwards = "70"
+	branchMagic = "z9hG4bK"
+	allow       = "INVITE, ACK, CANCEL, BYE, UPDATE, OPTIONS"
+)
+
+var (
+	// Logger is an implementation of log.Logger. If is not provided - will be turned off.
+	Logger log.Logger = log.GetLogger()
+
+	// ErrSessionNotFound is returned by the OnInvite handler to reject with 404
+	ErrSessionNotFound = errors.New("session not found")
+
+	errInvalidURI     = errors.New("invalid sip uri")
+	errTimeout        = errors.New("sip transaction timeout")
+	errCallTerminated = errors.New("call terminated")
+	errNoListener     = errors.New("no sip listener for transport")
+)
+
+// StatusError is a final non 2xx response to an outbound INVITE
+type StatusError struct {
+	Code   int
+	Reason string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("sip %d %s", e.Code, e.Reason)
+}
+
+// Config defines the sip user agent options
+type Config struct {
+	// UDPAddr TCPAddr 监听地址, 为空不监听.
+	UDPAddr string
+	TCPAddr string
+	// Host 写入Via/Contact的地址, 为空时使用监听地址.
+	Host string
+	// MediaIP 写入SDP的地址.
+	MediaIP      string
+	UserAgent    string
+	MediaTimeout time.Duration
+	// Events 发布dtmf和媒体超时事件, bill-id为Call-ID.
+	Events *events.Bus
+}
+
+// UA is a sip user agent, media of every call is a rtpengine.Leg
+type UA struct {
+	sync.RWMutex
+	cfg           Config
+	pool          *rtpengine.PortPool
+	bufferFactory *buffer.Factory
+	tp            *transport
+
+	calls    map[string]*Call
+	clientTx map[string]*clientTx // key: branch/method
+	serverTx map[string]*serverTx // key: branch/method
+
+	onInvite     atomic.Value // func(*Call) error
+	onAnswer     atomic.Value // func(*Call) error
+	onTerminated atomic.Value // func(*Call, string)
+}
+
+// NewUA creates a sip user agent allocating media ports from pool
+func NewUA(cfg Config, pool *rtpengine.PortPool, bf *buffer.Factory) *UA {
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "mediasfu"
+	}
+	ua := &UA{
+		cfg:           cfg,
+		pool:          pool,
+		bufferFactory: bf,
+		calls:         make(map[string]*Call),
+		clientTx:      make(map[string]*clientTx),
+		serverTx:      make(map[string]*serverTx),
+	}
+	ua.tp = newTransport(ua.handle)
+	return ua
+}
+
+// Start starts the listeners
+func (ua *UA) Start() error {
+	if ua.cfg.UDPAddr != "" {
+		if err := ua.tp.listenUDP(ua.cfg.UDPAddr); err != nil {
+			return err
+		}
+	}
+	if ua.cfg.TCPAddr != "" {
+		if err := ua.tp.listenTCP(ua.cfg.TCPAddr); err != nil {
+			return err
+		}
+	}
+	Logger.Info("sip ua started", "udp", ua.cfg.UDPAddr, "tcp", ua.cfg.TCPAddr)
+	return nil
+}
+
+// Close hangs up all calls and stops the listeners
+func (ua *UA) Close() error {
+	for _, c := range ua.Calls() {
+		_ = c.Hangup()
+	}
+	ua.tp.close()
+	return nil
+}
+
+// OnInvite is called for every new inbound call before it is answered,
+// returning an error rejects the call (ErrSessionNotFound with 404, others with 603).
+func (ua *UA) OnInvite(f func(c *Call) error) {
+	ua.onInvite.Store(f)
+}
+
+// OnAnswer is called when an outbound call is answered, returning an error hangs up the call
+func (ua *UA) OnAnswer(f func(c *Call) error) {
+	ua.onAnswer.Store(f)
+}
+
+// OnTerminated is called once for every call, inbound or outbound, when it ends
+func (ua *UA) OnTerminated(f func(c *Call, reason string)) {
+	ua.onTerminated.Store(f)
+}
+
+// Calls returns all active calls
+func (ua *UA) Calls() []*Call {
+	ua.RLock()
+	defer ua.RUnlock()
+	calls := make([]*Call, 0, len(ua.calls))
+	for _, c := range ua.calls {
+		calls = append(calls, c)
+	}
+	return calls
+}
+
+// Call returns the call by Call-ID
+func (ua *UA) Call(id string) *Call {
+	ua.RLock()
+	defer ua.RUnlock()
+	return ua.calls[id]
+}
+
+// Invite places an outbound call to target on behalf of session, it returns once the call is answered.
+// Cancelling ctx before the answer sends CANCEL.
+func (ua *UA) Invite(ctx context.Context, target, session string) (*Call, error) {
+	_, to, err := parseURI(target)
+	if err != nil {
+		return nil, err
+	}
+	if ua.hostport(to.Transport) == "" {
+		return nil, errNoListener
+	}
+	c, err := ua.newCall(newToken(16), session, "outbound", to)
+	if err != nil {
+		return nil, err
+	}
+	c.localAddr = fmt.Sprintf("<sip:%s@%s>", session, ua.hostport(to.Transport))
+	c.remoteAddr = "<" + addrURI(target) + ">"
+	c.remoteTarget = addrURI(target)
+
+	req := c.newRequest("INVITE")
+	req.SetHeader("Content-Type", "application/sdp")
+	req.Body = localSDP(ua.mediaIP(), c.leg.LocalPort(), nil, telephoneEventPT, "sendrecv", c.nextVersion())
+	c.invite = req
+	ua.addCall(c)
+
+	tx, err := ua.request(req, to)
+	if err != nil {
+		c.terminate("send failed")
+		return nil, err
+	}
+
+	cancelled := false
+	for {
+		select {
+		case <-ctx.Done():
+			if !cancelled {
+				cancelled = true
+				c.cancel()
+			}
+			ctx = context.Background()
+			continue
+		case resp, ok := <-tx.responses:
+			if !ok {
+				c.terminate("timeout")
+				return nil, errTimeout
+			}
+			switch {
+			case resp.StatusCode < 200:
+				c.early(resp)
+			case resp.StatusCode < 300:
+				if err = c.confirm(resp, tx); err != nil {
+					_ = c.Hangup()
+					return nil, err
+				}
+				if cancelled {
+					// CANCEL和200交叉, 挂断.
+					_ = c.Hangup()
+					return nil, errCallTerminated
+				}
+				if f, ok := ua.onAnswer.Load().(func(*Call) error); ok && f != nil {
+					if err = f(c); err != nil {
+						_ = c.Hangup()
+						return nil, err
+					}
+				}
+				return c, nil
+			default:
+				c.terminate(resp.Reason)
+				return nil, &StatusError{Code: resp.StatusCode, Reason: resp.Reason}
+			}
+		}
+	}
+}
+
+func (ua *UA) newCall(id, session, direction string, to Addr) (*Call, error) {
+	leg, err := rtpengine.NewLeg(id, ua.pool, ua.bufferFactory, rtpengine.LegConfig{
+		Latching:     true,
+		Relatch:      true,
+		MediaTimeout: ua.cfg.MediaTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := &Call{
+		ua:        ua,
+		id:        id,
+		session:   session,
+		direction: direction,
+		localTag:  newToken(8),
+		to:        to,
+		leg:       leg,
+		dtmfPT:    -1,
+		created:   time.Now(),
+		done:      make(chan struct{}),
+	}
+	leg.OnDTMF(func(digit string, duration time.Duration) {
+		ua.cfg.Events.Publish(events.TypeDTMF, session, id, events.DTMFData{
+			Digit:    digit,
+			Duration: duration.Milliseconds(),
+		})
+	})
+	leg.OnMediaTimeout(func() {
+		Logger.Info("sip call media timeout", "call_id", id)
+		ua.cfg.Events.Publish(events.TypeMediaTimeout, session, id, events.MediaTimeoutData{
+			LegID:   id,
+			Timeout: ua.cfg.MediaTimeout.Milliseconds(),
+		})
+		c.hangup("media timeout")
+	})
+	return c, nil
+}
+
+func (ua *UA) addCall(c *Call) {
+	ua.Lock()
+	ua.calls[c.id] = c
+	ua.Unlock()
+}
+
+func (ua *UA) removeCall(c *Call) {
+	ua.Lock()
+	if ua.calls[c.id] == c {
+		delete(ua.calls, c.id)
+	}
+	ua.Unlock()
+}
+
+// handle 处理收到的消息.
+func (ua *UA) handle(m *Message, from Addr) {
+	if !m.IsRequest() {
+		ua.handleResponse(m)
+		return
+	}
+
+	// 请求重传直接回复上次的响应.
+	key := m.Branch() + "/" + m.Method
+	ua.Lock()
+	if tx, ok := ua.serverTx[key]; ok && m.Method != "ACK" {
+		ua.Unlock()
+		if last := tx.lastResponse(); last != nil {
+			_ = ua.tp.send(last, tx.from)
+		}
+		return
+	}
+	tx := &serverTx{req: m, from: from}
+	if m.Method != "ACK" {
+		ua.serverTx[key] = tx
+	}
+	ua.Unlock()
+	if m.Method != "ACK" {
+		time.AfterFunc(timerB, func() {
+			ua.Lock()
+			delete(ua.serverTx, key)
+			ua.Unlock()
+		})
+	}
+
+	switch m.Method {
+	case "INVITE":
+		if m.ToTag() != "" {
+			ua.handleReinvite(tx)
+			return
+		}
+		ua.handleInvite(tx)
+	case "UPDATE":
+		ua.handleReinvite(tx)
+	case "ACK":
+		ua.handleACK(m)
+	case "BYE":
+		c := ua.dialog(m)
+		if c == nil {
+			ua.respond(tx, NewResponse(m, 481, ""))
+			return
+		}
+		ua.respond(tx, NewResponse(m, 200, ""))
+		c.terminate("remote bye")
+	case "CANCEL":
+		ua.handleCancel(tx)
+	case "OPTIONS":
+		resp := NewResponse(m, 200, "")
+		resp.SetHeader("Allow", allow)
+		ua.respond(tx, resp)
+	default:
+		resp := NewResponse(m, 405, "")
+		resp.SetHeader("Allow", allow)
+		ua.respond(tx, resp)
+	}
+}
+
+func (ua *UA) handleInvite(tx *serverTx) {
+	req := tx.req
+	ua.respond(tx, NewResponse(req, 100, ""))
+
+	remote, err := parseRemoteSDP(req.Body)
+	if err != nil {
+		ua.respond(tx, NewResponse(req, 488, ""))
+		return
+	}
+	codec, err := remote.selectCodec()
+	if err != nil {
+		ua.respond(tx, NewResponse(req, 488, ""))
+		return
+	}
+	session, _, err := parseURI(req.RequestURI)
+	if err != nil {
+		ua.respond(tx, NewResponse(req, 400, ""))
+		return
+	}
+
+	c, err := ua.newCall(req.CallID(), session, "inbound", tx.from)
+	if err != nil {
+		Logger.Error(err, "allocate rtp leg failed", "call_id", req.CallID())
+		ua.respond(tx, NewResponse(req, 503, ""))
+		return
+	}
+	c.remoteTag = req.FromTag()
+	c.remoteSeq, _ = req.CSeq()
+	c.localAddr = withoutTag(req.Header("To"))
+	c.remoteAddr = withoutTag(req.Header("From"))
+	c.remoteTarget = addrURI(req.Header("Contact"))
+	if c.remoteTarget == "" {
+		c.remoteTarget = addrURI(req.Header("From"))
+	}
+	c.routeSet = req.Headers("Record-Route")
+	c.inviteTx = tx
+	c.applyRemote(remote, codec, false)
+	ua.addCall(c)
+	Logger.Info("sip inbound call", "call_id", c.id, "session", session, "from", c.remoteAddr, "codec", codec.Name)
+
+	if f, ok := ua.onInvite.Load().(func(*Call) error); ok && f != nil {
+		if err = f(c); err != nil {
+			code := 603
+			if errors.Is(err, ErrSessionNotFound) {
+				code = 404
+			}
+			ua.respond(tx, c.response(req, code))
+			c.terminate(err.Error())
+			return
+		}
+	}
+
+	c.Lock()
+	if c.state == CallStateTerminated {
+		// 处理期间收到了CANCEL.
+		c.Unlock()
+		return
+	}
+	c.state = CallStateConfirmed
+	c.answered = time.Now()
+	resp := c.response(req, 200)
+	resp.SetHeader("Content-Type", "application/sdp")
+	resp.Body = localSDP(ua.mediaIP(), c.leg.LocalPort(), []Codec{codec}, remote.dtmfPT, answerDirection(remote.direction), c.nextVersion())
+	c.Unlock()
+	ua.respond2xx(tx, resp, c)
+}
+
+// handleReinvite 处理对话内的re-INVITE/UPDATE: hold、恢复和换编码.
+func (ua *UA) handleReinvite(tx *serverTx) {
+	req := tx.req
+	c := ua.dialog(req)
+	if c == nil {
+		ua.respond(tx, NewResponse(req, 481, ""))
+		return
+	}
+	seq, _ := req.CSeq()
+
+	c.Lock()
+	if seq <= c.remoteSeq {
+		c.Unlock()
+		ua.respond(tx, NewResponse(req, 500, ""))
+		return
+	}
+	c.remoteSeq = seq
+	if contact := addrURI(req.Header("Contact")); contact != "" {
+		c.remoteTarget = contact
+	}
+	c.Unlock()
+
+	resp := c.response(req, 200)
+	if len(req.Body) == 0 {
+		if req.Method == "INVITE" {
+			// 没有offer的re-INVITE, 在200里带offer, answer在ACK里.
+			c.Lock()
+			resp.SetHeader("Content-Type", "application/sdp")
+			resp.Body = localSDP(ua.mediaIP(), c.leg.LocalPort(), nil, telephoneEventPT, "sendrecv", c.nextVersion())
+			c.Unlock()
+			ua.respond2xx(tx, resp, c)
+			return
+		}
+		// session timer刷新.
+		ua.respond(tx, resp)
+		return
+	}
+
+	remote, err := parseRemoteSDP(req.Body)
+	if err != nil {
+		ua.respond(tx, NewResponse(req, 488, ""))
+		return
+	}
+	codec, err := remote.selectCodec()
+	if err != nil {
+		ua.respond(tx, NewResponse(req, 488, ""))
+		return
+	}
+	c.applyRemote(remote, codec, true)
+
+	c.Lock()
+	resp.SetHeader("Content-Type", "application/sdp")
+	resp.Body = localSDP(ua.mediaIP(), c.leg.LocalPort(), []Codec{codec}, remote.dtmfPT, answerDirection(remote.direction), c.nextVersion())
+	c.Unlock()
+	if req.Method == "INVITE" {
+		ua.respond2xx(tx, resp, c)
+		return
+	}
+	ua.respond(tx, resp)
+}
+
+func (ua *UA) handleACK(m *Message) {
+	// 非2xx的ACK和INVITE同一个事务, 不需要处理.
+	c := ua.dialog(m)
+	if c == nil {
+		return
+	}
+	c.ack()
+	if len(m.Body) == 0 {
+		return
+	}
+	remote, err := parseRemoteSDP(m.Body)
+	if err != nil {
+		return
+	}
+	if codec, err := remote.selectCodec(); err == nil {
+		c.applyRemote(remote, codec, true)
+	}
+}
+
+func (ua *UA) handleCancel(tx *serverTx) {
+	req := tx.req
+	ua.RLock()
+	inviteTx, ok := ua.serverTx[req.Branch()+"/INVITE"]
+	c := ua.calls[req.CallID()]
+	ua.RUnlock()
+	if !ok {
+		ua.respond(tx, NewResponse(req, 481, ""))
+		return
+	}
+	ua.respond(tx, NewResponse(req, 200, ""))
+	if c == nil {
+		return
+	}
+	c.Lock()
+	answered := c.state == CallStateConfirmed
+	if !answered {
+		c.state = CallStateTerminated
+	}
+	c.Unlock()
+	if answered {
+		return
+	}
+	ua.respond(inviteTx, c.response(inviteTx.req, 487))
+	c.terminate("remote cancel")
+}
+
+func (ua *UA) handleResponse(m *Message) {
+	_, method := m.CSeq()
+	key := m.Branch() + "/" + method
+	ua.RLock()
+	tx, ok := ua.clientTx[key]
+	ua.RUnlock()
+	if !ok {
+		return
+	}
+	if method == "INVITE" && m.StatusCode >= 300 && atomic.LoadInt32(&tx.final) == 0 {
+		tx.sendACK(tx.failureACK(m), tx.to)
+	}
+	tx.receive(m)
+}
+
+// dialog 按Call-ID和tag找到对话.
+func (ua *UA) dialog(m *Message) *Call {
+	c := ua.Call(m.CallID())
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.remoteTag != "" && m.FromTag() != c.remoteTag && m.ToTag() != c.remoteTag {
+		return nil
+	}
+	return c
+}
+
+// request 发送请求并创建客户端事务, udp时按T1重传.
+func (ua *UA) request(req *Message, to Addr) (*clientTx, error) {
+	_, method := req.CSeq()
+	tx := &clientTx{
+		ua:        ua,
+		req:       req,
+		to:        to,
+		key:       req.Branch() + "/" + method,
+		responses: make(chan *Message, 8),
+		done:      make(chan struct{}),
+	}
+	ua.Lock()
+	ua.clientTx[tx.key] = tx
+	ua.Unlock()
+
+	if err := ua.tp.send(req, to); err != nil {
+		tx.finish()
+		return nil, err
+	}
+	go tx.run(method)
+	return tx, nil
+}
+
+func (ua *UA) respond(tx *serverTx, resp *Message) {
+	tx.setResponse(resp)
+	if err := ua.tp.send(resp, tx.from); err != nil {
+		Logger.Error(err, "send sip response failed", "call_id", resp.CallID(), "to", tx.from.String())
+	}
+}
+
+// respond2xx udp上重传INVITE的200直到收到ACK.
+func (ua *UA) respond2xx(tx *serverTx, resp *Message, c *Call) {
+	acked := c.resetAck()
+	ua.respond(tx, resp)
+	if tx.from.reliable() {
+		return
+	}
+	go func() {
+		interval := t1
+		deadline := time.After(timerB)
+		for {
+			select {
+			case <-acked:
+				return
+			case <-c.done:
+				return
+			case <-deadline:
+				Logger.Info("sip ack timeout", "call_id", c.id)
+				c.hangup("ack timeout")
+				return
+			case <-time.After(interval):
+			}
+			_ = ua.tp.send(resp, tx.from)
+			if interval *= 2; interval > t2 {
+				interval = t2
+			}
+		}
+	}()
+}
+
+func (ua *UA) hostport(transport string) string {
+	host, port := ua.tp.localAddr(transport)
+	if port == 0 {
+		return ""
+	}
+	if ua.cfg.Host != "" {
+		host = ua.cfg.Host
+	} else if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = ua.mediaIP()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func (ua *UA) mediaIP() string {
+	if ua.cfg.MediaIP != "" {
+		return ua.cfg.MediaIP
+	}
+	if ua.cfg.Host != "" {
+		return ua.cfg.Host
+	}
+	return "127.0.0.1"
+}
+
+// clientTx 客户端事务.
+type clientTx struct {
+	ua        *UA
+	req       *Message
+	to        Addr
+	key       string
+	responses chan *Message
+	done      chan struct{}
+	once      sync.Once
+	got       int32 // 收到过响应
+	final     int32
+
+	ackMu sync.Mutex
+	ackTo Addr
+	ackM  *Message
+}
+
+func (tx *clientTx) run(method string) {
+	if !tx.to.reliable() {
+		interval := t1
+		deadline := time.After(timerB)
+	retransmit:
+		for atomic.LoadInt32(&tx.got) == 0 {
+			select {
+			case <-tx.done:
+				return
+			case <-deadline:
+				break retransmit
+			case <-time.After(interval):
+			}
+			if atomic.LoadInt32(&tx.got) != 0 {
+				break
+			}
+			_ = tx.ua.tp.send(tx.req, tx.to)
+			interval *= 2
+			if method != "INVITE" && interval > t2 {
+				interval = t2
+			}
+		}
+	}
+
+	// 等待最终响应; INVITE振铃时间不限, 其他请求最多timerB.
+	timeout := time.NewTimer(timerB)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-tx.done:
+			return
+		case <-timeout.C:
+			if method == "INVITE" && atomic.LoadInt32(&tx.got) != 0 && atomic.LoadInt32(&tx.final) == 0 {
+				timeout.Reset(timerB)
+				continue
+			}
+			tx.finish()
+			return
+		}
+	}
+}
+
+func (tx *clientTx) receive(m *Message) {
+	atomic.StoreInt32(&tx.got, 1)
+	if m.StatusCode >= 200 {
+		if !atomic.CompareAndSwapInt32(&tx.final, 0, 1) {
+			// 最终响应的重传, 重发ACK.
+			tx.ackMu.Lock()
+			ack, to := tx.ackM, tx.ackTo
+			tx.ackMu.Unlock()
+			if ack != nil {
+				_ = tx.ua.tp.send(ack, to)
+			}
+			return
+		}
+	}
+	select {
+	case tx.responses <- m:
+	case <-tx.done:
+	}
+	if m.StatusCode >= 200 {
+		// 保留一段时间用于吸收最终响应的重传.
+		time.AfterFunc(timerB, tx.finish)
+	}
+}
+
+// failureACK 非2xx最终响应的ACK, 和INVITE属于同一个事务.
+func (tx *clientTx) failureACK(resp *Message) *Message {
+	ack := NewRequest("ACK", tx.req.RequestURI)
+	ack.AddHeader("Via", tx.req.Header("Via"))
+	ack.AddHeader("Max-Forwards", maxForwards)
+	for _, r := range tx.req.Headers("Route") {
+		ack.AddHeader("Route", r)
+	}
+	ack.AddHeader("From", tx.req.Header("From"))
+	ack.AddHeader("To", resp.Header("To"))
+	ack.AddHeader("Call-ID", tx.req.CallID())
+	seq, _ := tx.req.CSeq()
+	ack.AddHeader("CSeq", fmt.Sprintf("%d ACK", seq))
+	return ack
+}
+
+// sendACK 发送ACK并保存, 收到最终响应的重传时重发.
+func (tx *clientTx) sendACK(ack *Message, to Addr) {
+	tx.ackMu.Lock()
+	tx.ackM, tx.ackTo = ack, to
+	tx.ackMu.Unlock()
+	_ = tx.ua.tp.send(ack, to)
+}
+
+func (tx *clientTx) finish() {
+	tx.once.Do(func() {
+		close(tx.done)
+		tx.ua.Lock()
+		delete(tx.ua.clientTx, tx.key)
+		tx.ua.Unlock()
+		if atomic.LoadInt32(&tx.final) == 0 {
+			close(tx.responses)
+		}
+	})
+}
+
+// serverTx 服务端事务, 保存最后一个响应用于重传.
+type serverTx struct {
+	sync.Mutex
+	req  *Message
+	from Addr
+	last *Message
+}
+
+func (tx *serverTx) setResponse(m *Message) {
+	tx.Lock()
+	tx.last = m
+	tx.Unlock()
+}
+
+func (tx *serverTx) lastResponse() *Message {
+	tx.Lock()
+	defer tx.Unlock()
+	return tx.last
+}
+
+func newToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newBranch() string {
+	return branchMagic + newToken(8)
+}
+
+// withoutTag 去掉name-addr后的tag参数.
+func withoutTag(v string) string {
+	end := strings.LastIndexByte(v, '>')
+	params := strings.Split(v[end+1:], ";")
+	out := v[:end+1] + params[0]
+	for _, p := range params[1:] {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(p)), "tag=") {
+			continue
+		}
+		out += ";" + p
+	}
+	return strings.TrimSpace(out)
+}
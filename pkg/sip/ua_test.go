@@ -0,0 +1,343 @@
+package sip
+
+import (
+	log "common/log/newlog"
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/rtpengine"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+var (
+	pcmu = supportedCodecs[0]
+	pcma = supportedCodecs[1]
+)
+
+func newTestUA(t *testing.T, minPort, maxPort int) *UA {
+	ua := NewUA(Config{UDPAddr: "127.0.0.1:0", MediaIP: "127.0.0.1"},
+		rtpengine.NewPortPool("127.0.0.1", minPort, maxPort), buffer.NewBufferFactory(100, log.GetLogger()))
+	require.NoError(t, ua.Start())
+	t.Cleanup(func() { _ = ua.Close() })
+	return ua
+}
+
+// testPeer 模拟对端sip终端, 通过udp和ua收发消息.
+type testPeer struct {
+	t    *testing.T
+	conn *net.UDPConn
+	ua   *net.UDPAddr
+}
+
+func newTestPeer(t *testing.T, ua *UA) *testPeer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_, port := ua.tp.localAddr(transportUDP)
+	return &testPeer{t: t, conn: conn, ua: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}
+}
+
+func (p *testPeer) addr() string { return p.conn.LocalAddr().String() }
+
+func (p *testPeer) send(m *Message) {
+	_, err := p.conn.WriteToUDP(m.Bytes(), p.ua)
+	require.NoError(p.t, err)
+}
+
+// recv 读取下一个消息, 超时返回nil.
+func (p *testPeer) recv(timeout time.Duration) *Message {
+	buf := make([]byte, maxMessageSize)
+	require.NoError(p.t, p.conn.SetReadDeadline(time.Now().Add(timeout)))
+	n, err := p.conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	m, err := ParseMessage(buf[:n])
+	require.NoError(p.t, err)
+	return m
+}
+
+// response 等待method的code响应, 跳过临时响应和重传.
+func (p *testPeer) response(method string, code int) *Message {
+	for {
+		m := p.recv(2 * time.Second)
+		require.NotNil(p.t, m, "no %d for %s", code, method)
+		if _, cseqMethod := m.CSeq(); !m.IsRequest() && cseqMethod == method && m.StatusCode == code {
+			return m
+		}
+	}
+}
+
+// request 等待ua发来的method请求.
+func (p *testPeer) request(method string) *Message {
+	for {
+		m := p.recv(2 * time.Second)
+		require.NotNil(p.t, m, "no %s", method)
+		if m.Method == method {
+			return m
+		}
+	}
+}
+
+// newRequest 对端发往会话room1的请求, toTag为空表示对话外.
+func (p *testPeer) newRequest(method, callID, fromTag, toTag string, seq int) *Message {
+	req := NewRequest(method, "sip:room1@"+p.ua.String())
+	req.AddHeader("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=%s;rport", p.addr(), newBranch()))
+	req.AddHeader("Max-Forwards", maxForwards)
+	req.AddHeader("From", "<sip:alice@"+p.addr()+">;tag="+fromTag)
+	to := "<sip:room1@" + p.ua.String() + ">"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	req.AddHeader("To", to)
+	req.AddHeader("Call-ID", callID)
+	req.AddHeader("CSeq", fmt.Sprintf("%d %s", seq, method))
+	req.AddHeader("Contact", "<sip:alice@"+p.addr()+">")
+	return req
+}
+
+func withSDP(m *Message, direction string, codecs ...Codec) *Message {
+	m.SetHeader("Content-Type", "application/sdp")
+	m.Body = localSDP("127.0.0.1", 40000, codecs, telephoneEventPT, direction, 1)
+	return m
+}
+
+func answer(t *testing.T, resp *Message) *remoteMedia {
+	remote, err := parseRemoteSDP(resp.Body)
+	require.NoError(t, err)
+	return remote
+}
+
+func TestUA_InboundCall(t *testing.T) {
+	ua := newTestUA(t, 42600, 42610)
+	invited := make(chan *Call, 1)
+	ua.OnInvite(func(c *Call) error {
+		invited <- c
+		return nil
+	})
+	p := newTestPeer(t, ua)
+
+	p.send(withSDP(p.newRequest("INVITE", "call-1", "a1", "", 1), "sendrecv", pcmu, pcma))
+	p.response("INVITE", 100)
+	ok := p.response("INVITE", 200)
+	toTag := ok.ToTag()
+	require.NotEmpty(t, toTag)
+	c := <-invited
+	assert.Equal(t, "room1", c.Session())
+	assert.Equal(t, "inbound", c.Direction())
+	assert.Equal(t, pcmu, c.Codec())
+	remote := answer(t, ok)
+	assert.Equal(t, []Codec{pcmu}, remote.codecs)
+	assert.Equal(t, "sendrecv", remote.direction)
+	assert.Equal(t, c.Leg().LocalPort(), remote.addr.Port)
+
+	// ACK后停止重传200.
+	p.send(p.newRequest("ACK", "call-1", "a1", toTag, 1))
+	assert.Eventually(t, func() bool {
+		c.Lock()
+		acked := c.acked
+		c.Unlock()
+		select {
+		case <-acked:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, CallStateConfirmed, c.State())
+
+	held := make(chan bool, 4)
+	codecs := make(chan Codec, 4)
+	c.OnHold(func(h bool) { held <- h })
+	c.OnCodecChange(func(codec Codec) { codecs <- codec })
+
+	// re-INVITE hold.
+	p.send(withSDP(p.newRequest("INVITE", "call-1", "a1", toTag, 2), "sendonly", pcmu))
+	assert.Equal(t, "recvonly", answer(t, p.response("INVITE", 200)).direction)
+	p.send(p.newRequest("ACK", "call-1", "a1", toTag, 2))
+	assert.True(t, <-held)
+	assert.True(t, c.Held())
+
+	// UPDATE inactive并换成PCMA, 仍然是hold.
+	p.send(withSDP(p.newRequest("UPDATE", "call-1", "a1", toTag, 3), "inactive", pcma))
+	remote = answer(t, p.response("UPDATE", 200))
+	assert.Equal(t, "inactive", remote.direction)
+	assert.Equal(t, []Codec{pcma}, remote.codecs)
+	assert.Equal(t, pcma, <-codecs)
+	assert.Empty(t, held)
+
+	// 恢复.
+	p.send(withSDP(p.newRequest("INVITE", "call-1", "a1", toTag, 4), "sendrecv", pcma))
+	assert.Equal(t, "sendrecv", answer(t, p.response("INVITE", 200)).direction)
+	p.send(p.newRequest("ACK", "call-1", "a1", toTag, 4))
+	assert.False(t, <-held)
+	assert.Empty(t, codecs)
+
+	// 旧的CSeq.
+	p.send(withSDP(p.newRequest("UPDATE", "call-1", "a1", toTag, 4), "sendonly", pcma))
+	p.response("UPDATE", 500)
+
+	terminated := make(chan string, 1)
+	c.OnTerminated(func(reason string) { terminated <- reason })
+	p.send(p.newRequest("BYE", "call-1", "a1", toTag, 5))
+	p.response("BYE", 200)
+	assert.Equal(t, "remote bye", <-terminated)
+	assert.Equal(t, CallStateTerminated, c.State())
+	assert.Empty(t, ua.Calls())
+
+	// 对话已经结束.
+	p.send(p.newRequest("BYE", "call-1", "a1", toTag, 6))
+	p.response("BYE", 481)
+}
+
+func TestUA_OutboundInvite(t *testing.T) {
+	ua := newTestUA(t, 42610, 42620)
+	answered := make(chan *Call, 1)
+	ua.OnAnswer(func(c *Call) error {
+		answered <- c
+		return nil
+	})
+	p := newTestPeer(t, ua)
+
+	type result struct {
+		c   *Call
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := ua.Invite(context.Background(), "sip:bob@"+p.addr(), "room1")
+		done <- result{c, err}
+	}()
+
+	inv := p.request("INVITE")
+	assert.Equal(t, "sip:bob@"+p.addr(), inv.RequestURI)
+	offer := answer(t, inv)
+	assert.Equal(t, supportedCodecs, offer.codecs)
+	assert.Equal(t, telephoneEventPT, offer.dtmfPT)
+
+	ringing := NewResponse(inv, 180, "")
+	ringing.SetHeader("To", inv.Header("To")+";tag=b1")
+	p.send(ringing)
+	ok := NewResponse(inv, 200, "")
+	ok.SetHeader("To", inv.Header("To")+";tag=b1")
+	ok.SetHeader("Contact", "<sip:bob@"+p.addr()+">")
+	ok.SetHeader("Content-Type", "application/sdp")
+	ok.Body = localSDP("127.0.0.1", 40000, []Codec{pcma}, telephoneEventPT, "sendrecv", 1)
+	p.send(ok)
+
+	ack := p.request("ACK")
+	seq, method := ack.CSeq()
+	assert.Equal(t, uint32(1), seq)
+	assert.Equal(t, "ACK", method)
+	assert.Equal(t, "b1", ack.ToTag())
+	r := <-done
+	require.NoError(t, r.err)
+	c := r.c
+	assert.Equal(t, c, <-answered)
+	assert.Equal(t, "outbound", c.Direction())
+	assert.Equal(t, CallStateConfirmed, c.State())
+	assert.Equal(t, pcma, c.Codec())
+
+	// 200的重传再回ACK.
+	p.send(ok)
+	p.request("ACK")
+
+	require.NoError(t, c.Hangup())
+	bye := p.request("BYE")
+	seq, _ = bye.CSeq()
+	assert.Equal(t, uint32(2), seq)
+	assert.Equal(t, "b1", bye.ToTag())
+	assert.Equal(t, c.ID(), bye.CallID())
+	p.send(NewResponse(bye, 200, ""))
+	assert.Equal(t, CallStateTerminated, c.State())
+	assert.Empty(t, ua.Calls())
+}
+
+func TestUA_CancelBeforeAnswer(t *testing.T) {
+	ua := newTestUA(t, 42620, 42630)
+	invited := make(chan *Call, 1)
+	release := make(chan struct{})
+	ua.OnInvite(func(c *Call) error {
+		invited <- c
+		<-release
+		return nil
+	})
+	p := newTestPeer(t, ua)
+
+	inv := withSDP(p.newRequest("INVITE", "call-1", "a1", "", 1), "sendrecv", pcmu)
+	p.send(inv)
+	p.response("INVITE", 100)
+	c := <-invited
+	terminated := make(chan string, 1)
+	c.OnTerminated(func(reason string) { terminated <- reason })
+
+	cancel := NewRequest("CANCEL", inv.RequestURI)
+	for _, name := range []string{"Via", "Max-Forwards", "From", "To", "Call-ID"} {
+		cancel.AddHeader(name, inv.Header(name))
+	}
+	cancel.AddHeader("CSeq", "1 CANCEL")
+	p.send(cancel)
+	p.response("CANCEL", 200)
+	final := p.response("INVITE", 487)
+	assert.NotEmpty(t, final.ToTag())
+	assert.Equal(t, "remote cancel", <-terminated)
+	assert.Empty(t, ua.Calls())
+
+	// OnInvite返回后不再应答200.
+	close(release)
+	ack := NewRequest("ACK", inv.RequestURI)
+	for _, name := range []string{"Via", "Max-Forwards", "From", "Call-ID"} {
+		ack.AddHeader(name, inv.Header(name))
+	}
+	ack.AddHeader("To", final.Header("To"))
+	ack.AddHeader("CSeq", "1 ACK")
+	p.send(ack)
+	if m := p.recv(300 * time.Millisecond); m != nil {
+		assert.NotEqual(t, 200, m.StatusCode, m.String())
+	}
+}
+
+func TestUA_InviteRetransmission(t *testing.T) {
+	ua := newTestUA(t, 42630, 42640)
+	var invites int32
+	ua.OnInvite(func(c *Call) error {
+		atomic.AddInt32(&invites, 1)
+		return nil
+	})
+	p := newTestPeer(t, ua)
+
+	inv := withSDP(p.newRequest("INVITE", "call-1", "a1", "", 1), "sendrecv", pcmu)
+	p.send(inv)
+	first := p.response("INVITE", 200)
+
+	// 同一个branch的INVITE由服务端事务回复上次的响应.
+	p.send(inv)
+	again := p.response("INVITE", 200)
+	assert.Equal(t, first.ToTag(), again.ToTag())
+	assert.Equal(t, first.Body, again.Body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&invites))
+	assert.Len(t, ua.Calls(), 1)
+}
+
+func TestUA_UnknownDialog(t *testing.T) {
+	ua := newTestUA(t, 42640, 42650)
+	p := newTestPeer(t, ua)
+
+	p.send(withSDP(p.newRequest("INVITE", "unknown", "a1", "b1", 2), "sendonly", pcmu))
+	p.response("INVITE", 481)
+	p.send(withSDP(p.newRequest("UPDATE", "unknown", "a1", "b1", 3), "sendonly", pcmu))
+	p.response("UPDATE", 481)
+	p.send(p.newRequest("BYE", "unknown", "a1", "b1", 4))
+	p.response("BYE", 481)
+
+	// 没有对应INVITE事务的CANCEL.
+	p.send(p.newRequest("CANCEL", "unknown", "a1", "", 1))
+	p.response("CANCEL", 481)
+}
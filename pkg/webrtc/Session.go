@@ -1,5 +1,8 @@
 package webrtc
 
+import (
+	"sync"
+)
 
 // Session represents a set of peers. Transports inside a SessionLocal
 // are automatically subscribed to each other.
@@ -15,6 +18,7 @@ type Session interface {
 	GetPeer(peerID string) Peer // 获取peer,一个bill-id公用一个
 	RemovePeer(peer Peer) //获取声音检测
 	AudioObserver() *AudioObserver // 可选.
+	E2EE() bool // 端到端加密(insertable streams)会话, 不解析也不修改payload.
 
 	Peers() []Peer // 默认两个.
 }
@@ -27,3 +31,165 @@ type Session interface {
 
 
 // 一个会话管理多个peer.
+// SessionLocal 本节点的会话, 发布的track自动转发给会话中的其他peer.
+type SessionLocal struct {
+	mu            sync.RWMutex
+	id            string
+	config        WebRTCTransportConfig
+	peers         map[string]Peer
+	e2ee          bool
+	audioObserver *AudioObserver
+	closed        atomicBool
+	stop          chan struct{}
+
+	onCloseHandler func()
+}
+
+// NewSession creates a session, e2ee is decided by the first peer and never changes
+func NewSession(id string, e2ee bool, cfg WebRTCTransportConfig) *SessionLocal {
+	router := cfg.Router
+	if cfg.RouterFunc != nil {
+		router = cfg.RouterFunc()
+	}
+	s := &SessionLocal{
+		id:            id,
+		config:        cfg,
+		peers:         make(map[string]Peer),
+		e2ee:          e2ee,
+		audioObserver: NewAudioObserver(router.AudioLevelThreshold, router.AudioLevelInterval, router.AudioLevelFilter),
+		stop:          make(chan struct{}),
+	}
+	// 说话者检测随会话启动, 会话关闭时停止.
+	go ObserveActiveSpeakers(s, cfg.Events, router.AudioLevelInterval, s.stop)
+	return s
+}
+
+// ID -.
+func (s *SessionLocal) ID() string {
+	return s.id
+}
+
+// E2EE -.
+func (s *SessionLocal) E2EE() bool {
+	return s.e2ee
+}
+
+// AudioObserver -.
+func (s *SessionLocal) AudioObserver() *AudioObserver {
+	return s.audioObserver
+}
+
+// OnClose is called when the last peer leaves
+func (s *SessionLocal) OnClose(f func()) {
+	s.onCloseHandler = f
+}
+
+// AddPeer 同id的peer被替换.
+func (s *SessionLocal) AddPeer(peer Peer) {
+	s.mu.Lock()
+	s.peers[peer.ID()] = peer
+	s.mu.Unlock()
+}
+
+// GetPeer -.
+func (s *SessionLocal) GetPeer(peerID string) Peer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.peers[peerID]
+}
+
+// RemovePeer 最后一个peer离开时关闭会话.
+func (s *SessionLocal) RemovePeer(peer Peer) {
+	s.mu.Lock()
+	if s.peers[peer.ID()] == peer {
+		delete(s.peers, peer.ID())
+	}
+	empty := len(s.peers) == 0
+	s.mu.Unlock()
+
+	Logger.Info("RemovePeer from session", "peer_id", peer.ID(), "session_id", s.id)
+	if empty {
+		s.Close()
+	}
+}
+
+// Close stops the speaker observer and calls the OnClose handler once
+func (s *SessionLocal) Close() {
+	if !s.closed.set(true) {
+		return
+	}
+	close(s.stop)
+	if s.onCloseHandler != nil {
+		s.onCloseHandler()
+	}
+}
+
+// Publish 把router的新track转发给会话中的其他peer.
+func (s *SessionLocal) Publish(router Router, r Receiver) {
+	for _, p := range s.Peers() {
+		// 不订阅自己发布的track.
+		if router.ID() == p.ID() {
+			continue
+		}
+		if rp, ok := p.(*RTPPeer); ok {
+			rp.subscribe(r)
+			continue
+		}
+		if p.Subscriber() == nil {
+			continue
+		}
+
+		Logger.Info("Publishing track to peer", "peer_id", p.ID())
+		if err := router.AddDownTracks(p.Subscriber(), r); err != nil {
+			Logger.Error(err, "Error subscribing transport to Router")
+			continue
+		}
+	}
+}
+
+// Subscribe 把会话中其他peer已经发布的track转发给peer.
+func (s *SessionLocal) Subscribe(peer Peer) {
+	rp, rtp := peer.(*RTPPeer)
+	if !rtp && peer.Subscriber() == nil {
+		return
+	}
+	for _, p := range s.Peers() {
+		router := peerRouter(p)
+		if p == peer || router == nil {
+			continue
+		}
+		if rtp {
+			for _, r := range router.Receivers() {
+				rp.subscribe(r)
+			}
+			continue
+		}
+
+		if err := router.AddDownTracks(peer.Subscriber(), nil); err != nil {
+			Logger.Error(err, "Subscribing to Router err")
+			continue
+		}
+	}
+}
+
+// peerRouter 返回peer发布track的router, 还没有时为nil.
+func peerRouter(p Peer) Router {
+	if rp, ok := p.(*RTPPeer); ok {
+		return rp.router
+	}
+	if pub := p.Publisher(); pub != nil {
+		return pub.GetRouter()
+	}
+	return nil
+}
+
+// Peers -.
+func (s *SessionLocal) Peers() []Peer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make([]Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
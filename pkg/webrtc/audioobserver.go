@@ -3,6 +3,9 @@ package webrtc
 import (
 	"sort"
 	"sync"
+	"time"
+
+	"mediasfu/pkg/events"
 )
 
 type audioStream struct {
@@ -21,6 +24,13 @@ type AudioObserver struct {
 }
 
 func NewAudioObserver(threshold uint8, interval, filter int) *AudioObserver {
+	a := &AudioObserver{}
+	a.Configure(threshold, interval, filter)
+	return a
+}
+
+// Configure 修改声音检测的阈值和过滤比例, 配置热更新时调用.
+func (a *AudioObserver) Configure(threshold uint8, interval, filter int) {
 	if threshold > 127 {
 		threshold = 127
 	}
@@ -31,19 +41,21 @@ func NewAudioObserver(threshold uint8, interval, filter int) *AudioObserver {
 		filter = 100
 	}
 
-	return &AudioObserver{
-		threshold: threshold,
-		expected:  interval * filter / 2000,
-	}
+	a.Lock()
+	a.threshold = threshold
+	a.expected = interval * filter / 2000
+	a.Unlock()
 }
 
-func (a *AudioObserver) addStream(streamID string) {
+// AddStream 开始检测streamID的声音, webrtc发布的音频和rtp leg都按stream计算.
+func (a *AudioObserver) AddStream(streamID string) {
 	a.Lock()
 	a.streams = append(a.streams, &audioStream{id: streamID})
 	a.Unlock()
 }
 
-func (a *AudioObserver) removeStream(streamID string) {
+// RemoveStream -.
+func (a *AudioObserver) RemoveStream(streamID string) {
 	a.Lock()
 	defer a.Unlock()
 	idx := -1
@@ -61,7 +73,8 @@ func (a *AudioObserver) removeStream(streamID string) {
 	a.streams = a.streams[:len(a.streams)-1]
 }
 
-func (a *AudioObserver) observe(streamID string, dBov uint8) {
+// Observe 记录一个包的声音大小, dBov越小声音越大.
+func (a *AudioObserver) Observe(streamID string, dBov uint8) {
 	a.RLock()
 	defer a.RUnlock()
 	for _, as := range a.streams {
@@ -110,4 +123,24 @@ func (a *AudioObserver) Calc() []string {
 
 	a.previous = streamIDs
 	return streamIDs
-}
\ No newline at end of file
+}
+
+// ObserveActiveSpeakers 每interval毫秒计算一次session的说话者, 变化时发布speaker.active事件, 直到stop关闭.
+func ObserveActiveSpeakers(s Session, bus *events.Bus, interval int, stop <-chan struct{}) {
+	observer := s.AudioObserver()
+	if observer == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if speakers := observer.Calc(); speakers != nil {
+				bus.Publish(events.TypeActiveSpeaker, s.ID(), "", events.ActiveSpeakerData{Speakers: speakers})
+			}
+		}
+	}
+}
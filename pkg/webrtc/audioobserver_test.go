@@ -0,0 +1,102 @@
+package webrtc
+
+import (
+	log "common/log/newlog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func TestAudioObserver_Calc(t *testing.T) {
+	// 1000ms内至少有20%的包(按每20ms一个包)超过阈值才算说话.
+	a := NewAudioObserver(40, 1000, 20)
+	a.AddStream("a")
+	a.AddStream("b")
+	a.AddStream("c")
+	for i := 0; i < 10; i++ {
+		a.Observe("a", 30)
+		a.Observe("b", 10)
+		a.Observe("c", 90)
+	}
+	a.Observe("b", 10)
+	assert.Equal(t, []string{"b", "a"}, a.Calc())
+
+	// 没有变化时不返回.
+	for i := 0; i < 10; i++ {
+		a.Observe("a", 30)
+		a.Observe("b", 10)
+	}
+	assert.Nil(t, a.Calc())
+
+	a.RemoveStream("b")
+	a.Observe("unknown", 10)
+	assert.Equal(t, []string{}, a.Calc())
+}
+
+func TestObserveActiveSpeakers(t *testing.T) {
+	// 总线的订阅者代替rabbitmq broker, 收到的就是AMQPPublisher要发布的事件.
+	bus := events.NewBus()
+	published := make(chan events.Event, 10)
+	defer bus.Subscribe(func(e events.Event) {
+		published <- e
+	})()
+
+	node := NewSFU(WebRTCTransportConfig{
+		BufferFactory: buffer.NewBufferFactory(100, log.GetLogger()),
+		Events:        bus,
+		Router:        RouterConfig{AudioLevelThreshold: 40, AudioLevelInterval: 50, AudioLevelFilter: 80},
+	})
+	s, _ := node.NewSession("s1", false)
+	observer := s.AudioObserver()
+	observer.AddStream("a")
+	observer.AddStream("b")
+	for i := 0; i < 5; i++ {
+		observer.Observe("a", 20)
+	}
+
+	var e events.Event
+	select {
+	case e = <-published:
+	case <-time.After(time.Second):
+		t.Fatal("no active speaker event")
+	}
+	assert.Equal(t, events.TypeActiveSpeaker, e.Type)
+	assert.Equal(t, "s1", e.SessionID)
+	assert.Equal(t, events.ActiveSpeakerData{Speakers: []string{"a"}}, e.Data)
+	assert.Equal(t, "s1.-."+events.TypeActiveSpeaker, events.RoutingKey(e))
+
+	// 会话关闭后停止检测.
+	s.(*SessionLocal).Close()
+	time.Sleep(60 * time.Millisecond)
+	for len(published) > 0 {
+		<-published
+	}
+	for i := 0; i < 5; i++ {
+		observer.Observe("b", 20)
+	}
+	select {
+	case e = <-published:
+		t.Fatalf("unexpected event after close: %v", e)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestObserveActiveSpeakers_Disabled(t *testing.T) {
+	s := NewSession("s1", false, WebRTCTransportConfig{})
+	defer s.Close()
+	done := make(chan struct{})
+	go func() {
+		ObserveActiveSpeakers(s, nil, 0, make(chan struct{}))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "zero interval should return at once")
+	}
+}
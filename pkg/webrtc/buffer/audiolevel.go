@@ -0,0 +1,89 @@
+package buffer
+
+import (
+	"math"
+
+	"github.com/pion/rtp"
+)
+
+// sip终端和网关不发送ssrc-audio-level扩展, PCMU/PCMA直接解码计算声音大小(RFC 6464, -dBov),
+// 和扩展一样交给onAudioLevel, 下行可以按订阅端的扩展id补上.
+// Opus需要完整的解码器, 只使用发布端的扩展.
+
+const silenceLevel = 127
+
+var ulawMagnitude, alawMagnitude [256]float64
+
+func init() {
+	for i := range ulawMagnitude {
+		ulawMagnitude[i] = math.Abs(float64(ulawToLinear(byte(i))))
+		alawMagnitude[i] = math.Abs(float64(alawToLinear(byte(i))))
+	}
+}
+
+// g711Level returns the RFC 6464 level of a PCMU or PCMA payload, 0 is the loudest and 127 is silence
+func g711Level(payload []byte, alaw bool) uint8 {
+	if len(payload) == 0 {
+		return silenceLevel
+	}
+	table := &ulawMagnitude
+	if alaw {
+		table = &alawMagnitude
+	}
+	var sum float64
+	for _, s := range payload {
+		sum += table[s] * table[s]
+	}
+	rms := math.Sqrt(sum / float64(len(payload)))
+	if rms < 1 {
+		return silenceLevel
+	}
+	level := math.Round(-20 * math.Log10(rms/32768))
+	if level < 0 {
+		return 0
+	}
+	if level > silenceLevel {
+		return silenceLevel
+	}
+	return uint8(level)
+}
+
+// payloadAudioLevel 按编码计算声音大小, 不支持的编码返回nil.
+func payloadAudioLevel(mime string, payload []byte) *rtp.AudioLevelExtension {
+	switch mime {
+	case "audio/pcmu":
+		return &rtp.AudioLevelExtension{Level: g711Level(payload, false)}
+	case "audio/pcma":
+		return &rtp.AudioLevelExtension{Level: g711Level(payload, true)}
+	}
+	return nil
+}
+
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	sign := u & 0x80
+	exponent := int(u>>4) & 0x07
+	mantissa := int(u & 0x0f)
+	s := ((mantissa << 3) + 0x84) << exponent
+	s -= 0x84
+	if sign != 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	exponent := int(a>>4) & 0x07
+	mantissa := int(a & 0x0f)
+	var s int
+	if exponent == 0 {
+		s = mantissa<<4 + 8
+	} else {
+		s = (mantissa<<4 + 0x108) << (exponent - 1)
+	}
+	if a&0x80 == 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
@@ -0,0 +1,27 @@
+package buffer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestG711Level(t *testing.T) {
+	// 0xff是PCMU的0, PCMA没有0, 最小的0xd5是8, 约-72dBov.
+	assert.Equal(t, uint8(127), g711Level(bytes.Repeat([]byte{0xff}, 160), false))
+	assert.Equal(t, uint8(72), g711Level(bytes.Repeat([]byte{0xd5}, 160), true))
+	assert.Equal(t, uint8(127), g711Level(nil, false))
+
+	// 满幅方波为0dBov.
+	assert.Equal(t, uint8(0), g711Level(bytes.Repeat([]byte{0x80, 0x00}, 80), false))
+	assert.Equal(t, uint8(0), g711Level(bytes.Repeat([]byte{0xaa, 0x2a}, 80), true))
+
+	// 幅度4096约为-18dBov.
+	assert.Equal(t, int16(4092), ulawToLinear(0xaf))
+	assert.Equal(t, int16(4032), alawToLinear(0x9a))
+	assert.Equal(t, uint8(18), g711Level(bytes.Repeat([]byte{0xaf, 0x2f}, 80), false))
+	assert.Equal(t, uint8(18), g711Level(bytes.Repeat([]byte{0x9a, 0x1a}, 80), true))
+
+	assert.Nil(t, payloadAudioLevel("audio/opus", []byte{0x01}))
+}
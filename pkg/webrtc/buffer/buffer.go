@@ -1,6 +1,7 @@
 package buffer
 
 import (
+	"bytes"
 	log "common/log/newlog"
 	"common/util/mem"
 	"encoding/binary"
@@ -20,6 +21,12 @@ const (
 	maxSN = 1 << 16
 
 	reportDelta = 1e9
+
+	// 帧间隔超过平均间隔3倍且超过平均间隔+150ms时记为一次卡顿.
+	freezeMinDelta = 150 * int64(time.Millisecond)
+
+	// rtt超过最小rtt的2倍且多出50ms时认为链路排队, REMB不再上调.
+	rembHoldRTTDelta = 50 * time.Millisecond
 )
 
 // Logger is an implementation of logr.Logger. If is not provided - will be turned off.
@@ -37,6 +44,14 @@ type ExtPacket struct {
 	Packet   rtp.Packet
 	Payload  interface{}
 	KeyFrame bool
+	// DependencyDescriptor of SVC AV1, nil if the extension is not negotiated or the packet has none
+	DependencyDescriptor *DependencyDescriptor
+	// FrameMarking nil if the extension is not negotiated or the packet has none
+	FrameMarking *FrameMarking
+	// Encrypted the payload is E2EE ciphertext and must not be parsed or modified
+	Encrypted bool
+	// AudioLevel computed from the PCMU/PCMA payload when the publisher does not send the audio level extension
+	AudioLevel *rtp.AudioLevelExtension
 }
 
 // Buffer contains all packets
@@ -56,7 +71,14 @@ type Buffer struct {
 	lastReport int64
 	twccExt    uint8
 	audioExt   uint8
-	bound      bool
+	ddExt      uint8
+	fmExt      uint8
+	ddParser   DependencyDescriptorParser
+	dups       dupWindow
+	reorder    reorderQueue
+	e2ee       bool
+	bound      atomicBool
+	created    int64 // Factory创建的时间, 用于清理一直没有Bind的buffer
 	closed     atomicBool
 	mime       string
 
@@ -69,6 +91,7 @@ type Buffer struct {
 	minPacketProbe     int
 	lastPacketRead     int
 	maxTemporalLayer   int32
+	maxSpatialLayer    int32
 	bitrate            uint64
 	bitrateHelper      uint64
 	lastSRNTPTime      uint64
@@ -80,14 +103,24 @@ type Buffer struct {
 	lastRtcpSrTime     int64 // Time the last RTCP SR was received. Required for DLSR computation.
 	lastTransit        uint32
 	maxSeqNo           uint16 // The highest sequence number received in an RTP data packet
+	avgPacketSize      int    // 平滑后的包大小, 估算NACK的重传量
 
 	stats Stats
 
 	latestTimestamp     uint32 // latest received RTP timestamp on packet
 	latestTimestampTime int64  // Time of the latest timestamp (in nanos since unix epoch)
+	frameInterval       int64  // 视频平均帧间隔, 不含卡顿
+
+	// 到发布端的rtt, SFU只接收, 通过XR RRTR/DLRR计算.
+	rtt RTTEstimator
+
+	// 最近的H.264 SPS/PPS, 很多sip终端只在开始时发一次, 后加入的订阅端在第一个IDR前补上.
+	sps, pps      []byte
+	h264ParamSets []byte // SPS和PPS组成的STAP-A
 
 	// callbacks
 	onClose      func()
+	onBind       func(codecType webrtc.RTPCodecType)
 	onAudioLevel func(level uint8)
 	feedbackCB   func([]rtcp.Packet)
 	feedbackTWCC func(sn uint16, timeNS int64, marker bool)
@@ -103,11 +136,32 @@ type Stats struct {
 	PacketCount  uint32  // Number of packets received from this source.
 	Jitter       float64 // An estimate of the statistical variance of the RTP data packet inter-arrival time.
 	TotalByte    uint64
+	LateDropped  uint32 // Packets dropped by the egress jitter buffer for arriving after their playout time.
+	PacketsLost  uint32 // Cumulative number of packets lost, filled in by GetStats.
+
+	// 视频帧统计, 用于质量评分.
+	Frames         uint32 // Number of video frames, counted by new RTP timestamps.
+	Freezes        uint32
+	FreezeDuration uint32 // ms
+
+	// NACK统计, Recovered为NACK之后收到的重传, GivenUp为重试用完、超时或超出缓存放弃的丢包.
+	NacksSent      uint32
+	NacksRecovered uint32
+	NacksGivenUp   uint32
+
+	Reordered  uint32 // Packets arrived after a later packet, retransmissions included.
+	Duplicates uint32 // Packets received more than once, dropped.
 }
 
 // BufferOptions provides configuration options for the buffer
 type Options struct {
 	MaxBitRate uint64
+	// E2EE payload是端到端加密的密文, 不解析payload, 关键帧和层只从frame marking或Dependency Descriptor获取.
+	E2EE bool
+	// Nack NACK重试次数、间隔和重传预算, 零值使用默认值.
+	Nack NackOptions
+	// ReorderWindow 乱序包最多等待的时间, ReadExtended按序列号顺序返回, 0不等待.
+	ReorderWindow time.Duration
 }
 
 // NewBuffer constructs a new Buffer
@@ -125,11 +179,16 @@ func NewBuffer(ssrc uint32, vp, ap *sync.Pool, logger log.Logger) *Buffer {
 func (b *Buffer) Bind(params webrtc.RTPParameters, o Options) {
 	b.Lock()
 	defer b.Unlock()
+	if b.closed.get() {
+		return
+	}
 
 	codec := params.Codecs[0]
 	b.clockRate = codec.ClockRate
 	b.maxBitrate = o.MaxBitRate
 	b.mime = strings.ToLower(codec.MimeType)
+	b.e2ee = o.E2EE
+	b.reorder.window = int64(o.ReorderWindow)
 
 	switch {
 	case strings.HasPrefix(b.mime, "audio/"):
@@ -143,9 +202,13 @@ func (b *Buffer) Bind(params webrtc.RTPParameters, o Options) {
 	}
 
 	for _, ext := range params.HeaderExtensions {
-		if ext.URI == sdp.TransportCCURI {
+		switch ext.URI {
+		case sdp.TransportCCURI:
 			b.twccExt = uint8(ext.ID)
-			break
+		case DependencyDescriptorURI:
+			b.ddExt = uint8(ext.ID)
+		case FrameMarkingURI:
+			b.fmExt = uint8(ext.ID)
 		}
 	}
 
@@ -160,7 +223,7 @@ func (b *Buffer) Bind(params webrtc.RTPParameters, o Options) {
 				b.twcc = true
 			case webrtc.TypeRTCPFBNACK:
 				b.logger.Info("Setting feedback", "type", webrtc.TypeRTCPFBNACK)
-				b.nacker = newNACKQueue()
+				b.nacker = newNACKQueue(o.Nack)
 				b.nack = true
 			}
 		}
@@ -177,7 +240,10 @@ func (b *Buffer) Bind(params webrtc.RTPParameters, o Options) {
 		b.calc(pp.packet, pp.arrivalTime)
 	}
 	b.pPackets = nil
-	b.bound = true
+	b.bound.set(true)
+	if b.onBind != nil {
+		b.onBind(b.codecType)
+	}
 
 	b.logger.Info("NewBuffer", "MaxBitRate", o.MaxBitRate)
 }
@@ -192,7 +258,7 @@ func (b *Buffer) Write(pkt []byte) (n int, err error) {
 		return
 	}
 
-	if !b.bound {
+	if !b.bound.get() {
 		packet := make([]byte, len(pkt))
 		copy(packet, pkt)
 		b.pPackets = append(b.pPackets, pendingPackets{
@@ -237,6 +303,9 @@ func (b *Buffer) ReadExtended() (*ExtPacket, error) {
 			return nil, io.EOF
 		}
 		b.Lock()
+		if b.reorder.window > 0 {
+			b.releaseReordered(time.Now().UnixNano())
+		}
 		if b.extPackets.Len() > 0 {
 			extPkt := b.extPackets.PopFront().(*ExtPacket)
 			b.Unlock()
@@ -268,9 +337,20 @@ func (b *Buffer) OnClose(fn func()) {
 	b.onClose = fn
 }
 
+// orphan 创建后到deadline仍没有Bind.
+func (b *Buffer) orphan(deadline int64) bool {
+	return b.created != 0 && b.created < deadline && !b.bound.get() && !b.closed.get()
+}
+
 func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 	sn := binary.BigEndian.Uint16(pkt[2:4])
 
+	extSN := b.extendedSN(sn)
+	if b.dups.seen(extSN) {
+		b.stats.Duplicates++
+		return
+	}
+
 	if b.stats.PacketCount == 0 {
 		b.baseSN = sn
 		b.maxSeqNo = sn
@@ -289,18 +369,15 @@ func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 				} else {
 					extSN = b.cycles | uint32(msn)
 				}
-				b.nacker.push(extSN)
+				b.nacker.push(extSN, arrivalTime)
 			}
 		}
 		b.maxSeqNo = sn
-	} else if b.nack && (sn-b.maxSeqNo)&0x8000 > 0 {
-		var extSN uint32
-		if sn > b.maxSeqNo && sn&0x8000 > 0 && b.maxSeqNo&0x8000 == 0 {
-			extSN = (b.cycles - maxSN) | uint32(sn)
-		} else {
-			extSN = b.cycles | uint32(sn)
+	} else {
+		b.stats.Reordered++
+		if b.nack {
+			b.nacker.remove(extSN)
 		}
-		b.nacker.remove(extSN)
 	}
 
 	var p rtp.Packet
@@ -316,6 +393,11 @@ func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 	}
 
 	b.stats.TotalByte += uint64(len(pkt))
+	if b.avgPacketSize == 0 {
+		b.avgPacketSize = len(pkt)
+	} else {
+		b.avgPacketSize += (len(pkt) - b.avgPacketSize) / 16
+	}
 	b.bitrateHelper += uint64(len(pkt))
 	b.stats.PacketCount++
 
@@ -326,16 +408,73 @@ func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 		Arrival: arrivalTime,
 	}
 
-	switch b.mime {
-	case "video/vp8":
-		vp8Packet := VP8{}
-		if err := vp8Packet.Unmarshal(p.Payload); err != nil {
-			return
+	if b.fmExt != 0 {
+		if ext := p.GetExtension(b.fmExt); ext != nil {
+			fm := &FrameMarking{}
+			if err := fm.Unmarshal(ext); err == nil {
+				ep.FrameMarking = fm
+			}
+		}
+	}
+
+	// E2EE的payload是密文, 不解析.
+	if !b.e2ee {
+		switch b.mime {
+		case "video/vp8":
+			vp8Packet := VP8{}
+			if err := vp8Packet.Unmarshal(p.Payload); err == nil {
+				ep.Payload = vp8Packet
+				ep.KeyFrame = vp8Packet.IsKeyFrame
+			} else if ep.FrameMarking == nil {
+				return
+			}
+		case "video/vp9":
+			vp9Packet := VP9{}
+			if err := vp9Packet.Unmarshal(p.Payload); err == nil {
+				ep.Payload = vp9Packet
+				ep.KeyFrame = vp9Packet.IsKeyFrame
+			} else if ep.FrameMarking == nil {
+				return
+			}
+		case "video/av1":
+			av1Packet := AV1{}
+			if err := av1Packet.Unmarshal(p.Payload); err == nil {
+				ep.Payload = av1Packet
+				ep.KeyFrame = av1Packet.IsKeyFrame
+			} else if ep.FrameMarking == nil {
+				return
+			}
+		case "video/h264":
+			ep.KeyFrame = isH264Keyframe(p.Payload)
+			b.updateH264ParameterSets(p.Payload)
+		case "video/h265":
+			ep.KeyFrame = isH265Keyframe(p.Payload)
+		case "audio/pcmu", "audio/pcma":
+			if !b.audioLevel {
+				ep.AudioLevel = payloadAudioLevel(b.mime, p.Payload)
+			}
+		}
+	}
+	if fm := ep.FrameMarking; fm != nil {
+		// 加密的payload解析不出关键帧, 以frame marking为准.
+		ep.KeyFrame = fm.IsKeyFrame()
+	}
+
+	if b.ddExt != 0 {
+		if ext := p.GetExtension(b.ddExt); ext != nil {
+			// 还没有收到模板结构时无法解析, 按没有处理.
+			if dd, err := b.ddParser.Parse(ext); err == nil {
+				dd.Raw, dd.ExtID = ext, b.ddExt
+				ep.DependencyDescriptor = dd
+			}
+		}
+	}
+	if b.e2ee {
+		ep.Encrypted = true
+		// 带模板结构的帧是关键帧.
+		if dd := ep.DependencyDescriptor; ep.FrameMarking == nil && dd != nil {
+			ep.KeyFrame = dd.StartOfFrame && dd.Structure != nil
 		}
-		ep.Payload = vp8Packet
-		ep.KeyFrame = vp8Packet.IsKeyFrame
-	case "video/h264":
-		ep.KeyFrame = isH264Keyframe(p.Payload)
 	}
 
 	if b.minPacketProbe < 25 {
@@ -343,23 +482,47 @@ func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 			b.baseSN = sn
 		}
 
-		if b.mime == "video/vp8" {
-			pld := ep.Payload.(VP8)
+		if pld, ok := ep.Payload.(VP8); ok {
 			mtl := atomic.LoadInt32(&b.maxTemporalLayer)
 			if mtl < int32(pld.TID) {
 				atomic.StoreInt32(&b.maxTemporalLayer, int32(pld.TID))
 			}
 		}
+		if pld, ok := ep.Payload.(VP9); ok {
+			if mtl := atomic.LoadInt32(&b.maxTemporalLayer); mtl < int32(pld.TID) {
+				atomic.StoreInt32(&b.maxTemporalLayer, int32(pld.TID))
+			}
+			if msl := atomic.LoadInt32(&b.maxSpatialLayer); msl < int32(pld.SID) {
+				atomic.StoreInt32(&b.maxSpatialLayer, int32(pld.SID))
+			}
+		}
+		if fm := ep.FrameMarking; fm != nil && fm.Scalable {
+			if mtl := atomic.LoadInt32(&b.maxTemporalLayer); mtl < int32(fm.TID) {
+				atomic.StoreInt32(&b.maxTemporalLayer, int32(fm.TID))
+			}
+			if msl := atomic.LoadInt32(&b.maxSpatialLayer); msl < int32(fm.LID) {
+				atomic.StoreInt32(&b.maxSpatialLayer, int32(fm.LID))
+			}
+		}
 
 		b.minPacketProbe++
 	}
 
-	b.extPackets.PushBack(&ep)
+	if b.reorder.window == 0 {
+		b.extPackets.PushBack(&ep)
+	} else if b.reorder.push(&ep, extSN) {
+		b.extPackets.PushBack(&ep)
+	} else {
+		b.releaseReordered(arrivalTime)
+	}
 
 	// if first time update or the timestamp is later (factoring timestamp wrap around)
 	latestTimestamp := atomic.LoadUint32(&b.latestTimestamp)
 	latestTimestampTimeInNanosSinceEpoch := atomic.LoadInt64(&b.latestTimestampTime)
 	if (latestTimestampTimeInNanosSinceEpoch == 0) || IsLaterTimestamp(p.Timestamp, latestTimestamp) {
+		if b.codecType == webrtc.RTPCodecTypeVideo {
+			b.updateFrameStats(latestTimestampTimeInNanosSinceEpoch, arrivalTime)
+		}
 		atomic.StoreUint32(&b.latestTimestamp, p.Timestamp)
 		atomic.StoreInt64(&b.latestTimestampTime, arrivalTime)
 	}
@@ -388,12 +551,14 @@ func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 				b.onAudioLevel(ext.Level)
 			}
 		}
+	} else if ep.AudioLevel != nil && b.onAudioLevel != nil {
+		b.onAudioLevel(ep.AudioLevel.Level)
 	}
 
 	diff := arrivalTime - b.lastReport
 
 	if b.nacker != nil {
-		if r := b.buildNACKPacket(); r != nil {
+		if r := b.buildNACKPacket(arrivalTime); r != nil {
 			b.feedbackCB(r)
 		}
 	}
@@ -407,8 +572,57 @@ func (b *Buffer) calc(pkt []byte, arrivalTime int64) {
 	}
 }
 
-func (b *Buffer) buildNACKPacket() []rtcp.Packet {
-	if nacks, askKeyframe := b.nacker.pairs(b.cycles | uint32(b.maxSeqNo)); (nacks != nil && len(nacks) > 0) || askKeyframe {
+// extendedSN 按当前的maxSeqNo和cycles计算sn的扩展序列号, 在更新maxSeqNo之前调用.
+func (b *Buffer) extendedSN(sn uint16) uint32 {
+	if b.stats.PacketCount == 0 {
+		return b.cycles | uint32(sn)
+	}
+	if (sn-b.maxSeqNo)&0x8000 == 0 {
+		if sn < b.maxSeqNo {
+			return (b.cycles + maxSN) | uint32(sn)
+		}
+		return b.cycles | uint32(sn)
+	}
+	if sn > b.maxSeqNo && sn&0x8000 > 0 && b.maxSeqNo&0x8000 == 0 {
+		return (b.cycles - maxSN) | uint32(sn)
+	}
+	return b.cycles | uint32(sn)
+}
+
+// releaseReordered 把乱序窗口中按序到齐或者等待超时的包放入extPackets.
+func (b *Buffer) releaseReordered(now int64) {
+	for ep := b.reorder.pop(now); ep != nil; ep = b.reorder.pop(now) {
+		b.extPackets.PushBack(ep)
+	}
+}
+
+// updateFrameStats 新的时间戳表示新的一帧, 按帧到达间隔统计卡顿.
+func (b *Buffer) updateFrameStats(lastFrameTime, arrivalTime int64) {
+	b.stats.Frames++
+	if lastFrameTime == 0 {
+		return
+	}
+
+	gap := arrivalTime - lastFrameTime
+	if b.frameInterval == 0 {
+		b.frameInterval = gap
+		return
+	}
+	if gap > 3*b.frameInterval && gap > b.frameInterval+freezeMinDelta {
+		b.stats.Freezes++
+		b.stats.FreezeDuration += uint32(gap / int64(time.Millisecond))
+		return
+	}
+	b.frameInterval += (gap - b.frameInterval) / 8
+}
+
+// buildNACKPacket 只NACK到了重试时间的丢包, 重试用完或者超时放弃时请求关键帧.
+func (b *Buffer) buildNACKPacket(now int64) []rtcp.Packet {
+	nacks, askKeyframe := b.nacker.pairs(b.cycles|uint32(b.maxSeqNo), now, b.rtt.RTT(), b.avgPacketSize)
+	b.stats.NacksSent = b.nacker.sent
+	b.stats.NacksRecovered = b.nacker.recovered
+	b.stats.NacksGivenUp = b.nacker.givenUp
+	if len(nacks) > 0 || askKeyframe {
 		var pkts []rtcp.Packet
 		if len(nacks) > 0 {
 			pkts = []rtcp.Packet{&rtcp.TransportLayerNack{
@@ -427,9 +641,16 @@ func (b *Buffer) buildNACKPacket() []rtcp.Packet {
 	return nil
 }
 
+// SetMaxBitrate 修改REMB的码率上限, 配置热更新时调用.
+func (b *Buffer) SetMaxBitrate(br uint64) {
+	b.Lock()
+	b.maxBitrate = br
+	b.Unlock()
+}
+
 func (b *Buffer) buildREMBPacket() *rtcp.ReceiverEstimatedMaximumBitrate {
 	br := b.bitrate
-	if b.stats.LostRate < 0.02 {
+	if b.stats.LostRate < 0.02 && !b.queuing() {
 		br = uint64(float64(br)*1.09) + 2000
 	}
 	if b.stats.LostRate > .1 {
@@ -449,6 +670,12 @@ func (b *Buffer) buildREMBPacket() *rtcp.ReceiverEstimatedMaximumBitrate {
 	}
 }
 
+// queuing rtt明显高于最小rtt, 说明链路开始排队.
+func (b *Buffer) queuing() bool {
+	rtt, minRTT := b.rtt.RTT(), b.rtt.MinRTT()
+	return minRTT > 0 && rtt > 2*minRTT && rtt-minRTT > rembHoldRTTDelta
+}
+
 func (b *Buffer) buildReceptionReport() rtcp.ReceptionReport {
 	extMaxSeq := b.cycles | uint32(b.maxSeqNo)
 	expected := extMaxSeq - uint32(b.baseSN) + 1
@@ -497,6 +724,53 @@ func (b *Buffer) SetSenderReportData(rtpTime uint32, ntpTime uint64) {
 	b.Unlock()
 }
 
+// HandleExtendedReport 处理发布端回复的XR DLRR, 计算rtt.
+func (b *Buffer) HandleExtendedReport(xr *rtcp.ExtendedReport) {
+	now := time.Now().UnixNano()
+	for _, block := range xr.Reports {
+		dlrr, ok := block.(*rtcp.DLRRReportBlock)
+		if !ok {
+			continue
+		}
+		for _, r := range dlrr.Reports {
+			b.rtt.OnReport(r.LastRR, r.DLRR, now)
+		}
+	}
+}
+
+// RTT returns the round trip time to the publisher, 0 before the first XR DLRR
+func (b *Buffer) RTT() time.Duration {
+	return b.rtt.RTT()
+}
+
+// H264ParameterSets returns a STAP-A payload of the latest SPS and PPS, nil if any of them is not received
+func (b *Buffer) H264ParameterSets() []byte {
+	b.Lock()
+	defer b.Unlock()
+	return b.h264ParamSets
+}
+
+func (b *Buffer) updateH264ParameterSets(payload []byte) {
+	changed := false
+	for _, nalu := range h264NALUs(payload) {
+		switch nalu[0] & 0x1F {
+		case h264NaluSPS:
+			if !bytes.Equal(b.sps, nalu) {
+				b.sps = append([]byte(nil), nalu...)
+				changed = true
+			}
+		case h264NaluPPS:
+			if !bytes.Equal(b.pps, nalu) {
+				b.pps = append([]byte(nil), nalu...)
+				changed = true
+			}
+		}
+	}
+	if changed && b.sps != nil && b.pps != nil {
+		b.h264ParamSets = h264STAPA(b.sps, b.pps)
+	}
+}
+
 func (b *Buffer) getRTCP() []rtcp.Packet {
 	var pkts []rtcp.Packet
 
@@ -504,6 +778,14 @@ func (b *Buffer) getRTCP() []rtcp.Packet {
 		Reports: []rtcp.ReceptionReport{b.buildReceptionReport()},
 	})
 
+	// SFU只接收不发送SR, 带上RRTR让发布端回复DLRR.
+	now := time.Now().UnixNano()
+	ntp := toNtpTime(now)
+	b.rtt.OnSend(ntp, now)
+	pkts = append(pkts, &rtcp.ExtendedReport{
+		Reports: []rtcp.ReportBlock{&rtcp.ReceiverReferenceTimeReportBlock{NTPTimestamp: ntp}},
+	})
+
 	if b.remb && !b.twcc {
 		pkts = append(pkts, b.buildREMBPacket())
 	}
@@ -529,6 +811,11 @@ func (b *Buffer) MaxTemporalLayer() int32 {
 	return atomic.LoadInt32(&b.maxTemporalLayer)
 }
 
+// MaxSpatialLayer returns the highest spatial layer seen in the first packets of a SVC stream
+func (b *Buffer) MaxSpatialLayer() int32 {
+	return atomic.LoadInt32(&b.maxSpatialLayer)
+}
+
 // twcc在视频情况下，twcc重新计算.
 func (b *Buffer) OnTransportWideCC(fn func(sn uint16, timeNS int64, marker bool)) {
 	b.feedbackTWCC = fn
@@ -565,6 +852,12 @@ func (b *Buffer) GetSenderReportData() (rtpTime uint32, ntpTime uint64, lastRece
 func (b *Buffer) GetStats() (stats Stats) {
 	b.Lock()
 	stats = b.stats
+	if stats.PacketCount != 0 {
+		expected := (b.cycles | uint32(b.maxSeqNo)) - uint32(b.baseSN) + 1
+		if stats.PacketCount < expected {
+			stats.PacketsLost = expected - stats.PacketCount
+		}
+	}
 	b.Unlock()
 	return
 }
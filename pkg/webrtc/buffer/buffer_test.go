@@ -1,9 +1,11 @@
 package buffer
 
 import (
+	"bytes"
 	"common/log/newlog"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pion/rtcp"
 
@@ -85,6 +87,8 @@ func TestNack(t *testing.T) {
 			},
 		},
 	}, Options{})
+	// 同一个丢包按重试间隔(没有rtt时100ms)重复NACK, 直接给出到达时间, 不用等待.
+	arrival := time.Now().UnixNano()
 	for i := 0; i < 15; i++ {
 		if i == 2 {
 			continue
@@ -95,8 +99,10 @@ func TestNack(t *testing.T) {
 		}
 		b, err := pkt.Marshal()
 		assert.NoError(t, err)
-		_, err = buff.Write(b)
-		assert.NoError(t, err)
+		arrival += int64(40 * time.Millisecond)
+		buff.Lock()
+		buff.calc(b, arrival)
+		buff.Unlock()
 	}
 	wg.Wait()
 }
@@ -178,3 +184,174 @@ func TestNewBuffer(t *testing.T) {
 		})
 	}
 }
+
+func TestBuffer_FrameStats(t *testing.T) {
+	const ms = int64(time.Millisecond)
+	b := &Buffer{}
+	b.updateFrameStats(0, 1000*ms)
+	arrival := 1000 * ms
+	for i := 0; i < 10; i++ {
+		b.updateFrameStats(arrival, arrival+33*ms)
+		arrival += 33 * ms
+	}
+	assert.Equal(t, uint32(11), b.stats.Frames)
+	assert.Equal(t, uint32(0), b.stats.Freezes)
+
+	// 100ms超过3倍平均间隔, 但没有超过平均间隔+150ms.
+	b.updateFrameStats(arrival, arrival+100*ms)
+	arrival += 100 * ms
+	assert.Equal(t, uint32(0), b.stats.Freezes)
+
+	b.updateFrameStats(arrival, arrival+500*ms)
+	assert.Equal(t, uint32(13), b.stats.Frames)
+	assert.Equal(t, uint32(1), b.stats.Freezes)
+	assert.Equal(t, uint32(500), b.stats.FreezeDuration)
+}
+
+func TestBuffer_H264ParameterSets(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	b := &Buffer{}
+
+	b.updateH264ParameterSets(sps)
+	assert.Nil(t, b.H264ParameterSets())
+
+	// IDR不改变缓存.
+	b.updateH264ParameterSets([]byte{0x65, 0x88})
+	b.updateH264ParameterSets(pps)
+	stapA := []byte{0x78, 0x00, 0x04, 0x67, 0x42, 0xc0, 0x1f, 0x00, 0x04, 0x68, 0xce, 0x3c, 0x80}
+	assert.Equal(t, stapA, b.H264ParameterSets())
+	assert.True(t, HasH264ParameterSets(stapA))
+	assert.False(t, HasH264ParameterSets([]byte{0x65, 0x88}))
+	assert.True(t, isH264Keyframe([]byte{0x7c, 0x85, 0x88}))
+
+	// STAP-A中新的SPS替换旧的.
+	sps2 := []byte{0x67, 0x64, 0x00, 0x32}
+	b.updateH264ParameterSets(h264STAPA(sps2, pps))
+	assert.Equal(t, h264STAPA(sps2, pps), b.H264ParameterSets())
+}
+
+func TestBuffer_E2EE(t *testing.T) {
+	pool := &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 1500)
+			return &b
+		},
+	}
+	buff := NewBuffer(123, pool, pool, log.GetLogger())
+	buff.OnFeedback(func(_ []rtcp.Packet) {})
+	buff.Bind(webrtc.RTPParameters{
+		HeaderExtensions: []webrtc.RTPHeaderExtensionParameter{{URI: FrameMarkingURI, ID: 5}},
+		Codecs: []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/vp8", ClockRate: 90000},
+			PayloadType:        96,
+		}},
+	}, Options{E2EE: true})
+
+	// 密文看起来像vp8非关键帧, 以frame marking为准.
+	p := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: 1, Timestamp: 3000, SSRC: 123},
+		Payload: []byte{0x10, 0x01, 0x02, 0x03},
+	}
+	assert.NoError(t, p.SetExtension(5, []byte{0xa0}))
+	buf, _ := p.Marshal()
+	_, _ = buff.Write(buf)
+
+	ep, err := buff.ReadExtended()
+	assert.NoError(t, err)
+	assert.True(t, ep.Encrypted)
+	assert.True(t, ep.KeyFrame)
+	assert.Nil(t, ep.Payload)
+	assert.NotNil(t, ep.FrameMarking)
+}
+
+func TestBuffer_Reorder(t *testing.T) {
+	pool := &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 20*maxPktSize)
+			return &b
+		},
+	}
+	buff := NewBuffer(123, pool, pool, log.GetLogger())
+	buff.OnFeedback(func(_ []rtcp.Packet) {})
+	buff.Bind(webrtc.RTPParameters{
+		Codecs: []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/opus", ClockRate: 48000},
+			PayloadType:        111,
+		}},
+	}, Options{ReorderWindow: 50 * time.Millisecond})
+
+	write := func(sn uint16) {
+		p := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: sn, Timestamp: uint32(sn) * 960, SSRC: 123},
+			Payload: []byte{0x01},
+		}
+		buf, _ := p.Marshal()
+		_, _ = buff.Write(buf)
+	}
+	read := func() (uint16, bool) {
+		ep, err := buff.ReadExtended()
+		assert.NoError(t, err)
+		return ep.Packet.SequenceNumber, ep.Head
+	}
+
+	// 1 3 2 2 按顺序放行, 重复的2丢弃.
+	for _, sn := range []uint16{1, 3, 2, 2} {
+		write(sn)
+	}
+	for _, want := range []uint16{1, 2, 3} {
+		sn, head := read()
+		assert.Equal(t, want, sn)
+		assert.True(t, head)
+	}
+
+	// 5等待4直到超时, 之后到达的4直接放行, 不是head.
+	write(5)
+	sn, head := read()
+	assert.Equal(t, uint16(5), sn)
+	assert.True(t, head)
+	write(4)
+	sn, head = read()
+	assert.Equal(t, uint16(4), sn)
+	assert.False(t, head)
+
+	stats := buff.GetStats()
+	assert.Equal(t, uint32(2), stats.Reordered)
+	assert.Equal(t, uint32(1), stats.Duplicates)
+	assert.Equal(t, uint32(5), stats.PacketCount)
+}
+
+func TestBuffer_G711AudioLevel(t *testing.T) {
+	pool := &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 2*maxPktSize)
+			return &b
+		},
+	}
+	buff := NewBuffer(123, pool, pool, log.GetLogger())
+	buff.OnFeedback(func(_ []rtcp.Packet) {})
+	var levels []uint8
+	buff.OnAudioLevel(func(level uint8) {
+		levels = append(levels, level)
+	})
+	// sip网关发布的PCMU没有声音扩展.
+	buff.Bind(webrtc.RTPParameters{
+		Codecs: []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+		}},
+	}, Options{})
+
+	for i, payload := range [][]byte{bytes.Repeat([]byte{0xff}, 160), bytes.Repeat([]byte{0xaf, 0x2f}, 80)} {
+		p := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: uint16(i + 1), Timestamp: uint32(i * 160), SSRC: 123},
+			Payload: payload,
+		}
+		buf, _ := p.Marshal()
+		_, _ = buff.Write(buf)
+	}
+	assert.Equal(t, []uint8{127, 18}, levels)
+
+	ep, err := buff.ReadExtended()
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(127), ep.AudioLevel.Level)
+}
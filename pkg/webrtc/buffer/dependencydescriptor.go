@@ -0,0 +1,309 @@
+package buffer
+
+import (
+	"errors"
+)
+
+// DependencyDescriptorURI AV1 rtp规范附录A的Dependency Descriptor头扩展, SVC AV1通过它描述每帧的层和依赖.
+const DependencyDescriptorURI = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
+// Decode target indication, 帧对某个decode target的作用.
+const (
+	DTINotPresent  = 0 // 解码这个decode target不需要这一帧
+	DTIDiscardable = 1
+	DTISwitch      = 2 // 可以从这一帧切换到这个decode target
+	DTIRequired    = 3
+)
+
+var (
+	errDDShort       = errors.New("dependency descriptor is not large enough")
+	errDDNoStructure = errors.New("dependency descriptor without template structure")
+	errDDTemplate    = errors.New("dependency descriptor template id out of range")
+)
+
+// FrameDependencyStructure 模板结构, 只在关键帧等少数包里携带, 之后的包按模板id引用.
+type FrameDependencyStructure struct {
+	TemplateIDOffset int
+	DecodeTargets    int
+	Chains           int
+	Templates        []FrameDependencyTemplate
+	// DecodeTargetProtectedBy decode target对应的chain
+	DecodeTargetProtectedBy []int
+	// 每个decode target的最高空间层和时间层
+	DecodeTargetSpatialID  []int
+	DecodeTargetTemporalID []int
+	Width                  []int // render resolutions per spatial layer, nil if not present
+	Height                 []int
+}
+
+// FrameDependencyTemplate -.
+type FrameDependencyTemplate struct {
+	SpatialID  int
+	TemporalID int
+	DTIs       []int
+	FDiffs     []int
+	ChainDiffs []int
+}
+
+// DependencyDescriptor 一个rtp包的Dependency Descriptor.
+type DependencyDescriptor struct {
+	StartOfFrame bool
+	EndOfFrame   bool
+	FrameNumber  uint16
+	SpatialID    int
+	TemporalID   int
+	DTIs         []int
+	FDiffs       []int
+	ChainDiffs   []int
+	// ActiveDecodeTargets bitmask, 只在携带时有效.
+	ActiveDecodeTargets        uint32
+	ActiveDecodeTargetsPresent bool
+	// Structure 包里携带了新的模板结构, 表示新的关键帧.
+	Structure *FrameDependencyStructure
+	// Raw is the header extension payload
+	Raw []byte
+	// ExtID is the extension id negotiated with the publisher
+	ExtID uint8
+}
+
+// DependencyDescriptorParser 保存最近的模板结构, 解析同一个流的Dependency Descriptor.
+type DependencyDescriptorParser struct {
+	structure *FrameDependencyStructure
+}
+
+// Structure returns the latest template structure, nil before the first one
+func (p *DependencyDescriptorParser) Structure() *FrameDependencyStructure {
+	return p.structure
+}
+
+// Parse parses the header extension payload
+func (p *DependencyDescriptorParser) Parse(buf []byte) (*DependencyDescriptor, error) {
+	if len(buf) < 3 {
+		return nil, errDDShort
+	}
+	r := &bitReader{buf: buf}
+	dd := &DependencyDescriptor{}
+
+	// mandatory_descriptor_fields
+	dd.StartOfFrame = r.bit()
+	dd.EndOfFrame = r.bit()
+	templateID := int(r.bits(6))
+	dd.FrameNumber = uint16(r.bits(16))
+
+	var customDTIs, customFDiffs, customChains bool
+	if len(buf) > 3 {
+		// extended_descriptor_fields
+		structurePresent := r.bit()
+		dd.ActiveDecodeTargetsPresent = r.bit()
+		customDTIs = r.bit()
+		customFDiffs = r.bit()
+		customChains = r.bit()
+		if structurePresent {
+			s, err := readStructure(r)
+			if err != nil {
+				return nil, err
+			}
+			p.structure = s
+			dd.Structure = s
+			dd.ActiveDecodeTargets = 1<<uint(s.DecodeTargets) - 1
+		}
+		if dd.ActiveDecodeTargetsPresent {
+			if p.structure == nil {
+				return nil, errDDNoStructure
+			}
+			dd.ActiveDecodeTargets = uint32(r.bits(p.structure.DecodeTargets))
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// frame_dependency_definition
+	s := p.structure
+	if s == nil {
+		return nil, errDDNoStructure
+	}
+	templateIndex := (templateID + 64 - s.TemplateIDOffset) % 64
+	if templateIndex >= len(s.Templates) {
+		return nil, errDDTemplate
+	}
+	t := s.Templates[templateIndex]
+	dd.SpatialID = t.SpatialID
+	dd.TemporalID = t.TemporalID
+
+	dd.DTIs = t.DTIs
+	if customDTIs {
+		dd.DTIs = make([]int, s.DecodeTargets)
+		for i := range dd.DTIs {
+			dd.DTIs[i] = int(r.bits(2))
+		}
+	}
+	dd.FDiffs = t.FDiffs
+	if customFDiffs {
+		dd.FDiffs = nil
+		for size := r.bits(2); size != 0 && r.err == nil; size = r.bits(2) {
+			dd.FDiffs = append(dd.FDiffs, int(r.bits(int(4*size)))+1)
+		}
+	}
+	dd.ChainDiffs = t.ChainDiffs
+	if customChains {
+		dd.ChainDiffs = make([]int, s.Chains)
+		for i := range dd.ChainDiffs {
+			dd.ChainDiffs[i] = int(r.bits(8))
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return dd, nil
+}
+
+func readStructure(r *bitReader) (*FrameDependencyStructure, error) {
+	s := &FrameDependencyStructure{
+		TemplateIDOffset: int(r.bits(6)),
+		DecodeTargets:    int(r.bits(5)) + 1,
+	}
+
+	// template_layers
+	spatialID, temporalID := 0, 0
+	for {
+		s.Templates = append(s.Templates, FrameDependencyTemplate{SpatialID: spatialID, TemporalID: temporalID})
+		next := r.bits(2)
+		if r.err != nil {
+			return nil, r.err
+		}
+		if next == 3 {
+			break
+		}
+		switch next {
+		case 1:
+			temporalID++
+		case 2:
+			temporalID = 0
+			spatialID++
+		}
+		if len(s.Templates) >= 64 {
+			return nil, errDDTemplate
+		}
+	}
+	maxSpatialID := spatialID
+
+	// template_dtis
+	for i := range s.Templates {
+		s.Templates[i].DTIs = make([]int, s.DecodeTargets)
+		for dt := range s.Templates[i].DTIs {
+			s.Templates[i].DTIs[dt] = int(r.bits(2))
+		}
+	}
+
+	// template_fdiffs
+	for i := range s.Templates {
+		for r.bit() && r.err == nil {
+			s.Templates[i].FDiffs = append(s.Templates[i].FDiffs, int(r.bits(4))+1)
+		}
+	}
+
+	// template_chains
+	s.Chains = int(r.ns(uint32(s.DecodeTargets + 1)))
+	if s.Chains > 0 {
+		s.DecodeTargetProtectedBy = make([]int, s.DecodeTargets)
+		for dt := range s.DecodeTargetProtectedBy {
+			s.DecodeTargetProtectedBy[dt] = int(r.ns(uint32(s.Chains)))
+		}
+		for i := range s.Templates {
+			s.Templates[i].ChainDiffs = make([]int, s.Chains)
+			for c := range s.Templates[i].ChainDiffs {
+				s.Templates[i].ChainDiffs[c] = int(r.bits(4))
+			}
+		}
+	}
+
+	// decode_target_layers
+	s.DecodeTargetSpatialID = make([]int, s.DecodeTargets)
+	s.DecodeTargetTemporalID = make([]int, s.DecodeTargets)
+	for dt := 0; dt < s.DecodeTargets; dt++ {
+		for _, t := range s.Templates {
+			if t.DTIs[dt] == DTINotPresent {
+				continue
+			}
+			if t.SpatialID > s.DecodeTargetSpatialID[dt] {
+				s.DecodeTargetSpatialID[dt] = t.SpatialID
+			}
+			if t.TemporalID > s.DecodeTargetTemporalID[dt] {
+				s.DecodeTargetTemporalID[dt] = t.TemporalID
+			}
+		}
+	}
+
+	// render_resolutions
+	if r.bit() {
+		s.Width = make([]int, maxSpatialID+1)
+		s.Height = make([]int, maxSpatialID+1)
+		for i := 0; i <= maxSpatialID; i++ {
+			s.Width[i] = int(r.bits(16)) + 1
+			s.Height[i] = int(r.bits(16)) + 1
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return s, nil
+}
+
+// DecodeTarget returns the highest active decode target not above the spatial and temporal layer, -1 if there is none
+func (s *FrameDependencyStructure) DecodeTarget(spatial, temporal int, active uint32) int {
+	target := -1
+	for dt := 0; dt < s.DecodeTargets; dt++ {
+		if active&(1<<uint(dt)) == 0 {
+			continue
+		}
+		sid, tid := s.DecodeTargetSpatialID[dt], s.DecodeTargetTemporalID[dt]
+		if sid > spatial || tid > temporal {
+			continue
+		}
+		if target < 0 || sid > s.DecodeTargetSpatialID[target] ||
+			(sid == s.DecodeTargetSpatialID[target] && tid > s.DecodeTargetTemporalID[target]) {
+			target = dt
+		}
+	}
+	return target
+}
+
+// bitReader 按位读取, 越界后err不为空, 之后读到的都是0.
+type bitReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) bit() bool {
+	return r.bits(1) == 1
+}
+
+func (r *bitReader) bits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.buf)*8 {
+			r.err = errDDShort
+			return 0
+		}
+		v = v<<1 | uint32(r.buf[r.pos/8]>>(7-uint(r.pos%8)))&1
+		r.pos++
+	}
+	return v
+}
+
+// ns non-symmetric unsigned encoded integer with maximum number of values n.
+func (r *bitReader) ns(n uint32) uint32 {
+	w := 0
+	for x := n; x != 0; x >>= 1 {
+		w++
+	}
+	m := uint32(1)<<uint(w) - n
+	v := r.bits(w - 1)
+	if v < m {
+		return v
+	}
+	return v<<1 - m + r.bits(1)
+}
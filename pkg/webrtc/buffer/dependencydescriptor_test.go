@@ -0,0 +1,115 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bitWriter struct {
+	buf []byte
+	pos int
+}
+
+func (w *bitWriter) write(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.pos%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[w.pos/8] |= byte((v>>uint(i))&1) << (7 - uint(w.pos%8))
+		w.pos++
+	}
+}
+
+// L1T2: decode target 0 只有时间层0, decode target 1 包含时间层0和1.
+func l1t2Structure() []byte {
+	w := &bitWriter{}
+	// mandatory fields: start, end, template id 0, frame number 1
+	w.write(1, 1)
+	w.write(1, 1)
+	w.write(0, 6)
+	w.write(1, 16)
+	// extended fields: structure present
+	w.write(1, 1)
+	w.write(0, 4)
+	// template id offset 0, 2 decode targets
+	w.write(0, 6)
+	w.write(1, 5)
+	// template layers: (s0,t0) next temporal, (s0,t1) end
+	w.write(1, 2)
+	w.write(3, 2)
+	// template dtis
+	w.write(DTIRequired, 2)
+	w.write(DTIRequired, 2)
+	w.write(DTINotPresent, 2)
+	w.write(DTIDiscardable, 2)
+	// template fdiffs: template 1 refers to the previous frame
+	w.write(0, 1)
+	w.write(1, 1)
+	w.write(0, 4)
+	w.write(0, 1)
+	// no chains, no resolutions
+	w.write(0, 1)
+	w.write(0, 1)
+	return w.buf
+}
+
+func TestDependencyDescriptorParser(t *testing.T) {
+	p := &DependencyDescriptorParser{}
+
+	// 没有模板结构时不能解析.
+	_, err := p.Parse([]byte{0xc1, 0x00, 0x02})
+	assert.Error(t, err)
+
+	dd, err := p.Parse(l1t2Structure())
+	assert.NoError(t, err)
+	assert.NotNil(t, dd.Structure)
+	assert.True(t, dd.StartOfFrame)
+	assert.True(t, dd.EndOfFrame)
+	assert.Equal(t, uint16(1), dd.FrameNumber)
+	assert.Equal(t, uint32(3), dd.ActiveDecodeTargets)
+	assert.Equal(t, 2, dd.Structure.DecodeTargets)
+	assert.Len(t, dd.Structure.Templates, 2)
+	assert.Equal(t, []int{0, 1}, dd.Structure.DecodeTargetTemporalID)
+	assert.Equal(t, []int{DTIRequired, DTIRequired}, dd.DTIs)
+
+	// template id 1, frame number 2
+	dd, err = p.Parse([]byte{0xc1, 0x00, 0x02})
+	assert.NoError(t, err)
+	assert.Nil(t, dd.Structure)
+	assert.Equal(t, uint16(2), dd.FrameNumber)
+	assert.Equal(t, 1, dd.TemporalID)
+	assert.Equal(t, []int{DTINotPresent, DTIDiscardable}, dd.DTIs)
+	assert.Equal(t, []int{1}, dd.FDiffs)
+
+	// template id out of range
+	_, err = p.Parse([]byte{0xc5, 0x00, 0x03})
+	assert.Error(t, err)
+
+	s := p.Structure()
+	assert.Equal(t, 1, s.DecodeTarget(0, 1, 3))
+	assert.Equal(t, 0, s.DecodeTarget(0, 0, 3))
+	assert.Equal(t, 0, s.DecodeTarget(0, 1, 1))
+	assert.Equal(t, -1, s.DecodeTarget(0, 1, 0))
+}
+
+func TestAV1Helper_Unmarshal(t *testing.T) {
+	p := &AV1{}
+	assert.Error(t, p.Unmarshal([]byte{0x18}))
+
+	// N bit
+	p = &AV1{}
+	assert.NoError(t, p.Unmarshal([]byte{0x18, 0x0a, 0x00}))
+	assert.True(t, p.IsKeyFrame)
+	assert.Equal(t, uint8(1), p.W)
+
+	// sequence header without N bit, W=2 so the first element has a length
+	p = &AV1{}
+	assert.NoError(t, p.Unmarshal([]byte{0x20, 0x02, 0x0a, 0x00}))
+	assert.True(t, p.IsKeyFrame)
+
+	// continuation is never a keyframe
+	p = &AV1{}
+	assert.NoError(t, p.Unmarshal([]byte{0x90, 0x0a, 0x00}))
+	assert.False(t, p.IsKeyFrame)
+}
@@ -3,81 +3,239 @@ package buffer
 import (
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	log "common/log/newlog"
 
-	"github.com/go-logr/logr"
 	"github.com/pion/transport/packetio"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultAudioPackets  = 25
+	defaultOrphanTimeout = 30 * time.Second
 )
 
+var (
+	// 进程内所有Factory的bucket占用(字节), 新流按它做准入.
+	memoryInUse     int64
+	rejectedStreams uint64
+)
+
+// MemoryInUse returns the bytes of buckets held by the buffers of all factories, unbound buffers count as video
+func MemoryInUse() int64 {
+	return atomic.LoadInt64(&memoryInUse)
+}
+
+// RejectedStreams returns the number of new streams rejected by the memory cap
+func RejectedStreams() uint64 {
+	return atomic.LoadUint64(&rejectedStreams)
+}
+
 type Factory struct {
 	sync.RWMutex
 	videoPool   *sync.Pool
 	audioPool   *sync.Pool
 	rtpBuffers  map[uint32]*Buffer
 	rtcpReaders map[uint32]*RTCPReader
-	logger      logr.Logger
+	logger      log.Logger
+
+	videoSize     int64
+	audioSize     int64
+	maxMemory     int64
+	orphanTimeout time.Duration
+	lastSweep     int64
 }
 
-func NewBufferFactory(trackingPackets int, logger logr.Logger) *Factory {
-	// Enable package wide logging for non-method functions.
-	// If logger is empty - use default Logger.
-	// Logger is a public variable in buffer package.
-	if logger == (logr.Logger{}) {
-		logger = Logger
-	} else {
-		Logger = logger
+// FactoryOption -.
+type FactoryOption func(*Factory)
+
+// FactoryAudioPackets 音频bucket的包数, 默认25.
+func FactoryAudioPackets(n int) FactoryOption {
+	return func(f *Factory) {
+		if n > 0 {
+			f.audioSize = int64(n * maxPktSize)
+		}
 	}
+}
 
-	return &Factory{
-		videoPool: &sync.Pool{
-			New: func() interface{} {
-				b := make([]byte, trackingPackets*maxPktSize)
-				return &b
-			},
+// FactoryMaxMemory 所有Factory的bucket占用超过max(字节)时拒绝新的流, 0不限制.
+// 还没有Bind的buffer按视频bucket预留.
+func FactoryMaxMemory(max int64) FactoryOption {
+	return func(f *Factory) {
+		f.maxMemory = max
+	}
+}
+
+// FactoryOrphanTimeout 创建后超过timeout仍没有Bind的Buffer和没有使用者的RTCPReader会被关闭, 默认30s.
+func FactoryOrphanTimeout(timeout time.Duration) FactoryOption {
+	return func(f *Factory) {
+		if timeout > 0 {
+			f.orphanTimeout = timeout
+		}
+	}
+}
+
+// NewBufferFactory trackingPackets is the number of packets in a video bucket
+func NewBufferFactory(trackingPackets int, logger log.Logger, opts ...FactoryOption) *Factory {
+	if logger == nil {
+		logger = log.GetLogger()
+	}
+
+	f := &Factory{
+		rtpBuffers:    make(map[uint32]*Buffer),
+		rtcpReaders:   make(map[uint32]*RTCPReader),
+		logger:        logger,
+		videoSize:     int64(trackingPackets * maxPktSize),
+		audioSize:     int64(defaultAudioPackets * maxPktSize),
+		orphanTimeout: defaultOrphanTimeout,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	videoSize, audioSize := f.videoSize, f.audioSize
+	f.videoPool = &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, videoSize)
+			return &b
 		},
-		audioPool: &sync.Pool{
-			New: func() interface{} {
-				b := make([]byte, maxPktSize*25)
-				return &b
-			},
+	}
+	f.audioPool = &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, audioSize)
+			return &b
 		},
-		rtpBuffers:  make(map[uint32]*Buffer),
-		rtcpReaders: make(map[uint32]*RTCPReader),
-		logger:      logger,
 	}
+	return f
 }
 
 func (f *Factory) GetOrNew(packetType packetio.BufferPacketType, ssrc uint32) io.ReadWriteCloser {
 	f.Lock()
-	defer f.Unlock()
 	switch packetType {
 	case packetio.RTCPBufferPacket:
 		if reader, ok := f.rtcpReaders[ssrc]; ok {
+			f.Unlock()
 			return reader
 		}
+		orphans := f.sweep(false)
 		reader := NewRTCPReader(ssrc)
 		f.rtcpReaders[ssrc] = reader
 		reader.OnClose(func() {
 			f.Lock()
-			delete(f.rtcpReaders, ssrc)
+			if f.rtcpReaders[ssrc] == reader {
+				delete(f.rtcpReaders, ssrc)
+			}
 			f.Unlock()
 		})
+		f.Unlock()
+		closeAll(orphans)
 		return reader
 	case packetio.RTPBufferPacket:
 		if reader, ok := f.rtpBuffers[ssrc]; ok {
+			f.Unlock()
 			return reader
 		}
+		over := f.maxMemory > 0 && MemoryInUse()+f.videoSize > f.maxMemory
+		orphans := f.sweep(over)
+		if over {
+			// 关闭孤儿释放预留后再检查, 仍然超出时拒绝.
+			f.Unlock()
+			closeAll(orphans)
+			orphans = nil
+			if MemoryInUse()+f.videoSize > f.maxMemory {
+				atomic.AddUint64(&rejectedStreams, 1)
+				f.logger.Warn("buffer memory cap reached, stream rejected", "ssrc", ssrc, "in_use", MemoryInUse(), "max", f.maxMemory)
+				return rejectedBuffer(ssrc, f.logger)
+			}
+			f.Lock()
+			if reader, ok := f.rtpBuffers[ssrc]; ok {
+				f.Unlock()
+				return reader
+			}
+		}
 		buffer := NewBuffer(ssrc, f.videoPool, f.audioPool, f.logger)
+		buffer.created = time.Now().UnixNano()
+		buffer.onBind = func(codecType webrtc.RTPCodecType) {
+			atomic.AddInt64(&memoryInUse, f.bucketSize(codecType)-f.videoSize)
+		}
+		atomic.AddInt64(&memoryInUse, f.videoSize)
 		f.rtpBuffers[ssrc] = buffer
 		buffer.OnClose(func() {
+			// Close持有buffer的锁, bound和codecType不会再变.
+			if buffer.bound.get() {
+				atomic.AddInt64(&memoryInUse, -f.bucketSize(buffer.codecType))
+			} else {
+				atomic.AddInt64(&memoryInUse, -f.videoSize)
+			}
 			f.Lock()
-			delete(f.rtpBuffers, ssrc)
+			if f.rtpBuffers[ssrc] == buffer {
+				delete(f.rtpBuffers, ssrc)
+			}
 			f.Unlock()
 		})
+		f.Unlock()
+		closeAll(orphans)
 		return buffer
 	}
+	f.Unlock()
 	return nil
 }
 
+func (f *Factory) bucketSize(codecType webrtc.RTPCodecType) int64 {
+	switch codecType {
+	case webrtc.RTPCodecTypeVideo:
+		return f.videoSize
+	case webrtc.RTPCodecTypeAudio:
+		return f.audioSize
+	}
+	return 0
+}
+
+// sweep 从表中移除超时的孤儿, 由调用者在释放锁后关闭. force为false时最多每半个超时检查一次.
+// 孤儿是创建后一直没有Bind的Buffer(pion为未协商的ssrc创建, 或sip对端发来的未知ssrc),
+// 和没有设置OnPacket的RTCPReader(rtcp中引用的未知ssrc).
+func (f *Factory) sweep(force bool) (orphans []io.Closer) {
+	now := time.Now().UnixNano()
+	if !force && now-f.lastSweep < int64(f.orphanTimeout)/2 {
+		return nil
+	}
+	f.lastSweep = now
+	deadline := now - int64(f.orphanTimeout)
+
+	for ssrc, b := range f.rtpBuffers {
+		if b.orphan(deadline) {
+			delete(f.rtpBuffers, ssrc)
+			orphans = append(orphans, b)
+		}
+	}
+	for ssrc, r := range f.rtcpReaders {
+		if r.orphan(deadline) {
+			delete(f.rtcpReaders, ssrc)
+			orphans = append(orphans, r)
+		}
+	}
+	if len(orphans) > 0 {
+		f.logger.Info("closing orphan buffers", "count", len(orphans))
+	}
+	return orphans
+}
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		_ = c.Close()
+	}
+}
+
+// rejectedBuffer 被拒绝的流, 不在表中, 读写都返回io.EOF.
+func rejectedBuffer(ssrc uint32, logger log.Logger) *Buffer {
+	b := NewBuffer(ssrc, nil, nil, logger)
+	b.OnClose(func() {})
+	_ = b.Close()
+	return b
+}
+
 func (f *Factory) GetBufferPair(ssrc uint32) (*Buffer, *RTCPReader) {
 	f.RLock()
 	defer f.RUnlock()
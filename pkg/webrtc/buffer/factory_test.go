@@ -0,0 +1,64 @@
+package buffer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	log "common/log/newlog"
+
+	"github.com/pion/transport/packetio"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFactory_Memory(t *testing.T) {
+	base, rejectedBase := MemoryInUse(), RejectedStreams()
+	videoSize, audioSize := int64(10*maxPktSize), int64(2*maxPktSize)
+	f := NewBufferFactory(10, log.GetLogger(), FactoryAudioPackets(2), FactoryMaxMemory(base+2*videoSize))
+
+	// 没有Bind之前按视频预留, Bind音频后按音频计算.
+	audio := f.GetOrNew(packetio.RTPBufferPacket, 1).(*Buffer)
+	assert.Equal(t, base+videoSize, MemoryInUse())
+	audio.Bind(webrtc.RTPParameters{Codecs: []webrtc.RTPCodecParameters{{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000},
+	}}}, Options{})
+	assert.Equal(t, base+audioSize, MemoryInUse())
+	assert.Len(t, *audio.bucket.src, int(audioSize))
+
+	video := f.GetOrNew(packetio.RTPBufferPacket, 2).(*Buffer)
+	assert.Equal(t, base+audioSize+videoSize, MemoryInUse())
+
+	// 超出上限的新流被拒绝, 不在表中.
+	rejected := f.GetOrNew(packetio.RTPBufferPacket, 3).(*Buffer)
+	assert.Nil(t, f.GetBuffer(3))
+	_, err := rejected.Write([]byte{0x80, 0, 0, 1})
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, rejectedBase+1, RejectedStreams())
+
+	assert.NoError(t, video.Close())
+	assert.NoError(t, audio.Close())
+	assert.Equal(t, base, MemoryInUse())
+	assert.Nil(t, f.GetBuffer(1))
+}
+
+func TestFactory_Orphans(t *testing.T) {
+	base := MemoryInUse()
+	f := NewBufferFactory(10, log.GetLogger(), FactoryOrphanTimeout(20*time.Millisecond))
+
+	orphan := f.GetOrNew(packetio.RTPBufferPacket, 1).(*Buffer)
+	used := f.GetOrNew(packetio.RTCPBufferPacket, 1).(*RTCPReader)
+	used.OnPacket(func([]byte) {})
+	unused := f.GetOrNew(packetio.RTCPBufferPacket, 2).(*RTCPReader)
+
+	time.Sleep(30 * time.Millisecond)
+	// 新建时清理超时没有Bind的Buffer和没有使用者的RTCPReader.
+	f.GetOrNew(packetio.RTCPBufferPacket, 3)
+	assert.True(t, orphan.closed.get())
+	assert.True(t, unused.closed.get())
+	assert.False(t, used.closed.get())
+	assert.Nil(t, f.GetBuffer(1))
+	assert.Nil(t, f.GetRTCPReader(2))
+	assert.Equal(t, used, f.GetRTCPReader(1))
+	assert.Equal(t, base, MemoryInUse())
+}
@@ -0,0 +1,60 @@
+package buffer
+
+import (
+	"errors"
+)
+
+// FrameMarkingURI frame marking头扩展(draft-ietf-avtext-framemarking), 不解析payload就能知道帧边界、关键帧和层,
+// 端到端加密(E2EE)的payload无法解析时靠它转发.
+const FrameMarkingURI = "urn:ietf:params:rtp-hdrext:framemarking"
+
+var errFrameMarkingShort = errors.New("frame marking is not large enough")
+
+// FrameMarking is the frame marking header extension of a packet
+/*
+	 0                   1                   2
+	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|S|E|I|D|B| TID |      LID      |   TL0PICIDX   |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	非分层的流只有第一个字节, B和TID为0.
+*/
+type FrameMarking struct {
+	StartOfFrame  bool
+	EndOfFrame    bool
+	Independent   bool
+	Discardable   bool
+	BaseLayerSync bool
+	TID           uint8
+	// LID 分层的流才有, vp9为空间层id
+	LID       uint8
+	TL0PICIDX uint8
+	Scalable  bool
+}
+
+// Unmarshal parses the extension payload
+func (f *FrameMarking) Unmarshal(buf []byte) error {
+	if len(buf) < 1 {
+		return errFrameMarkingShort
+	}
+	f.StartOfFrame = buf[0]&0x80 != 0
+	f.EndOfFrame = buf[0]&0x40 != 0
+	f.Independent = buf[0]&0x20 != 0
+	f.Discardable = buf[0]&0x10 != 0
+	f.Scalable = len(buf) > 1
+	if !f.Scalable {
+		return nil
+	}
+	f.BaseLayerSync = buf[0]&0x08 != 0
+	f.TID = buf[0] & 0x07
+	f.LID = buf[1]
+	if len(buf) > 2 {
+		f.TL0PICIDX = buf[2]
+	}
+	return nil
+}
+
+// IsKeyFrame is true for the first packet of an independent frame of the base spatial layer
+func (f *FrameMarking) IsKeyFrame() bool {
+	return f.StartOfFrame && f.Independent && f.LID == 0
+}
@@ -0,0 +1,35 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameMarking_Unmarshal(t *testing.T) {
+	f := &FrameMarking{}
+	assert.Error(t, f.Unmarshal(nil))
+
+	// 非分层: S|I
+	assert.NoError(t, f.Unmarshal([]byte{0xa0}))
+	assert.True(t, f.StartOfFrame)
+	assert.False(t, f.EndOfFrame)
+	assert.False(t, f.Scalable)
+	assert.True(t, f.IsKeyFrame())
+
+	// 分层: E|D|B, TID 2, LID 1, TL0PICIDX 7
+	f = &FrameMarking{}
+	assert.NoError(t, f.Unmarshal([]byte{0x5a, 0x01, 0x07}))
+	assert.False(t, f.StartOfFrame)
+	assert.True(t, f.EndOfFrame)
+	assert.True(t, f.Discardable)
+	assert.True(t, f.BaseLayerSync)
+	assert.True(t, f.Scalable)
+	assert.Equal(t, uint8(2), f.TID)
+	assert.Equal(t, uint8(1), f.LID)
+	assert.Equal(t, uint8(7), f.TL0PICIDX)
+
+	// 空间层1的独立帧不是关键帧.
+	assert.NoError(t, f.Unmarshal([]byte{0xa0, 0x01}))
+	assert.False(t, f.IsKeyFrame())
+}
@@ -8,8 +8,9 @@ import (
 )
 
 var (
-	errShortPacket = errors.New("packet is not large enough")
-	errNilPacket   = errors.New("invalid nil packet")
+	errShortPacket   = errors.New("packet is not large enough")
+	errNilPacket     = errors.New("invalid nil packet")
+	errInvalidPacket = errors.New("invalid packet")
 )
 
 type atomicBool int32
@@ -144,6 +145,258 @@ func (p *VP8) Unmarshal(payload []byte) error {
 	return nil
 }
 
+// VP9 is a helper to get layer data from VP9 packet header
+/*
+	VP9 Payload Descriptor, https://datatracker.ietf.org/doc/html/rfc9628
+	flexible mode(F=1)                      non-flexible mode(F=0)
+			0 1 2 3 4 5 6 7                      0 1 2 3 4 5 6 7
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+			|I|P|L|F|B|E|V|Z| (REQUIRED)        |I|P|L|F|B|E|V|Z| (REQUIRED)
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+		I:  |M| PICTURE ID  | (REQUIRED)   I:   |M| PICTURE ID  | (RECOMMENDED)
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+		M:  | EXTENDED PID  | (RECOMMENDED) M:  | EXTENDED PID  | (RECOMMENDED)
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+		L:  | TID |U| SID |D| (COND)       L:   | TID |U| SID |D| (COND)
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+		P,F:| P_DIFF      |N| (COND, <=3)       |   TL0PICIDX   | (COND)
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+		V:  | SS            |              V:   | SS            |
+			| ..            |                   | ..            |
+			+-+-+-+-+-+-+-+-+                   +-+-+-+-+-+-+-+-+
+
+	Scalability Structure (SS)
+			+-+-+-+-+-+-+-+-+
+		V:  | N_S |Y|G|-|-|-|
+			+-+-+-+-+-+-+-+-+              -|
+		Y:  |     WIDTH     | (OPTIONAL)    .
+			+               +               .
+			|               | (OPTIONAL)    .
+			+-+-+-+-+-+-+-+-+               . N_S + 1 times
+			|     HEIGHT    | (OPTIONAL)    .
+			+               +               .
+			|               | (OPTIONAL)    .
+			+-+-+-+-+-+-+-+-+              -|
+		G:  |      N_G      | (OPTIONAL)
+			+-+-+-+-+-+-+-+-+                           -|
+		N_G:| TID |U| R |-|-| (OPTIONAL)                 .
+			+-+-+-+-+-+-+-+-+              -|            . N_G times
+			|    P_DIFF     | (OPTIONAL)    . R times    .
+			+-+-+-+-+-+-+-+-+              -|           -|
+*/
+type VP9 struct {
+	PictureID uint16 /* 7 or 15 bits, picture ID */
+	MBit      bool
+	Flexible  bool
+	// InterPicturePredicted P bit, the picture is predicted from earlier pictures.
+	InterPicturePredicted bool
+	BeginFrame            bool // B bit, first packet of a layer frame
+	EndFrame              bool // E bit, last packet of a layer frame
+
+	// Layer indices, present if L bit is set to 1
+	LayerIndices bool
+	TID          uint8 /* 3 bits temporal layer id */
+	U            bool  /* switching up point */
+	SID          uint8 /* 3 bits spatial layer id */
+	D            bool  /* inter-layer dependency used */
+	TL0PICIDX    uint8 /* non-flexible mode only */
+	PDiff        []uint8
+
+	// Scalability structure, present if V bit is set to 1
+	NumSpatialLayers int // N_S + 1, 0 if there is no SS
+	Width            []uint16
+	Height           []uint16
+
+	// HeaderSize is the size of the payload descriptor
+	HeaderSize int
+	// IsKeyFrame is a helper to detect if current packet is the beginning of a keyframe
+	IsKeyFrame bool
+}
+
+// Unmarshal parses the passed byte slice and stores the result in the VP9 this method is called upon
+func (p *VP9) Unmarshal(payload []byte) error {
+	if payload == nil {
+		return errNilPacket
+	}
+	payloadLen := len(payload)
+	if payloadLen < 1 {
+		return errShortPacket
+	}
+
+	idx := 0
+	I := payload[idx]&0x80 > 0
+	p.InterPicturePredicted = payload[idx]&0x40 > 0
+	p.LayerIndices = payload[idx]&0x20 > 0
+	p.Flexible = payload[idx]&0x10 > 0
+	p.BeginFrame = payload[idx]&0x08 > 0
+	p.EndFrame = payload[idx]&0x04 > 0
+	V := payload[idx]&0x02 > 0
+	idx++
+
+	// Check for PictureID
+	if I {
+		if payloadLen < idx+1 {
+			return errShortPacket
+		}
+		pid := uint16(payload[idx] & 0x7f)
+		// Check if m is 1, then Picture ID is 15 bits
+		if payload[idx]&0x80 > 0 {
+			idx++
+			if payloadLen < idx+1 {
+				return errShortPacket
+			}
+			p.MBit = true
+			pid = pid<<8 | uint16(payload[idx])
+		}
+		p.PictureID = pid
+		idx++
+	}
+
+	// Check for layer indices
+	if p.LayerIndices {
+		if payloadLen < idx+1 {
+			return errShortPacket
+		}
+		p.TID = payload[idx] >> 5
+		p.U = payload[idx]&0x10 > 0
+		p.SID = (payload[idx] >> 1) & 0x07
+		p.D = payload[idx]&0x01 > 0
+		idx++
+		if !p.Flexible {
+			if payloadLen < idx+1 {
+				return errShortPacket
+			}
+			p.TL0PICIDX = payload[idx]
+			idx++
+		}
+	}
+
+	// Reference indices, flexible mode only
+	if p.Flexible && p.InterPicturePredicted {
+		for {
+			if payloadLen < idx+1 {
+				return errShortPacket
+			}
+			p.PDiff = append(p.PDiff, payload[idx]>>1)
+			n := payload[idx]&0x01 > 0
+			idx++
+			if !n {
+				break
+			}
+			if len(p.PDiff) >= 3 {
+				return errInvalidPacket
+			}
+		}
+	}
+
+	// Scalability structure
+	if V {
+		var err error
+		if idx, err = p.unmarshalSS(payload, idx); err != nil {
+			return err
+		}
+	}
+
+	p.HeaderSize = idx
+	// Keyframe: first packet of a picture not predicted from earlier pictures, at the base spatial layer
+	p.IsKeyFrame = !p.InterPicturePredicted && p.BeginFrame && p.SID == 0
+	return nil
+}
+
+func (p *VP9) unmarshalSS(payload []byte, idx int) (int, error) {
+	payloadLen := len(payload)
+	if payloadLen < idx+1 {
+		return idx, errShortPacket
+	}
+	p.NumSpatialLayers = int(payload[idx]>>5) + 1
+	Y := payload[idx]&0x10 > 0
+	G := payload[idx]&0x08 > 0
+	idx++
+
+	if Y {
+		if payloadLen < idx+4*p.NumSpatialLayers {
+			return idx, errShortPacket
+		}
+		p.Width = make([]uint16, p.NumSpatialLayers)
+		p.Height = make([]uint16, p.NumSpatialLayers)
+		for i := 0; i < p.NumSpatialLayers; i++ {
+			p.Width[i] = binary.BigEndian.Uint16(payload[idx:])
+			p.Height[i] = binary.BigEndian.Uint16(payload[idx+2:])
+			idx += 4
+		}
+	}
+
+	if G {
+		if payloadLen < idx+1 {
+			return idx, errShortPacket
+		}
+		ng := int(payload[idx])
+		idx++
+		for i := 0; i < ng; i++ {
+			if payloadLen < idx+1 {
+				return idx, errShortPacket
+			}
+			r := int(payload[idx]>>2) & 0x03
+			idx += 1 + r
+			if payloadLen < idx {
+				return idx, errShortPacket
+			}
+		}
+	}
+	return idx, nil
+}
+
+// AV1 is a helper to get the aggregation header of AV1 packet
+/*
+	AV1 Aggregation Header, https://aomediacodec.github.io/av1-rtp-spec/
+			0 1 2 3 4 5 6 7
+			+-+-+-+-+-+-+-+-+
+			|Z|Y| W |N|-|-|-|
+			+-+-+-+-+-+-+-+-+
+*/
+type AV1 struct {
+	Z bool  // the first OBU element is a continuation of the previous packet
+	Y bool  // the last OBU element continues in the next packet
+	W uint8 // number of OBU elements, 0 means every element has a length field
+	N bool  // the first packet of a coded video sequence
+	// IsKeyFrame is a helper to detect if current packet is the beginning of a keyframe
+	IsKeyFrame bool
+}
+
+const obuSequenceHeader = 1
+
+// Unmarshal parses the passed byte slice and stores the result in the AV1 this method is called upon
+func (p *AV1) Unmarshal(payload []byte) error {
+	if payload == nil {
+		return errNilPacket
+	}
+	if len(payload) < 2 {
+		return errShortPacket
+	}
+
+	p.Z = payload[0]&0x80 > 0
+	p.Y = payload[0]&0x40 > 0
+	p.W = (payload[0] >> 4) & 0x03
+	p.N = payload[0]&0x08 > 0
+
+	p.IsKeyFrame = p.N
+	if !p.IsKeyFrame && !p.Z {
+		// 有的发送端不设置N, 新序列以sequence header开始.
+		idx := 1
+		if p.W != 1 {
+			// skip the leb128 length of the first element
+			for idx < len(payload) && payload[idx]&0x80 > 0 {
+				idx++
+			}
+			idx++
+		}
+		if idx < len(payload) {
+			p.IsKeyFrame = (payload[idx]>>3)&0x0f == obuSequenceHeader
+		}
+	}
+	return nil
+}
+
 // isH264Keyframe detects if h264 payload is a keyframe
 // this code was taken from https://github.com/jech/galene/blob/codecs/rtpconn/rtpreader.go#L45
 // all credits belongs to Juliusz Chroboczek @jech and the awesome Galene SFU
@@ -185,7 +438,7 @@ func isH264Keyframe(payload []byte) bool {
 				return false
 			}
 			n := payload[i+offset] & 0x1F
-			if n == 7 {
+			if n == 7 || n == 5 {
 				return true
 			} else if n >= 24 {
 				// is this legal?
@@ -206,7 +459,109 @@ func isH264Keyframe(payload []byte) bool {
 			// not a starting fragment
 			return false
 		}
-		return payload[1]&0x1F == 7
+		// 只发一次SPS/PPS的编码器, 之后的IDR都是分片的.
+		n := payload[1] & 0x1F
+		return n == 7 || n == 5
+	}
+	return false
+}
+
+const (
+	h264NaluSPS   = 7
+	h264NaluPPS   = 8
+	h264NaluSTAPA = 24
+)
+
+// h264NALUs returns the NAL units of a single NAL unit packet or a STAP-A, nil for fragments
+func h264NALUs(payload []byte) [][]byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	nalu := payload[0] & 0x1F
+	switch {
+	case nalu > 0 && nalu < 24:
+		return [][]byte{payload}
+	case nalu == h264NaluSTAPA:
+		var nalus [][]byte
+		for i := 1; i+2 <= len(payload); {
+			length := int(payload[i])<<8 | int(payload[i+1])
+			i += 2
+			if length == 0 || i+length > len(payload) {
+				break
+			}
+			nalus = append(nalus, payload[i:i+length])
+			i += length
+		}
+		return nalus
+	}
+	return nil
+}
+
+// HasH264ParameterSets returns true if the payload carries a SPS
+func HasH264ParameterSets(payload []byte) bool {
+	for _, nalu := range h264NALUs(payload) {
+		if nalu[0]&0x1F == h264NaluSPS {
+			return true
+		}
+	}
+	return false
+}
+
+// h264STAPA aggregates the NAL units into a STAP-A payload, NRI is the highest of them.
+func h264STAPA(nalus ...[]byte) []byte {
+	size, nri := 1, byte(0)
+	for _, nalu := range nalus {
+		size += 2 + len(nalu)
+		if n := nalu[0] & 0x60; n > nri {
+			nri = n
+		}
+	}
+	payload := make([]byte, 1, size)
+	payload[0] = nri | h264NaluSTAPA
+	for _, nalu := range nalus {
+		payload = append(payload, byte(len(nalu)>>8), byte(len(nalu)))
+		payload = append(payload, nalu...)
+	}
+	return payload
+}
+
+// isH265Keyframe detects if h265 payload starts an IRAP picture(BLA, IDR or CRA),
+// parameter sets before it come in the same aggregation packet or separately.
+func isH265Keyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+	nalu := (payload[0] >> 1) & 0x3F
+	switch {
+	case nalu < 48:
+		// single NAL unit
+		return isH265IRAP(nalu)
+	case nalu == 48:
+		// AP, 不带DONL(sprop-max-don-diff=0)
+		i := 2
+		for i+2 <= len(payload) {
+			length := int(payload[i])<<8 | int(payload[i+1])
+			i += 2
+			if length < 2 || i+length > len(payload) {
+				return false
+			}
+			if isH265IRAP((payload[i] >> 1) & 0x3F) {
+				return true
+			}
+			i += length
+		}
+		return false
+	case nalu == 49:
+		// FU, 只看第一个分片
+		if len(payload) < 3 || payload[2]&0x80 == 0 {
+			return false
+		}
+		return isH265IRAP(payload[2] & 0x3F)
 	}
 	return false
 }
+
+// isH265IRAP nal unit type 16-23 (BLA_W_LP ~ RSV_IRAP_VCL23).
+func isH265IRAP(nalu byte) bool {
+	return nalu >= 16 && nalu <= 23
+}
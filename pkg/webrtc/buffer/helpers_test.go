@@ -92,3 +92,84 @@ func TestVP8Helper_Unmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestVP9Helper_Unmarshal(t *testing.T) {
+	t.Run("Empty or nil payload must return error", func(t *testing.T) {
+		p := &VP9{}
+		assert.Error(t, p.Unmarshal(nil))
+		assert.Error(t, p.Unmarshal([]byte{}))
+	})
+
+	t.Run("Non-flexible keyframe with scalability structure", func(t *testing.T) {
+		p := &VP9{}
+		err := p.Unmarshal([]byte{
+			0xaa,       // I|L|B|V
+			0x81, 0x23, // M=1, picture id 0x123
+			0x00, 0x05, // TID=0 SID=0, TL0PICIDX=5
+			0x50, // N_S=2, Y=1
+			0x01, 0x40, 0x00, 0xb4,
+			0x02, 0x80, 0x01, 0x68,
+			0x05, 0x00, 0x02, 0xd0,
+			0x00,
+		})
+		assert.NoError(t, err)
+		assert.True(t, p.IsKeyFrame)
+		assert.True(t, p.BeginFrame)
+		assert.False(t, p.Flexible)
+		assert.Equal(t, uint16(0x123), p.PictureID)
+		assert.Equal(t, uint8(5), p.TL0PICIDX)
+		assert.Equal(t, 3, p.NumSpatialLayers)
+		assert.Equal(t, []uint16{320, 640, 1280}, p.Width)
+		assert.Equal(t, []uint16{180, 360, 720}, p.Height)
+		assert.Equal(t, 18, p.HeaderSize)
+	})
+
+	t.Run("Flexible inter picture with reference indices", func(t *testing.T) {
+		p := &VP9{}
+		err := p.Unmarshal([]byte{0xf4, 0x05, 0x53, 0x03, 0x04, 0x00})
+		assert.NoError(t, err)
+		assert.False(t, p.IsKeyFrame)
+		assert.True(t, p.Flexible)
+		assert.True(t, p.EndFrame)
+		assert.Equal(t, uint16(5), p.PictureID)
+		assert.Equal(t, uint8(2), p.TID)
+		assert.True(t, p.U)
+		assert.Equal(t, uint8(1), p.SID)
+		assert.True(t, p.D)
+		assert.Equal(t, []uint8{1, 2}, p.PDiff)
+		assert.Equal(t, 5, p.HeaderSize)
+	})
+
+	t.Run("Truncated or too many reference indices must return error", func(t *testing.T) {
+		p := &VP9{}
+		assert.Error(t, p.Unmarshal([]byte{0xf4, 0x05, 0x53, 0x03}))
+		p = &VP9{}
+		assert.Error(t, p.Unmarshal([]byte{0xf4, 0x05, 0x53, 0x03, 0x03, 0x03, 0x03}))
+	})
+}
+
+func TestIsH265Keyframe(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    bool
+	}{
+		{name: "Empty", payload: nil, want: false},
+		{name: "IDR", payload: []byte{0x26, 0x01, 0xaf}, want: true},
+		{name: "CRA", payload: []byte{0x2a, 0x01, 0xaf}, want: true},
+		{name: "TrailR", payload: []byte{0x02, 0x01, 0xaf}, want: false},
+		// AP: VPS + IDR
+		{name: "AggregationWithIDR", payload: []byte{0x60, 0x01, 0x00, 0x03, 0x40, 0x01, 0x0c, 0x00, 0x03, 0x26, 0x01, 0xaf}, want: true},
+		{name: "AggregationWithoutIRAP", payload: []byte{0x60, 0x01, 0x00, 0x03, 0x40, 0x01, 0x0c, 0x00, 0x03, 0x02, 0x01, 0xaf}, want: false},
+		{name: "AggregationTruncated", payload: []byte{0x60, 0x01, 0x00, 0x09, 0x26, 0x01}, want: false},
+		{name: "FragmentStartIDR", payload: []byte{0x62, 0x01, 0x93, 0xaf}, want: true},
+		{name: "FragmentMiddleIDR", payload: []byte{0x62, 0x01, 0x13, 0xaf}, want: false},
+		{name: "FragmentStartTrail", payload: []byte{0x62, 0x01, 0x81, 0xaf}, want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isH265Keyframe(tt.payload))
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package buffer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJitterMinDelay = 20 * time.Millisecond
+	defaultJitterMaxDelay = 200 * time.Millisecond
+
+	// jitterDelayFactor 目标延时 = jitter * factor, 覆盖绝大部分抖动.
+	jitterDelayFactor = 3
+	maxJitterPackets  = 512
+)
+
+// JitterOptions provides configuration options for the egress jitter buffer
+type JitterOptions struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+type jitterPacket struct {
+	extSN   uint32
+	extTS   uint64
+	playout int64
+	pkt     *ExtPacket
+}
+
+// JitterBuffer 用于纯rtp出口的自适应抖动缓冲:
+// 按扩展序列号重排, 按rtp时间戳平滑发送, 延时在[MinDelay, MaxDelay]之间自适应.
+// SIP话机等终端抖动缓冲很小或者没有, 直接转发时突发包会被丢弃.
+type JitterBuffer struct {
+	sync.Mutex
+	clockRate uint32
+	minDelay  int64
+	maxDelay  int64
+	delay     int64
+
+	packets []jitterPacket
+
+	init        bool
+	released    bool
+	lastSN      uint32 // 最近一次出队的扩展序列号
+	baseTS      uint64
+	baseTime    int64
+	tsCycles    uint64
+	lastTS      uint32
+	lastTransit int64
+	jitter      float64 // nanos
+
+	stats Stats
+}
+
+// NewJitterBuffer constructs a new JitterBuffer
+func NewJitterBuffer(clockRate uint32, o JitterOptions) *JitterBuffer {
+	if o.MinDelay <= 0 {
+		o.MinDelay = defaultJitterMinDelay
+	}
+	if o.MaxDelay < o.MinDelay {
+		o.MaxDelay = defaultJitterMaxDelay
+		if o.MaxDelay < o.MinDelay {
+			o.MaxDelay = o.MinDelay
+		}
+	}
+	return &JitterBuffer{
+		clockRate: clockRate,
+		minDelay:  int64(o.MinDelay),
+		maxDelay:  int64(o.MaxDelay),
+		delay:     int64(o.MinDelay),
+		packets:   make([]jitterPacket, 0, 64),
+	}
+}
+
+// Push adds a packet, the packet is copied since bucket memory may be reused before playout.
+func (j *JitterBuffer) Push(ep *ExtPacket) {
+	j.Lock()
+	defer j.Unlock()
+
+	extSN := ep.Cycle | uint32(ep.Packet.SequenceNumber)
+	if j.released && int32(extSN-j.lastSN) <= 0 {
+		j.stats.LateDropped++
+		return
+	}
+
+	i := sort.Search(len(j.packets), func(i int) bool { return int32(j.packets[i].extSN-extSN) >= 0 })
+	if i < len(j.packets) && j.packets[i].extSN == extSN {
+		// duplicate.
+		return
+	}
+
+	extTS := j.extendTimestamp(ep.Packet.Timestamp)
+	j.updateDelay(ep.Arrival, extTS)
+
+	if !j.init {
+		j.baseTS = extTS
+		j.baseTime = ep.Arrival
+		j.init = true
+	}
+
+	cp := *ep
+	cp.Packet.Payload = append([]byte(nil), ep.Packet.Payload...)
+	jp := jitterPacket{
+		extSN:   extSN,
+		extTS:   extTS,
+		playout: j.baseTime + j.tsToNanos(int64(extTS)-int64(j.baseTS)) + j.delay,
+		pkt:     &cp,
+	}
+	if i == len(j.packets) {
+		j.packets = append(j.packets, jp)
+	} else {
+		j.packets = append(j.packets[:i+1], j.packets[i:]...)
+		j.packets[i] = jp
+	}
+
+	j.stats.PacketCount++
+	j.stats.TotalByte += uint64(len(cp.Packet.Payload))
+
+	// 缓存过大时丢弃最旧的包.
+	if len(j.packets) > maxJitterPackets {
+		j.stats.LateDropped++
+		j.packets = j.packets[1:]
+	}
+}
+
+// Pop returns the next packet due for playout at now, nil if nothing is due.
+func (j *JitterBuffer) Pop(now int64) *ExtPacket {
+	j.Lock()
+	defer j.Unlock()
+
+	if len(j.packets) == 0 {
+		return nil
+	}
+	head := j.packets[0]
+	if head.playout > now && now-head.pkt.Arrival < j.maxDelay {
+		return nil
+	}
+	j.packets[0] = jitterPacket{}
+	j.packets = j.packets[1:]
+
+	// 出队顺序是递增的, 出队的包都可以作为head处理.
+	head.pkt.Head = !j.released || int32(head.extSN-j.lastSN) > 0
+	if head.pkt.Head {
+		j.lastSN = head.extSN
+		j.released = true
+	}
+	if len(j.packets) == 0 {
+		// 缓冲为空时重新计算时钟基准, 下一个包使用新的目标延时.
+		j.init = false
+	}
+	return head.pkt
+}
+
+// NextPlayout returns how long to wait until the head packet is due, -1 if empty.
+func (j *JitterBuffer) NextPlayout(now int64) time.Duration {
+	j.Lock()
+	defer j.Unlock()
+
+	if len(j.packets) == 0 {
+		return -1
+	}
+	head := j.packets[0]
+	due := head.playout
+	if deadline := head.pkt.Arrival + j.maxDelay; deadline < due {
+		due = deadline
+	}
+	if due <= now {
+		return 0
+	}
+	return time.Duration(due - now)
+}
+
+// Delay returns the current target playout delay
+func (j *JitterBuffer) Delay() time.Duration {
+	j.Lock()
+	defer j.Unlock()
+	return time.Duration(j.delay)
+}
+
+// Len returns the number of buffered packets
+func (j *JitterBuffer) Len() int {
+	j.Lock()
+	defer j.Unlock()
+	return len(j.packets)
+}
+
+// GetStats returns the jitter buffer statistics
+func (j *JitterBuffer) GetStats() Stats {
+	j.Lock()
+	defer j.Unlock()
+	return j.stats
+}
+
+func (j *JitterBuffer) extendTimestamp(ts uint32) uint64 {
+	if j.stats.PacketCount == 0 {
+		j.lastTS = ts
+		return uint64(ts)
+	}
+	if int32(ts-j.lastTS) >= 0 {
+		if ts < j.lastTS {
+			j.tsCycles += 1 << 32
+		}
+		j.lastTS = ts
+	} else if ts > j.lastTS {
+		// 回绕前的乱序包.
+		return j.tsCycles - 1<<32 + uint64(ts)
+	}
+	return j.tsCycles + uint64(ts)
+}
+
+// updateDelay 使用RFC3550的方法估算抖动, 目标延时只在缓冲为空时生效.
+func (j *JitterBuffer) updateDelay(arrival int64, extTS uint64) {
+	if j.clockRate == 0 {
+		return
+	}
+	transit := arrival - j.tsToNanos(int64(extTS))
+	if j.lastTransit != 0 {
+		d := transit - j.lastTransit
+		if d < 0 {
+			d = -d
+		}
+		j.jitter += (float64(d) - j.jitter) / 16
+		// Stats中的jitter和Buffer一致, 使用rtp时间戳单位.
+		j.stats.Jitter = j.jitter * float64(j.clockRate) / float64(time.Second)
+	}
+	j.lastTransit = transit
+
+	if j.init {
+		return
+	}
+	delay := int64(j.jitter) * jitterDelayFactor
+	if delay < j.minDelay {
+		delay = j.minDelay
+	}
+	if delay > j.maxDelay {
+		delay = j.maxDelay
+	}
+	j.delay = delay
+}
+
+func (j *JitterBuffer) tsToNanos(ts int64) int64 {
+	if j.clockRate == 0 {
+		return 0
+	}
+	return ts * int64(time.Second) / int64(j.clockRate)
+}
@@ -0,0 +1,106 @@
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func createJitterPacket(sn uint16, ts uint32, arrival int64) *ExtPacket {
+	return &ExtPacket{
+		Arrival: arrival,
+		Packet: rtp.Packet{
+			Header: rtp.Header{
+				SequenceNumber: sn,
+				Timestamp:      ts,
+			},
+			Payload: []byte{1, 2, 3},
+		},
+	}
+}
+
+func TestJitterBuffer_Reorder(t *testing.T) {
+	jb := NewJitterBuffer(8000, JitterOptions{MinDelay: 40 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+	start := time.Now().UnixNano()
+
+	// 20ms ptime, packets 2 and 3 arrive swapped.
+	jb.Push(createJitterPacket(1, 160, start))
+	jb.Push(createJitterPacket(3, 480, start+int64(40*time.Millisecond)))
+	jb.Push(createJitterPacket(2, 320, start+int64(41*time.Millisecond)))
+	assert.Equal(t, 3, jb.Len())
+
+	// Nothing is due before the playout delay.
+	assert.Nil(t, jb.Pop(start+int64(10*time.Millisecond)))
+
+	var sns []uint16
+	for now := start; now < start+int64(200*time.Millisecond); now += int64(5 * time.Millisecond) {
+		if p := jb.Pop(now); p != nil {
+			assert.True(t, p.Head)
+			sns = append(sns, p.Packet.SequenceNumber)
+		}
+	}
+	assert.Equal(t, []uint16{1, 2, 3}, sns)
+}
+
+func TestJitterBuffer_Pacing(t *testing.T) {
+	jb := NewJitterBuffer(8000, JitterOptions{MinDelay: 20 * time.Millisecond, MaxDelay: 200 * time.Millisecond})
+	start := time.Now().UnixNano()
+
+	// A burst of 5 packets arriving at the same time must be spread by their timestamps.
+	for i := uint16(0); i < 5; i++ {
+		jb.Push(createJitterPacket(i, uint32(i)*160, start))
+	}
+
+	var released []int64
+	for now := start; now < start+int64(200*time.Millisecond); now += int64(time.Millisecond) {
+		if p := jb.Pop(now); p != nil {
+			released = append(released, now)
+		}
+	}
+	assert.Len(t, released, 5)
+	for i := 1; i < len(released); i++ {
+		assert.InDelta(t, int64(20*time.Millisecond), released[i]-released[i-1], float64(time.Millisecond))
+	}
+}
+
+func TestJitterBuffer_LateDrop(t *testing.T) {
+	jb := NewJitterBuffer(8000, JitterOptions{MinDelay: 20 * time.Millisecond, MaxDelay: 60 * time.Millisecond})
+	start := time.Now().UnixNano()
+
+	jb.Push(createJitterPacket(1, 160, start))
+	jb.Push(createJitterPacket(3, 480, start+int64(40*time.Millisecond)))
+	assert.NotNil(t, jb.Pop(start+int64(30*time.Millisecond)))
+	assert.NotNil(t, jb.Pop(start+int64(100*time.Millisecond)))
+
+	// Packet 2 arrives after packet 3 has been played out.
+	jb.Push(createJitterPacket(2, 320, start+int64(110*time.Millisecond)))
+	// Duplicate of a queued packet is ignored.
+	jb.Push(createJitterPacket(4, 640, start+int64(110*time.Millisecond)))
+	jb.Push(createJitterPacket(4, 640, start+int64(111*time.Millisecond)))
+
+	stats := jb.GetStats()
+	assert.Equal(t, uint32(1), stats.LateDropped)
+	assert.Equal(t, uint32(3), stats.PacketCount)
+	assert.Equal(t, 1, jb.Len())
+}
+
+func TestJitterBuffer_DelayBounds(t *testing.T) {
+	jb := NewJitterBuffer(8000, JitterOptions{MinDelay: 20 * time.Millisecond, MaxDelay: 80 * time.Millisecond})
+	assert.Equal(t, 20*time.Millisecond, jb.Delay())
+
+	start := time.Now().UnixNano()
+	// Very jittery arrivals, the target delay must stay within MaxDelay.
+	for i := uint16(0); i < 50; i++ {
+		arrival := start + int64(i)*int64(20*time.Millisecond)
+		if i%2 == 0 {
+			arrival += int64(150 * time.Millisecond)
+		}
+		jb.Push(createJitterPacket(i, uint32(i)*160, arrival))
+		for jb.Pop(arrival+int64(time.Second)) != nil {
+		}
+	}
+	assert.True(t, jb.Delay() <= 80*time.Millisecond)
+	assert.True(t, jb.Delay() > 20*time.Millisecond)
+}
@@ -2,27 +2,103 @@ package buffer
 
 import (
 	"sort"
+	"time"
 
 	"github.com/pion/rtcp"
 )
 
-const maxNackTimes = 3   // Max number of times a packet will be NACKed
-const maxNackCache = 100 // Max NACK sn the sfu will keep reference
+const (
+	defaultNackRetries = 3                             // Max number of times a packet will be NACKed
+	defaultNackCache   = 100                           // Max NACK sn the sfu will keep reference
+	defaultNackMaxAge  = int64(time.Second)            // 丢包超过这个时间重传也没有意义, 不再NACK
+	defaultNackPktSize = 1200                          // 还没有统计到包大小时按这个估算重传量
+	nackBudgetBurst    = int64(250 * time.Millisecond) // 重传预算最多积累这么长时间
+
+	// 同一个包两次NACK的间隔按rtt计算, 没有rtt时用默认值.
+	defaultNackInterval = int64(100 * time.Millisecond)
+	minNackInterval     = int64(20 * time.Millisecond)
+	maxNackInterval     = int64(400 * time.Millisecond)
+)
+
+// NackOptions NACK策略, 零值使用默认值.
+type NackOptions struct {
+	// MaxRetries 每个丢包最多NACK的次数, 用完后请求关键帧
+	MaxRetries int
+	// MaxAge 丢包超过这个时间不再NACK
+	MaxAge time.Duration
+	// MaxCache 最多跟踪的丢包个数, 超出时放弃最早的
+	MaxCache int
+	// Budget 请求重传的码率上限(bps), 按平均包大小估算, 0不限制
+	Budget uint64
+}
 
 type nack struct {
-	sn     uint32
-	nacked uint8
+	sn       uint32
+	nacked   uint8
+	lost     int64 // 发现丢包的时间
+	lastNack int64
 }
 
+// nackQueue 按扩展序号保存丢包, 零值可以直接使用.
 type nackQueue struct {
+	opts  NackOptions
 	nacks []nack
 	kfSN  uint32
+	askKF bool // 丢包被放弃, 下一次pairs请求关键帧
+
+	// 重传预算, 令牌桶, 单位字节.
+	tokens   int64
+	lastFill int64
+	filled   bool
+
+	sent      uint32
+	recovered uint32
+	givenUp   uint32
+}
+
+func newNACKQueue(opts NackOptions) *nackQueue {
+	n := &nackQueue{opts: opts}
+	n.nacks = make([]nack, 0, n.maxCache()+1)
+	return n
+}
+
+func (n *nackQueue) maxRetries() uint8 {
+	if n.opts.MaxRetries <= 0 {
+		return defaultNackRetries
+	}
+	if n.opts.MaxRetries > 255 {
+		return 255
+	}
+	return uint8(n.opts.MaxRetries)
 }
 
-func newNACKQueue() *nackQueue {
-	return &nackQueue{
-		nacks: make([]nack, 0, maxNackCache+1),
+func (n *nackQueue) maxCache() int {
+	if n.opts.MaxCache <= 0 {
+		return defaultNackCache
 	}
+	return n.opts.MaxCache
+}
+
+func (n *nackQueue) maxAge() int64 {
+	if n.opts.MaxAge <= 0 {
+		return defaultNackMaxAge
+	}
+	return int64(n.opts.MaxAge)
+}
+
+// interval 重传请求到达发布端、重传包回来大约需要一个rtt, 之前不再重复NACK.
+func nackInterval(rtt time.Duration) int64 {
+	if rtt <= 0 {
+		return defaultNackInterval
+	}
+	i := int64(rtt) + int64(rtt)/4
+	if i < minNackInterval {
+		return minNackInterval
+	}
+	if i > maxNackInterval {
+		return maxNackInterval
+	}
+	return i
 }
 
 func (n *nackQueue) remove(extSN uint32) {
@@ -30,19 +106,22 @@ func (n *nackQueue) remove(extSN uint32) {
 	if i >= len(n.nacks) || n.nacks[i].sn != extSN {
 		return
 	}
+	if n.nacks[i].nacked > 0 {
+		n.recovered++
+	}
 	copy(n.nacks[i:], n.nacks[i+1:])
 	n.nacks = n.nacks[:len(n.nacks)-1]
 }
 
-func (n *nackQueue) push(extSN uint32) {
+func (n *nackQueue) push(extSN uint32, now int64) {
 	i := sort.Search(len(n.nacks), func(i int) bool { return n.nacks[i].sn >= extSN })
 	if i < len(n.nacks) && n.nacks[i].sn == extSN {
 		return
 	}
 
 	nck := nack{
-		sn:     extSN,
-		nacked: 0,
+		sn:   extSN,
+		lost: now,
 	}
 	if i == len(n.nacks) {
 		n.nacks = append(n.nacks, nck)
@@ -51,37 +130,79 @@ func (n *nackQueue) push(extSN uint32) {
 		n.nacks[i] = nck
 	}
 
-	if len(n.nacks) >= maxNackCache {
+	if len(n.nacks) > n.maxCache() {
+		// 丢包太多, 放弃最早的, 等关键帧恢复.
+		n.giveUp(n.nacks[0].sn)
 		copy(n.nacks, n.nacks[1:])
+		n.nacks = n.nacks[:len(n.nacks)-1]
 	}
 }
 
-func (n *nackQueue) pairs(headSN uint32) ([]rtcp.NackPair, bool) {
+func (n *nackQueue) giveUp(sn uint32) {
+	n.givenUp++
+	if sn > n.kfSN {
+		n.kfSN = sn
+		n.askKF = true
+	}
+}
+
+// take 从重传预算中扣除size字节, 预算不够时返回false.
+func (n *nackQueue) take(size, now int64) bool {
+	if n.opts.Budget == 0 {
+		return true
+	}
+	burst := int64(n.opts.Budget) / 8 * nackBudgetBurst / int64(time.Second)
+	if !n.filled {
+		n.tokens = burst
+		n.filled = true
+	} else if now > n.lastFill {
+		n.tokens += int64(n.opts.Budget) / 8 * (now - n.lastFill) / int64(time.Second)
+		if n.tokens > burst {
+			n.tokens = burst
+		}
+	}
+	n.lastFill = now
+	if n.tokens < size {
+		return false
+	}
+	n.tokens -= size
+	return true
+}
+
+// pairs returns the nack pairs of the packets due at now, askKF is true if a lost packet is given up.
+// rtt spaces the retries of a packet, pktSize is the average packet size charged to the budget.
+func (n *nackQueue) pairs(headSN uint32, now int64, rtt time.Duration, pktSize int) ([]rtcp.NackPair, bool) {
+	askKF := n.askKF
+	n.askKF = false
 	if len(n.nacks) == 0 {
-		return nil, false
+		return nil, askKF
+	}
+	if pktSize <= 0 {
+		pktSize = defaultNackPktSize
 	}
+	interval := nackInterval(rtt)
+	maxRetries, maxAge := n.maxRetries(), n.maxAge()
+
 	i := 0
-	askKF := false
 	var np rtcp.NackPair
 	var nps []rtcp.NackPair
 	for _, nck := range n.nacks {
-		if nck.nacked >= maxNackTimes {
-			if nck.sn > n.kfSN {
-				n.kfSN = nck.sn
-				askKF = true
-			}
+		if (nck.nacked >= maxRetries && now-nck.lastNack >= interval) || now-nck.lost > maxAge {
+			n.giveUp(nck.sn)
 			continue
 		}
-		if nck.sn >= headSN-2 {
+		if nck.sn >= headSN-2 || nck.nacked >= maxRetries ||
+			(nck.nacked > 0 && now-nck.lastNack < interval) || !n.take(int64(pktSize), now) {
+			// 可能只是乱序, 或者还没到重试的时间, 或者超出了重传预算.
 			n.nacks[i] = nck
 			i++
 			continue
 		}
-		n.nacks[i] = nack{
-			sn:     nck.sn,
-			nacked: nck.nacked + 1,
-		}
+		nck.nacked++
+		nck.lastNack = now
+		n.nacks[i] = nck
 		i++
+		n.sent++
 		if np.PacketID == 0 || uint16(nck.sn) > np.PacketID+16 {
 			if np.PacketID != 0 {
 				nps = append(nps, np)
@@ -96,5 +217,7 @@ func (n *nackQueue) pairs(headSN uint32) ([]rtcp.NackPair, bool) {
 		nps = append(nps, np)
 	}
 	n.nacks = n.nacks[:i]
+	askKF = askKF || n.askKF
+	n.askKF = false
 	return nps, askKF
 }
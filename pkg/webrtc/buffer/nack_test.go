@@ -56,9 +56,9 @@ func Test_nackQueue_pairs(t *testing.T) {
 				nacks: tt.fields.nacks,
 			}
 			for _, sn := range tt.args {
-				n.push(sn)
+				n.push(sn, 0)
 			}
-			got, _ := n.pairs(30)
+			got, _ := n.pairs(30, 0, 0, 0)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("pairs() = %v, want %v", got, tt.want)
 			}
@@ -97,7 +97,7 @@ func Test_nackQueue_push(t *testing.T) {
 				nacks: tt.fields.nacks,
 			}
 			for _, sn := range tt.args.sn {
-				n.push(sn)
+				n.push(sn, 0)
 			}
 			var newSN []uint32
 			for _, sn := range n.nacks {
@@ -137,9 +137,9 @@ func Test_nackQueue(t *testing.T) {
 			r := rand.New(rand.NewSource(time.Now().UnixNano()))
 			for i := 0; i < 100; i++ {
 				assert.NotPanics(t, func() {
-					n.push(uint32(r.Intn(60000)))
+					n.push(uint32(r.Intn(60000)), 0)
 					n.remove(uint32(r.Intn(60000)))
-					n.pairs(60001)
+					n.pairs(60001, 0, 0, 0)
 				})
 			}
 		})
@@ -168,7 +168,7 @@ func Test_nackQueue_remove(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			n := nackQueue{}
 			for _, sn := range tt.args.sn {
-				n.push(sn)
+				n.push(sn, 0)
 			}
 			n.remove(5)
 			var newSN []uint32
@@ -179,3 +179,62 @@ func Test_nackQueue_remove(t *testing.T) {
 		})
 	}
 }
+
+func Test_nackQueue_policy(t *testing.T) {
+	ms := int64(time.Millisecond)
+	rtt := 40 * time.Millisecond // 重试间隔50ms
+
+	n := newNACKQueue(NackOptions{MaxRetries: 2})
+	n.push(10, 0)
+	n.push(11, 0)
+
+	got, kf := n.pairs(20, 0, rtt, 0)
+	assert.Equal(t, []rtcp.NackPair{{PacketID: 10, LostPackets: 1}}, got)
+	assert.False(t, kf)
+	// 不到一个重试间隔不再NACK.
+	got, _ = n.pairs(20, 10*ms, rtt, 0)
+	assert.Empty(t, got)
+
+	// 11在第一次NACK后收到.
+	n.remove(11)
+	got, _ = n.pairs(20, 50*ms, rtt, 0)
+	assert.Equal(t, []rtcp.NackPair{{PacketID: 10}}, got)
+
+	// 重试用完后再等一个间隔放弃, 请求关键帧.
+	got, kf = n.pairs(20, 60*ms, rtt, 0)
+	assert.Empty(t, got)
+	assert.False(t, kf)
+	got, kf = n.pairs(20, 100*ms, rtt, 0)
+	assert.Empty(t, got)
+	assert.True(t, kf)
+	assert.Equal(t, uint32(3), n.sent)
+	assert.Equal(t, uint32(1), n.recovered)
+	assert.Equal(t, uint32(1), n.givenUp)
+
+	// 超过MaxAge放弃.
+	n = newNACKQueue(NackOptions{MaxAge: 200 * time.Millisecond})
+	n.push(10, 0)
+	_, kf = n.pairs(20, 300*ms, rtt, 0)
+	assert.True(t, kf)
+	assert.Empty(t, n.nacks)
+
+	// 超出缓存放弃最早的.
+	n = newNACKQueue(NackOptions{MaxCache: 2})
+	n.push(1, 0)
+	n.push(2, 0)
+	n.push(3, 0)
+	assert.Len(t, n.nacks, 2)
+	assert.Equal(t, uint32(1), n.givenUp)
+	_, kf = n.pairs(20, 0, rtt, 0)
+	assert.True(t, kf)
+
+	// 预算250ms积累4000字节, 只够NACK 4个1000字节的包, 之后按128kbps恢复, 用完重试的1-4放弃.
+	n = newNACKQueue(NackOptions{Budget: 128000, MaxRetries: 1})
+	for sn := uint32(1); sn <= 6; sn++ {
+		n.push(sn, 0)
+	}
+	got, _ = n.pairs(20, 1, rtt, 1000)
+	assert.Equal(t, []rtcp.NackPair{{PacketID: 1, LostPackets: 7}}, got)
+	got, _ = n.pairs(20, 1+125*ms, rtt, 1000)
+	assert.Equal(t, []rtcp.NackPair{{PacketID: 5, LostPackets: 1}}, got)
+}
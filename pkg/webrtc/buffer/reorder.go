@@ -0,0 +1,115 @@
+package buffer
+
+import (
+	"sort"
+)
+
+const (
+	// 重复检测记录最近这么多个序列号, 更早的包bucket已经不保存.
+	dupWindowSize = 1024
+	// 乱序窗口最多缓存的包数, 超出时不再等待缺少的包.
+	maxReorderPackets = 256
+)
+
+// dupWindow 按扩展序列号记录最近收到的包, 零值可以直接使用.
+type dupWindow struct {
+	bits    [dupWindowSize / 64]uint64
+	highest uint32
+	init    bool
+}
+
+// seen marks extSN as received, returns true if it was received before
+func (w *dupWindow) seen(extSN uint32) bool {
+	if !w.init {
+		w.init = true
+		w.highest = extSN
+		w.set(extSN)
+		return false
+	}
+
+	d := int32(extSN - w.highest)
+	switch {
+	case d > 0:
+		if d >= dupWindowSize {
+			w.bits = [dupWindowSize / 64]uint64{}
+		} else {
+			for sn := w.highest + 1; sn != extSN; sn++ {
+				w.clear(sn)
+			}
+		}
+		w.highest = extSN
+	case -d >= dupWindowSize:
+		// 太旧了无法判断, bucket也不会再接收.
+		return false
+	case w.get(extSN):
+		return true
+	}
+	w.set(extSN)
+	return false
+}
+
+func (w *dupWindow) get(sn uint32) bool {
+	i := sn % dupWindowSize
+	return w.bits[i/64]&(1<<(i%64)) != 0
+}
+
+func (w *dupWindow) set(sn uint32) {
+	i := sn % dupWindowSize
+	w.bits[i/64] |= 1 << (i % 64)
+}
+
+func (w *dupWindow) clear(sn uint32) {
+	i := sn % dupWindowSize
+	w.bits[i/64] &^= 1 << (i % 64)
+}
+
+type reorderPacket struct {
+	extSN uint32
+	pkt   *ExtPacket
+}
+
+// reorderQueue 按序列号放行包, 缺包时最多等待window(纳秒).
+// 等待超时后跳过缺少的包, 之后到达的包(通常是NACK重传)不再等待, 直接放行, Head为false.
+type reorderQueue struct {
+	window   int64
+	packets  []reorderPacket
+	released bool
+	lastSN   uint32 // 最近一次放行的扩展序列号
+}
+
+// push adds a packet, late is true if a later packet was already released and the packet should be forwarded now
+func (q *reorderQueue) push(ep *ExtPacket, extSN uint32) (late bool) {
+	if q.released && int32(extSN-q.lastSN) <= 0 {
+		ep.Head = false
+		return true
+	}
+
+	i := sort.Search(len(q.packets), func(i int) bool { return int32(q.packets[i].extSN-extSN) >= 0 })
+	rp := reorderPacket{extSN: extSN, pkt: ep}
+	if i == len(q.packets) {
+		q.packets = append(q.packets, rp)
+	} else {
+		q.packets = append(q.packets[:i+1], q.packets[i:]...)
+		q.packets[i] = rp
+	}
+	return false
+}
+
+// pop returns the next packet in sequence order, nil if the head is still waiting for a missing packet at now.
+func (q *reorderQueue) pop(now int64) *ExtPacket {
+	if len(q.packets) == 0 {
+		return nil
+	}
+	head := q.packets[0]
+	if q.released && head.extSN != q.lastSN+1 && now-head.pkt.Arrival < q.window && len(q.packets) <= maxReorderPackets {
+		return nil
+	}
+	q.packets[0] = reorderPacket{}
+	q.packets = q.packets[1:]
+
+	// 放行顺序是递增的, 都可以作为head处理.
+	head.pkt.Head = true
+	q.lastSN = head.extSN
+	q.released = true
+	return head.pkt
+}
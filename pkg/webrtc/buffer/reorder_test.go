@@ -0,0 +1,62 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_dupWindow(t *testing.T) {
+	var w dupWindow
+	for _, sn := range []uint32{10, 12, 11} {
+		assert.False(t, w.seen(sn))
+	}
+	assert.True(t, w.seen(10))
+	assert.True(t, w.seen(12))
+
+	// 跳过的序列号被清除, 旧的记录不会误判.
+	assert.False(t, w.seen(10+dupWindowSize))
+	assert.True(t, w.seen(11))
+	assert.False(t, w.seen(12+dupWindowSize))
+	assert.False(t, w.seen(11+dupWindowSize))
+	assert.True(t, w.seen(10+dupWindowSize))
+	// 超出窗口的无法判断.
+	assert.False(t, w.seen(10))
+
+	// 扩展序列号跨越16位回绕.
+	w = dupWindow{}
+	assert.False(t, w.seen(0xfffe))
+	assert.False(t, w.seen(0x10001))
+	assert.False(t, w.seen(0xffff))
+	assert.True(t, w.seen(0xffff))
+}
+
+func Test_reorderQueue(t *testing.T) {
+	q := reorderQueue{window: 100}
+	pkt := func(arrival int64) *ExtPacket { return &ExtPacket{Arrival: arrival} }
+
+	// 第一个包直接放行.
+	assert.False(t, q.push(pkt(0), 1))
+	assert.NotNil(t, q.pop(0))
+
+	// 3等待2.
+	p3 := pkt(10)
+	assert.False(t, q.push(p3, 3))
+	assert.Nil(t, q.pop(10))
+	p2 := pkt(20)
+	assert.False(t, q.push(p2, 2))
+	assert.Equal(t, p2, q.pop(20))
+	assert.Equal(t, p3, q.pop(20))
+	assert.Nil(t, q.pop(20))
+
+	// 5等待超时后放行, 迟到的4直接转发.
+	p5 := pkt(30)
+	q.push(p5, 5)
+	assert.Nil(t, q.pop(129))
+	assert.Equal(t, p5, q.pop(130))
+	assert.True(t, p5.Head)
+	p4 := pkt(140)
+	assert.True(t, q.push(p4, 4))
+	assert.False(t, p4.Head)
+	assert.Nil(t, q.pop(140))
+}
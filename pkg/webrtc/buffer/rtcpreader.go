@@ -3,6 +3,7 @@ package buffer
 import (
 	"io"
 	"sync/atomic"
+	"time"
 )
 
 type RTCPReader struct {
@@ -10,10 +11,11 @@ type RTCPReader struct {
 	closed   atomicBool
 	onPacket atomic.Value //func([]byte)
 	onClose  func()
+	created  int64
 }
 
 func NewRTCPReader(ssrc uint32) *RTCPReader {
-	return &RTCPReader{ssrc: ssrc}
+	return &RTCPReader{ssrc: ssrc, created: time.Now().UnixNano()}
 }
 
 func (r *RTCPReader) Write(p []byte) (n int, err error) {
@@ -41,4 +43,10 @@ func (r *RTCPReader) OnPacket(f func([]byte)) {
 	r.onPacket.Store(f)
 }
 
+// orphan 创建后到deadline还没有使用者设置OnPacket.
+func (r *RTCPReader) orphan(deadline int64) bool {
+	_, used := r.onPacket.Load().(func([]byte))
+	return !used && r.created < deadline && !r.closed.get()
+}
+
 func (r *RTCPReader) Read(_ []byte) (n int, err error) { return }
@@ -0,0 +1,129 @@
+package buffer
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 保存最近发出的SR/RRTR个数, 对端的RR/DLRR可能回报较早的一个.
+	rttHistory = 8
+	// 超过这个时间的回报不再匹配.
+	rttMaxAge = 30 * int64(time.Second)
+)
+
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type sentReport struct {
+	ntp  uint32 // NTP中间32位
+	sent int64
+}
+
+// RTTEstimator 按发出的SR(或XR RRTR)的NTP中间32位匹配对端回报的LSR/DLSR(或LRR/DLRR), 计算往返时延.
+// 只接收不发送的一端没有SR, 通过XR RRTR/DLRR(RFC 3611)计算, OnReferenceTime/DLRR用于回复对端的RRTR.
+// 零值可以直接使用.
+type RTTEstimator struct {
+	sync.Mutex
+	sent [rttHistory]sentReport
+	next int
+
+	rtt    time.Duration // 平滑后的rtt, 0表示还没有测量
+	minRTT time.Duration
+
+	// 对端最近一次RRTR.
+	lastRRTR     uint32
+	lastRRTRRecv int64
+	rrtrSSRC     uint32
+}
+
+// OnSend records a sent SR or RRTR, ntp is the full 64 bit timestamp in it
+func (e *RTTEstimator) OnSend(ntp uint64, now int64) {
+	e.Lock()
+	e.sent[e.next] = sentReport{ntp: uint32(ntp >> 16), sent: now}
+	e.next = (e.next + 1) % rttHistory
+	e.Unlock()
+}
+
+// OnReport computes the rtt from the LSR/DLSR of a RR or the LRR/DLRR of a XR DLRR block,
+// ok is false if lastReport does not match a sent report
+func (e *RTTEstimator) OnReport(lastReport, delay uint32, now int64) (rtt time.Duration, ok bool) {
+	if lastReport == 0 {
+		return 0, false
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	for _, s := range e.sent {
+		if s.sent == 0 || s.ntp != lastReport || now-s.sent > rttMaxAge {
+			continue
+		}
+		sample := time.Duration(now-s.sent) - ntpDuration(delay)
+		if sample < 0 {
+			// 对端时钟精度问题, 按0处理.
+			sample = 0
+		}
+		if e.rtt == 0 {
+			e.rtt = sample
+		} else {
+			e.rtt += (sample - e.rtt) / 8
+		}
+		if e.minRTT == 0 || sample < e.minRTT {
+			e.minRTT = sample
+		}
+		return sample, true
+	}
+	return 0, false
+}
+
+// RTT returns the smoothed rtt, 0 before the first report
+func (e *RTTEstimator) RTT() time.Duration {
+	e.Lock()
+	defer e.Unlock()
+	return e.rtt
+}
+
+// MinRTT returns the minimum rtt seen, used as the base of delay based estimation
+func (e *RTTEstimator) MinRTT() time.Duration {
+	e.Lock()
+	defer e.Unlock()
+	return e.minRTT
+}
+
+// OnReferenceTime records a RRTR received from ssrc
+func (e *RTTEstimator) OnReferenceTime(ssrc uint32, ntp uint64, now int64) {
+	e.Lock()
+	e.rrtrSSRC = ssrc
+	e.lastRRTR = uint32(ntp >> 16)
+	e.lastRRTRRecv = now
+	e.Unlock()
+}
+
+// DLRR returns the DLRR sub-block replying the latest RRTR, ok is false if no RRTR is received
+func (e *RTTEstimator) DLRR(now int64) (ssrc, lastRR, dlrr uint32, ok bool) {
+	e.Lock()
+	defer e.Unlock()
+	if e.lastRRTRRecv == 0 || now-e.lastRRTRRecv > rttMaxAge {
+		return 0, 0, 0, false
+	}
+	return e.rrtrSSRC, e.lastRRTR, toNtpDelay(now - e.lastRRTRRecv), true
+}
+
+// toNtpTime returns the 64 bit NTP timestamp of nanos since unix epoch.
+func toNtpTime(nanos int64) uint64 {
+	nsec := uint64(time.Unix(0, nanos).Sub(ntpEpoch))
+	sec := nsec / 1e9
+	frac := ((nsec - sec*1e9) << 32) / 1e9
+	return sec<<32 | frac
+}
+
+// toNtpDelay 把时长换算成1/65536秒, 用于DLSR和DLRR.
+func toNtpDelay(nanos int64) uint32 {
+	if nanos < 0 {
+		return 0
+	}
+	return uint32(nanos * 65536 / int64(time.Second))
+}
+
+func ntpDuration(delay uint32) time.Duration {
+	return time.Duration(delay) * time.Second / 65536
+}
@@ -0,0 +1,46 @@
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	var e RTTEstimator
+	now := time.Now().UnixNano()
+
+	ntp := toNtpTime(now)
+	e.OnSend(ntp, now)
+	e.OnSend(toNtpTime(now+int64(time.Second)), now+int64(time.Second))
+
+	// 对端收到第一个SR后20ms回报, 发出后100ms到达.
+	rtt, ok := e.OnReport(uint32(ntp>>16), toNtpDelay(int64(20*time.Millisecond)), now+int64(100*time.Millisecond))
+	assert.True(t, ok)
+	assert.InDelta(t, float64(80*time.Millisecond), float64(rtt), float64(time.Millisecond))
+	assert.Equal(t, rtt, e.RTT())
+	assert.Equal(t, rtt, e.MinRTT())
+
+	// 没有发出过的LSR和0不计算.
+	_, ok = e.OnReport(uint32(ntp>>16)+12345, 0, now)
+	assert.False(t, ok)
+	_, ok = e.OnReport(0, 0, now)
+	assert.False(t, ok)
+
+	// DLRR回复RRTR.
+	_, _, _, ok = e.DLRR(now)
+	assert.False(t, ok)
+	e.OnReferenceTime(42, ntp, now)
+	ssrc, lastRR, dlrr, ok := e.DLRR(now + int64(500*time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(42), ssrc)
+	assert.Equal(t, uint32(ntp>>16), lastRR)
+	assert.Equal(t, uint32(32768), dlrr)
+}
+
+func TestNtpDelay(t *testing.T) {
+	assert.Equal(t, time.Second, ntpDuration(65536))
+	assert.Equal(t, uint32(65536), toNtpDelay(int64(time.Second)))
+	assert.Equal(t, uint32(0), toNtpDelay(-1))
+}
@@ -3,6 +3,8 @@ package webrtc
 import (
 	"fmt"
 	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/transport/packetio"
 	"github.com/pion/webrtc/v3"
 	"mediasfu/pkg/webrtc/buffer"
@@ -20,6 +22,9 @@ const (
 	// SimulcastDownTrack, SimulcastDownTrack暂时不考虑，在需要
 )
 
+// keyFrameRequestInterval 等待关键帧期间同一个DownTrack重发PLI的最小间隔, rtt更大时按2倍rtt.
+const keyFrameRequestInterval = 500 * time.Millisecond
+
 // DownTrack  implements TrackLocal, is the track used to write packets
 // to SFU Subscriber, the track handle the packets for simple, simulcast
 // and SVC Publisher.
@@ -47,7 +52,14 @@ type DownTrack struct {
 	lastSSRC uint32
 	lastSN   uint16
 	lastTS   uint32
+	// 上次请求关键帧的时间, unix纳秒.
+	lastPli int64
+
 
+	// 纯rtp出口可选的抖动缓冲, nil时直接转发.
+	jitter   *buffer.JitterBuffer
+	jitterCh chan struct{}
+	done     chan struct{}
 
 	codec          webrtc.RTPCodecCapability
 	receiver       Receiver
@@ -61,11 +73,27 @@ type DownTrack struct {
 	octetCount  uint32
 	packetCount uint32
 	maxPacketTs uint32
+
+	// 订阅端最近一次RR, 用于质量评分.
+	rrMu   sync.Mutex
+	lastRR rtcp.ReceptionReport
+	hasRR  bool
+
+	// 按发出的SR和订阅端RR计算rtt, 并回复订阅端的XR RRTR.
+	rtt buffer.RTTEstimator
+
+	// vp9和AV1 SVC的层选择, 其他编码为nil.
+	svc *svcLayers
+	// 订阅端协商的Dependency Descriptor扩展id, 0表示没有协商.
+	ddExt uint8
+	// audioLevelEgress 发布端没有声音扩展时, 按订阅端协商的audioLevelExt补上buffer计算的声音大小, 可以热更新.
+	audioLevelEgress atomicBool
+	audioLevelExt    uint8
 }
 
 // NewDownTrack returns a DownTrack.
 func NewDownTrack(c webrtc.RTPCodecCapability, r Receiver, bf *buffer.Factory, peerID string, mt int) (*DownTrack, error) {
-	return &DownTrack{
+	d := &DownTrack{
 		id:            r.TrackID(),
 		peerID:        peerID,
 		maxTrack:      mt,
@@ -73,7 +101,12 @@ func NewDownTrack(c webrtc.RTPCodecCapability, r Receiver, bf *buffer.Factory, p
 		bufferFactory: bf,
 		receiver:      r,
 		codec:         c,
-	}, nil
+	}
+	if strings.HasPrefix(strings.ToLower(c.MimeType), "video/") {
+		// vp9、av1, 以及带frame marking的任意编码都可以丢层.
+		d.svc = newSVCLayers()
+	}
+	return d, nil
 }
 
 // SDP协商完成后进行Bind绑定.
@@ -89,6 +122,14 @@ func (d *DownTrack) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters,
 		d.mime = strings.ToLower(codec.MimeType)
 		d.reSync.set(true)
 		d.enabled.set(true)
+		for _, ext := range t.HeaderExtensions() {
+			switch ext.URI {
+			case buffer.DependencyDescriptorURI:
+				d.ddExt = uint8(ext.ID)
+			case sdp.AudioLevelURI:
+				d.audioLevelExt = uint8(ext.ID)
+			}
+		}
 		if rr := d.bufferFactory.GetOrNew(packetio.RTCPBufferPacket, uint32(t.SSRC())).(*buffer.RTCPReader); rr != nil {
 			rr.OnPacket(func(pkt []byte) {
 				d.handleRTCP(pkt)
@@ -106,6 +147,17 @@ func (d *DownTrack) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters,
 	return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
 }
 
+// bindWriter 绑定不经过pc的出口, 例如sip的rtp leg, 没有协商的扩展和rtcp反馈.
+func (d *DownTrack) bindWriter(w webrtc.TrackLocalWriter, ssrc uint32, payloadType uint8) {
+	d.ssrc = ssrc
+	d.payloadType = payloadType
+	d.writeStream = w
+	d.mime = strings.ToLower(d.codec.MimeType)
+	d.reSync.set(true)
+	d.enabled.set(true)
+	d.bound.set(true)
+}
+
 // Unbind implements the teardown logic when the track is no longer needed. This happens
 // because a track has been stopped.
 func (d *DownTrack) Unbind(_ webrtc.TrackLocalContext) error {
@@ -154,13 +206,104 @@ func (d *DownTrack) WriteRTP(p *buffer.ExtPacket) error {
 		return nil
 	}
 
+	if d.jitter != nil {
+		d.jitter.Push(p)
+		select {
+		case d.jitterCh <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+
 	return d.writeSimpleRTP(p)
 }
 
+// EnableJitterBuffer 为纯rtp出口开启自适应抖动缓冲和平滑发送, 需要在Bind之前调用.
+// 浏览器有自己的抖动缓冲, webrtc出口不需要开启.
+func (d *DownTrack) EnableJitterBuffer(o buffer.JitterOptions) {
+	if d.jitter != nil {
+		return
+	}
+	d.jitter = buffer.NewJitterBuffer(d.codec.ClockRate, o)
+	d.jitterCh = make(chan struct{}, 1)
+	d.done = make(chan struct{})
+	go d.playout()
+}
+
+// JitterStats returns the egress jitter buffer statistics, ok is false if the jitter buffer is disabled
+func (d *DownTrack) JitterStats() (stats buffer.Stats, ok bool) {
+	if d.jitter == nil {
+		return
+	}
+	return d.jitter.GetStats(), true
+}
+
+// playout 按抖动缓冲计算的播放时间发送.
+func (d *DownTrack) playout() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		for pkt := d.jitter.Pop(time.Now().UnixNano()); pkt != nil; pkt = d.jitter.Pop(time.Now().UnixNano()) {
+			if !d.enabled.get() || !d.bound.get() {
+				continue
+			}
+			if err := d.writeSimpleRTP(pkt); err != nil {
+				Logger.Error(err, "Write jitter buffered packet err", "peer_id", d.peerID)
+			}
+		}
+
+		wait := d.jitter.NextPlayout(time.Now().UnixNano())
+		if wait < 0 {
+			wait = time.Hour
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-d.done:
+			return
+		case <-d.jitterCh:
+		case <-timer.C:
+		}
+	}
+}
+
 func (d *DownTrack) Enabled() bool {
 	return d.enabled.get()
 }
 
+// Bitrate 源track的码率, 静音时为0.
+func (d *DownTrack) Bitrate() uint64 {
+	if !d.enabled.get() {
+		return 0
+	}
+	return d.receiver.GetBitrate()
+}
+
+// SetTargetLayers sets the highest spatial and temporal layer forwarded to the subscriber,
+// ok is false if the track is not a video track
+func (d *DownTrack) SetTargetLayers(spatial, temporal int32) (ok bool) {
+	if d.svc == nil {
+		return false
+	}
+	d.svc.setTarget(spatial, temporal)
+	return true
+}
+
+// TargetLayers returns the target layers, ok is false if the track is not a video track
+func (d *DownTrack) TargetLayers() (spatial, temporal int32, ok bool) {
+	if d.svc == nil {
+		return 0, 0, false
+	}
+	spatial, temporal = d.svc.target()
+	return spatial, temporal, true
+}
+
 // Mute enables or disables media forwarding
 func (d *DownTrack) Mute(val bool) {
 	if d.enabled.get() != val {
@@ -179,6 +322,9 @@ func (d *DownTrack) Close() {
 		if d.payload != nil {
 			packetFactory.Put(d.payload)
 		}
+		if d.done != nil {
+			close(d.done)
+		}
 		if d.onCloseHandler != nil {
 			d.onCloseHandler()
 		}
@@ -234,6 +380,7 @@ func (d *DownTrack) CreateSenderReport() *rtcp.SenderReport {
 		diff = 0
 	}
 	octets, packets := d.getSRStats()
+	d.rtt.OnSend(uint64(nowNTP), now.UnixNano())
 
 	return &rtcp.SenderReport{
 		SSRC:        d.ssrc,
@@ -244,6 +391,23 @@ func (d *DownTrack) CreateSenderReport() *rtcp.SenderReport {
 	}
 }
 
+// CreateExtendedReport returns a XR DLRR replying the latest RRTR of the subscriber, nil if there is none
+func (d *DownTrack) CreateExtendedReport() *rtcp.ExtendedReport {
+	if !d.bound.get() {
+		return nil
+	}
+	ssrc, lastRR, dlrr, ok := d.rtt.DLRR(time.Now().UnixNano())
+	if !ok {
+		return nil
+	}
+	return &rtcp.ExtendedReport{
+		SenderSSRC: d.ssrc,
+		Reports: []rtcp.ReportBlock{&rtcp.DLRRReportBlock{
+			Reports: []rtcp.DLRRReport{{SSRC: ssrc, LastRR: lastRR, DLRR: dlrr}},
+		}},
+	}
+}
+
 func (d *DownTrack) UpdateStats(packetLen uint32) {
 	atomic.AddUint32(&d.octetCount, packetLen)
 	atomic.AddUint32(&d.packetCount, 1)
@@ -252,11 +416,13 @@ func (d *DownTrack) UpdateStats(packetLen uint32) {
 func (d *DownTrack) writeSimpleRTP(extPkt *buffer.ExtPacket) error {
 	if d.reSync.get() {
 		if d.Kind() == webrtc.RTPCodecTypeVideo {
+			// 加密的payload没有frame marking和Dependency Descriptor时无法判断关键帧, 请求一次关键帧后直接转发.
+			opaque := extPkt.Encrypted && extPkt.FrameMarking == nil && extPkt.DependencyDescriptor == nil
 			if !extPkt.KeyFrame {
-				d.receiver.SendRTCP([]rtcp.Packet{
-					&rtcp.PictureLossIndication{SenderSSRC: d.ssrc, MediaSSRC: extPkt.Packet.SSRC},
-				})
-				return nil
+				d.requestKeyFrame(extPkt.Packet.SSRC)
+				if !opaque {
+					return nil
+				}
 			}
 		}
 
@@ -266,6 +432,41 @@ func (d *DownTrack) writeSimpleRTP(extPkt *buffer.ExtPacket) error {
 		}
 		atomic.StoreUint32(&d.lastSSRC, extPkt.Packet.SSRC)
 		d.reSync.set(false)
+		if d.svc != nil {
+			d.svc.reset()
+		}
+		if d.mime == "video/h264" && !extPkt.Encrypted && !buffer.HasH264ParameterSets(extPkt.Packet.Payload) {
+			if err := d.writeParameterSets(extPkt); err != nil {
+				return err
+			}
+		}
+	}
+
+	marker := extPkt.Packet.Marker
+	layer := uint8(0)
+	if d.svc != nil {
+		forward, keyframe := true, false
+		if dd := extPkt.DependencyDescriptor; dd != nil {
+			forward, marker, keyframe = d.svc.dd(dd, marker)
+			layer = uint8(dd.SpatialID)
+		} else if pld, ok := extPkt.Payload.(buffer.VP9); ok {
+			forward, marker, keyframe = d.svc.vp9(extPkt.Packet.Timestamp, pld, marker)
+			layer = pld.SID
+		} else if fm := extPkt.FrameMarking; fm != nil {
+			forward, marker, keyframe = d.svc.frameMarking(extPkt.Packet.Timestamp, fm, marker)
+			layer = fm.LID
+		}
+		if keyframe {
+			// 升空间层等关键帧.
+			d.requestKeyFrame(extPkt.Packet.SSRC)
+		}
+		if !forward {
+			// 丢弃的包不占用下行的序号.
+			if extPkt.Head {
+				d.snOffset++
+			}
+			return nil
+		}
 	}
 
 	d.UpdateStats(uint32(len(extPkt.Packet.Payload)))
@@ -273,7 +474,7 @@ func (d *DownTrack) writeSimpleRTP(extPkt *buffer.ExtPacket) error {
 	newSN := extPkt.Packet.SequenceNumber - d.snOffset
 	newTS := extPkt.Packet.Timestamp - d.tsOffset
 	if d.sequencer != nil {
-		d.sequencer.push(extPkt.Packet.SequenceNumber, newSN, newTS, 0, extPkt.Head)
+		d.sequencer.push(extPkt.Packet.SequenceNumber, newSN, newTS, layer, marker, extPkt.Head)
 	}
 	if extPkt.Head {
 		d.lastSN = newSN
@@ -281,14 +482,82 @@ func (d *DownTrack) writeSimpleRTP(extPkt *buffer.ExtPacket) error {
 	}
 	hdr := extPkt.Packet.Header
 	hdr.PayloadType = d.payloadType
+	hdr.Marker = marker
 	hdr.Timestamp = newTS
 	hdr.SequenceNumber = newSN
 	hdr.SSRC = d.ssrc
+	if dd := extPkt.DependencyDescriptor; dd != nil && dd.ExtID != d.ddExt {
+		// 发布端和订阅端的扩展id可能不同, 只按订阅端id重写Dependency Descriptor, 其他扩展(transport-cc等)保留.
+		// Extensions和源包共用, 其他订阅端还要用, 先复制.
+		hdr.Extensions = append([]rtp.Extension(nil), hdr.Extensions...)
+		_ = hdr.DelExtension(dd.ExtID)
+		if len(hdr.Extensions) == 0 {
+			hdr.Extension = false
+		}
+		if d.ddExt != 0 {
+			if err := hdr.SetExtension(d.ddExt, dd.Raw); err != nil {
+				return err
+			}
+		}
+	}
+	if lvl := extPkt.AudioLevel; lvl != nil && d.audioLevelExt != 0 && d.audioLevelEgress.get() {
+		if raw, err := lvl.Marshal(); err == nil {
+			// 只替换声音扩展, 其他扩展保留; Extensions和源包共用, 先复制.
+			hdr.Extensions = append([]rtp.Extension(nil), hdr.Extensions...)
+			if err = hdr.SetExtension(d.audioLevelExt, raw); err != nil {
+				return err
+			}
+		}
+	}
 
 	_, err := d.writeStream.WriteRTP(&hdr, extPkt.Packet.Payload)
 	return err
 }
 
+// requestKeyFrame 向发布端发送PLI, 等待关键帧时每一帧都会调用, 按keyFrameRequestInterval限速.
+func (d *DownTrack) requestKeyFrame(mediaSSRC uint32) {
+	interval := keyFrameRequestInterval
+	if rtt := 2 * d.rtt.RTT(); rtt > interval {
+		interval = rtt
+	}
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&d.lastPli) < int64(interval) {
+		return
+	}
+	atomic.StoreInt64(&d.lastPli, now)
+	d.receiver.SendRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{SenderSSRC: d.ssrc, MediaSSRC: mediaSSRC},
+	})
+}
+
+// writeParameterSets 在第一个IDR前插入缓存的SPS/PPS, 之后的包序号加一.
+func (d *DownTrack) writeParameterSets(extPkt *buffer.ExtPacket) error {
+	ps := d.receiver.H264ParameterSets()
+	if ps == nil {
+		return nil
+	}
+	hdr := extPkt.Packet.Header
+	hdr.PayloadType = d.payloadType
+	hdr.Marker = false
+	hdr.Timestamp = extPkt.Packet.Timestamp - d.tsOffset
+	hdr.SequenceNumber = extPkt.Packet.SequenceNumber - d.snOffset
+	hdr.SSRC = d.ssrc
+	hdr.Extension = false
+	hdr.Extensions = nil
+	if d.sequencer != nil {
+		// 占用了下行的序号, 记录下来订阅端NACK时可以重传.
+		if meta := d.sequencer.push(extPkt.Packet.SequenceNumber, hdr.SequenceNumber, hdr.Timestamp, 0, false, extPkt.Head); meta != nil {
+			meta.parameterSets = true
+		}
+	}
+	if _, err := d.writeStream.WriteRTP(&hdr, ps); err != nil {
+		return err
+	}
+	d.snOffset--
+	d.UpdateStats(uint32(len(ps)))
+	return nil
+}
+
 // all rtcp process for video.
 func (d *DownTrack) handleRTCP(bytes []byte) {
 	if !d.enabled.get() {
@@ -338,11 +607,22 @@ func (d *DownTrack) handleRTCP(bytes []byte) {
 				if maxRatePacketLoss == 0 || maxRatePacketLoss < r.FractionLost {
 					maxRatePacketLoss = r.FractionLost
 				}
+				if r.SSRC == d.ssrc {
+					d.updateReceiverReport(r)
+				}
+			}
+		case *rtcp.ExtendedReport:
+			// 只接收的订阅端不发SR, 通过RRTR/DLRR计算它那边的rtt.
+			for _, block := range p.Reports {
+				if rrtr, ok := block.(*rtcp.ReceiverReferenceTimeReportBlock); ok {
+					d.rtt.OnReferenceTime(p.SenderSSRC, rrtr.NTPTimestamp, time.Now().UnixNano())
+				}
 			}
 		case *rtcp.TransportLayerNack:
 			var nackedPackets []packetMeta
+			rtt := d.rtt.RTT()
 			for _, pair := range p.Nacks {
-				nackedPackets = append(nackedPackets, d.sequencer.getSeqNoPairs(pair.PacketList())...)
+				nackedPackets = append(nackedPackets, d.sequencer.getSeqNoPairs(pair.PacketList(), rtt)...)
 			}
 			if err = d.receiver.RetransmitPackets(d, nackedPackets); err != nil {
 				return
@@ -360,6 +640,28 @@ func (d *DownTrack) handleRTCP(bytes []byte) {
 	}
 }
 
+// updateReceiverReport 保存RR, LSR匹配发出的SR时计算rtt.
+func (d *DownTrack) updateReceiverReport(r rtcp.ReceptionReport) {
+	d.rrMu.Lock()
+	d.lastRR = r
+	d.hasRR = true
+	d.rrMu.Unlock()
+
+	d.rtt.OnReport(r.LastSenderReport, r.Delay, time.Now().UnixNano())
+}
+
+// ReceiverReport returns the latest report of the subscriber and the rtt, ok is false before the first RR
+func (d *DownTrack) ReceiverReport() (report rtcp.ReceptionReport, rtt time.Duration, ok bool) {
+	d.rrMu.Lock()
+	defer d.rrMu.Unlock()
+	return d.lastRR, d.rtt.RTT(), d.hasRR
+}
+
+// RTT returns the round trip time to the subscriber, 0 before the first matching RR or XR DLRR
+func (d *DownTrack) RTT() time.Duration {
+	return d.rtt.RTT()
+}
+
 // todo: 适合视频低速传输.
 func (d *DownTrack) handleLayerChange(maxRatePacketLoss uint8, expectedMinBitrate uint64) {
     // 待实现.
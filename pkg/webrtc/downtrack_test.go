@@ -0,0 +1,256 @@
+package webrtc
+
+import (
+	"common/gpool"
+	log "common/log/newlog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/transport/packetio"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+var codecVP9 = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}
+
+// testReceiver 只实现DownTrack用到的方法, 记录发往发布端的rtcp.
+type testReceiver struct {
+	Receiver
+	sync.Mutex
+	rtcp          [][]rtcp.Packet
+	parameterSets []byte
+}
+
+func (r *testReceiver) TrackID() string  { return "video" }
+func (r *testReceiver) StreamID() string { return "stream" }
+
+func (r *testReceiver) H264ParameterSets() []byte { return r.parameterSets }
+
+func (r *testReceiver) SendRTCP(pkts []rtcp.Packet) {
+	r.Lock()
+	r.rtcp = append(r.rtcp, pkts)
+	r.Unlock()
+}
+
+func (r *testReceiver) plis() int {
+	r.Lock()
+	defer r.Unlock()
+	n := 0
+	for _, pkts := range r.rtcp {
+		for _, pkt := range pkts {
+			if _, ok := pkt.(*rtcp.PictureLossIndication); ok {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func newTestDownTrack(t *testing.T, c webrtc.RTPCodecCapability, recv Receiver) (*DownTrack, *testLeg) {
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	d, err := NewDownTrack(c, recv, bf, "sub", 500)
+	require.NoError(t, err)
+	leg := newTestLeg("sub", bf)
+	d.bindWriter(leg, 4321, 98)
+	return d, leg
+}
+
+func vp9Packet(sn uint16, ts uint32, sid uint8, keyframe, endFrame bool) *buffer.ExtPacket {
+	return &buffer.ExtPacket{
+		Head:     true,
+		KeyFrame: keyframe && sid == 0,
+		Packet: rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 98, SequenceNumber: sn, Timestamp: ts, SSRC: 1234, Marker: endFrame && sid == 1},
+			Payload: []byte{0x00},
+		},
+		Payload: buffer.VP9{LayerIndices: true, SID: sid, IsKeyFrame: keyframe && sid == 0, BeginFrame: true, EndFrame: endFrame},
+	}
+}
+
+func TestDownTrack_KeyFrameRequest(t *testing.T) {
+	recv := &testReceiver{}
+	d, leg := newTestDownTrack(t, codecVP9, recv)
+	d.SetTargetLayers(0, maxTemporalLayer)
+
+	sn, ts := uint16(1), uint32(3000)
+	frame := func(keyframe bool) {
+		for sid := uint8(0); sid < 2; sid++ {
+			require.NoError(t, d.WriteRTP(vp9Packet(sn, ts, sid, keyframe, true)))
+			sn++
+		}
+		ts += 3000
+	}
+
+	// 重新同步期间每个包都在等关键帧, 只请求一次.
+	for i := 0; i < 5; i++ {
+		frame(false)
+	}
+	assert.Equal(t, 1, recv.plis())
+	assert.Empty(t, leg.written)
+
+	atomic.StoreInt64(&d.lastPli, 0)
+	frame(true)
+	assert.Len(t, leg.written, 1, "spatial layer 1 dropped")
+
+	// 升空间层等关键帧, 30fps下几帧内只发一个PLI.
+	d.SetTargetLayers(1, maxTemporalLayer)
+	for i := 0; i < 10; i++ {
+		frame(false)
+	}
+	assert.Equal(t, 2, recv.plis())
+	assert.Len(t, leg.written, 11)
+
+	// 超过间隔还没有关键帧时重发.
+	atomic.StoreInt64(&d.lastPli, time.Now().Add(-keyFrameRequestInterval).UnixNano())
+	frame(false)
+	assert.Equal(t, 3, recv.plis())
+
+	frame(true)
+	assert.Len(t, leg.written, 14, "both layers after the keyframe")
+	frame(false)
+	assert.Equal(t, 3, recv.plis())
+}
+
+func TestDownTrack_DependencyDescriptorExtension(t *testing.T) {
+	d, leg := newTestDownTrack(t, webrtc.RTPCodecCapability{MimeType: mimeTypeAV1, ClockRate: 90000}, &testReceiver{})
+	d.ddExt = 3
+
+	raw := []byte{0x80, 0x00, 0x01}
+	pkt := &buffer.ExtPacket{
+		Head:     true,
+		KeyFrame: true,
+		Packet: rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 45, SequenceNumber: 1, Timestamp: 3000, SSRC: 1234, Marker: true},
+			Payload: []byte{0x00},
+		},
+		DependencyDescriptor: &buffer.DependencyDescriptor{StartOfFrame: true, EndOfFrame: true, Raw: raw, ExtID: 5},
+	}
+	require.NoError(t, pkt.Packet.Header.SetExtension(1, []byte{0x00, 0x01}))
+	require.NoError(t, pkt.Packet.Header.SetExtension(5, raw))
+	require.NoError(t, d.WriteRTP(pkt))
+
+	out := <-leg.written
+	assert.Equal(t, []byte{0x00, 0x01}, out.Header.GetExtension(1), "transport-cc kept")
+	assert.Equal(t, raw, out.Header.GetExtension(3))
+	assert.Nil(t, out.Header.GetExtension(5))
+
+	// 源包其他订阅端还要用, 不能被修改.
+	assert.Equal(t, []uint8{1, 5}, pkt.Packet.Header.GetExtensionIDs())
+}
+
+func TestDownTrack_AudioLevelExtension(t *testing.T) {
+	d, leg := newTestDownTrack(t, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, &testReceiver{})
+	d.audioLevelExt = 1
+	d.audioLevelEgress.set(true)
+
+	pkt := &buffer.ExtPacket{
+		Head: true,
+		Packet: rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1, Timestamp: 160, SSRC: 1234},
+			Payload: []byte{0xff},
+		},
+		AudioLevel: &rtp.AudioLevelExtension{Level: 30, Voice: true},
+	}
+	require.NoError(t, pkt.Packet.Header.SetExtension(2, []byte{0x00, 0x00, 0x01}))
+	require.NoError(t, d.WriteRTP(pkt))
+
+	out := <-leg.written
+	assert.Equal(t, []byte{0x00, 0x00, 0x01}, out.Header.GetExtension(2), "abs-send-time kept")
+	var lvl rtp.AudioLevelExtension
+	require.NoError(t, lvl.Unmarshal(out.Header.GetExtension(1)))
+	assert.Equal(t, uint8(30), lvl.Level)
+	assert.Equal(t, []uint8{2}, pkt.Packet.Header.GetExtensionIDs())
+}
+
+func TestDownTrack_H264ParameterSets(t *testing.T) {
+	// STAP-A: SPS + PPS.
+	ps := []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}
+	d, leg := newTestDownTrack(t, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, &testReceiver{parameterSets: ps})
+	d.sequencer = newSequencer(d.maxTrack)
+
+	h264Packet := func(sn uint16, ts uint32, keyframe bool) *buffer.ExtPacket {
+		nalu := byte(0x41)
+		if keyframe {
+			nalu = 0x65
+		}
+		return &buffer.ExtPacket{
+			Head:     true,
+			KeyFrame: keyframe,
+			Packet: rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 102, SequenceNumber: sn, Timestamp: ts, SSRC: 1234, Marker: true},
+				Payload: []byte{nalu, 0x00},
+			},
+		}
+	}
+	next := func() rtp.Packet {
+		select {
+		case pkt := <-leg.written:
+			return pkt
+		default:
+			t.Fatal("no packet written")
+			return rtp.Packet{}
+		}
+	}
+
+	require.NoError(t, d.WriteRTP(h264Packet(100, 3000, true)))
+	assert.Equal(t, ps, next().Payload)
+	assert.Equal(t, byte(0x65), next().Payload[0])
+	require.NoError(t, d.WriteRTP(h264Packet(101, 6000, false)))
+	last := next().SequenceNumber
+
+	// 发布端切换后重新同步, 序号跳变.
+	d.reSync.set(true)
+	require.NoError(t, d.WriteRTP(h264Packet(5000, 90000, false)))
+	assert.Empty(t, leg.written, "waiting for a keyframe")
+	require.NoError(t, d.WriteRTP(h264Packet(5001, 93000, true)))
+	require.NoError(t, d.WriteRTP(h264Packet(5002, 96000, false)))
+
+	sps, idr, p := next(), next(), next()
+	assert.Equal(t, ps, sps.Payload, "the first IDR is preceded by SPS/PPS")
+	assert.Equal(t, byte(0x65), idr.Payload[0])
+	assert.Equal(t, []uint16{last + 1, last + 2, last + 3}, []uint16{sps.SequenceNumber, idr.SequenceNumber, p.SequenceNumber})
+	assert.Equal(t, sps.Timestamp, idr.Timestamp)
+
+	// SPS/PPS也可以重传.
+	metas := d.sequencer.getSeqNoPairs([]uint16{sps.SequenceNumber, idr.SequenceNumber, p.SequenceNumber}, 0)
+	require.Len(t, metas, 3)
+	assert.True(t, metas[0].parameterSets)
+	assert.Equal(t, sps.Timestamp, metas[0].timestamp)
+	assert.False(t, metas[1].parameterSets)
+	assert.Equal(t, uint16(5001), metas[1].sourceSeqNo)
+	assert.Equal(t, uint16(5002), metas[2].sourceSeqNo)
+
+	// 发布端buffer里只有缓存的参数集, 按序号映射重新发送.
+	buff := d.bufferFactory.GetOrNew(packetio.RTPBufferPacket, 1234).(*buffer.Buffer)
+	buff.OnFeedback(func(_ []rtcp.Packet) {})
+	buff.Bind(webrtc.RTPParameters{Codecs: []webrtc.RTPCodecParameters{{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000},
+		PayloadType:        102,
+	}}}, buffer.Options{})
+	raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 4990, Timestamp: 80000, SSRC: 1234}, Payload: ps}).Marshal()
+	require.NoError(t, err)
+	_, err = buff.Write(raw)
+	require.NoError(t, err)
+	worker, err := gpool.NewPool(1)
+	require.NoError(t, err)
+	w := &WebRTCReceiver{buffers: buff, nackWorker: worker}
+	defer worker.Release()
+
+	require.NoError(t, w.RetransmitPackets(d, metas[:1]))
+	select {
+	case rtx := <-leg.written:
+		assert.Equal(t, ps, rtx.Payload)
+		assert.Equal(t, sps.SequenceNumber, rtx.SequenceNumber)
+		assert.Equal(t, sps.Timestamp, rtx.Timestamp)
+		assert.Equal(t, uint32(4321), rtx.SSRC)
+	case <-time.After(time.Second):
+		t.Fatal("parameter sets not retransmitted")
+	}
+}
@@ -4,10 +4,16 @@ import "errors"
 
 var (
 	// PeerLocal erors
+	ErrTransportExists          = errors.New("rtc transport already exists for this connection")
+	ErrNoTransportEstablished   = errors.New("no rtc transport exists for this Peer")
+	ErrOfferIgnored             = errors.New("offered ignored")
+	ErrNoPeerID                 = errors.New("peer id is required to join")
+	ErrE2EEMismatch             = errors.New("session e2ee mode mismatch")
 	errPeerConnectionInitFailed = errors.New("pc init failed")
 	errCreatingDataChannel      = errors.New("failed to create data channel")
 	// router errors
 	errNoReceiverFound = errors.New("no receiver found")
+	errNoBuffer        = errors.New("no buffer for the track")
 	// Helpers errors
 	errShortPacket = errors.New("packet is not large enough")
 	errNilPacket   = errors.New("invalid nil packet")
@@ -2,6 +2,7 @@ package webrtc
 
 import (
 	"github.com/pion/webrtc/v3"
+	"mediasfu/pkg/webrtc/buffer"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -73,3 +74,19 @@ func (t ntpTime) Duration() time.Duration {
 func (t ntpTime) Time() time.Time {
 	return ntpEpoch.Add(t.Duration())
 }
+
+// ntpToMillisSinceEpoch 高32位为秒, 低32位为1/2^32秒.
+func ntpToMillisSinceEpoch(ntp uint64) uint64 {
+	return (((ntp & 0xFFFFFFFF) * 1000) >> 32) + ((ntp >> 32) * 1000)
+}
+
+// fastForwardTimestampAmount newestTimestamp相对referenceTimestamp前进的时间戳, 考虑回绕.
+func fastForwardTimestampAmount(newestTimestamp uint32, referenceTimestamp uint32) uint32 {
+	if buffer.IsTimestampWrapAround(newestTimestamp, referenceTimestamp) {
+		return uint32(uint64(newestTimestamp) + 0x100000000 - uint64(referenceTimestamp))
+	}
+	if newestTimestamp < referenceTimestamp {
+		return 0
+	}
+	return newestTimestamp - referenceTimestamp
+}
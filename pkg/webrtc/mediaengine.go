@@ -3,6 +3,8 @@ package webrtc
 import (
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
+
+	"mediasfu/pkg/webrtc/buffer"
 )
 
 const (
@@ -10,11 +12,13 @@ const (
 	mimeTypeOpus = "audio/opus"
 	mimeTypeVP8  = "video/vp8"
 	mimeTypeVP9  = "video/vp9"
+	mimeTypeAV1  = "video/AV1"
+	mimeTypeH265 = "video/H265"
 )
 
 // 支持的所有编解码.
 var (
-	videoRTCPFeedback       = []webrtc.RTCPFeedback{{"goog-remb", ""}, {"ccm", "fir"}, {"nack", ""}, {"nack", "pli"}}
+	videoRTCPFeedback       = []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
 	videoRTPCodecParameters = []webrtc.RTPCodecParameters{
 		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
@@ -28,6 +32,11 @@ var (
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=1", RTCPFeedback: videoRTCPFeedback},
 			PayloadType:        100,
 		},
+		{
+			// 新版chrome屏幕共享默认AV1, SVC时通过Dependency Descriptor选择decode target.
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeAV1, ClockRate: 90000, SDPFmtpLine: "profile=0", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        45,
+		},
 		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: videoRTCPFeedback},
 			PayloadType:        102,
@@ -48,6 +57,11 @@ var (
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032", RTCPFeedback: videoRTCPFeedback},
 			PayloadType:        123,
 		},
+		{
+			// 部分会议室终端只支持H.265, 不转码, 两端都协商了H.265时原样转发.
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeH265, ClockRate: 90000, SDPFmtpLine: "level-id=93;profile-id=1;tier-flag=0;tx-mode=SRST", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        49,
+		},
 
 	}
 
@@ -68,8 +82,6 @@ var (
 	}
 )
 
-const frameMarking = "urn:ietf:params:rtp-hdrext:framemarking"
-
 // support g711 and opus.
 func getPublisherMediaEngine(mimeAudio, mimeVideo string) (*webrtc.MediaEngine, error) {
 	me := &webrtc.MediaEngine{}
@@ -109,7 +121,8 @@ func getPublisherMediaEngine(mimeAudio, mimeVideo string) (*webrtc.MediaEngine,
 		sdp.SDESMidURI,
 		sdp.SDESRTPStreamIDURI,
 		sdp.TransportCCURI,
-		frameMarking,
+		buffer.FrameMarkingURI,
+		buffer.DependencyDescriptorURI,
 	} {
 		if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeVideo); err != nil {
 			return nil, err
@@ -131,6 +144,19 @@ func getPublisherMediaEngine(mimeAudio, mimeVideo string) (*webrtc.MediaEngine,
 func getSubscriberMediaEngine() (*webrtc.MediaEngine, error) {
 	me := &webrtc.MediaEngine{}
 	me.RegisterDefaultCodecs()
+	// pion的默认编码里没有H.265.
+	for _, codec := range videoRTPCodecParameters {
+		if codec.MimeType != mimeTypeH265 {
+			continue
+		}
+		if err := me.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+	// 转发SVC AV1时带上Dependency Descriptor.
+	if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: buffer.DependencyDescriptorURI}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, err
+	}
 	return me, nil
 }
 
@@ -1,10 +1,17 @@
 package webrtc
 
 import (
+	"fmt"
 	"github.com/pion/webrtc/v3"
 	"sync"
 )
 
+const (
+	// OnIceCandidate的target, 候选属于哪一个pc.
+	publisher  = 0
+	subscriber = 1
+)
+
 // local peer: only one tack.
 type Peer interface {
 	ID() string
@@ -21,6 +28,8 @@ type Peer interface {
 // This allows the sfu.SFU{} implementation to be customized / wrapped by another package
 type SessionProvider interface {
 	GetSession(sid string) (Session, WebRTCTransportConfig)
+	// NewSession returns the session of sid, creates it if not exists
+	NewSession(sid string, e2ee bool) (Session, WebRTCTransportConfig)
 }
 
 // PeerLocal represents a pair peer connection
@@ -42,4 +51,201 @@ type PeerLocal struct {
 
 	remoteAnswerPending bool
 	negotiationPending  bool
-}
\ No newline at end of file
+}
+
+// NewPeer creates a new PeerLocal for signaling with the given SFU
+func NewPeer(provider SessionProvider) *PeerLocal {
+	return &PeerLocal{
+		provider: provider,
+	}
+}
+
+// Join initializes this peer for a given sessionID, e2ee is used only if the session is new
+func (p *PeerLocal) Join(sid, uid string, e2ee bool) error {
+	var err error
+	if p.session != nil {
+		Logger.Info("peer already exists", "session_id", sid, "peer_id", p.id)
+		return ErrTransportExists
+	}
+	if uid == "" {
+		return ErrNoPeerID
+	}
+
+	p.id = uid
+	s, cfg := p.provider.NewSession(sid, e2ee)
+	// 会话已经存在时加密模式由它决定.
+	if s.E2EE() != e2ee {
+		return ErrE2EEMismatch
+	}
+	p.session = s
+
+	p.subscriber, err = NewSubscriber(uid, cfg)
+	if err != nil {
+		p.session.RemovePeer(p)
+		return fmt.Errorf("error creating transport: %w", err)
+	}
+
+	// 下行有track增减时发offer给客户端, 上一个offer还没有answer时等它返回.
+	p.subscriber.OnNegotiationNeeded(func() {
+		p.Lock()
+		defer p.Unlock()
+
+		if p.remoteAnswerPending {
+			p.negotiationPending = true
+			return
+		}
+
+		offer, err := p.subscriber.CreateOffer()
+		if err != nil {
+			Logger.Error(err, "CreateOffer error", "peer_id", p.id)
+			return
+		}
+
+		p.remoteAnswerPending = true
+		if p.OnOffer != nil && !p.closed.get() {
+			Logger.Info("Send offer", "peer_id", p.id)
+			p.OnOffer(&offer)
+		}
+	})
+
+	p.subscriber.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		if p.OnIceCandidate != nil && !p.closed.get() {
+			json := c.ToJSON()
+			p.OnIceCandidate(&json, subscriber)
+		}
+	})
+
+	p.publisher, err = NewPublisher(uid, p.session, &cfg)
+	if err != nil {
+		_ = p.subscriber.Close()
+		p.session.RemovePeer(p)
+		return fmt.Errorf("error creating transport: %w", err)
+	}
+
+	p.publisher.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		if p.OnIceCandidate != nil && !p.closed.get() {
+			json := c.ToJSON()
+			p.OnIceCandidate(&json, publisher)
+		}
+	})
+
+	p.publisher.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
+		if p.OnICEConnectionStateChange != nil && !p.closed.get() {
+			p.OnICEConnectionStateChange(s)
+		}
+	})
+
+	p.session.AddPeer(p)
+	Logger.Info("PeerLocal join SessionLocal", "peer_id", p.id, "session_id", sid)
+	p.session.Subscribe(p)
+	return nil
+}
+
+// Answer an offer from remote
+func (p *PeerLocal) Answer(sdp webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if p.publisher == nil {
+		return nil, ErrNoTransportEstablished
+	}
+
+	Logger.Info("PeerLocal got offer", "peer_id", p.id)
+	if p.publisher.SignalingState() != webrtc.SignalingStateStable {
+		return nil, ErrOfferIgnored
+	}
+
+	answer, err := p.publisher.Answer(sdp)
+	if err != nil {
+		return nil, fmt.Errorf("error creating answer: %w", err)
+	}
+
+	Logger.Info("PeerLocal send answer", "peer_id", p.id)
+	return &answer, nil
+}
+
+// SetRemoteDescription when receiving an answer from remote
+func (p *PeerLocal) SetRemoteDescription(sdp webrtc.SessionDescription) error {
+	if p.subscriber == nil {
+		return ErrNoTransportEstablished
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	Logger.Info("PeerLocal got answer", "peer_id", p.id)
+	if err := p.subscriber.SetRemoteDescription(sdp); err != nil {
+		return fmt.Errorf("setting remote description: %w", err)
+	}
+
+	p.remoteAnswerPending = false
+	if p.negotiationPending {
+		p.negotiationPending = false
+		p.subscriber.negotiate()
+	}
+	return nil
+}
+
+// Trickle candidates available for this peer, target is publisher(0) or subscriber(1)
+func (p *PeerLocal) Trickle(candidate webrtc.ICECandidateInit, target int) error {
+	if p.subscriber == nil || p.publisher == nil {
+		return ErrNoTransportEstablished
+	}
+	Logger.Info("PeerLocal trickle", "peer_id", p.id)
+	switch target {
+	case publisher:
+		if err := p.publisher.AddICECandidate(candidate); err != nil {
+			return fmt.Errorf("setting ice candidate: %w", err)
+		}
+	case subscriber:
+		if err := p.subscriber.AddICECandidate(candidate); err != nil {
+			return fmt.Errorf("setting ice candidate: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close shuts down the peer connection and sends true to the done channel
+func (p *PeerLocal) Close() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.closed.set(true) {
+		return nil
+	}
+
+	if p.session != nil {
+		p.session.RemovePeer(p)
+	}
+	if p.publisher != nil {
+		p.publisher.Close()
+	}
+	if p.subscriber != nil {
+		if err := p.subscriber.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ID -.
+func (p *PeerLocal) ID() string {
+	return p.id
+}
+
+// Session -.
+func (p *PeerLocal) Session() Session {
+	return p.session
+}
+
+// Publisher returns the publisher of the peer, nil before Join
+func (p *PeerLocal) Publisher() *Publisher {
+	return p.publisher
+}
+
+// Subscriber returns the subscriber of the peer, nil before Join
+func (p *PeerLocal) Subscriber() *Subscriber {
+	return p.subscriber
+}
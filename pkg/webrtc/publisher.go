@@ -1,11 +1,9 @@
 package webrtc
 
 import (
-	"fmt"
-	"github.com/pion/rtcp"
-	"github.com/pion/transport/packetio"
 	"github.com/pion/webrtc/v3"
-	"mediasfu/pkg/webrtc/buffer"
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/webrtc/stats"
 	"sync"
 	"sync/atomic"
 )
@@ -20,8 +18,6 @@ type Publisher struct {
 	router     Router
 	session    Session
 	tracks     []PublisherTrack
-	relayed    atomicBool
-	// relayPeers []*relayPeer
 	candidates []webrtc.ICECandidateInit
 
 	onICEConnectionStateChangeHandler atomic.Value // func(webrtc.ICEConnectionState)
@@ -47,7 +43,7 @@ type PublisherTrack struct {
 // //这里要注意cfg.Setting，里边的bufferFactory已经设置好了为自定义的c.BufferFactory.GetOrNew
 //  //可以搜一下这个函数NewWebRTCTransportConfig，这一行“se.BufferFactory = c.BufferFactory.GetOrNew”.
 func NewPublisher(id string, session Session, cfg *WebRTCTransportConfig) (*Publisher, error) {
-	me, err := getPublisherMediaEngine()
+	me, err := getPublisherMediaEngine("", "")
 	if err != nil {
 		Logger.Error(err, "NewPeer error", "peer_id", id)
 		return nil, errPeerConnectionInitFailed
@@ -68,6 +64,8 @@ func NewPublisher(id string, session Session, cfg *WebRTCTransportConfig) (*Publ
 		router:  newRouter(id, session, cfg),
 		session: session,
 	}
+	// 一个peer一个Publisher, Close时减少.
+	stats.Peers.Inc()
 
 	// 媒体处理.和down track区别?.
 	// 新的track到达.
@@ -83,17 +81,20 @@ func NewPublisher(id string, session Session, cfg *WebRTCTransportConfig) (*Publ
 		// 这里AddReceiver会新建WebRTCReceiver，然后AddUpTrack
 		// uptrack是收流的，downtrack是发流的
 		r, pub := p.router.AddReceiver(receiver, track, track.ID(), track.StreamID())
+		if r == nil {
+			return
+		}
 		if pub {
 			// 这里会把流发布到房间内，其他peer会订阅到
 			p.session.Publish(p.router, r)
+			p.cfg.Events.Publish(events.TypeTrackPublished, p.session.ID(), p.id, events.TrackData{
+				TrackID:  track.ID(),
+				StreamID: track.StreamID(),
+				Kind:     track.Kind().String(),
+			})
 			p.mu.Lock()
 			publisherTrack := PublisherTrack{track, r}
 			p.tracks = append(p.tracks, publisherTrack) // 增加的publisherTrack，客户端publish的track.
-			for _, rp := range p.relayPeers {
-				if err = p.createRelayTrack(track, r, rp.peer); err != nil {
-					Logger.V(1).Error(err, "Creating relay track.", "peer_id", p.id)
-				}
-			}
 			p.mu.Unlock()
 
 			// 这里如果上层业务，通过OnPublisherTrack设置了回调，就会触发
@@ -103,21 +104,16 @@ func NewPublisher(id string, session Session, cfg *WebRTCTransportConfig) (*Publ
 			}
 		} else {
 			p.mu.Lock()
-			p.tracks = append(p.tracks, PublisherTrack{track, r, false})
+			p.tracks = append(p.tracks, PublisherTrack{track, r})
 			p.mu.Unlock()
 		}
 	})
 
-	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
-		if dc.Label() == APIChannelLabel {
-			// terminate api data channel
-			return
-		}
-		p.session.AddDatachannel(id, dc)
-	})
-
 	pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		Logger.V(1).Info("ice connection status", "state", connectionState)
+		p.cfg.Events.Publish(events.TypeICEState, p.session.ID(), p.id, events.ICEStateData{
+			State: connectionState.String(),
+		})
 		switch connectionState {
 		case webrtc.ICEConnectionStateFailed:
 			fallthrough
@@ -166,19 +162,11 @@ func (p *Publisher) GetRouter() Router {
 // Close peer
 func (p *Publisher) Close() {
 	p.closeOnce.Do(func() {
-		if len(p.relayPeers) > 0 {
-			p.mu.Lock()
-			for _, rp := range p.relayPeers {
-				if err := rp.peer.Close(); err != nil {
-					Logger.Error(err, "Closing relay peer transport.")
-				}
-			}
-			p.mu.Unlock()
-		}
 		p.router.Stop()
 		if err := p.pc.Close(); err != nil {
 			Logger.Error(err, "webrtc transport close err")
 		}
+		stats.Peers.Dec()
 	})
 }
 
@@ -203,93 +191,6 @@ func (p *Publisher) PeerConnection() *webrtc.PeerConnection {
 	return p.pc
 }
 
-// Relay will relay all current and future tracks from current Publisher
-func (p *Publisher) Relay(signalFn func(meta relay.PeerMeta, signal []byte) ([]byte, error),
-	options ...func(r *relayPeer)) (*relay.Peer, error) {
-	lrp := &relayPeer{}
-	for _, o := range options {
-		o(lrp)
-	}
-
-	rp, err := relay.NewPeer(relay.PeerMeta{
-		PeerID:    p.id,
-		SessionID: p.session.ID(),
-	}, &relay.PeerConfig{
-		SettingEngine: p.cfg.Setting,
-		ICEServers:    p.cfg.Configuration.ICEServers,
-		Logger:        Logger,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("relay: %w", err)
-	}
-	lrp.peer = rp
-
-	rp.OnReady(func() {
-		peer := p.session.GetPeer(p.id)
-
-		p.relayed.set(true)
-		if lrp.relayFanOutDataChannels {
-			for _, lbl := range p.session.GetFanOutDataChannelLabels() {
-				lbl := lbl
-				dc, err := rp.CreateDataChannel(lbl)
-				if err != nil {
-					Logger.V(1).Error(err, "Creating data channels.", "peer_id", p.id)
-				}
-				dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-					if peer == nil || peer.Subscriber() == nil {
-						return
-					}
-					if sdc := peer.Subscriber().DataChannel(lbl); sdc != nil {
-						if msg.IsString {
-							if err = sdc.SendText(string(msg.Data)); err != nil {
-								Logger.Error(err, "Sending dc message err")
-							}
-						} else {
-							if err = sdc.Send(msg.Data); err != nil {
-								Logger.Error(err, "Sending dc message err")
-							}
-						}
-					}
-				})
-			}
-		}
-
-		p.mu.Lock()
-		for _, tp := range p.tracks {
-			if !tp.clientRelay {
-				// simulcast will just relay client track for now
-				continue
-			}
-			if err = p.createRelayTrack(tp.Track, tp.Receiver, rp); err != nil {
-				Logger.V(1).Error(err, "Creating relay track.", "peer_id", p.id)
-			}
-		}
-		p.relayPeers = append(p.relayPeers, lrp)
-		p.mu.Unlock()
-
-		if lrp.withSRReports {
-			go p.relayReports(rp)
-		}
-	})
-
-	rp.OnDataChannel(func(channel *webrtc.DataChannel) {
-		if !lrp.relayFanOutDataChannels {
-			return
-		}
-		p.mu.Lock()
-		lrp.dcs = append(lrp.dcs, channel)
-		p.mu.Unlock()
-
-		p.session.AddDatachannel("", channel)
-	})
-
-	if err = rp.Offer(signalFn); err != nil {
-		return nil, fmt.Errorf("relay: %w", err)
-	}
-
-	return rp, nil
-}
-
 func (p *Publisher) PublisherTracks() []PublisherTrack {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -301,51 +202,6 @@ func (p *Publisher) PublisherTracks() []PublisherTrack {
 	return tracks
 }
 
-// AddRelayFanOutDataChannel adds fan out data channel to relayed peers
-func (p *Publisher) AddRelayFanOutDataChannel(label string) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
-	for _, rp := range p.relayPeers {
-		for _, dc := range rp.dcs {
-			if dc.Label() == label {
-				continue
-			}
-		}
-
-		dc, err := rp.peer.CreateDataChannel(label)
-		if err != nil {
-			Logger.V(1).Error(err, "Creating data channels.", "peer_id", p.id)
-		}
-		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-			p.session.FanOutMessage("", label, msg)
-		})
-	}
-}
-
-// GetRelayedDataChannels Returns a slice of data channels that belongs to relayed
-// peers
-func (p *Publisher) GetRelayedDataChannels(label string) []*webrtc.DataChannel {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
-	dcs := make([]*webrtc.DataChannel, 0, len(p.relayPeers))
-	for _, rp := range p.relayPeers {
-		for _, dc := range rp.dcs {
-			if dc.Label() == label {
-				dcs = append(dcs, dc)
-				break
-			}
-		}
-	}
-	return dcs
-}
-
-// Relayed returns true if the publisher has been relayed at least once
-func (p *Publisher) Relayed() bool {
-	return p.relayed.get()
-}
-
 func (p *Publisher) Tracks() []*webrtc.TrackRemote {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -365,60 +221,3 @@ func (p *Publisher) AddICECandidate(candidate webrtc.ICECandidateInit) error {
 	p.candidates = append(p.candidates, candidate)
 	return nil
 }
-
-func (p *Publisher) createRelayTrack(track *webrtc.TrackRemote, receiver Receiver, rp *relay.Peer) error {
-	codec := track.Codec()
-	downTrack, err := NewDownTrack(webrtc.RTPCodecCapability{
-		MimeType:     codec.MimeType,
-		ClockRate:    codec.ClockRate,
-		Channels:     codec.Channels,
-		SDPFmtpLine:  codec.SDPFmtpLine,
-		RTCPFeedback: []webrtc.RTCPFeedback{{"nack", ""}, {"nack", "pli"}},
-	}, receiver, p.cfg.BufferFactory, p.id, p.cfg.Router.MaxPacketTrack)
-	if err != nil {
-		Logger.V(1).Error(err, "Create Relay downtrack err", "peer_id", p.id)
-		return err
-	}
-
-	sdr, err := rp.AddTrack(receiver.(*WebRTCReceiver).receiver, track, downTrack)
-	if err != nil {
-		Logger.V(1).Error(err, "Relaying track.", "peer_id", p.id)
-		return fmt.Errorf("relay: %w", err)
-	}
-
-	p.cfg.BufferFactory.GetOrNew(packetio.RTCPBufferPacket,
-		uint32(sdr.GetParameters().Encodings[0].SSRC)).(*buffer.RTCPReader).OnPacket(func(bytes []byte) {
-		pkts, err := rtcp.Unmarshal(bytes)
-		if err != nil {
-			Logger.V(1).Error(err, "Unmarshal rtcp reports", "peer_id", p.id)
-			return
-		}
-		var rpkts []rtcp.Packet
-		for _, pkt := range pkts {
-			switch pk := pkt.(type) {
-			case *rtcp.PictureLossIndication:
-				rpkts = append(rpkts, &rtcp.PictureLossIndication{
-					SenderSSRC: pk.MediaSSRC,
-					MediaSSRC:  uint32(track.SSRC()),
-				})
-			}
-		}
-
-		if len(rpkts) > 0 {
-			if err := p.pc.WriteRTCP(rpkts); err != nil {
-				Logger.V(1).Error(err, "Sending rtcp relay reports", "peer_id", p.id)
-			}
-		}
-
-	})
-
-	downTrack.OnCloseHandler(func() {
-		if err = sdr.Stop(); err != nil {
-			Logger.V(1).Error(err, "Stopping relay sender.", "peer_id", p.id)
-		}
-	})
-
-	receiver.AddDownTrack(downTrack, true)
-	return nil
-}
-
@@ -0,0 +1,302 @@
+package webrtc
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/qoe"
+	"mediasfu/pkg/webrtc/buffer"
+	"mediasfu/pkg/webrtc/stats"
+)
+
+const (
+	_defaultQualityInterval  = 5 * time.Second
+	_defaultQualityThreshold = 3.0
+	_defaultQualityHistory   = 120
+)
+
+// QualitySample 一次采样的peer评分, MOS 1-5, 0表示该方向没有媒体.
+type QualitySample struct {
+	Time      time.Time
+	Publish   float64 // 上行track中最差的评分, 基于buffer.Stats
+	Subscribe float64 // 下行track中最差的评分, 基于订阅端的RR
+	Score     float64 // 两个方向中较低的一个
+}
+
+// QualityMonitor 定期为每个peer评分, 保存最近的采样, 评分低于阈值时发布peer.quality_drop事件.
+type QualityMonitor struct {
+	sync.RWMutex
+	sfu       *SFU
+	bus       *events.Bus
+	interval  time.Duration
+	threshold float64
+	history   int
+	peers     map[string]*peerQuality // session id + "/" + peer id
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type peerQuality struct {
+	samples []QualitySample
+	tracks  map[string]buffer.Stats // 上一次采样时上行track的统计, 按track id
+	low     bool
+}
+
+// QualityOption -.
+type QualityOption func(*QualityMonitor)
+
+// QualityInterval -.
+func QualityInterval(interval time.Duration) QualityOption {
+	return func(m *QualityMonitor) {
+		m.interval = interval
+	}
+}
+
+// QualityThreshold 评分低于threshold时发布事件, 恢复后再次低于才会再发布.
+func QualityThreshold(threshold float64) QualityOption {
+	return func(m *QualityMonitor) {
+		m.threshold = threshold
+	}
+}
+
+// QualityHistory 每个peer保存的采样数.
+func QualityHistory(n int) QualityOption {
+	return func(m *QualityMonitor) {
+		m.history = n
+	}
+}
+
+// NewQualityMonitor -.
+func NewQualityMonitor(sfu *SFU, bus *events.Bus, opts ...QualityOption) *QualityMonitor {
+	m := &QualityMonitor{
+		sfu:       sfu,
+		bus:       bus,
+		interval:  _defaultQualityInterval,
+		threshold: _defaultQualityThreshold,
+		history:   _defaultQualityHistory,
+		peers:     make(map[string]*peerQuality),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	// Custom options
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Start samples every interval until Stop
+func (m *QualityMonitor) Start() {
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.sample()
+			}
+		}
+	}()
+}
+
+// Stop -.
+func (m *QualityMonitor) Stop() {
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+		<-m.done
+	}
+}
+
+// Samples returns the samples of the peer, oldest first
+func (m *QualityMonitor) Samples(sessionID, peerID string) []QualitySample {
+	m.RLock()
+	defer m.RUnlock()
+	pq, ok := m.peers[sessionID+"/"+peerID]
+	if !ok {
+		return nil
+	}
+	samples := make([]QualitySample, len(pq.samples))
+	copy(samples, pq.samples)
+	return samples
+}
+
+// Latest returns the latest sample of the peer
+func (m *QualityMonitor) Latest(sessionID, peerID string) (QualitySample, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	pq, ok := m.peers[sessionID+"/"+peerID]
+	if !ok || len(pq.samples) == 0 {
+		return QualitySample{}, false
+	}
+	return pq.samples[len(pq.samples)-1], true
+}
+
+func (m *QualityMonitor) sample() {
+	now := time.Now()
+	seen := make(map[string]struct{})
+
+	m.Lock()
+	defer m.Unlock()
+	for _, s := range m.sfu.Sessions() {
+		for _, p := range s.Peers() {
+			key := s.ID() + "/" + p.ID()
+			seen[key] = struct{}{}
+			pq, ok := m.peers[key]
+			if !ok {
+				pq = &peerQuality{tracks: make(map[string]buffer.Stats)}
+				m.peers[key] = pq
+			}
+
+			sample := QualitySample{Time: now}
+			if pub := p.Publisher(); pub != nil {
+				sample.Publish = pq.publishScore(pub, m.interval)
+				if sample.Publish > 0 {
+					stats.PeerMOS.WithLabelValues("publish").Observe(sample.Publish)
+				}
+			}
+			if sub := p.Subscriber(); sub != nil {
+				sample.Subscribe = subscribeScore(sub)
+				if sample.Subscribe > 0 {
+					stats.PeerMOS.WithLabelValues("subscribe").Observe(sample.Subscribe)
+				}
+			}
+			sample.Score = minScore(sample.Publish, sample.Subscribe)
+
+			pq.samples = append(pq.samples, sample)
+			if len(pq.samples) > m.history {
+				pq.samples = pq.samples[len(pq.samples)-m.history:]
+			}
+
+			low := sample.Score > 0 && sample.Score < m.threshold
+			if low && !pq.low {
+				m.bus.Publish(events.TypeQualityDrop, s.ID(), p.ID(), events.QualityData{
+					PeerID:    p.ID(),
+					Score:     sample.Score,
+					Publish:   sample.Publish,
+					Subscribe: sample.Subscribe,
+					Threshold: m.threshold,
+				})
+			}
+			pq.low = low
+		}
+	}
+
+	for key := range m.peers {
+		if _, ok := seen[key]; !ok {
+			delete(m.peers, key)
+		}
+	}
+}
+
+// publishScore 按两次采样之间的增量计算丢包率、帧率和卡顿占比.
+func (pq *peerQuality) publishScore(pub *Publisher, interval time.Duration) float64 {
+	score := 0.0
+	tracks := make(map[string]buffer.Stats)
+	for _, t := range pub.PublisherTracks() {
+		recv := t.Receiver
+		cur := recv.GetStats()
+		tracks[recv.TrackID()] = cur
+		last, ok := pq.tracks[recv.TrackID()]
+		if !ok {
+			continue
+		}
+
+		received := float64(cur.PacketCount - last.PacketCount)
+		lost := float64(cur.PacketsLost - last.PacketsLost)
+		if cur.PacketsLost < last.PacketsLost {
+			lost = 0
+		}
+		lossRate := 0.0
+		if received+lost > 0 {
+			lossRate = lost / (received + lost)
+		}
+
+		var mos float64
+		switch recv.Kind() {
+		case webrtc.RTPCodecTypeAudio:
+			jitterMs := 0.0
+			if clockRate := recv.Codec().ClockRate; clockRate > 0 {
+				jitterMs = cur.Jitter * 1000 / float64(clockRate)
+			}
+			mos = qoe.AudioMOS(qoe.AudioParams{
+				Codec:    recv.Codec().MimeType,
+				LossRate: lossRate,
+				JitterMs: jitterMs,
+				RTTMs:    float64(recv.RTT().Milliseconds()),
+			})
+		case webrtc.RTPCodecTypeVideo:
+			mos = qoe.VideoMOS(qoe.VideoParams{
+				Bitrate:     recv.GetBitrate(),
+				FPS:         float64(cur.Frames-last.Frames) / interval.Seconds(),
+				FreezeRatio: float64(cur.FreezeDuration-last.FreezeDuration) / float64(interval.Milliseconds()),
+				LossRate:    lossRate,
+			})
+		default:
+			continue
+		}
+		score = minScore(score, mos)
+	}
+	pq.tracks = tracks
+	return score
+}
+
+// subscribeScore 订阅端的丢包率和抖动来自RR, 静音和还没有RR的track不参与评分.
+func subscribeScore(sub *Subscriber) float64 {
+	score := 0.0
+	for _, dt := range sub.DownTracks() {
+		if !dt.Enabled() {
+			continue
+		}
+		rr, rtt, ok := dt.ReceiverReport()
+		if !ok {
+			continue
+		}
+		lossRate := float64(rr.FractionLost) / 256
+
+		var mos float64
+		switch dt.Kind() {
+		case webrtc.RTPCodecTypeAudio:
+			jitterMs := 0.0
+			if dt.Codec().ClockRate > 0 {
+				jitterMs = float64(rr.Jitter) * 1000 / float64(dt.Codec().ClockRate)
+			}
+			mos = qoe.AudioMOS(qoe.AudioParams{
+				Codec:    dt.Codec().MimeType,
+				LossRate: lossRate,
+				JitterMs: jitterMs,
+				RTTMs:    float64(rtt.Milliseconds()),
+			})
+		case webrtc.RTPCodecTypeVideo:
+			mos = qoe.VideoMOS(qoe.VideoParams{
+				Bitrate:  dt.Bitrate(),
+				LossRate: lossRate,
+			})
+		default:
+			continue
+		}
+		score = minScore(score, mos)
+	}
+	return score
+}
+
+// minScore 0表示没有评分.
+func minScore(a, b float64) float64 {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	}
+	return math.Min(a, b)
+}
@@ -24,6 +24,9 @@ type Receiver interface {
 	AddUpTrack(track *webrtc.TrackRemote, buffer *buffer.Buffer)
 	AddDownTrack(track *DownTrack)
 	GetBitrate() uint64
+	GetStats() buffer.Stats
+	RTT() time.Duration
+	H264ParameterSets() []byte
 	RetransmitPackets(track *DownTrack, packets []packetMeta) error
 	DeleteDownTrack(id string)
 	OnCloseHandler(fn func())
@@ -55,6 +58,7 @@ type WebRTCReceiver struct {
 
 	buffers        *buffer.Buffer
 	upTracks       *webrtc.TrackRemote
+	ssrc           uint32 // 普通rtp没有upTracks
 
 	// 不考虑simulcast.
 	// isSimulcast    bool // always false
@@ -78,6 +82,20 @@ func NewWebRTCReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRemote,
 	}
 }
 
+// NewRTPReceiver creates a receiver of a plain rtp stream, such as a sip leg, it has no pion RTPReceiver
+func NewRTPReceiver(codec webrtc.RTPCodecParameters, kind webrtc.RTPCodecType, ssrc uint32, trackID, streamID, pid string) Receiver {
+	worker, _ := gpool.NewPool(1)
+	return &WebRTCReceiver{
+		peerID:     pid,
+		trackID:    trackID,
+		streamID:   streamID,
+		codec:      codec,
+		kind:       kind,
+		ssrc:       ssrc,
+		nackWorker: worker,
+	}
+}
+
 func (w *WebRTCReceiver) SetTrackMeta(trackID, streamID string) {
 	w.streamID = streamID
 	w.trackID = trackID
@@ -95,7 +113,7 @@ func (w *WebRTCReceiver) SSRC() uint32 {
 	if w.upTracks != nil {
 		return uint32(w.upTracks.SSRC())
 	}
-	return 0
+	return w.ssrc
 }
 
 func (w *WebRTCReceiver) Codec() webrtc.RTPCodecParameters {
@@ -143,6 +161,21 @@ func (w *WebRTCReceiver) GetBitrate() uint64 {
 	return w.buffers.Bitrate()
 }
 
+// GetStats returns the stats of the up track buffer
+func (w *WebRTCReceiver) GetStats() buffer.Stats {
+	return w.buffers.GetStats()
+}
+
+// RTT returns the round trip time to the publisher
+func (w *WebRTCReceiver) RTT() time.Duration {
+	return w.buffers.RTT()
+}
+
+// H264ParameterSets returns the cached SPS/PPS of the publisher as a STAP-A payload
+func (w *WebRTCReceiver) H264ParameterSets() []byte {
+	return w.buffers.H264ParameterSets()
+}
+
 func (w *WebRTCReceiver) GetMaxTemporalLayer() int32 {
 	return w.buffers.MaxTemporalLayer()
 }
@@ -278,27 +311,36 @@ func (w *WebRTCReceiver) RetransmitPackets(track *DownTrack, packets []packetMet
 			if buff == nil {
 				break
 			}
-			i, err := buff.GetPacket(pktBuff, meta.sourceSeqNo)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				continue
-			}
 			var pkt rtp.Packet
-			if err = pkt.Unmarshal(pktBuff[:i]); err != nil {
-				continue
+			if meta.parameterSets {
+				// DownTrack插入的SPS/PPS, 不在buffer里.
+				if pkt.Payload = buff.H264ParameterSets(); pkt.Payload == nil {
+					continue
+				}
+				pkt.Header.Version = 2
+			} else {
+				i, err := buff.GetPacket(pktBuff, meta.sourceSeqNo)
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					continue
+				}
+				if err = pkt.Unmarshal(pktBuff[:i]); err != nil {
+					continue
+				}
 			}
 			pkt.Header.SequenceNumber = meta.targetSeqNo
 			pkt.Header.Timestamp = meta.timestamp
+			pkt.Header.Marker = meta.marker
 			pkt.Header.SSRC = track.ssrc
 			pkt.Header.PayloadType = track.payloadType
 			// simulcast ignored.
 
-			if _, err = track.writeStream.WriteRTP(&pkt.Header, pkt.Payload); err != nil {
+			if n, err := track.writeStream.WriteRTP(&pkt.Header, pkt.Payload); err != nil {
 				Logger.Error(err, "Writing rtx packet err")
 			} else {
-				track.UpdateStats(uint32(i))
+				track.UpdateStats(uint32(n))
 			}
 		}
 		packetFactory.Put(src)
@@ -2,18 +2,26 @@ package webrtc
 
 import (
 	"github.com/pion/rtcp"
+	"github.com/pion/transport/packetio"
 	"github.com/pion/webrtc/v3"
+	"mediasfu/pkg/events"
 	"mediasfu/pkg/webrtc/buffer"
+	"mediasfu/pkg/webrtc/stats"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Router defines a track rtp/rtcp Router
 type Router interface {
 	ID() string
 	AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRemote, trackID, streamID string) (Receiver, bool)
+	AddRTPReceiver(ssrc uint32, codec webrtc.RTPCodecParameters, trackID, streamID string) (Receiver, bool)
+	Receivers() []Receiver
 	AddDownTracks(s *Subscriber, r Receiver) error
 	SetRTCPWriter(func([]rtcp.Packet) error)
 	AddDownTrack(s *Subscriber, r Receiver) (*DownTrack, error)
+	Reload()
 	Stop()
 }
 
@@ -22,14 +30,27 @@ type Router interface {
 //1、该方法支持基本类型，不支持结构体 struct
 //2、该方法支持map结构中的值是map的嵌套结构
 type RouterConfig struct {
-	// WithStats           bool            `mapstructure:"withstats"`
-	MaxBandwidth        uint64          `mapstructure:"maxbandwidth"`
-	MaxPacketTrack      int             `mapstructure:"maxpackettrack"`
-
-	// for audio observer.
-	AudioLevelInterval  int             `mapstructure:"audiolevelinterval"`
-	AudioLevelThreshold uint8           `mapstructure:"audiolevelthreshold"`
-	AudioLevelFilter    int             `mapstructure:"audiolevelfilter"`
+	// WithStats           bool            `mapstructure:"withstats" yaml:"withstats" toml:"withstats"`
+	MaxBandwidth        uint64          `mapstructure:"maxbandwidth" yaml:"maxbandwidth" toml:"maxbandwidth"`
+	MaxPacketTrack      int             `mapstructure:"maxpackettrack" yaml:"maxpackettrack" toml:"maxpackettrack"`
+
+	// 上行NACK策略, 0使用默认值.
+	NackRetries         int             `mapstructure:"nackretries" yaml:"nackretries" toml:"nackretries"`
+	NackMaxAge          int             `mapstructure:"nackmaxage" yaml:"nackmaxage" toml:"nackmaxage"` // ms
+	NackBudget          uint64          `mapstructure:"nackbudget" yaml:"nackbudget" toml:"nackbudget"` // bps, 每个流请求重传的码率上限
+	// 上行乱序包最多等待的时间(ms), 按序列号顺序转发, 0不等待.
+	ReorderWindow       int             `mapstructure:"reorderwindow" yaml:"reorderwindow" toml:"reorderwindow"`
+
+	// for audio observer. 没有声音扩展时只计算PCMU/PCMA的声音大小, 不解码opus.
+	AudioLevelInterval  int             `mapstructure:"audiolevelinterval" yaml:"audiolevelinterval" toml:"audiolevelinterval"`
+	AudioLevelThreshold uint8           `mapstructure:"audiolevelthreshold" yaml:"audiolevelthreshold" toml:"audiolevelthreshold"`
+	AudioLevelFilter    int             `mapstructure:"audiolevelfilter" yaml:"audiolevelfilter" toml:"audiolevelfilter"`
+	// PCMU/PCMA发布端没有声音扩展时, 下行补上计算的声音大小.
+	AudioLevelEgress    bool            `mapstructure:"audiolevelegress" yaml:"audiolevelegress" toml:"audiolevelegress"`
+
+	// sip等纯rtp出口的抖动缓冲延时(ms), JitterMinDelay为0不开启. 浏览器有自己的抖动缓冲, webrtc出口不使用.
+	JitterMinDelay      int             `mapstructure:"jittermindelay" yaml:"jittermindelay" toml:"jittermindelay"`
+	JitterMaxDelay      int             `mapstructure:"jittermaxdelay" yaml:"jittermaxdelay" toml:"jittermaxdelay"`
 }
 
 // publish的订购关系实际路由.
@@ -39,15 +60,17 @@ type router struct {
 	sync.RWMutex
 	id            string
 
-	// 不支持twcc和统计.
+	// 不支持twcc.
 	//twcc          *twcc.Responder
-	//stats         map[uint32]*stats.Stream
+	stats         map[uint32]*stats.Stream
 	rtcpCh        chan []rtcp.Packet
 	stopCh        chan struct{}
 	config        RouterConfig
+	reload        func() RouterConfig
 	session       Session
 	receivers     map[string]Receiver // many receiver.
 	bufferFactory *buffer.Factory
+	events        *events.Bus
 	writeRTCP     func([]rtcp.Packet) error
 }
 
@@ -59,9 +82,12 @@ func newRouter(id string, session Session, config *WebRTCTransportConfig) Router
 		rtcpCh:        ch,
 		stopCh:        make(chan struct{}),
 		config:        config.Router,
+		reload:        config.RouterFunc,
 		session:       session,
 		receivers:     make(map[string]Receiver),
+		stats:         make(map[uint32]*stats.Stream),
 		bufferFactory: config.BufferFactory,
+		events:        config.Events,
 	}
 
 	return r
@@ -90,11 +116,53 @@ func (r *router) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRe
 	r.Lock()
 	defer r.Unlock()
 
+	return r.addReceiver(uint32(track.SSRC()), track.Kind(), trackID, streamID, receiver.GetParameters(), track, func() Receiver {
+		return NewWebRTCReceiver(receiver, track, r.id)
+	})
+}
+
+// AddRTPReceiver 发布不经过pc的普通rtp流(sip的rtp leg), 包由leg按ssrc写入bufferFactory的Buffer.
+func (r *router) AddRTPReceiver(ssrc uint32, codec webrtc.RTPCodecParameters, trackID, streamID string) (Receiver, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	kind := webrtc.RTPCodecTypeAudio
+	if strings.HasPrefix(strings.ToLower(codec.MimeType), "video/") {
+		kind = webrtc.RTPCodecTypeVideo
+	}
+	parameters := webrtc.RTPParameters{Codecs: []webrtc.RTPCodecParameters{codec}}
+	// leg收到rtcp之前没有RTCPReader.
+	r.bufferFactory.GetOrNew(packetio.RTCPBufferPacket, ssrc)
+	return r.addReceiver(ssrc, kind, trackID, streamID, parameters, nil, func() Receiver {
+		return NewRTPReceiver(codec, kind, ssrc, trackID, streamID, r.id)
+	})
+}
+
+// Receivers returns the published tracks of the router
+func (r *router) Receivers() []Receiver {
+	r.RLock()
+	defer r.RUnlock()
+	receivers := make([]Receiver, 0, len(r.receivers))
+	for _, recv := range r.receivers {
+		receivers = append(receivers, recv)
+	}
+	return receivers
+}
+
+// addReceiver 设置ssrc对应buffer的回调并绑定, track只有webrtc发布时有, 普通rtp为nil.
+func (r *router) addReceiver(ssrc uint32, kind webrtc.RTPCodecType, trackID, streamID string, parameters webrtc.RTPParameters,
+	track *webrtc.TrackRemote, newReceiver func() Receiver) (Receiver, bool) {
 	publish := false
+	r.refreshConfig()
 
 	// //这里获取了之前ReadStreamSRTP init函数中，new出来的buffer和rtcpReader，开始搞事情
 	// bufferFactory继承自SettingEngine.BufferFactory(来自DTLSTransport的srtp的bufferFactory).
-	buff, rtcpReader := r.bufferFactory.GetBufferPair(uint32(track.SSRC()))
+	buff, rtcpReader := r.bufferFactory.GetBufferPair(ssrc)
+	if buff == nil || rtcpReader == nil {
+		// buffer超出内存上限被拒绝, 或者作为孤儿被清理.
+		Logger.Error(errNoBuffer, "Adding receiver.", "ssrc", ssrc, "track_id", trackID)
+		return nil, false
+	}
 
 	// //设置rtcp的回调，比如nack、twcc、rr
 	buff.OnFeedback(func(fb []rtcp.Packet) {
@@ -102,14 +170,14 @@ func (r *router) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRe
 	})
 
 	//
-	if track.Kind() == webrtc.RTPCodecTypeAudio {
+	if kind == webrtc.RTPCodecTypeAudio {
 		// 如果是音频track，设置OnAudioLevel回调声音控制回调.
 		buff.OnAudioLevel(func(level uint8) {
-			r.session.AudioObserver().observe(streamID, level)
+			r.session.AudioObserver().Observe(streamID, level)
 		})
-		r.session.AudioObserver().addStream(streamID)
+		r.session.AudioObserver().AddStream(streamID)
 
-	} else if track.Kind() == webrtc.RTPCodecTypeVideo {
+	} else if kind == webrtc.RTPCodecTypeVideo {
 		//if r.twcc == nil {
 		//	// 如果是视频track，创建twcc计算器，并设置回调，当计算器生成twcc包就会回调.
 		//	// 注：这是rtp扩展字段支持.
@@ -117,7 +185,13 @@ func (r *router) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRe
 		////  server->client: twcc计算生成rtcp包，再回调OnFeedback发送给客户端.
 	}
 
-	// TODO: 每个track的统计.
+	// 每个ssrc的统计, 按session和kind打标签.
+	if old, ok := r.stats[ssrc]; ok {
+		old.Close()
+	}
+	stream := stats.NewStream(buff, r.session.ID(), kind.String())
+	r.stats[ssrc] = stream
+
 	// 设置rtcpReader.OnPacket
 	rtcpReader.OnPacket(func(bytes []byte) {
 		// 收到SDES、SR包做些处理
@@ -129,10 +203,24 @@ func (r *router) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRe
 		for _, pkt := range pkts {
 			switch pkt := pkt.(type) {
 			case *rtcp.SourceDescription:
-				// SName修改.
+				// 同一CNAME的音视频流计算同步偏差.
+				for _, chunk := range pkt.Chunks {
+					if chunk.Source != ssrc {
+						continue
+					}
+					for _, item := range chunk.Items {
+						if item.Type == rtcp.SDESCNAME {
+							stream.SetCName(item.Text)
+						}
+					}
+				}
 			case *rtcp.SenderReport:
 				buff.SetSenderReportData(pkt.RTPTime, pkt.NTPTime)
 				// 更新统计结果.
+				r.updateStats(stream)
+			case *rtcp.ExtendedReport:
+				// 回复RRTR的DLRR, 计算到发布端的rtt.
+				buff.HandleExtendedReport(pkt)
 			}
 		}
 	})
@@ -141,30 +229,83 @@ func (r *router) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.TrackRe
 	recv, ok := r.receivers[trackID]
 	if !ok {
 		//创建WebRTCReceiver并设置回调.
-		recv = NewWebRTCReceiver(receiver, track, r.id)
+		recv = newReceiver()
 		r.receivers[trackID] = recv
 		recv.SetRTCPCh(r.rtcpCh)
 		recv.OnCloseHandler(func() {
 			// audio track need to remove observer.
 			if recv.Kind() == webrtc.RTPCodecTypeAudio {
-				r.session.AudioObserver().removeStream(track.StreamID())
+				r.session.AudioObserver().RemoveStream(streamID)
+				stats.AudioTracks.Dec()
+			} else {
+				stats.VideoTracks.Dec()
 			}
-			r.deleteReceiver(trackID, uint32(track.SSRC()))
+			r.deleteReceiver(trackID, ssrc)
+			r.events.Publish(events.TypeTrackUnpublished, r.session.ID(), r.id, events.TrackData{
+				TrackID:  trackID,
+				StreamID: streamID,
+				Kind:     recv.Kind().String(),
+			})
 		})
 		publish = true
+		if kind == webrtc.RTPCodecTypeAudio {
+			stats.AudioTracks.Inc()
+		} else {
+			stats.VideoTracks.Inc()
+		}
 	}
 
 	// 把track buffer塞入recv
 	// 创建uptrack.
 	recv.AddUpTrack(track, buff)
 
-	buff.Bind(receiver.GetParameters(), buffer.Options{
+	buff.Bind(parameters, buffer.Options{
 		MaxBitRate: r.config.MaxBandwidth,
+		E2EE:       r.session.E2EE(),
+		Nack: buffer.NackOptions{
+			MaxRetries: r.config.NackRetries,
+			MaxAge:     time.Duration(r.config.NackMaxAge) * time.Millisecond,
+			Budget:     r.config.NackBudget,
+		},
+		ReorderWindow: time.Duration(r.config.ReorderWindow) * time.Millisecond,
 	})
 
 	return recv, publish
 }
 
+// refreshConfig 读取热更新的配置, 声音检测参数变化时同步到session的AudioObserver.
+func (r *router) refreshConfig() {
+	if r.reload == nil {
+		return
+	}
+	config := r.reload()
+	observer := r.session.AudioObserver()
+	if observer != nil && (config.AudioLevelThreshold != r.config.AudioLevelThreshold ||
+		config.AudioLevelInterval != r.config.AudioLevelInterval ||
+		config.AudioLevelFilter != r.config.AudioLevelFilter) {
+		observer.Configure(config.AudioLevelThreshold, config.AudioLevelInterval, config.AudioLevelFilter)
+	}
+	r.config = config
+}
+
+// Reload 立即应用热更新的配置, 带宽上限和下行声音大小同步到已有的receiver和downtrack.
+func (r *router) Reload() {
+	r.Lock()
+	defer r.Unlock()
+	r.refreshConfig()
+	for _, recv := range r.receivers {
+		if b := r.bufferFactory.GetBuffer(recv.SSRC()); b != nil {
+			b.SetMaxBitrate(r.config.MaxBandwidth)
+		}
+		if w, ok := recv.(*WebRTCReceiver); ok {
+			dts, _ := w.downTracks.Load().([]*DownTrack)
+			for _, dt := range dts {
+				dt.audioLevelEgress.set(r.config.AudioLevelEgress)
+			}
+		}
+	}
+}
+
 // for download track.
 func (r *router) AddDownTracks(s *Subscriber, recv Receiver) error {
 	r.Lock()
@@ -222,11 +363,12 @@ func (r *router) AddDownTrack(sub *Subscriber, recv Receiver) (*DownTrack, error
 		ClockRate:    codec.ClockRate,
 		Channels:     codec.Channels,
 		SDPFmtpLine:  codec.SDPFmtpLine,
-		RTCPFeedback: []webrtc.RTCPFeedback{{"goog-remb", ""}, {"nack", ""}, {"nack", "pli"}},
+		RTCPFeedback: []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}},
 	}, recv, r.bufferFactory, sub.id, r.config.MaxPacketTrack)
 	if err != nil {
 		return nil, err
 	}
+	downTrack.audioLevelEgress.set(r.config.AudioLevelEgress)
 	// Create webrtc sender for the peer we are sending track to
 	if downTrack.transceiver, err = sub.pc.AddTransceiverFromTrack(downTrack, webrtc.RTPTransceiverInit{
 		Direction: webrtc.RTPTransceiverDirectionSendonly,
@@ -261,7 +403,10 @@ func (r *router) AddDownTrack(sub *Subscriber, recv Receiver) (*DownTrack, error
 func (r *router) deleteReceiver(track string, ssrc uint32) {
 	r.Lock()
 	delete(r.receivers, track)
-	// delete(r.stats, ssrc)
+	if stream, ok := r.stats[ssrc]; ok {
+		stream.Close()
+		delete(r.stats, ssrc)
+	}
 	r.Unlock()
 }
 
@@ -279,59 +424,63 @@ func (r *router) sendRTCP() {
 }
 
 // 基于rtcp的promethues统计.
-//func (r *router) updateStats(stream *stats.Stream) {
-//	calculateLatestMinMaxSenderNtpTime := func(cname string) (minPacketNtpTimeInMillisSinceSenderEpoch uint64, maxPacketNtpTimeInMillisSinceSenderEpoch uint64) {
-//		if len(cname) < 1 {
-//			return
-//		}
-//		r.RLock()
-//		defer r.RUnlock()
-//
-//		for _, s := range r.stats {
-//			if s.GetCName() != cname {
-//				continue
-//			}
-//
-//			clockRate := s.Buffer.GetClockRate()
-//			srrtp, srntp, _ := s.Buffer.GetSenderReportData()
-//			latestTimestamp, _ := s.Buffer.GetLatestTimestamp()
-//
-//			fastForwardTimestampInClockRate := fastForwardTimestampAmount(latestTimestamp, srrtp)
-//			fastForwardTimestampInMillis := (fastForwardTimestampInClockRate * 1000) / clockRate
-//			latestPacketNtpTimeInMillisSinceSenderEpoch := ntpToMillisSinceEpoch(srntp) + uint64(fastForwardTimestampInMillis)
-//
-//			if 0 == minPacketNtpTimeInMillisSinceSenderEpoch || latestPacketNtpTimeInMillisSinceSenderEpoch < minPacketNtpTimeInMillisSinceSenderEpoch {
-//				minPacketNtpTimeInMillisSinceSenderEpoch = latestPacketNtpTimeInMillisSinceSenderEpoch
-//			}
-//			if 0 == maxPacketNtpTimeInMillisSinceSenderEpoch || latestPacketNtpTimeInMillisSinceSenderEpoch > maxPacketNtpTimeInMillisSinceSenderEpoch {
-//				maxPacketNtpTimeInMillisSinceSenderEpoch = latestPacketNtpTimeInMillisSinceSenderEpoch
-//			}
-//		}
-//		return minPacketNtpTimeInMillisSinceSenderEpoch, maxPacketNtpTimeInMillisSinceSenderEpoch
-//	}
-//
-//	setDrift := func(cname string, driftInMillis uint64) {
-//		if len(cname) < 1 {
-//			return
-//		}
-//		r.RLock()
-//		defer r.RUnlock()
-//
-//		for _, s := range r.stats {
-//			if s.GetCName() != cname {
-//				continue
-//			}
-//			s.SetDriftInMillis(driftInMillis)
-//		}
-//	}
-//
-//	cname := stream.GetCName()
-//
-//	minPacketNtpTimeInMillisSinceSenderEpoch, maxPacketNtpTimeInMillisSinceSenderEpoch := calculateLatestMinMaxSenderNtpTime(cname)
-//
-//	driftInMillis := maxPacketNtpTimeInMillisSinceSenderEpoch - minPacketNtpTimeInMillisSinceSenderEpoch
-//
-//	setDrift(cname, driftInMillis)
-//
-//	stream.CalcStats()
-//}
+func (r *router) updateStats(stream *stats.Stream) {
+	calculateLatestMinMaxSenderNtpTime := func(cname string) (minPacketNtpTimeInMillisSinceSenderEpoch uint64, maxPacketNtpTimeInMillisSinceSenderEpoch uint64) {
+		if len(cname) < 1 {
+			return
+		}
+		r.RLock()
+		defer r.RUnlock()
+
+		for _, s := range r.stats {
+			if s.GetCName() != cname {
+				continue
+			}
+
+			clockRate := s.Buffer.GetClockRate()
+			srrtp, srntp, _ := s.Buffer.GetSenderReportData()
+			if clockRate == 0 || srntp == 0 {
+				// 未bind或还没有收到SR.
+				continue
+			}
+			latestTimestamp, _ := s.Buffer.GetLatestTimestamp()
+
+			fastForwardTimestampInClockRate := fastForwardTimestampAmount(latestTimestamp, srrtp)
+			fastForwardTimestampInMillis := uint64(fastForwardTimestampInClockRate) * 1000 / uint64(clockRate)
+			latestPacketNtpTimeInMillisSinceSenderEpoch := ntpToMillisSinceEpoch(srntp) + fastForwardTimestampInMillis
+
+			if 0 == minPacketNtpTimeInMillisSinceSenderEpoch || latestPacketNtpTimeInMillisSinceSenderEpoch < minPacketNtpTimeInMillisSinceSenderEpoch {
+				minPacketNtpTimeInMillisSinceSenderEpoch = latestPacketNtpTimeInMillisSinceSenderEpoch
+			}
+			if 0 == maxPacketNtpTimeInMillisSinceSenderEpoch || latestPacketNtpTimeInMillisSinceSenderEpoch > maxPacketNtpTimeInMillisSinceSenderEpoch {
+				maxPacketNtpTimeInMillisSinceSenderEpoch = latestPacketNtpTimeInMillisSinceSenderEpoch
+			}
+		}
+		return minPacketNtpTimeInMillisSinceSenderEpoch, maxPacketNtpTimeInMillisSinceSenderEpoch
+	}
+
+	setDrift := func(cname string, driftInMillis uint64) {
+		if len(cname) < 1 {
+			return
+		}
+		r.RLock()
+		defer r.RUnlock()
+
+		for _, s := range r.stats {
+			if s.GetCName() != cname {
+				continue
+			}
+			s.SetDriftInMillis(driftInMillis)
+		}
+	}
+
+	cname := stream.GetCName()
+
+	minPacketNtpTimeInMillisSinceSenderEpoch, maxPacketNtpTimeInMillisSinceSenderEpoch := calculateLatestMinMaxSenderNtpTime(cname)
+
+	driftInMillis := maxPacketNtpTimeInMillisSinceSenderEpoch - minPacketNtpTimeInMillisSinceSenderEpoch
+
+	setDrift(cname, driftInMillis)
+
+	stream.CalcStats()
+}
@@ -0,0 +1,197 @@
+package webrtc
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"mediasfu/pkg/events"
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// RTPLeg 普通rtp的一路音频, sip呼叫的rtpengine.Leg实现它.
+// 收到的rtp写入BufferFactory中对应ssrc的Buffer, 同时作为DownTrack的出口.
+type RTPLeg interface {
+	ID() string
+	OnNewSSRC(f func(ssrc uint32))
+	WriteRTP(header *rtp.Header, payload []byte) (int, error)
+	Write(b []byte) (int, error)
+	WriteRTCP(pkts []rtcp.Packet) error
+}
+
+// RTPPeer sip等普通rtp终端在会话中的peer, 没有pc.
+// 上行的Buffer通过router发布给会话中的其他peer; 下行订阅会话中和leg编码相同的音频,
+// 不转码也不混音, 同一时间只转发一路, 正在转发的track结束后换下一路.
+type RTPPeer struct {
+	sync.Mutex
+	id            string
+	session       Session
+	router        Router
+	leg           RTPLeg
+	codec         webrtc.RTPCodecParameters
+	ssrc          uint32
+	bufferFactory *buffer.Factory
+	events        *events.Bus
+	jitter        buffer.JitterOptions
+	downTracks    []*DownTrack
+	closed        atomicBool
+}
+
+// NewRTPPeer joins leg into the session sid as peer id, codec is the negotiated audio codec of the leg.
+// The leg must receive into the BufferFactory of the provider's transport config.
+func NewRTPPeer(provider SessionProvider, sid, id string, leg RTPLeg, codec webrtc.RTPCodecParameters) (*RTPPeer, error) {
+	if id == "" {
+		return nil, ErrNoPeerID
+	}
+	s, cfg := provider.NewSession(sid, false)
+	// 服务端要解析明文音频, 不能加入端到端加密的会话.
+	if s.E2EE() {
+		return nil, ErrE2EEMismatch
+	}
+
+	routerConfig := cfg.Router
+	if cfg.RouterFunc != nil {
+		routerConfig = cfg.RouterFunc()
+	}
+	p := &RTPPeer{
+		id:            id,
+		session:       s,
+		router:        newRouter(id, s, &cfg),
+		leg:           leg,
+		codec:         codec,
+		ssrc:          rand.Uint32(),
+		bufferFactory: cfg.BufferFactory,
+		events:        cfg.Events,
+		jitter: buffer.JitterOptions{
+			MinDelay: time.Duration(routerConfig.JitterMinDelay) * time.Millisecond,
+			MaxDelay: time.Duration(routerConfig.JitterMaxDelay) * time.Millisecond,
+		},
+	}
+	p.router.SetRTCPWriter(leg.WriteRTCP)
+	leg.OnNewSSRC(p.publish)
+
+	s.AddPeer(p)
+	s.Subscribe(p)
+	Logger.Info("rtp peer joined", "session_id", sid, "peer_id", id, "codec", codec.MimeType)
+	return p, nil
+}
+
+// ID -.
+func (p *RTPPeer) ID() string {
+	return p.id
+}
+
+// Session -.
+func (p *RTPPeer) Session() Session {
+	return p.session
+}
+
+// Publisher always returns nil, the peer has no peer connection
+func (p *RTPPeer) Publisher() *Publisher {
+	return nil
+}
+
+// Subscriber always returns nil, the peer has no peer connection
+func (p *RTPPeer) Subscriber() *Subscriber {
+	return nil
+}
+
+// DownTracks returns the down tracks subscribed by the leg, only one of them is unmuted
+func (p *RTPPeer) DownTracks() []*DownTrack {
+	p.Lock()
+	defer p.Unlock()
+	return append([]*DownTrack(nil), p.downTracks...)
+}
+
+// Close leaves the session, the leg is closed by its owner
+func (p *RTPPeer) Close() error {
+	if !p.closed.set(true) {
+		return nil
+	}
+	p.session.RemovePeer(p)
+
+	p.Lock()
+	downTracks := p.downTracks
+	p.downTracks = nil
+	p.Unlock()
+	for _, dt := range downTracks {
+		dt.Mute(true)
+		dt.Close()
+	}
+	p.router.Stop()
+	return nil
+}
+
+// publish 收到新ssrc时发布一个音频track, 对端换ssrc时原来的track随Buffer关闭.
+func (p *RTPPeer) publish(ssrc uint32) {
+	if p.closed.get() {
+		return
+	}
+	trackID := fmt.Sprintf("%s-%d", p.id, ssrc)
+	recv, publish := p.router.AddRTPReceiver(ssrc, p.codec, trackID, p.id)
+	if recv == nil || !publish {
+		return
+	}
+	p.session.Publish(p.router, recv)
+	p.events.Publish(events.TypeTrackPublished, p.session.ID(), p.id, events.TrackData{
+		TrackID:  trackID,
+		StreamID: p.id,
+		Kind:     recv.Kind().String(),
+	})
+}
+
+// subscribe 订阅会话中其他peer发布的音频, 编码和leg协商的不同时跳过.
+func (p *RTPPeer) subscribe(recv Receiver) {
+	codec := recv.Codec()
+	if recv.Kind() != webrtc.RTPCodecTypeAudio || !strings.EqualFold(codec.MimeType, p.codec.MimeType) {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.closed.get() {
+		return
+	}
+	for _, dt := range p.downTracks {
+		if dt.receiver == recv {
+			return
+		}
+	}
+	dt, err := NewDownTrack(codec.RTPCodecCapability, recv, p.bufferFactory, p.id, 0)
+	if err != nil {
+		Logger.Error(err, "Create rtp peer down track err", "peer_id", p.id)
+		return
+	}
+	// 话机的抖动缓冲很小, 按配置在出口平滑发送.
+	if p.jitter.MinDelay > 0 {
+		dt.EnableJitterBuffer(p.jitter)
+	}
+	dt.bindWriter(p.leg, p.ssrc, uint8(p.codec.PayloadType))
+	// 同一时间只转发一路.
+	dt.Mute(len(p.downTracks) > 0)
+	dt.OnCloseHandler(func() {
+		p.removeDownTrack(dt)
+	})
+	p.downTracks = append(p.downTracks, dt)
+	recv.AddDownTrack(dt)
+}
+
+// removeDownTrack 正在转发的track结束时换下一路.
+func (p *RTPPeer) removeDownTrack(dt *DownTrack) {
+	p.Lock()
+	defer p.Unlock()
+	for i, d := range p.downTracks {
+		if d == dt {
+			p.downTracks = append(p.downTracks[:i], p.downTracks[i+1:]...)
+			break
+		}
+	}
+	if dt.Enabled() && len(p.downTracks) > 0 {
+		p.downTracks[0].Mute(false)
+	}
+}
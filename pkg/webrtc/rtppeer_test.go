@@ -0,0 +1,178 @@
+package webrtc
+
+import (
+	log "common/log/newlog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/transport/packetio"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+var (
+	codecPCMU = webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+		PayloadType:        0,
+	}
+	codecPCMA = webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
+		PayloadType:        8,
+	}
+)
+
+// testLeg 代替rtpengine.Leg: receive按ssrc写入BufferFactory, 发出的包放进written, rtcp放进rtcp.
+type testLeg struct {
+	sync.Mutex
+	id        string
+	bf        *buffer.Factory
+	onNewSSRC func(uint32)
+	ssrcs     map[uint32]bool
+	written   chan rtp.Packet
+	rtcp      chan []rtcp.Packet
+}
+
+func newTestLeg(id string, bf *buffer.Factory) *testLeg {
+	return &testLeg{id: id, bf: bf, ssrcs: make(map[uint32]bool), written: make(chan rtp.Packet, 100), rtcp: make(chan []rtcp.Packet, 10)}
+}
+
+func (l *testLeg) ID() string { return l.id }
+
+func (l *testLeg) OnNewSSRC(f func(ssrc uint32)) {
+	l.Lock()
+	l.onNewSSRC = f
+	l.Unlock()
+}
+
+func (l *testLeg) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	l.written <- rtp.Packet{Header: *header, Payload: append([]byte(nil), payload...)}
+	return len(payload), nil
+}
+
+func (l *testLeg) Write(b []byte) (int, error) {
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	return l.WriteRTP(&pkt.Header, pkt.Payload)
+}
+
+func (l *testLeg) WriteRTCP(pkts []rtcp.Packet) error {
+	select {
+	case l.rtcp <- pkts:
+	default:
+	}
+	return nil
+}
+
+func (l *testLeg) receive(t *testing.T, pkt *rtp.Packet) {
+	buff := l.bf.GetOrNew(packetio.RTPBufferPacket, pkt.SSRC).(*buffer.Buffer)
+	l.Lock()
+	found, f := l.ssrcs[pkt.SSRC], l.onNewSSRC
+	l.ssrcs[pkt.SSRC] = true
+	l.Unlock()
+	if !found && f != nil {
+		f(pkt.SSRC)
+	}
+	raw, err := pkt.Marshal()
+	require.NoError(t, err)
+	_, err = buff.Write(raw)
+	require.NoError(t, err)
+}
+
+func TestRTPPeer(t *testing.T) {
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	node := NewSFU(WebRTCTransportConfig{BufferFactory: bf})
+
+	legA, legB, legC := newTestLeg("a", bf), newTestLeg("b", bf), newTestLeg("c", bf)
+	a, err := NewRTPPeer(node, "s1", "a", legA, codecPCMU)
+	require.NoError(t, err)
+	b, err := NewRTPPeer(node, "s1", "b", legB, codecPCMU)
+	require.NoError(t, err)
+	// 不转码, 编码不同的peer收不到.
+	c, err := NewRTPPeer(node, "s1", "c", legC, codecPCMA)
+	require.NoError(t, err)
+	session, _ := node.GetSession("s1")
+	require.NotNil(t, session)
+	assert.Len(t, session.Peers(), 3)
+
+	for i := 0; i < 5; i++ {
+		legA.receive(t, &rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: uint16(100 + i), Timestamp: uint32(160 * i), SSRC: 1234},
+			Payload: []byte{0xff, byte(i)},
+		})
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case pkt := <-legB.written:
+			assert.Equal(t, b.ssrc, pkt.SSRC)
+			assert.Equal(t, uint8(0), pkt.PayloadType)
+			assert.Equal(t, []byte{0xff, byte(i)}, pkt.Payload)
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not forwarded", i)
+		}
+	}
+	assert.Empty(t, legA.written, "own track is not subscribed")
+	assert.Empty(t, legC.written)
+	require.Len(t, b.DownTracks(), 1)
+	_, ok := b.DownTracks()[0].JitterStats()
+	assert.False(t, ok, "jitter buffer is disabled by default")
+
+	require.NoError(t, c.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, a.Close())
+	assert.Empty(t, node.Sessions())
+}
+
+func TestRTPPeer_JitterBuffer(t *testing.T) {
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	node := NewSFU(WebRTCTransportConfig{
+		BufferFactory: bf,
+		Router:        RouterConfig{JitterMinDelay: 20, JitterMaxDelay: 100},
+	})
+	legA, legB := newTestLeg("a", bf), newTestLeg("b", bf)
+	a, err := NewRTPPeer(node, "s1", "a", legA, codecPCMU)
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := NewRTPPeer(node, "s1", "b", legB, codecPCMU)
+	require.NoError(t, err)
+	defer b.Close()
+
+	// 2和3乱序到达, 出口按序列号发送.
+	for _, i := range []int{0, 2, 1, 3, 4} {
+		legA.receive(t, &rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: uint16(100 + i), Timestamp: uint32(160 * i), SSRC: 1234},
+			Payload: []byte{0xff, byte(i)},
+		})
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case pkt := <-legB.written:
+			assert.Equal(t, []byte{0xff, byte(i)}, pkt.Payload)
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not played out", i)
+		}
+	}
+	require.Len(t, b.DownTracks(), 1)
+	stats, ok := b.DownTracks()[0].JitterStats()
+	require.True(t, ok)
+	assert.Equal(t, uint32(5), stats.PacketCount)
+}
+
+func TestRTPPeer_E2EE(t *testing.T) {
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	node := NewSFU(WebRTCTransportConfig{BufferFactory: bf})
+	s, _ := node.NewSession("s1", true)
+	s.AddPeer(NewPeer(node))
+
+	_, err := NewRTPPeer(node, "s1", "a", newTestLeg("a", bf), codecPCMU)
+	assert.ErrorIs(t, err, ErrE2EEMismatch)
+	_, err = NewRTPPeer(node, "s1", "", newTestLeg("b", bf), codecPCMU)
+	assert.ErrorIs(t, err, ErrNoPeerID)
+}
@@ -6,9 +6,28 @@ import (
 )
 
 const (
-	ignoreRetransmission = 100 // Ignore packet retransmission after ignoreRetransmission milliseconds
+	// 同一个包在一个rtt内只重传一次, 重传包还在路上时订阅端可能重复NACK.
+	// 还没有rtt时按defaultIgnoreRetransmission毫秒.
+	defaultIgnoreRetransmission = 100
+	minIgnoreRetransmission     = 20
+	maxIgnoreRetransmission     = 500
 )
 
+// ignoreRetransmission returns the milliseconds a nacked packet is not retransmitted again
+func ignoreRetransmission(rtt time.Duration) uint32 {
+	if rtt <= 0 {
+		return defaultIgnoreRetransmission
+	}
+	ms := uint32(rtt.Milliseconds())
+	switch {
+	case ms < minIgnoreRetransmission:
+		return minIgnoreRetransmission
+	case ms > maxIgnoreRetransmission:
+		return maxIgnoreRetransmission
+	}
+	return ms
+}
+
 type packetMeta struct {
 	// Original sequence number from stream.
 	// The original sequence number is used to find the original
@@ -30,6 +49,10 @@ type packetMeta struct {
 	lastNack uint32
 	// Spatial layer of packet
 	layer uint8
+	// Marker bit after layer dropping, the retransmission must keep it
+	marker bool
+	// parameterSets 重新同步时插入的SPS/PPS, 发布端的buffer里没有, 重传时按缓存的参数集重新发送
+	parameterSets bool
 	// Information that differs depending the codec
 	misc uint32
 }
@@ -61,7 +84,7 @@ func newSequencer(maxTrack int) *sequencer {
 	}
 }
 
-func (n *sequencer) push(sn, offSn uint16, timeStamp uint32, layer uint8, head bool) *packetMeta {
+func (n *sequencer) push(sn, offSn uint16, timeStamp uint32, layer uint8, marker, head bool) *packetMeta {
 	n.Lock()
 	defer n.Unlock()
 	if !n.init {
@@ -95,6 +118,7 @@ func (n *sequencer) push(sn, offSn uint16, timeStamp uint32, layer uint8, head b
 		targetSeqNo: offSn,
 		timestamp:   timeStamp,
 		layer:       layer,
+		marker:      marker,
 	}
 	pm := &n.seq[n.step]
 	n.step++
@@ -104,10 +128,11 @@ func (n *sequencer) push(sn, offSn uint16, timeStamp uint32, layer uint8, head b
 	return pm
 }
 
-func (n *sequencer) getSeqNoPairs(seqNo []uint16) []packetMeta {
+func (n *sequencer) getSeqNoPairs(seqNo []uint16, rtt time.Duration) []packetMeta {
 	n.Lock()
 	meta := make([]packetMeta, 0, 17)
 	refTime := uint32(time.Now().UnixNano()/1e6 - n.startTime)
+	ignore := ignoreRetransmission(rtt)
 	for _, sn := range seqNo {
 		step := n.step - int(n.headSN-sn) - 1
 		if step < 0 {
@@ -118,7 +143,7 @@ func (n *sequencer) getSeqNoPairs(seqNo []uint16) []packetMeta {
 		}
 		seq := &n.seq[step]
 		if seq.targetSeqNo == sn {
-			if seq.lastNack == 0 || refTime-seq.lastNack > ignoreRetransmission {
+			if seq.lastNack == 0 || refTime-seq.lastNack > ignore {
 				seq.lastNack = refTime
 				meta = append(meta, *seq)
 			}
@@ -2,8 +2,11 @@ package webrtc
 
 import (
 	log "common/log/newlog"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
+	"mediasfu/pkg/events"
 	"mediasfu/pkg/webrtc/buffer"
+	"mediasfu/pkg/webrtc/stats"
 	"sync"
 )
 
@@ -36,7 +39,13 @@ import (
 var (
 	// Logger is an implementation of log.Logger. If is not provided - will be turned off.
 	Logger log.Logger = log.GetLogger()
-	packetFactory *sync.Pool // 使用pool
+	// packetFactory 重传时读取发布端buffer用的内存池.
+	packetFactory = &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 1500)
+			return &b
+		},
+	}
 )
 
 // webrtc传输配置
@@ -45,4 +54,129 @@ type WebRTCTransportConfig struct {
 	Setting       webrtc.SettingEngine
 	Router        RouterConfig
 	BufferFactory *buffer.Factory
-}
\ No newline at end of file
+	// Events 发布ice状态和track发布事件, 为空不发布.
+	Events *events.Bus
+	// RouterFunc 返回热更新后的router配置, 之后发布的track使用新的带宽上限和声音检测参数, 为空时使用Router.
+	RouterFunc func() RouterConfig
+}
+
+// SFU 分发单元, 管理本节点的session, 运维接口通过它查询和操作peer.
+type SFU struct {
+	sync.RWMutex
+	config   WebRTCTransportConfig
+	sessions map[string]Session
+}
+
+// NewSFU creates a SFU with the transport config shared by its sessions
+func NewSFU(c WebRTCTransportConfig) *SFU {
+	return &SFU{
+		config:   c,
+		sessions: make(map[string]Session),
+	}
+}
+
+// AddSession 注册session, 同id的session被替换.
+func (s *SFU) AddSession(session Session) {
+	s.Lock()
+	if _, ok := s.sessions[session.ID()]; !ok {
+		stats.Sessions.Inc()
+	}
+	s.sessions[session.ID()] = session
+	s.Unlock()
+}
+
+// NewSession implements SessionProvider, returns the registered session or creates one,
+// the session is removed when its last peer leaves. e2ee only applies to a new session.
+func (s *SFU) NewSession(sid string, e2ee bool) (Session, WebRTCTransportConfig) {
+	s.Lock()
+	defer s.Unlock()
+	if session, ok := s.sessions[sid]; ok {
+		return session, s.config
+	}
+
+	session := NewSession(sid, e2ee, s.config)
+	session.OnClose(func() {
+		s.Lock()
+		// 同id的会话可能已经被替换.
+		if cur, ok := s.sessions[sid]; ok && cur == Session(session) {
+			stats.Sessions.Dec()
+			delete(s.sessions, sid)
+		}
+		s.Unlock()
+	})
+	s.sessions[sid] = session
+	stats.Sessions.Inc()
+	return session, s.config
+}
+
+// RemoveSession 最后一个peer离开时调用.
+func (s *SFU) RemoveSession(sid string) {
+	s.Lock()
+	if _, ok := s.sessions[sid]; ok {
+		stats.Sessions.Dec()
+		delete(s.sessions, sid)
+	}
+	s.Unlock()
+}
+
+// GetSession implements SessionProvider, the session is nil if not registered
+func (s *SFU) GetSession(sid string) (Session, WebRTCTransportConfig) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.sessions[sid], s.config
+}
+
+// Sessions returns all sessions of this node
+func (s *SFU) Sessions() []Session {
+	s.RLock()
+	defer s.RUnlock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Reload 把热更新的router配置推送到已有会话, 不必等新的track发布.
+// 会话的AudioObserver也用于rtp leg, 一起更新.
+func (s *SFU) Reload() {
+	if s.config.RouterFunc == nil {
+		return
+	}
+	config := s.config.RouterFunc()
+	for _, session := range s.Sessions() {
+		if observer := session.AudioObserver(); observer != nil {
+			observer.Configure(config.AudioLevelThreshold, config.AudioLevelInterval, config.AudioLevelFilter)
+		}
+	}
+	for _, router := range s.routers() {
+		router.Reload()
+	}
+}
+
+// RequestKeyFrames 向所有视频发布者请求关键帧, 按keyframe_interval周期调用.
+func (s *SFU) RequestKeyFrames() {
+	for _, router := range s.routers() {
+		for _, recv := range router.Receivers() {
+			if recv.Kind() != webrtc.RTPCodecTypeVideo {
+				continue
+			}
+			recv.SendRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: recv.SSRC()}})
+		}
+	}
+}
+
+// routers 所有会话中发布者的Router, rtp peer和webrtc Publisher.
+func (s *SFU) routers() []Router {
+	var routers []Router
+	for _, session := range s.Sessions() {
+		for _, peer := range session.Peers() {
+			if p, ok := peer.(*RTPPeer); ok {
+				routers = append(routers, p.router)
+			} else if pub := peer.Publisher(); pub != nil {
+				routers = append(routers, pub.GetRouter())
+			}
+		}
+	}
+	return routers
+}
@@ -0,0 +1,100 @@
+package webrtc
+
+import (
+	log "common/log/newlog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+func TestSFU_Reload(t *testing.T) {
+	var mu sync.Mutex
+	config := RouterConfig{MaxBandwidth: 500, AudioLevelThreshold: 40, AudioLevelInterval: 1000, AudioLevelFilter: 20}
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	node := NewSFU(WebRTCTransportConfig{
+		BufferFactory: bf,
+		RouterFunc: func() RouterConfig {
+			mu.Lock()
+			defer mu.Unlock()
+			return config
+		},
+	})
+	// 没有会话时不出错.
+	node.Reload()
+
+	legA := newTestLeg("a", bf)
+	a, err := NewRTPPeer(node, "s1", "a", legA, codecPCMU)
+	require.NoError(t, err)
+	defer a.Close()
+	legA.receive(t, &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1, SSRC: 1234}, Payload: []byte{0xff}})
+	require.Len(t, a.router.Receivers(), 1)
+
+	session, _ := node.GetSession("s1")
+	observer := session.AudioObserver()
+	observer.RLock()
+	assert.Equal(t, uint8(40), observer.threshold)
+	assert.Equal(t, 10, observer.expected)
+	observer.RUnlock()
+
+	// 不需要新的track发布, 已有的会话和router直接生效.
+	mu.Lock()
+	config = RouterConfig{MaxBandwidth: 1500, AudioLevelThreshold: 60, AudioLevelInterval: 1000, AudioLevelFilter: 40, AudioLevelEgress: true}
+	mu.Unlock()
+	node.Reload()
+
+	observer.RLock()
+	assert.Equal(t, uint8(60), observer.threshold)
+	assert.Equal(t, 20, observer.expected)
+	observer.RUnlock()
+	r := a.router.(*router)
+	r.RLock()
+	assert.Equal(t, uint64(1500), r.config.MaxBandwidth)
+	assert.True(t, r.config.AudioLevelEgress)
+	r.RUnlock()
+}
+
+func TestSFU_RequestKeyFrames(t *testing.T) {
+	bf := buffer.NewBufferFactory(100, log.GetLogger())
+	node := NewSFU(WebRTCTransportConfig{BufferFactory: bf})
+	// 没有会话时不出错.
+	node.RequestKeyFrames()
+
+	legA := newTestLeg("a", bf)
+	a, err := NewRTPPeer(node, "s1", "a", legA, codecPCMU)
+	require.NoError(t, err)
+	defer a.Close()
+	legV := newTestLeg("v", bf)
+	v, err := NewRTPPeer(node, "s1", "v", legV, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        96,
+	})
+	require.NoError(t, err)
+	defer v.Close()
+	legA.receive(t, &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1, SSRC: 1234}, Payload: []byte{0xff}})
+	legV.receive(t, &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1, SSRC: 5678}, Payload: []byte{0x10, 0x00}})
+
+	// 只向视频发布者请求.
+	node.RequestKeyFrames()
+	select {
+	case pkts := <-legV.rtcp:
+		require.Len(t, pkts, 1)
+		pli, ok := pkts[0].(*rtcp.PictureLossIndication)
+		require.True(t, ok)
+		assert.Equal(t, uint32(5678), pli.MediaSSRC)
+	case <-time.After(time.Second):
+		t.Fatal("no pli sent")
+	}
+	select {
+	case pkts := <-legA.rtcp:
+		t.Fatalf("unexpected rtcp to audio publisher: %v", pkts)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
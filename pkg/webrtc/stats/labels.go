@@ -0,0 +1,50 @@
+package stats
+
+import "sync"
+
+// OtherSession 超出MaxSessionLabels的会话共用的session标签.
+const OtherSession = "other"
+
+// MaxSessionLabels 同时带独立session标签的会话数上限, 限制prometheus时序数量.
+var MaxSessionLabels = 64
+
+var sessionLabels = struct {
+	sync.Mutex
+	streams map[string]int // session -> 使用该标签的stream数
+}{streams: make(map[string]int)}
+
+// acquireSessionLabel 返回stream使用的session标签, 超出上限时返回OtherSession.
+func acquireSessionLabel(session string) string {
+	if session == OtherSession {
+		return OtherSession
+	}
+
+	sessionLabels.Lock()
+	defer sessionLabels.Unlock()
+
+	if _, ok := sessionLabels.streams[session]; !ok && len(sessionLabels.streams) >= MaxSessionLabels {
+		return OtherSession
+	}
+	sessionLabels.streams[session]++
+	return session
+}
+
+// releaseSessionLabel 会话的最后一个stream关闭时删除它的时序, 释放名额.
+func releaseSessionLabel(session string) {
+	if session == OtherSession {
+		return
+	}
+
+	sessionLabels.Lock()
+	defer sessionLabels.Unlock()
+
+	if n := sessionLabels.streams[session]; n > 1 {
+		sessionLabels.streams[session] = n - 1
+		return
+	}
+	delete(sessionLabels.streams, session)
+	for _, vec := range streamVecs {
+		vec.DeleteLabelValues(session, "audio")
+		vec.DeleteLabelValues(session, "video")
+	}
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionLabel(t *testing.T) {
+	defer func(max int) { MaxSessionLabels = max }(MaxSessionLabels)
+	MaxSessionLabels = 2
+
+	s1a := NewStream(nil, "s1", "audio")
+	s1v := NewStream(nil, "s1", "video")
+	s2 := NewStream(nil, "s2", "audio")
+	// 超出上限的会话共用other标签.
+	s3 := NewStream(nil, "s3", "audio")
+	assert.Equal(t, "s1", s1a.session)
+	assert.Equal(t, "s1", s1v.session)
+	assert.Equal(t, "s2", s2.session)
+	assert.Equal(t, OtherSession, s3.session)
+	assert.Equal(t, OtherSession, NewStream(nil, OtherSession, "audio").session)
+
+	for _, s := range []*Stream{s1a, s1v, s2} {
+		expectedCount.WithLabelValues(s.session, s.kind).Add(1)
+	}
+	assert.Equal(t, 3, testutil.CollectAndCount(expectedCount))
+
+	// 会话还有stream时保留时序和名额.
+	s1a.Close()
+	s1a.Close()
+	assert.Equal(t, 3, testutil.CollectAndCount(expectedCount))
+	assert.Equal(t, OtherSession, NewStream(nil, "s4", "audio").session)
+
+	// 最后一个stream关闭后删除时序, 释放名额.
+	s1v.Close()
+	assert.Equal(t, 1, testutil.CollectAndCount(expectedCount))
+	s4 := NewStream(nil, "s4", "audio")
+	assert.Equal(t, "s4", s4.session)
+
+	s3.Close()
+	s2.Close()
+	s4.Close()
+	sessionLabels.Lock()
+	assert.Empty(t, sessionLabels.streams)
+	sessionLabels.Unlock()
+	assert.Equal(t, 0, testutil.CollectAndCount(expectedCount))
+}
@@ -8,49 +8,96 @@ import (
 	"sync/atomic"
 )
 
+// 流统计按session和kind打标签, 带session标签的会话数受MaxSessionLabels限制.
+var labelNames = []string{"session", "kind"}
+
 var (
 	driftBuckets = []float64{5, 10, 20, 40, 80, 160, math.Inf(+1)}
 
-	drift = prometheus.NewHistogram(prometheus.HistogramOpts{
+	drift = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Subsystem: "rtp",
 		Name:      "drift_millis",
 		Buckets:   driftBuckets,
-	})
+	}, labelNames)
 
-	expectedCount = prometheus.NewCounter(prometheus.CounterOpts{
+	expectedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Subsystem: "rtp",
 		Name:      "expected",
-	})
+	}, labelNames)
 
-	receivedCount = prometheus.NewCounter(prometheus.CounterOpts{
+	receivedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Subsystem: "rtp",
 		Name:      "received",
-	})
+	}, labelNames)
 
-	packetCount = prometheus.NewCounter(prometheus.CounterOpts{
+	packetCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Subsystem: "rtp",
 		Name:      "packets",
-	})
+	}, labelNames)
 
-	totalBytes = prometheus.NewCounter(prometheus.CounterOpts{
+	totalBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Subsystem: "rtp",
 		Name:      "bytes",
-	})
+	}, labelNames)
 
-	expectedMinusReceived = prometheus.NewSummary(prometheus.SummaryOpts{
+	expectedMinusReceived = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Subsystem: "rtp",
 		Name:      "expected_minus_received",
-	})
+	}, labelNames)
 
-	lostRate = prometheus.NewSummary(prometheus.SummaryOpts{
+	lostRate = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Subsystem: "rtp",
 		Name:      "lost_rate",
-	})
+	}, labelNames)
 
-	jitter = prometheus.NewSummary(prometheus.SummaryOpts{
+	jitter = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Subsystem: "rtp",
 		Name:      "jitter",
-	})
+	}, labelNames)
+
+	nacksSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "rtp",
+		Name:      "nacks_sent",
+		Help:      "Lost packets NACKed, retries included",
+	}, labelNames)
+
+	nacksRecovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "rtp",
+		Name:      "nacks_recovered",
+		Help:      "NACKed packets received later",
+	}, labelNames)
+
+	nacksGivenUp = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "rtp",
+		Name:      "nacks_given_up",
+		Help:      "Lost packets no longer NACKed, a PLI is sent instead",
+	}, labelNames)
+
+	reordered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "rtp",
+		Name:      "reordered",
+		Help:      "Packets arrived after a later packet",
+	}, labelNames)
+
+	duplicates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "rtp",
+		Name:      "duplicates",
+		Help:      "Duplicate packets dropped",
+	}, labelNames)
+
+	// 会话结束后删除这些时序.
+	streamVecs = []interface {
+		DeleteLabelValues(lvs ...string) bool
+	}{drift, expectedCount, receivedCount, packetCount, totalBytes, expectedMinusReceived, lostRate, jitter,
+		nacksSent, nacksRecovered, nacksGivenUp, reordered, duplicates}
+
+	// PeerMOS 每次质量采样的peer评分, direction为publish或subscribe.
+	PeerMOS = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "sfu",
+		Name:      "peer_mos",
+		Help:      "Sampled MOS of peers",
+		Buckets:   []float64{1.5, 2, 2.5, 3, 3.5, 4, 4.5},
+	}, []string{"direction"})
 
 	Sessions = prometheus.NewGauge(prometheus.GaugeOpts{
 		Subsystem: "sfu",
@@ -75,6 +122,18 @@ var (
 		Name:      "video_tracks",
 		Help:      "Current number of video tracks",
 	})
+
+	bufferBytes = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Subsystem: "sfu",
+		Name:      "buffer_bytes",
+		Help:      "Bytes of packet buckets held by receive buffers",
+	}, func() float64 { return float64(buffer.MemoryInUse()) })
+
+	bufferRejected = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Subsystem: "sfu",
+		Name:      "buffer_rejected",
+		Help:      "Streams rejected by the buffer memory cap",
+	}, func() float64 { return float64(buffer.RejectedStreams()) })
 )
 
 func InitStats() {
@@ -86,15 +145,27 @@ func InitStats() {
 	prometheus.MustRegister(expectedMinusReceived)
 	prometheus.MustRegister(lostRate)
 	prometheus.MustRegister(jitter)
+	prometheus.MustRegister(nacksSent)
+	prometheus.MustRegister(nacksRecovered)
+	prometheus.MustRegister(nacksGivenUp)
+	prometheus.MustRegister(reordered)
+	prometheus.MustRegister(duplicates)
+	prometheus.MustRegister(PeerMOS)
 	prometheus.MustRegister(Sessions)
+	prometheus.MustRegister(Peers)
 	prometheus.MustRegister(AudioTracks)
 	prometheus.MustRegister(VideoTracks)
+	prometheus.MustRegister(bufferBytes)
+	prometheus.MustRegister(bufferRejected)
 }
 
 // Stream contains buffer statistics: used by route.
 type Stream struct {
 	sync.RWMutex
 	Buffer        *buffer.Buffer
+	session       string // session标签, 超出上限时为OtherSession
+	kind          string
+	closeOnce     sync.Once
 	cname         string
 	driftInMillis uint64
 	hasStats      bool
@@ -102,14 +173,23 @@ type Stream struct {
 	diffStats     buffer.Stats
 }
 
-// NewStream constructs a new Stream
-func NewStream(buffer *buffer.Buffer) *Stream {
+// NewStream constructs a new Stream of session, kind is audio or video
+func NewStream(buffer *buffer.Buffer, session, kind string) *Stream {
 	s := &Stream{
-		Buffer: buffer,
+		Buffer:  buffer,
+		session: acquireSessionLabel(session),
+		kind:    kind,
 	}
 	return s
 }
 
+// Close releases the session label, the series of the session are deleted with its last stream
+func (s *Stream) Close() {
+	s.closeOnce.Do(func() {
+		releaseSessionLabel(s.session)
+	})
+}
+
 // GetCName returns the cname for a given stream
 func (s *Stream) GetCName() string {
 	s.RLock()
@@ -144,6 +224,11 @@ func (s *Stream) UpdateStats(stats buffer.Stats) (hasDiff bool, diffStats buffer
 		s.diffStats.LastReceived = stats.LastReceived - s.lastStats.LastReceived
 		s.diffStats.PacketCount = stats.PacketCount - s.lastStats.PacketCount
 		s.diffStats.TotalByte = stats.TotalByte - s.lastStats.TotalByte
+		s.diffStats.NacksSent = stats.NacksSent - s.lastStats.NacksSent
+		s.diffStats.NacksRecovered = stats.NacksRecovered - s.lastStats.NacksRecovered
+		s.diffStats.NacksGivenUp = stats.NacksGivenUp - s.lastStats.NacksGivenUp
+		s.diffStats.Reordered = stats.Reordered - s.lastStats.Reordered
+		s.diffStats.Duplicates = stats.Duplicates - s.lastStats.Duplicates
 		hadStats = true
 	}
 
@@ -159,15 +244,20 @@ func (s *Stream) CalcStats() {
 
 	hadStats, diffStats := s.UpdateStats(bufferStats)
 
-	drift.Observe(float64(driftInMillis))
+	drift.WithLabelValues(s.session, s.kind).Observe(float64(driftInMillis))
 	if hadStats {
-		expectedCount.Add(float64(diffStats.LastExpected))
-		receivedCount.Add(float64(diffStats.LastReceived))
-		packetCount.Add(float64(diffStats.PacketCount))
-		totalBytes.Add(float64(diffStats.TotalByte))
+		expectedCount.WithLabelValues(s.session, s.kind).Add(float64(diffStats.LastExpected))
+		receivedCount.WithLabelValues(s.session, s.kind).Add(float64(diffStats.LastReceived))
+		packetCount.WithLabelValues(s.session, s.kind).Add(float64(diffStats.PacketCount))
+		totalBytes.WithLabelValues(s.session, s.kind).Add(float64(diffStats.TotalByte))
+		nacksSent.WithLabelValues(s.session, s.kind).Add(float64(diffStats.NacksSent))
+		nacksRecovered.WithLabelValues(s.session, s.kind).Add(float64(diffStats.NacksRecovered))
+		nacksGivenUp.WithLabelValues(s.session, s.kind).Add(float64(diffStats.NacksGivenUp))
+		reordered.WithLabelValues(s.session, s.kind).Add(float64(diffStats.Reordered))
+		duplicates.WithLabelValues(s.session, s.kind).Add(float64(diffStats.Duplicates))
 	}
 
-	expectedMinusReceived.Observe(float64(bufferStats.LastExpected - bufferStats.LastReceived))
-	lostRate.Observe(float64(bufferStats.LostRate))
-	jitter.Observe(bufferStats.Jitter)
+	expectedMinusReceived.WithLabelValues(s.session, s.kind).Observe(float64(bufferStats.LastExpected - bufferStats.LastReceived))
+	lostRate.WithLabelValues(s.session, s.kind).Observe(float64(bufferStats.LostRate))
+	jitter.WithLabelValues(s.session, s.kind).Observe(bufferStats.Jitter)
 }
@@ -160,6 +160,11 @@ func (s *Subscriber) SetRemoteDescription(desc webrtc.SessionDescription) error
 	return nil
 }
 
+// ICEConnectionState -.
+func (s *Subscriber) ICEConnectionState() webrtc.ICEConnectionState {
+	return s.pc.ICEConnectionState()
+}
+
 func (s *Subscriber) DownTracks() []*DownTrack {
 	s.RLock()
 	defer s.RUnlock()
@@ -206,6 +211,9 @@ func (s *Subscriber) downTracksReports() {
 				if sr := dt.CreateSenderReport(); sr != nil {
 					r = append(r, sr)
 				}
+				if xr := dt.CreateExtendedReport(); xr != nil {
+					r = append(r, xr)
+				}
 				sd = append(sd, dt.CreateSourceDescriptionChunks()...)
 			}
 		}
@@ -0,0 +1,191 @@
+package webrtc
+
+import (
+	"sync/atomic"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// svc: 同一个rtp流里包含多个空间层(分辨率)和时间层(帧率), 和simulcast不同, 只有一个ssrc.
+// DownTrack按订阅端的目标层丢弃更高的层:
+// 降层在新的一帧开始时生效;
+// 升空间层要等关键帧, 等待时请求PLI, DownTrack按keyFrameRequestInterval限速;
+// 升时间层要等U(switching up point)标记的帧, frame marking中是B(base layer sync).
+// 端到端加密的payload无法解析, 按frame marking中的TID/LID丢层.
+// AV1通过Dependency Descriptor描述层, 按目标层选择decode target, 转发这个decode target需要的帧.
+
+const (
+	// vp9最多8个空间层和8个时间层, 默认转发所有层.
+	maxSpatialLayer  = 7
+	maxTemporalLayer = 7
+)
+
+type svcLayers struct {
+	targetSpatial  int32 // atomic
+	targetTemporal int32 // atomic
+
+	// 只在写包的goroutine中访问.
+	currentSpatial  int32
+	currentTemporal int32
+	// 正在转发的帧的时间戳和是否丢弃, 一帧的所有包按同一结果处理.
+	frameTS   uint32
+	frameDrop bool
+	started   bool
+
+	// AV1 Dependency Descriptor的模板结构、活跃的decode target和正在转发的decode target.
+	structure    *buffer.FrameDependencyStructure
+	active       uint32
+	decodeTarget int
+}
+
+func newSVCLayers() *svcLayers {
+	return &svcLayers{
+		targetSpatial:   maxSpatialLayer,
+		targetTemporal:  maxTemporalLayer,
+		currentSpatial:  maxSpatialLayer,
+		currentTemporal: maxTemporalLayer,
+		decodeTarget:    -1,
+	}
+}
+
+func (s *svcLayers) setTarget(spatial, temporal int32) {
+	atomic.StoreInt32(&s.targetSpatial, clampLayer(spatial, maxSpatialLayer))
+	atomic.StoreInt32(&s.targetTemporal, clampLayer(temporal, maxTemporalLayer))
+}
+
+func (s *svcLayers) target() (spatial, temporal int32) {
+	return atomic.LoadInt32(&s.targetSpatial), atomic.LoadInt32(&s.targetTemporal)
+}
+
+// reset 重新同步后(关键帧)直接切到目标层.
+func (s *svcLayers) reset() {
+	s.currentSpatial, s.currentTemporal = s.target()
+	s.started = false
+}
+
+// svcPacket 一个包的层信息, 来自vp9 payload descriptor或frame marking.
+type svcPacket struct {
+	sid, tid uint8
+	// switchUp 这一帧之后不再依赖之前的高时间层帧, 可以升到它的时间层
+	switchUp bool
+	keyframe bool
+	endFrame bool
+}
+
+// vp9 decides if the packet is forwarded, marker is true if the packet ends the forwarded part of the picture.
+// keyframe is true if a spatial layer up switch is waiting for a keyframe.
+func (s *svcLayers) vp9(ts uint32, pld buffer.VP9, marker bool) (forward, setMarker, keyframe bool) {
+	if !pld.LayerIndices {
+		return true, marker, false
+	}
+	return s.layers(ts, svcPacket{sid: pld.SID, tid: pld.TID, switchUp: pld.U, keyframe: pld.IsKeyFrame, endFrame: pld.EndFrame}, marker)
+}
+
+// frameMarking is the same as vp9 with the layers of frame marking, the payload may be encrypted.
+func (s *svcLayers) frameMarking(ts uint32, fm *buffer.FrameMarking, marker bool) (forward, setMarker, keyframe bool) {
+	if !fm.Scalable {
+		return true, marker, false
+	}
+	return s.layers(ts, svcPacket{sid: fm.LID, tid: fm.TID, switchUp: fm.BaseLayerSync, keyframe: fm.Independent && fm.LID == 0, endFrame: fm.EndOfFrame}, marker)
+}
+
+func (s *svcLayers) layers(ts uint32, pkt svcPacket, marker bool) (forward, setMarker, keyframe bool) {
+	targetSpatial, targetTemporal := s.target()
+	// 一帧(同一时间戳)的第一个包决定这一帧的层切换.
+	if !s.started || ts != s.frameTS {
+		s.started = true
+		s.frameTS = ts
+		s.switchLayers(pkt, targetSpatial, targetTemporal)
+		s.frameDrop = int32(pkt.tid) > s.currentTemporal
+		keyframe = targetSpatial > s.currentSpatial
+	}
+
+	if s.frameDrop || int32(pkt.sid) > s.currentSpatial {
+		return false, false, keyframe
+	}
+	// 丢弃了更高的空间层时, 转发的最高层的最后一个包要带marker.
+	return true, marker || (pkt.endFrame && int32(pkt.sid) == s.currentSpatial), keyframe
+}
+
+func (s *svcLayers) switchLayers(pkt svcPacket, targetSpatial, targetTemporal int32) {
+	switch {
+	case targetSpatial < s.currentSpatial:
+		s.currentSpatial = targetSpatial
+	case targetSpatial > s.currentSpatial && pkt.keyframe:
+		s.currentSpatial = targetSpatial
+	}
+
+	switch {
+	case targetTemporal < s.currentTemporal:
+		s.currentTemporal = targetTemporal
+	case targetTemporal > s.currentTemporal && pkt.switchUp && int32(pkt.tid) > s.currentTemporal:
+		s.currentTemporal = int32(pkt.tid)
+		if s.currentTemporal > targetTemporal {
+			s.currentTemporal = targetTemporal
+		}
+	case targetTemporal > s.currentTemporal && pkt.keyframe:
+		s.currentTemporal = targetTemporal
+	}
+}
+
+// dd decides if the AV1 packet is forwarded by its dependency descriptor, the results are the same as vp9.
+func (s *svcLayers) dd(dd *buffer.DependencyDescriptor, marker bool) (forward, setMarker, keyframe bool) {
+	if dd.Structure != nil {
+		// 新的模板结构, decode target的编号可能变化.
+		s.structure = dd.Structure
+		s.active = dd.ActiveDecodeTargets
+		s.decodeTarget = -1
+	}
+	if dd.ActiveDecodeTargetsPresent {
+		s.active = dd.ActiveDecodeTargets
+	}
+	st := s.structure
+	if st == nil {
+		return true, marker, false
+	}
+
+	if dd.StartOfFrame {
+		targetSpatial, targetTemporal := s.target()
+		want := st.DecodeTarget(int(targetSpatial), int(targetTemporal), s.active)
+		if want < 0 {
+			// 目标层低于最低的decode target时转发最低的.
+			want = st.DecodeTarget(maxSpatialLayer, 0, s.active)
+		}
+		switch {
+		case want < 0 || want == s.decodeTarget:
+		case s.decodeTarget < 0 || s.decodeTarget >= len(dd.DTIs) || s.active&(1<<uint(s.decodeTarget)) == 0:
+			// 还没有选择或者当前的decode target不再活跃.
+			s.decodeTarget = want
+		case lowerDecodeTarget(st, want, s.decodeTarget):
+			s.decodeTarget = want
+		case want < len(dd.DTIs) && dd.DTIs[want] == buffer.DTISwitch:
+			s.decodeTarget = want
+		default:
+			// 升空间层等关键帧, 升时间层等switch帧.
+			keyframe = st.DecodeTargetSpatialID[want] > st.DecodeTargetSpatialID[s.decodeTarget]
+		}
+	}
+
+	if s.decodeTarget < 0 || s.decodeTarget >= len(dd.DTIs) {
+		return true, marker, keyframe
+	}
+	if dd.DTIs[s.decodeTarget] == buffer.DTINotPresent {
+		return false, false, keyframe
+	}
+	return true, marker || (dd.EndOfFrame && dd.SpatialID == st.DecodeTargetSpatialID[s.decodeTarget]), keyframe
+}
+
+func lowerDecodeTarget(st *buffer.FrameDependencyStructure, a, b int) bool {
+	sa, sb := st.DecodeTargetSpatialID[a], st.DecodeTargetSpatialID[b]
+	return sa < sb || (sa == sb && st.DecodeTargetTemporalID[a] < st.DecodeTargetTemporalID[b])
+}
+
+func clampLayer(layer, max int32) int32 {
+	if layer < 0 {
+		return 0
+	}
+	if layer > max {
+		return max
+	}
+	return layer
+}
@@ -0,0 +1,176 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"mediasfu/pkg/webrtc/buffer"
+)
+
+// svcResult 一个包的转发结果.
+type svcResult struct {
+	forward, marker, keyframe bool
+}
+
+func layersOf(s *svcLayers, ts uint32, pkt svcPacket, marker bool) svcResult {
+	forward, setMarker, keyframe := s.layers(ts, pkt, marker)
+	return svcResult{forward, setMarker, keyframe}
+}
+
+func TestSVCLayers_FrameBoundary(t *testing.T) {
+	s := newSVCLayers()
+	assert.Equal(t, svcResult{forward: true}, layersOf(s, 1, svcPacket{sid: 0}, false))
+	// 帧中间降层, 这一帧的其他包仍然按帧开始时的层转发.
+	s.setTarget(0, maxTemporalLayer)
+	assert.Equal(t, svcResult{forward: true, marker: true}, layersOf(s, 1, svcPacket{sid: 1, endFrame: true}, true))
+
+	// 下一帧开始生效.
+	assert.Equal(t, svcResult{forward: true, marker: true}, layersOf(s, 2, svcPacket{sid: 0, endFrame: true}, false))
+	assert.Equal(t, svcResult{}, layersOf(s, 2, svcPacket{sid: 1, endFrame: true}, true))
+}
+
+func TestSVCLayers_TemporalDrop(t *testing.T) {
+	s := newSVCLayers()
+	s.setTarget(maxSpatialLayer, 0)
+	assert.True(t, layersOf(s, 1, svcPacket{tid: 0}, true).forward)
+	assert.False(t, layersOf(s, 2, svcPacket{tid: 1}, true).forward)
+	// 一帧的所有包按同一结果处理.
+	assert.False(t, layersOf(s, 2, svcPacket{tid: 1}, true).forward)
+	assert.True(t, layersOf(s, 3, svcPacket{tid: 0}, true).forward)
+
+	// 升时间层要等switching up point, 不需要关键帧.
+	s.setTarget(maxSpatialLayer, 1)
+	assert.Equal(t, svcResult{}, layersOf(s, 4, svcPacket{tid: 1}, true))
+	assert.Equal(t, svcResult{forward: true, marker: true}, layersOf(s, 5, svcPacket{tid: 1, switchUp: true}, true))
+	assert.True(t, layersOf(s, 6, svcPacket{tid: 1}, true).forward)
+	// 不超过目标层.
+	assert.False(t, layersOf(s, 7, svcPacket{tid: 2, switchUp: true}, true).forward)
+}
+
+func TestSVCLayers_Marker(t *testing.T) {
+	s := newSVCLayers()
+	s.setTarget(1, maxTemporalLayer)
+	// 丢弃了空间层2, 空间层1的最后一个包补上marker.
+	assert.Equal(t, svcResult{forward: true}, layersOf(s, 1, svcPacket{sid: 0, keyframe: true, endFrame: true}, false))
+	assert.Equal(t, svcResult{forward: true}, layersOf(s, 1, svcPacket{sid: 1}, false))
+	assert.Equal(t, svcResult{forward: true, marker: true}, layersOf(s, 1, svcPacket{sid: 1, endFrame: true}, false))
+	assert.Equal(t, svcResult{}, layersOf(s, 1, svcPacket{sid: 2, endFrame: true}, true))
+}
+
+func TestSVCLayers_SpatialUpSwitch(t *testing.T) {
+	s := newSVCLayers()
+	s.setTarget(0, maxTemporalLayer)
+	assert.True(t, layersOf(s, 1, svcPacket{sid: 0}, false).forward)
+
+	// 升空间层等关键帧, 等待期间每一帧请求一次.
+	s.setTarget(1, maxTemporalLayer)
+	assert.Equal(t, svcResult{forward: true, marker: true, keyframe: true}, layersOf(s, 2, svcPacket{sid: 0, endFrame: true}, false))
+	assert.Equal(t, svcResult{}, layersOf(s, 2, svcPacket{sid: 1, endFrame: true}, true))
+	assert.Equal(t, svcResult{forward: true, marker: true, keyframe: true}, layersOf(s, 3, svcPacket{sid: 0, endFrame: true}, false))
+
+	assert.Equal(t, svcResult{forward: true}, layersOf(s, 4, svcPacket{sid: 0, keyframe: true, endFrame: true}, false))
+	assert.Equal(t, svcResult{forward: true, marker: true}, layersOf(s, 4, svcPacket{sid: 1, endFrame: true}, true))
+
+	// reset后直接使用目标层.
+	s.setTarget(2, maxTemporalLayer)
+	s.reset()
+	assert.Equal(t, svcResult{forward: true, marker: true}, layersOf(s, 5, svcPacket{sid: 2, endFrame: true}, true))
+}
+
+func TestSVCLayers_Descriptors(t *testing.T) {
+	s := newSVCLayers()
+	s.setTarget(0, maxTemporalLayer)
+
+	// 没有层信息时按原样转发.
+	forward, marker, keyframe := s.vp9(1, buffer.VP9{}, true)
+	assert.Equal(t, svcResult{true, true, false}, svcResult{forward, marker, keyframe})
+	forward, marker, keyframe = s.frameMarking(1, &buffer.FrameMarking{}, false)
+	assert.Equal(t, svcResult{true, false, false}, svcResult{forward, marker, keyframe})
+
+	forward, _, _ = s.vp9(2, buffer.VP9{LayerIndices: true, SID: 1}, false)
+	assert.False(t, forward)
+	// frame marking中空间层0的独立帧是关键帧.
+	s.setTarget(1, maxTemporalLayer)
+	forward, _, keyframe = s.frameMarking(3, &buffer.FrameMarking{Scalable: true, LID: 1, Independent: true}, false)
+	assert.False(t, forward)
+	assert.True(t, keyframe)
+	forward, _, keyframe = s.frameMarking(4, &buffer.FrameMarking{Scalable: true, LID: 0, Independent: true}, false)
+	assert.True(t, forward)
+	assert.False(t, keyframe)
+	forward, marker, _ = s.frameMarking(4, &buffer.FrameMarking{Scalable: true, LID: 1, EndOfFrame: true}, false)
+	assert.True(t, forward)
+	assert.True(t, marker)
+}
+
+func TestSVCLayers_DependencyDescriptor(t *testing.T) {
+	// L2T2: dt0 s0t0, dt1 s0t1, dt2 s1t0, dt3 s1t1.
+	st := &buffer.FrameDependencyStructure{
+		DecodeTargets:          4,
+		DecodeTargetSpatialID:  []int{0, 0, 1, 1},
+		DecodeTargetTemporalID: []int{0, 1, 0, 1},
+	}
+	const (
+		np = buffer.DTINotPresent
+		sw = buffer.DTISwitch
+		rq = buffer.DTIRequired
+		dc = buffer.DTIDiscardable
+	)
+	ddOf := func(s *svcLayers, dd buffer.DependencyDescriptor, marker bool) svcResult {
+		forward, setMarker, keyframe := s.dd(&dd, marker)
+		return svcResult{forward, setMarker, keyframe}
+	}
+
+	s := newSVCLayers()
+	// 没有模板结构时按原样转发.
+	assert.Equal(t, svcResult{forward: true}, ddOf(s, buffer.DependencyDescriptor{StartOfFrame: true}, false))
+
+	s.setTarget(0, 0)
+	// 关键帧带模板结构, 选择dt0.
+	assert.Equal(t, svcResult{forward: true}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, Structure: st, ActiveDecodeTargets: 0xf, DTIs: []int{sw, sw, sw, sw},
+	}, false))
+	assert.Equal(t, 0, s.decodeTarget)
+	// 时间层1的帧dt0不需要.
+	assert.Equal(t, svcResult{}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, EndOfFrame: true, TemporalID: 1, DTIs: []int{np, dc, np, dc},
+	}, true))
+	// 空间层1的帧丢弃, 空间层0的最后一个包补上marker.
+	assert.Equal(t, svcResult{forward: true, marker: true}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, EndOfFrame: true, DTIs: []int{rq, rq, rq, rq},
+	}, false))
+	assert.Equal(t, svcResult{}, ddOf(s, buffer.DependencyDescriptor{
+		EndOfFrame: true, SpatialID: 1, DTIs: []int{np, np, rq, rq},
+	}, true))
+
+	// 升时间层等switch帧.
+	s.setTarget(0, 1)
+	assert.Equal(t, svcResult{forward: true}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, DTIs: []int{rq, rq, rq, rq},
+	}, false))
+	assert.Equal(t, 0, s.decodeTarget)
+	assert.Equal(t, svcResult{forward: true}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, DTIs: []int{rq, sw, rq, rq},
+	}, false))
+	assert.Equal(t, 1, s.decodeTarget)
+
+	// 升空间层请求关键帧, 直到有switch帧.
+	s.setTarget(1, 1)
+	assert.Equal(t, svcResult{forward: true, keyframe: true}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, DTIs: []int{rq, rq, rq, rq},
+	}, false))
+	assert.Equal(t, svcResult{}, ddOf(s, buffer.DependencyDescriptor{
+		SpatialID: 1, DTIs: []int{np, np, rq, rq},
+	}, false))
+	assert.Equal(t, svcResult{forward: true}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, DTIs: []int{rq, rq, rq, sw},
+	}, false))
+	assert.Equal(t, 3, s.decodeTarget)
+
+	// 降层立即生效.
+	s.setTarget(0, 0)
+	assert.Equal(t, svcResult{}, ddOf(s, buffer.DependencyDescriptor{
+		StartOfFrame: true, SpatialID: 1, DTIs: []int{np, np, rq, rq},
+	}, false))
+	assert.Equal(t, 0, s.decodeTarget)
+}
@@ -15,13 +15,13 @@ const (
 
 // WebRTCConfig defines parameters for ice
 type TurnConfig struct {
-	Enabled   bool     `mapstructure:"enabled"`
-	Realm     string   `mapstructure:"realm"`
-	Address   string   `mapstructure:"address"`
-	Cert      string   `mapstructure:"cert"`
-	Key       string   `mapstructure:"key"`
-	// Auth      TurnAuth `mapstructure:"auth"`
-	PortRange []uint16 `mapstructure:"portrange"`
+	Enabled   bool     `mapstructure:"enabled" yaml:"enabled" toml:"enabled"`
+	Realm     string   `mapstructure:"realm" yaml:"realm" toml:"realm"`
+	Address   string   `mapstructure:"address" yaml:"address" toml:"address"`
+	Cert      string   `mapstructure:"cert" yaml:"cert" toml:"cert"`
+	Key       string   `mapstructure:"key" yaml:"key" toml:"key"`
+	// Auth      TurnAuth `mapstructure:"auth" yaml:"auth" toml:"auth"`
+	PortRange []uint16 `mapstructure:"portrange" yaml:"portrange" toml:"portrange"`
 }
 
 